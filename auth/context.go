@@ -0,0 +1,40 @@
+package auth
+
+import "context"
+
+type principalKey struct{}
+
+// Principal is the authenticated user or service account of a request,
+// the claims are only present for a bearer token. While an administrator
+// impersonates a user, the principal is the user and the impersonator is
+// the administrator.
+type Principal struct {
+	UserId           int64
+	Email            string
+	ServiceAccountId int64
+	Name             string
+	Claims           *Claims
+	Impersonator     *Principal
+	ImpersonationId  int64
+}
+
+// IsServiceAccount tells whether the principal is a service account
+func (p *Principal) IsServiceAccount() bool {
+	return p.ServiceAccountId > 0
+}
+
+// IsImpersonated tells whether an administrator acts as the principal
+func (p *Principal) IsImpersonated() bool {
+	return p.Impersonator != nil
+}
+
+// NewContext returns a context carrying the principal
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the context, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksRefresh is the time after which a remote key set is fetched again
+	jwksRefresh = time.Hour
+	// jwksMinRefresh limits the fetches of a remote key set that are caused
+	// by tokens signed with an unknown key
+	jwksMinRefresh = time.Minute
+)
+
+// Key is a key that verifies token signatures. The key is a []byte for the
+// HS algorithms, a *rsa.PublicKey for RS and a *ecdsa.PublicKey for ES.
+type Key struct {
+	// Id is matched against the kid header of the tokens, a key without id
+	// is tried for every token
+	Id string
+	// Algorithm restricts the key to a single algorithm when it is not
+	// empty
+	Algorithm string
+	Key       interface{}
+}
+
+func (k *Key) supports(name string, alg *algorithm) bool {
+	if len(k.Algorithm) > 0 && k.Algorithm != name {
+		return false
+	}
+	switch k.Key.(type) {
+	case []byte:
+		return alg.family == "HS"
+	case *rsa.PublicKey:
+		return alg.family == "RS"
+	case *ecdsa.PublicKey:
+		return alg.family == "ES"
+	}
+	return false
+}
+
+// KeySource provides the keys that can verify a token with the given key id
+type KeySource interface {
+	Keys(kid string) ([]*Key, error)
+}
+
+// KeySet is a fixed set of keys
+type KeySet struct {
+	keys []*Key
+}
+
+// NewKeySet creates a key set from the keys
+func NewKeySet(keys ...*Key) *KeySet {
+	return &KeySet{keys: keys}
+}
+
+// Keys returns the keys with the id along with the keys without any id.
+// Every key is returned for a token without key id.
+func (s *KeySet) Keys(kid string) ([]*Key, error) {
+	if len(kid) == 0 {
+		return s.keys, nil
+	}
+	var keys []*Key
+	for _, k := range s.keys {
+		if len(k.Id) == 0 || k.Id == kid {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// Len returns the number of keys
+func (s *KeySet) Len() int {
+	return len(s.keys)
+}
+
+// HMACKey returns a key set with a shared secret for the HS algorithms
+func HMACKey(secret string) *KeySet {
+	return NewKeySet(&Key{Key: []byte(secret)})
+}
+
+// jwk is a single key of a JSON web key set, only the public parameters of
+// RSA and EC keys are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS reads the signing keys of a JSON web key set. Keys of other
+// types or meant for encryption are skipped.
+func ParseJWKS(b []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("error in decoding key set %s", err)
+	}
+	s := &KeySet{}
+	for _, j := range doc.Keys {
+		if len(j.Use) > 0 && j.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		var err error
+		switch j.Kty {
+		case "RSA":
+			key, err = j.rsaKey()
+		case "EC":
+			key, err = j.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s %s", j.Kid, err)
+		}
+		s.keys = append(s.keys, &Key{Id: j.Kid, Algorithm: j.Alg, Key: key})
+	}
+	return s, nil
+}
+
+func (j *jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(j.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(j.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent is too large")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (j *jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch j.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("curve %q is not supported", j.Crv)
+	}
+	x, err := decodeBigInt(j.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(j.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// ReadKeyFile reads the public keys of a file, either a JSON web key set or
+// PEM encoded RSA or EC public keys and certificates
+func ReadKeyFile(path string) (*KeySet, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(strings.TrimSpace(string(b)), "{") {
+		return ParseJWKS(b)
+	}
+	return ParsePEM(b)
+}
+
+// ParsePEM reads the RSA and EC public keys and certificates of PEM blocks
+func ParsePEM(b []byte) (*KeySet, error) {
+	s := &KeySet{}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in parsing %s %s", strings.ToLower(block.Type), err)
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+			s.keys = append(s.keys, &Key{Key: key})
+		default:
+			return nil, fmt.Errorf("key type %T is not supported", key)
+		}
+	}
+	if len(s.keys) == 0 {
+		return nil, errors.New("no public key found")
+	}
+	return s, nil
+}
+
+// RemoteKeySet is a JSON web key set served over http. It is fetched on
+// first use and again once an hour, or earlier when a token is signed with
+// a key id that is not part of the set.
+type RemoteKeySet struct {
+	url     string
+	client  *http.Client
+	mu      sync.Mutex
+	keys    *KeySet
+	fetched time.Time
+	now     func() time.Time
+}
+
+// NewRemoteKeySet creates a key set fetched from the url
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// Keys returns the keys with the given id, the key set is fetched again
+// if it has none
+func (r *RemoteKeySet) Keys(kid string) ([]*Key, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	age := r.now().Sub(r.fetched)
+	if r.keys == nil || age >= jwksRefresh {
+		if err := r.fetch(); err != nil {
+			return nil, err
+		}
+		return r.keys.Keys(kid)
+	}
+	keys, _ := r.keys.Keys(kid)
+	if len(keys) == 0 && age >= jwksMinRefresh {
+		if err := r.fetch(); err != nil {
+			return nil, err
+		}
+		return r.keys.Keys(kid)
+	}
+	return keys, nil
+}
+
+func (r *RemoteKeySet) fetch() error {
+	res, err := r.client.Get(r.url)
+	if err != nil {
+		return fmt.Errorf("error in fetching key set %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error in fetching key set from %s, status %s", r.url, res.Status)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error in reading key set %s", err)
+	}
+	keys, err := ParseJWKS(b)
+	if err != nil {
+		return err
+	}
+	r.keys = keys
+	r.fetched = r.now()
+	return nil
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func rsaJWK(kid string, k *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+	}
+}
+
+func jwksDoc(t *testing.T, keys ...map[string]string) []byte {
+	b, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := jwksDoc(t,
+		rsaJWK("rsa-1", &rsaKey.PublicKey),
+		map[string]string{
+			"kty": "EC",
+			"kid": "ec-1",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		},
+		map[string]string{"kty": "oct", "kid": "shared", "k": "c2VjcmV0"},
+		map[string]string{"kty": "RSA", "kid": "enc", "use": "enc"},
+	)
+	ks, err := ParseJWKS(doc)
+	if err != nil {
+		t.Fatalf("could not parse key set %s", err)
+	}
+	if ks.Len() != 2 {
+		t.Fatalf("expected the rsa and ec signing keys, received %d keys", ks.Len())
+	}
+	v := testVerifier(ks)
+	if _, err := v.Verify(sign(t, "RS256", "rsa-1", rsaKey, validClaims())); err != nil {
+		t.Fatalf("expected token signed by rsa-1 to be valid, received %s", err)
+	}
+	if _, err := v.Verify(sign(t, "ES256", "ec-1", ecKey, validClaims())); err != nil {
+		t.Fatalf("expected token signed by ec-1 to be valid, received %s", err)
+	}
+	bad := jwksDoc(t, map[string]string{"kty": "EC", "kid": "bad", "crv": "P-256", "x": "AQ", "y": "AQ"})
+	if _, err := ParseJWKS(bad); err == nil {
+		t.Fatal("expected a point off the curve to be rejected")
+	}
+}
+
+func TestParsePEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := ParsePEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("could not parse pem %s", err)
+	}
+	if _, err := testVerifier(ks).Verify(sign(t, "RS256", "any", rsaKey, validClaims())); err != nil {
+		t.Fatalf("expected a key without id to verify any token, received %s", err)
+	}
+	if _, err := ParsePEM([]byte("no pem here")); err == nil {
+		t.Fatal("expected an error without any public key")
+	}
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	doc := jwksDoc(t, rsaJWK("first", &first.PublicKey))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(doc)
+	}))
+	defer srv.Close()
+	now := testNow
+	rks := NewRemoteKeySet(srv.URL)
+	rks.now = func() time.Time { return now }
+	v := testVerifier(rks)
+	for i := 0; i < 2; i++ {
+		if _, err := v.Verify(sign(t, "RS256", "first", first, validClaims())); err != nil {
+			t.Fatalf("expected token signed by first to be valid, received %s", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected the key set to be fetched once, fetched %d times", n)
+	}
+	// the key is rotated, an unknown key id is fetched again only after
+	// the minimum refresh interval
+	doc = jwksDoc(t, rsaJWK("first", &first.PublicKey), rsaJWK("second", &second.PublicKey))
+	token := sign(t, "RS256", "second", second, validClaims())
+	if _, err := v.Verify(token); err != ErrInvalidSignature {
+		t.Fatalf("expected the unknown key to be rejected, received %v", err)
+	}
+	now = now.Add(jwksMinRefresh)
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, received %s", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("expected the key set to be fetched twice, fetched %d times", n)
+	}
+}
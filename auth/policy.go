@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/dictyBase/modware-user/rbac"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Verbs of the permissions required by the methods that have no rule of
+// their own
+const (
+	VerbRead   = "read"
+	VerbWrite  = "write"
+	VerbDelete = "delete"
+)
+
+// readPrefixes and deletePrefixes are the prefixes of the grpc method names
+// that read or delete, any other method writes
+var (
+	readPrefixes   = []string{"Get", "List", "Exist", "Check", "Healthz"}
+	deletePrefixes = []string{"Delete", "Remove"}
+)
+
+// Rule is the permission a method requires. A public method can be called
+// without a token, only reading methods can be public. An authenticated
+// method can be called by any principal with a valid token, whatever its
+// permissions are. A sensitive method cannot be called while impersonating
+// a user.
+type Rule struct {
+	Permission    string `yaml:"permission,omitempty" json:"permission,omitempty"`
+	Resource      string `yaml:"resource,omitempty" json:"resource,omitempty"`
+	Public        bool   `yaml:"public,omitempty" json:"public,omitempty"`
+	Authenticated bool   `yaml:"authenticated,omitempty" json:"authenticated,omitempty"`
+	Sensitive     bool   `yaml:"sensitive,omitempty" json:"sensitive,omitempty"`
+}
+
+// sensitiveResources are the resources whose changes alter what users are
+// allowed to do
+var sensitiveResources = map[string]bool{
+	"roles":            true,
+	"permissions":      true,
+	"role_requests":    true,
+	"service_accounts": true,
+	"impersonations":   true,
+	"tokens":           true,
+	"webhooks":         true,
+}
+
+// sensitiveMethods change the roles of a user through the user service
+var sensitiveMethods = map[string]bool{
+	"/dictybase.user.UserService/UpdateUser":             true,
+	"/dictybase.user.UserService/CreateRoleRelationship": true,
+	"/dictybase.user.UserService/UpdateRoleRelationship": true,
+	"/dictybase.user.UserService/DeleteRoleRelationship": true,
+}
+
+// Policy maps the methods to the permissions they require. A method is
+// either the full name of a grpc method, for example
+// /dictybase.user.UserService/GetUser, or the http method and path
+// template of a route, for example GET /roles/{id}/statistics.
+//
+// A method without a rule requires the permission derived from its name.
+// The verb is read for grpc methods starting with Get, List, Exist or Check
+// and for GET routes, delete for grpc methods starting with Delete or Remove
+// and for DELETE routes and write for everything else. The resource is the
+// lower cased and pluralized grpc service name without the Service suffix,
+// or the first segment of the route path. A rule that leaves out the
+// permission or resource inherits the derived one.
+//
+// Every method that deletes, that changes roles, permissions, service
+// accounts, tokens or webhooks, or that changes the roles of a user is
+// sensitive.
+// Rules can mark further methods as sensitive.
+type Policy struct {
+	// PublicReads makes every method requiring the read permission public
+	PublicReads bool             `yaml:"public_reads,omitempty" json:"public_reads,omitempty"`
+	Rules       map[string]*Rule `yaml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+// JWKSPath is the route of the JSON web key set that verifies the issued
+// tokens
+const JWKSPath = "/.well-known/jwks.json"
+
+// DefaultPolicy makes the health check and the key set public and lets
+// every authenticated user manage its own profile, stop its own
+// impersonations and request roles. Deciding a role request still requires
+// the write permission on role_requests.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Rules: map[string]*Rule{
+			"/dictybase.user.UserService/Healthz": {Public: true},
+			HTTPMethod("GET", JWKSPath):           {Resource: "tokens", Public: true},
+			"GET /users/me":                       {Authenticated: true},
+			"PATCH /users/me":                     {Authenticated: true, Sensitive: true},
+			"GET /users/me/permissions":           {Authenticated: true},
+			"POST /impersonations/{id}/stop":      {Authenticated: true},
+			"POST /role_requests":                 {Authenticated: true, Sensitive: true},
+			"POST /role_requests/{id}/comments":   {Authenticated: true},
+		},
+	}
+}
+
+// ReadPolicyYAML reads and validates a policy in yaml format, the rules of
+// the default policy are kept unless the policy overrides them
+func ReadPolicyYAML(r io.Reader) (*Policy, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := DefaultPolicy()
+	read := &Policy{}
+	if err := yaml.UnmarshalStrict(b, read); err != nil {
+		return nil, fmt.Errorf("error in decoding yaml %s", err)
+	}
+	p.PublicReads = read.PublicReads
+	for m, r := range read.Rules {
+		p.Rules[m] = r
+	}
+	return p, p.Validate()
+}
+
+// Validate checks that every rule requires a single permission on a single
+// resource and that only reading methods are public
+func (p *Policy) Validate() error {
+	methods := make([]string, 0, len(p.Rules))
+	for m := range p.Rules {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	for _, m := range methods {
+		if p.Rules[m] == nil {
+			return fmt.Errorf("rule of method %s is empty", m)
+		}
+		r, err := p.Rule(m)
+		if err != nil {
+			return err
+		}
+		pt, err := rbac.ParsePattern(r.Permission, r.Resource)
+		if err != nil {
+			return fmt.Errorf("invalid rule of method %s %s", m, err)
+		}
+		if !pt.IsLiteral() {
+			return fmt.Errorf("rule of method %s has to name a single permission and resource", m)
+		}
+		if r.Public && r.Permission != VerbRead {
+			return fmt.Errorf("method %s requires %s and cannot be public", m, r.Permission)
+		}
+		if r.Public && r.Authenticated {
+			return fmt.Errorf("method %s cannot be both public and authenticated", m)
+		}
+	}
+	return nil
+}
+
+// Rule returns the rule of the method, derived from its name if the policy
+// has none
+func (p *Policy) Rule(method string) (*Rule, error) {
+	verb, resource, err := deriveRule(method)
+	if err != nil {
+		return nil, err
+	}
+	r := &Rule{Permission: verb, Resource: resource}
+	if c, ok := p.Rules[method]; ok && c != nil {
+		r.Public = c.Public
+		r.Authenticated = c.Authenticated
+		r.Sensitive = c.Sensitive
+		if len(c.Permission) > 0 {
+			r.Permission = c.Permission
+		}
+		if len(c.Resource) > 0 {
+			r.Resource = c.Resource
+		}
+	}
+	if p.PublicReads && r.Permission == VerbRead && !r.Authenticated {
+		r.Public = true
+	}
+	if !r.Public && !r.Authenticated && isSensitive(method, r) {
+		r.Sensitive = true
+	}
+	return r, nil
+}
+
+// HTTPMethod returns the policy key of a http route
+func HTTPMethod(method, path string) string {
+	return fmt.Sprintf("%s %s", strings.ToUpper(method), path)
+}
+
+func deriveRule(method string) (string, string, error) {
+	if strings.HasPrefix(method, "/") {
+		parts := strings.Split(strings.TrimPrefix(method, "/"), "/")
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return "", "", fmt.Errorf("%s is not a grpc method", method)
+		}
+		svc := parts[0][strings.LastIndex(parts[0], ".")+1:]
+		svc = strings.TrimSuffix(svc, "Service")
+		if len(svc) == 0 {
+			return "", "", fmt.Errorf("unable to derive the resource of %s", method)
+		}
+		return grpcVerb(parts[1]), pluralize(strings.ToLower(svc)), nil
+	}
+	fields := strings.Fields(method)
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+		return "", "", fmt.Errorf("%s is neither a grpc method nor a http route", method)
+	}
+	segment := strings.Split(strings.TrimPrefix(fields[1], "/"), "/")[0]
+	if len(segment) == 0 || strings.HasPrefix(segment, "{") {
+		return "", "", fmt.Errorf("unable to derive the resource of %s", method)
+	}
+	switch fields[0] {
+	case "GET", "HEAD":
+		return VerbRead, segment, nil
+	case "DELETE":
+		return VerbDelete, segment, nil
+	}
+	return VerbWrite, segment, nil
+}
+
+func isSensitive(method string, r *Rule) bool {
+	switch {
+	case r.Permission == VerbDelete:
+		return true
+	case r.Permission != VerbRead && sensitiveResources[r.Resource]:
+		return true
+	}
+	return sensitiveMethods[method]
+}
+
+func grpcVerb(name string) string {
+	for _, p := range readPrefixes {
+		if strings.HasPrefix(name, p) {
+			return VerbRead
+		}
+	}
+	for _, p := range deletePrefixes {
+		if strings.HasPrefix(name, p) {
+			return VerbDelete
+		}
+	}
+	return VerbWrite
+}
+
+func pluralize(s string) string {
+	if strings.HasSuffix(s, "s") {
+		return s
+	}
+	return s + "s"
+}
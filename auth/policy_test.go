@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyDerivedRules(t *testing.T) {
+	p := DefaultPolicy()
+	for method, want := range map[string]Rule{
+		"/dictybase.user.UserService/GetUser":                   {Permission: "read", Resource: "users"},
+		"/dictybase.user.UserService/ListUsers":                 {Permission: "read", Resource: "users"},
+		"/dictybase.user.UserService/CreateUser":                {Permission: "write", Resource: "users"},
+		"/dictybase.user.UserService/UpdateUser":                {Permission: "write", Resource: "users", Sensitive: true},
+		"/dictybase.user.RoleService/CreateUserRelationship":    {Permission: "write", Resource: "roles", Sensitive: true},
+		"/dictybase.user.PermissionService/DeletePermission":    {Permission: "delete", Resource: "permissions", Sensitive: true},
+		"/dictybase.user.UserService/Healthz":                   {Permission: "read", Resource: "users", Public: true},
+		"GET /roles/{id}/statistics":                            {Permission: "read", Resource: "roles"},
+		"PUT /roles/{id}/permissions/{permission_id}/condition": {Permission: "write", Resource: "roles", Sensitive: true},
+		"DELETE /role_requests/{id}":                            {Permission: "delete", Resource: "role_requests", Sensitive: true},
+		"POST /role_requests":                                   {Permission: "write", Resource: "role_requests", Authenticated: true, Sensitive: true},
+		"POST /role_requests/{id}/comments":                     {Permission: "write", Resource: "role_requests", Authenticated: true},
+		"POST /role_requests/{id}/approve":                      {Permission: "write", Resource: "role_requests", Sensitive: true},
+		"GET /.well-known/jwks.json":                            {Permission: "read", Resource: "tokens", Public: true},
+		"POST /tokens":                                          {Permission: "write", Resource: "tokens", Sensitive: true},
+		"PATCH /users/me":                                       {Permission: "write", Resource: "users", Authenticated: true, Sensitive: true},
+		"POST /impersonations":                                  {Permission: "write", Resource: "impersonations", Sensitive: true},
+		"POST /impersonations/{id}/stop":                        {Permission: "write", Resource: "impersonations", Authenticated: true},
+		"GET /audit_events":                                     {Permission: "read", Resource: "audit_events"},
+		"GET /webhooks/{id}/deliveries":                         {Permission: "read", Resource: "webhooks"},
+		"POST /webhooks/{id}/deliveries/{delivery_id}/replay":   {Permission: "write", Resource: "webhooks", Sensitive: true},
+	} {
+		r, err := p.Rule(method)
+		if err != nil {
+			t.Fatalf("could not derive the rule of %s %s", method, err)
+		}
+		if *r != want {
+			t.Fatalf("expected %+v for %s, received %+v", want, method, r)
+		}
+	}
+	for _, method := range []string{"GetUser", "/UserService", "GET", "GET /{id}"} {
+		if _, err := p.Rule(method); err == nil {
+			t.Fatalf("expected an error for %s", method)
+		}
+	}
+	p.PublicReads = true
+	if r, _ := p.Rule("/dictybase.user.RoleService/GetRole"); !r.Public {
+		t.Fatal("expected reads to be public")
+	}
+	if r, _ := p.Rule("/dictybase.user.RoleService/UpdateRole"); r.Public {
+		t.Fatal("expected writes to stay private")
+	}
+}
+
+func TestReadPolicyYAML(t *testing.T) {
+	p, err := ReadPolicyYAML(strings.NewReader(`
+rules:
+  /dictybase.user.UserService/GetUserByEmail:
+    public: true
+  /dictybase.user.RoleService/DeleteRole:
+    permission: admin
+  GET /users/{id}/permissions/check:
+    resource: permissions
+`))
+	if err != nil {
+		t.Fatalf("could not read the policy %s", err)
+	}
+	for method, want := range map[string]Rule{
+		"/dictybase.user.UserService/GetUserByEmail": {Permission: "read", Resource: "users", Public: true},
+		"/dictybase.user.RoleService/DeleteRole":     {Permission: "admin", Resource: "roles", Sensitive: true},
+		"GET /users/{id}/permissions/check":          {Permission: "read", Resource: "permissions"},
+		"/dictybase.user.UserService/Healthz":        {Permission: "read", Resource: "users", Public: true},
+	} {
+		r, err := p.Rule(method)
+		if err != nil {
+			t.Fatalf("could not get the rule of %s %s", method, err)
+		}
+		if *r != want {
+			t.Fatalf("expected %+v for %s, received %+v", want, method, r)
+		}
+	}
+}
+
+func TestReadPolicyYAMLInvalid(t *testing.T) {
+	for _, doc := range []string{
+		"rules:\n  /dictybase.user.UserService/UpdateUser:\n    public: true\n",
+		"rules:\n  /dictybase.user.UserService/GetUser:\n    resource: users/*\n",
+		"rules:\n  /dictybase.user.UserService/GetUser:\n    permission: read,write\n",
+		"rules:\n  GetUser:\n    public: true\n",
+		"rules:\n  /dictybase.user.UserService/GetUser:\n    scope: all\n",
+		"rules:\n  /dictybase.user.UserService/GetUser:\n    public: true\n    authenticated: true\n",
+	} {
+		if _, err := ReadPolicyYAML(strings.NewReader(doc)); err == nil {
+			t.Fatalf("expected an error for %q", doc)
+		}
+	}
+}
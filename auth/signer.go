@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// SigningKey is a private key that signs the issued tokens, either a
+// *rsa.PrivateKey for RS256 or a *ecdsa.PrivateKey for the ES algorithm of
+// its curve
+type SigningKey struct {
+	// Id is the kid header of the signed tokens, the thumbprint of the
+	// public key is used when it is empty
+	Id        string
+	Algorithm string
+	Key       crypto.Signer
+}
+
+// Signer signs tokens with its first key. The remaining keys are retired
+// ones that are still published, so that the tokens they signed can be
+// verified until they expire.
+type Signer struct {
+	mu   sync.RWMutex
+	keys []*SigningKey
+}
+
+// NewSigner creates a signer from the active key followed by the retired
+// ones
+func NewSigner(keys ...*SigningKey) (*Signer, error) {
+	s := &Signer{}
+	return s, s.Rotate(keys...)
+}
+
+// Rotate replaces the keys of the signer, the first key becomes the active
+// one
+func (s *Signer) Rotate(keys ...*SigningKey) error {
+	if len(keys) == 0 {
+		return errors.New("at least one signing key is required")
+	}
+	for _, k := range keys {
+		if err := k.complete(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	return nil
+}
+
+// Sign serializes the claims and signs them with the active key
+func (s *Signer) Sign(claims interface{}) (string, error) {
+	s.mu.RLock()
+	k := s.keys[0]
+	s.mu.RUnlock()
+	h, err := json.Marshal(&header{Algorithm: k.Algorithm, KeyId: k.Id, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	alg := algorithms[k.Algorithm]
+	var sig []byte
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, alg.hash, digest(alg.hash, input))
+		if err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		r, ss, err := ecdsa.Sign(rand.Reader, key, digest(alg.hash, input))
+		if err != nil {
+			return "", err
+		}
+		sig = append(padBytes(r, alg.size), padBytes(ss, alg.size)...)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Keys returns the public keys of the signer, it lets the service verify
+// the tokens it issued
+func (s *Signer) Keys(kid string) ([]*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []*Key
+	for _, k := range s.keys {
+		if len(kid) == 0 || k.Id == kid {
+			keys = append(keys, k.public())
+		}
+	}
+	return keys, nil
+}
+
+// JWKS returns the JSON web key set with the public keys of the signer
+func (s *Signer) JWKS() ([]byte, error) {
+	keys, _ := s.Keys("")
+	doc := struct {
+		Keys []*jwk `json:"keys"`
+	}{Keys: make([]*jwk, 0, len(keys))}
+	for _, k := range keys {
+		j, err := publicJWK(k)
+		if err != nil {
+			return nil, err
+		}
+		doc.Keys = append(doc.Keys, j)
+	}
+	return json.Marshal(doc)
+}
+
+// complete sets the algorithm and the id of the key when they are absent
+func (k *SigningKey) complete() error {
+	var name string
+	switch key := k.Key.(type) {
+	case *rsa.PrivateKey:
+		name = "RS256"
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			name = "ES256"
+		case elliptic.P384():
+			name = "ES384"
+		case elliptic.P521():
+			name = "ES512"
+		default:
+			return fmt.Errorf("curve %s is not supported", key.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("signing key type %T is not supported", k.Key)
+	}
+	if len(k.Algorithm) == 0 {
+		k.Algorithm = name
+	}
+	alg, ok := algorithms[k.Algorithm]
+	if !ok || !k.public().supports(k.Algorithm, alg) || (alg.family == "ES" && k.Algorithm != name) {
+		return fmt.Errorf("algorithm %s does not fit the %T signing key", k.Algorithm, k.Key)
+	}
+	if len(k.Id) == 0 {
+		j, err := publicJWK(k.public())
+		if err != nil {
+			return err
+		}
+		k.Id = j.thumbprint()
+	}
+	return nil
+}
+
+func (k *SigningKey) public() *Key {
+	return &Key{Id: k.Id, Algorithm: k.Algorithm, Key: k.Key.Public()}
+}
+
+// publicJWK encodes the public parameters of a RSA or EC key
+func publicJWK(k *Key) (*jwk, error) {
+	j := &jwk{Kid: k.Id, Alg: k.Algorithm, Use: "sig"}
+	switch key := k.Key.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		j.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		j.Kty = "EC"
+		j.Crv = key.Curve.Params().Name
+		j.X = base64.RawURLEncoding.EncodeToString(padBytes(key.X, size))
+		j.Y = base64.RawURLEncoding.EncodeToString(padBytes(key.Y, size))
+	default:
+		return nil, fmt.Errorf("key type %T cannot be published", k.Key)
+	}
+	return j, nil
+}
+
+// thumbprint is the RFC 7638 thumbprint of the key, the required members
+// are serialized in lexical order
+func (j *jwk) thumbprint() string {
+	var doc string
+	if j.Kty == "RSA" {
+		doc = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, j.E, j.N)
+	} else {
+		doc = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, j.Crv, j.X, j.Y)
+	}
+	h := sha256.Sum256([]byte(doc))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// ReadSigningKeyFile reads a PEM encoded RSA or EC private key
+func ReadSigningKeyFile(path string) (*SigningKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSigningKey(b)
+}
+
+// ParseSigningKey reads the first private key of the PEM blocks, in PKCS #1,
+// PKCS #8 or SEC 1 form
+func ParseSigningKey(b []byte) (*SigningKey, error) {
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error in parsing %s %s", strings.ToLower(block.Type), err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key type %T cannot sign", key)
+		}
+		k := &SigningKey{Key: signer}
+		return k, k.complete()
+	}
+}
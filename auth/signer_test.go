@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []*SigningKey{{Key: rsaKey}, {Key: ecKey}} {
+		s, err := NewSigner(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := validClaims()
+		c.Roles = []string{"curator"}
+		c.Permissions = []string{"write:genes/*"}
+		token, err := s.Sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// verify through the published key set
+		b, err := s.JWKS()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ks, err := ParseJWKS(b)
+		if err != nil {
+			t.Fatalf("could not parse the published key set %s", err)
+		}
+		got, err := testVerifier(ks).Verify(token)
+		if err != nil {
+			t.Fatalf("expected %s token to be valid, received %s", key.Algorithm, err)
+		}
+		if len(got.Roles) != 1 || got.Permissions[0] != "write:genes/*" {
+			t.Fatalf("unexpected claims %+v", got)
+		}
+	}
+}
+
+func TestSignerRotate(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSigner(&SigningKey{Key: oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rotate(&SigningKey{Key: newKey}, &SigningKey{Key: oldKey}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := testVerifier(s)
+	for _, token := range []string{before, after} {
+		if _, err := v.Verify(token); err != nil {
+			t.Fatalf("expected the token to be valid after rotation, received %s", err)
+		}
+	}
+	keys, _ := s.Keys("")
+	if len(keys) != 2 || keys[0].Id == keys[1].Id {
+		t.Fatalf("expected two keys with distinct ids, received %d", len(keys))
+	}
+	if err := s.Rotate(&SigningKey{Key: oldKey}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(after); err != ErrInvalidSignature {
+		t.Fatalf("expected the token of the dropped key to be invalid, received %v", err)
+	}
+	if err := s.Rotate(); err == nil {
+		t.Fatal("expected a rotation without keys to fail")
+	}
+	if err := s.Rotate(&SigningKey{Key: oldKey, Algorithm: "RS256"}); err == nil {
+		t.Fatal("expected a mismatched algorithm to fail")
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDer, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want, block := range map[string]*pem.Block{
+		"RS256": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"ES512": {Type: "EC PRIVATE KEY", Bytes: ecDer},
+	} {
+		k, err := ParseSigningKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Fatalf("could not parse %s %s", block.Type, err)
+		}
+		if k.Algorithm != want || len(k.Id) == 0 {
+			t.Fatalf("expected %s key with id, received %s %q", want, k.Algorithm, k.Id)
+		}
+	}
+	k, err := ParseSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	if err != nil {
+		t.Fatalf("could not parse pkcs8 key %s", err)
+	}
+	if k.Algorithm != "RS256" {
+		t.Fatalf("expected RS256, received %s", k.Algorithm)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseSigningKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	if err == nil || !strings.Contains(err.Error(), "no private key") {
+		t.Fatalf("expected a public key to be rejected, received %v", err)
+	}
+}
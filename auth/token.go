@@ -0,0 +1,228 @@
+// Package auth verifies the bearer tokens of the requests and maps the
+// requested methods to the permissions they require.
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers the hash functions of the algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned when none of the keys verifies the
+// signature of a token
+var ErrInvalidSignature = errors.New("token signature is invalid")
+
+// algorithm describes a supported JSON web signature algorithm
+type algorithm struct {
+	hash crypto.Hash
+	// family is the kind of key, either HS, RS or ES
+	family string
+	// size is the byte length of each of the two integers of an ES
+	// signature
+	size int
+}
+
+var algorithms = map[string]*algorithm{
+	"HS256": {hash: crypto.SHA256, family: "HS"},
+	"HS384": {hash: crypto.SHA384, family: "HS"},
+	"HS512": {hash: crypto.SHA512, family: "HS"},
+	"RS256": {hash: crypto.SHA256, family: "RS"},
+	"RS384": {hash: crypto.SHA384, family: "RS"},
+	"RS512": {hash: crypto.SHA512, family: "RS"},
+	"ES256": {hash: crypto.SHA256, family: "ES", size: 32},
+	"ES384": {hash: crypto.SHA384, family: "ES", size: 48},
+	"ES512": {hash: crypto.SHA512, family: "ES", size: 66},
+}
+
+// Audience is the aud claim, a single value or a list of them
+type Audience []string
+
+// UnmarshalJSON accepts either a string or a list of strings
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return fmt.Errorf("aud claim is neither a string nor a list of strings")
+	}
+	*a = Audience(l)
+	return nil
+}
+
+// Contains checks if the audience includes the value
+func (a Audience) Contains(v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor is the party acting on behalf of the subject of a token
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// Claims are the registered claims of a token along with the email of the
+// user it was issued for. The tokens issued by this service also carry the
+// globally assigned roles of the user and the permission patterns they
+// grant, a permission in the denied list overrides any of the granted ones.
+// An impersonation token names the impersonating administrator as actor and
+// the impersonation session as token id.
+type Claims struct {
+	Id                string   `json:"jti,omitempty"`
+	Subject           string   `json:"sub"`
+	Issuer            string   `json:"iss,omitempty"`
+	Audience          Audience `json:"aud,omitempty"`
+	ExpiresAt         int64    `json:"exp,omitempty"`
+	NotBefore         int64    `json:"nbf,omitempty"`
+	IssuedAt          int64    `json:"iat,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Roles             []string `json:"roles,omitempty"`
+	Permissions       []string `json:"permissions,omitempty"`
+	DeniedPermissions []string `json:"denied_permissions,omitempty"`
+	Actor             *Actor   `json:"act,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// Verifier checks the signature and the time, issuer and audience claims of
+// a JSON web token in compact serialization
+type Verifier struct {
+	// Sources provide the keys, a token is valid if any matching key
+	// verifies its signature
+	Sources []KeySource
+	// Issuer and Audience are checked when they are not empty
+	Issuer   string
+	Audience string
+	// Leeway is the allowed clock skew for the time claims
+	Leeway time.Duration
+	now    func() time.Time
+}
+
+// Verify returns the claims of a valid token
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a compact JSON web signature")
+	}
+	h := &header{}
+	if err := decodeSegment(parts[0], h); err != nil {
+		return nil, fmt.Errorf("invalid token header %s", err)
+	}
+	alg, ok := algorithms[h.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("token algorithm %q is not supported", h.Algorithm)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature encoding %s", err)
+	}
+	if err := v.verifySignature(h, alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	c := &Claims{}
+	if err := decodeSegment(parts[1], c); err != nil {
+		return nil, fmt.Errorf("invalid token claims %s", err)
+	}
+	if err := v.validate(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (v *Verifier) verifySignature(h *header, alg *algorithm, input string, sig []byte) error {
+	for _, src := range v.Sources {
+		keys, err := src.Keys(h.KeyId)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if !k.supports(h.Algorithm, alg) {
+				continue
+			}
+			if verify(alg, k.Key, input, sig) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	if c.ExpiresAt == 0 {
+		return errors.New("token has no expiration")
+	}
+	if now.Add(-v.Leeway).Unix() >= c.ExpiresAt {
+		return errors.New("token is expired")
+	}
+	if c.NotBefore != 0 && now.Add(v.Leeway).Unix() < c.NotBefore {
+		return errors.New("token is not valid yet")
+	}
+	if len(v.Issuer) > 0 && c.Issuer != v.Issuer {
+		return fmt.Errorf("token issuer %q is not accepted", c.Issuer)
+	}
+	if len(v.Audience) > 0 && !c.Audience.Contains(v.Audience) {
+		return fmt.Errorf("token is not issued for audience %s", v.Audience)
+	}
+	if len(c.Subject) == 0 {
+		return errors.New("token has no subject")
+	}
+	return nil
+}
+
+func verify(alg *algorithm, key interface{}, input string, sig []byte) bool {
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(alg.hash.New, k)
+		mac.Write([]byte(input))
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, alg.hash, digest(alg.hash, input), sig) == nil
+	case *ecdsa.PublicKey:
+		if len(sig) != 2*alg.size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:alg.size])
+		s := new(big.Int).SetBytes(sig[alg.size:])
+		return ecdsa.Verify(k, digest(alg.hash, input), r, s)
+	}
+	return false
+}
+
+func digest(h crypto.Hash, input string) []byte {
+	hh := h.New()
+	hh.Write([]byte(input))
+	return hh.Sum(nil)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// sign creates a compact token signed with the key, which is a []byte, a
+// *rsa.PrivateKey or a *ecdsa.PrivateKey
+func sign(t *testing.T, alg, kid string, key interface{}, claims interface{}) string {
+	h, err := json.Marshal(&header{Algorithm: alg, KeyId: kid, Type: "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	a := algorithms[alg]
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(a.hash.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, a.hash, digest(a.hash, input))
+	case *ecdsa.PrivateKey:
+		r, s, serr := ecdsa.Sign(rand.Reader, k, digest(a.hash, input))
+		err = serr
+		sig = append(leftPad(r.Bytes(), a.size), leftPad(s.Bytes(), a.size)...)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func leftPad(b []byte, size int) []byte {
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func validClaims() *Claims {
+	return &Claims{
+		Subject:   "12",
+		Issuer:    "dictybase",
+		Audience:  Audience{"user-api"},
+		ExpiresAt: testNow.Add(time.Hour).Unix(),
+		IssuedAt:  testNow.Unix(),
+	}
+}
+
+func testVerifier(sources ...KeySource) *Verifier {
+	return &Verifier{
+		Sources:  sources,
+		Issuer:   "dictybase",
+		Audience: "user-api",
+		Leeway:   time.Minute,
+		now:      func() time.Time { return testNow },
+	}
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := testVerifier(
+		HMACKey("secret"),
+		NewKeySet(
+			&Key{Id: "rsa", Key: &rsaKey.PublicKey},
+			&Key{Id: "ec", Key: &ecKey.PublicKey},
+		),
+	)
+	for _, tc := range []struct {
+		alg, kid string
+		key      interface{}
+	}{
+		{"HS256", "", []byte("secret")},
+		{"HS512", "", []byte("secret")},
+		{"RS256", "rsa", rsaKey},
+		{"RS384", "", rsaKey},
+		{"ES256", "ec", ecKey},
+	} {
+		c, err := v.Verify(sign(t, tc.alg, tc.kid, tc.key, validClaims()))
+		if err != nil {
+			t.Fatalf("expected %s token to be valid, received %s", tc.alg, err)
+		}
+		if c.Subject != "12" {
+			t.Fatalf("expected subject 12, received %s", c.Subject)
+		}
+	}
+	// a key id that no key has
+	if _, err := v.Verify(sign(t, "RS256", "other", rsaKey, validClaims())); err != ErrInvalidSignature {
+		t.Fatalf("expected invalid signature for unknown key id, received %v", err)
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	v := testVerifier(HMACKey("secret"))
+	expired := validClaims()
+	expired.ExpiresAt = testNow.Add(-2 * time.Minute).Unix()
+	skewed := validClaims()
+	skewed.ExpiresAt = testNow.Add(-30 * time.Second).Unix()
+	early := validClaims()
+	early.NotBefore = testNow.Add(5 * time.Minute).Unix()
+	issuer := validClaims()
+	issuer.Issuer = "elsewhere"
+	audience := validClaims()
+	audience.Audience = Audience{"stock-api"}
+	noexp := validClaims()
+	noexp.ExpiresAt = 0
+	nosub := validClaims()
+	nosub.Subject = ""
+	for name, token := range map[string]string{
+		"expired":   sign(t, "HS256", "", []byte("secret"), expired),
+		"early":     sign(t, "HS256", "", []byte("secret"), early),
+		"issuer":    sign(t, "HS256", "", []byte("secret"), issuer),
+		"audience":  sign(t, "HS256", "", []byte("secret"), audience),
+		"noexp":     sign(t, "HS256", "", []byte("secret"), noexp),
+		"nosub":     sign(t, "HS256", "", []byte("secret"), nosub),
+		"secret":    sign(t, "HS256", "", []byte("guess"), validClaims()),
+		"malformed": "a.b",
+	} {
+		if _, err := v.Verify(token); err == nil {
+			t.Fatalf("expected %s token to be invalid", name)
+		}
+	}
+	if _, err := v.Verify(sign(t, "HS256", "", []byte("secret"), skewed)); err != nil {
+		t.Fatalf("expected the leeway to accept a recently expired token, received %s", err)
+	}
+	// the signature of the unsupported none algorithm is never checked
+	none := strings.Split(sign(t, "HS256", "", []byte("secret"), validClaims()), ".")
+	h := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	if _, err := v.Verify(h + "." + none[1] + "."); err == nil {
+		t.Fatal("expected a token without signature to be invalid")
+	}
+}
+
+func TestVerifyKeyAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := testVerifier(NewKeySet(&Key{Key: &rsaKey.PublicKey, Algorithm: "RS512"}))
+	if _, err := v.Verify(sign(t, "RS256", "", rsaKey, validClaims())); err != ErrInvalidSignature {
+		t.Fatalf("expected a key restricted to RS512 to reject RS256, received %v", err)
+	}
+	if _, err := v.Verify(sign(t, "RS512", "", rsaKey, validClaims())); err != nil {
+		t.Fatalf("expected RS512 token to be valid, received %s", err)
+	}
+}
+
+func TestAudienceUnmarshal(t *testing.T) {
+	for doc, want := range map[string]int{
+		`{"aud":"user-api"}`:              1,
+		`{"aud":["user-api","role-api"]}`: 2,
+	} {
+		c := &Claims{}
+		if err := json.Unmarshal([]byte(doc), c); err != nil {
+			t.Fatalf("could not decode %s %s", doc, err)
+		}
+		if len(c.Audience) != want || !c.Audience.Contains("user-api") {
+			t.Fatalf("unexpected audience %v of %s", c.Audience, doc)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"aud":12}`), &Claims{}); err == nil {
+		t.Fatal("expected a numeric audience to be rejected")
+	}
+}
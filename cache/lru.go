@@ -0,0 +1,187 @@
+// Package cache provides a bounded least recently used cache whose entries
+// expire after a fixed time and can be dropped in groups.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats are the counters of a cache since it was created
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Expirations   uint64 `json:"expirations"`
+	Invalidations uint64 `json:"invalidations"`
+	Size          int    `json:"size"`
+	Capacity      int    `json:"capacity"`
+}
+
+// HitRatio is the fraction of lookups that were served from the cache
+func (s *Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Token marks the time a value started to be loaded, see LRU.Begin
+type Token uint64
+
+type entry struct {
+	key     string
+	group   int64
+	value   interface{}
+	expires time.Time
+}
+
+// LRU is a cache of a fixed number of entries, the least recently used
+// entry is evicted to make room for a new one. Every entry belongs to a
+// group, for example the user it was computed for, and all entries of a
+// group are removed together. It is safe for concurrent use.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+	groups   map[int64]map[string]bool
+	// epoch is increased by every removal, values loaded before a
+	// removal are not added as they might be stale
+	epoch uint64
+	stats Stats
+	now   func() time.Time
+}
+
+// New creates a cache holding at most capacity entries for the given
+// duration. A capacity below one disables the cache, nothing is stored.
+func New(capacity int, ttl time.Duration) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		groups:   make(map[int64]map[string]bool),
+		now:      time.Now,
+	}
+}
+
+// Get returns the value of a key that has not expired
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.ttl > 0 && !c.now().Before(e.expires) {
+		c.removeElement(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return e.value, true
+}
+
+// Begin returns the token to be given to Add for a value that is about to
+// be loaded
+func (c *LRU) Begin() Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Token(c.epoch)
+}
+
+// Add stores the value of a key in a group. The value is discarded if any
+// entry was removed since the token was taken, as the value could have been
+// loaded from data that was changed in the meantime.
+func (c *LRU) Add(t Token, group int64, key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity < 1 || uint64(t) != c.epoch {
+		return false
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	el := c.ll.PushFront(&entry{
+		key:     key,
+		group:   group,
+		value:   value,
+		expires: c.now().Add(c.ttl),
+	})
+	c.items[key] = el
+	if c.groups[group] == nil {
+		c.groups[group] = make(map[string]bool)
+	}
+	c.groups[group][key] = true
+	for c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+	return true
+}
+
+// RemoveGroups removes every entry of the groups and returns the number of
+// removed entries
+func (c *LRU) RemoveGroups(groups ...int64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	n := 0
+	for _, g := range groups {
+		for key := range c.groups[g] {
+			c.removeElement(c.items[key])
+			n++
+		}
+	}
+	c.stats.Invalidations += uint64(n)
+	return n
+}
+
+// Purge removes every entry and returns the number of removed entries
+func (c *LRU) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.groups = make(map[int64]map[string]bool)
+	c.stats.Invalidations += uint64(n)
+	return n
+}
+
+// Len returns the number of entries, including the expired ones that were
+// not yet removed
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Stats returns a copy of the counters
+func (c *LRU) Stats() *Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Size = c.ll.Len()
+	s.Capacity = c.capacity
+	return &s
+}
+
+func (c *LRU) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	if keys := c.groups[e.group]; keys != nil {
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(c.groups, e.group)
+		}
+	}
+}
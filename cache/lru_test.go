@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func newTestLRU(capacity int, ttl time.Duration) (*LRU, *clock) {
+	c := New(capacity, ttl)
+	clk := &clock{t: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)}
+	c.now = clk.now
+	return c, clk
+}
+
+func TestLRUGetAdd(t *testing.T) {
+	c, _ := newTestLRU(2, time.Minute)
+	if _, ok := c.Get("1:genes"); ok {
+		t.Fatal("expected a miss in an empty cache")
+	}
+	if !c.Add(c.Begin(), 1, "1:genes", "grants") {
+		t.Fatal("expected the value to be added")
+	}
+	v, ok := c.Get("1:genes")
+	if !ok || v.(string) != "grants" {
+		t.Fatalf("expected a hit with grants, received %v %t", v, ok)
+	}
+	s := c.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.Size != 1 || s.Capacity != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.HitRatio() != 0.5 {
+		t.Fatalf("expected hit ratio of 0.5, received %f", s.HitRatio())
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	c, _ := newTestLRU(2, time.Minute)
+	c.Add(c.Begin(), 1, "a", 1)
+	c.Add(c.Begin(), 2, "b", 2)
+	// a becomes the most recently used
+	c.Get("a")
+	c.Add(c.Begin(), 3, "c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("expected %s to be kept", k)
+		}
+	}
+	if s := c.Stats(); s.Evictions != 1 || s.Size != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	// removing the evicted entry's group has nothing to remove
+	if n := c.RemoveGroups(2); n != 0 {
+		t.Fatalf("expected no entry of group 2, removed %d", n)
+	}
+}
+
+func TestLRUExpiration(t *testing.T) {
+	c, clk := newTestLRU(2, time.Minute)
+	c.Add(c.Begin(), 1, "a", 1)
+	clk.t = clk.t.Add(59 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected the entry before its expiration")
+	}
+	clk.t = clk.t.Add(time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected the entry to be expired")
+	}
+	if s := c.Stats(); s.Expirations != 1 || s.Size != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestLRURemoveGroups(t *testing.T) {
+	c, _ := newTestLRU(10, time.Minute)
+	c.Add(c.Begin(), 1, "1:global", 1)
+	c.Add(c.Begin(), 1, "1:genes:12", 1)
+	c.Add(c.Begin(), 2, "2:global", 2)
+	if n := c.RemoveGroups(1, 3); n != 2 {
+		t.Fatalf("expected 2 removed entries, received %d", n)
+	}
+	if _, ok := c.Get("1:genes:12"); ok {
+		t.Fatal("expected the entries of group 1 to be removed")
+	}
+	if _, ok := c.Get("2:global"); !ok {
+		t.Fatal("expected the entries of group 2 to be kept")
+	}
+	if n := c.Purge(); n != 1 || c.Len() != 0 {
+		t.Fatalf("expected purge to remove one entry, removed %d", n)
+	}
+	if s := c.Stats(); s.Invalidations != 3 {
+		t.Fatalf("expected 3 invalidations, received %d", s.Invalidations)
+	}
+}
+
+func TestLRUStaleToken(t *testing.T) {
+	c, _ := newTestLRU(10, time.Minute)
+	tok := c.Begin()
+	// the data changes while the value is loaded
+	c.RemoveGroups(1)
+	if c.Add(tok, 1, "1:global", "stale") {
+		t.Fatal("expected a value loaded before an invalidation to be discarded")
+	}
+	if !c.Add(c.Begin(), 1, "1:global", "fresh") {
+		t.Fatal("expected a value loaded after an invalidation to be added")
+	}
+}
+
+func TestLRUDisabled(t *testing.T) {
+	c, _ := newTestLRU(0, time.Minute)
+	if c.Add(c.Begin(), 1, "a", 1) {
+		t.Fatal("expected a cache without capacity to store nothing")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a miss")
+	}
+}
+
+func TestLRUConcurrent(t *testing.T) {
+	c := New(50, time.Minute)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("%d:%d", i, j%60)
+				if _, ok := c.Get(key); !ok {
+					c.Add(c.Begin(), int64(i), key, j)
+				}
+				if j%50 == 0 {
+					c.RemoveGroups(int64(i))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if c.Len() > 50 {
+		t.Fatalf("expected at most 50 entries, received %d", c.Len())
+	}
+}
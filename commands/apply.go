@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/dictyBase/modware-user/rbac"
+	"github.com/dictyBase/modware-user/server"
+	"github.com/urfave/cli"
+)
+
+// ApplyRBAC makes the stored roles, permissions and their bindings match
+// the yaml matrix of a file and lists the changes, which are only listed
+// in a dry run
+func ApplyRBAC(c *cli.Context) error {
+	fh, err := os.Open(c.String("file"))
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to open matrix file %s", err),
+			2,
+		)
+	}
+	defer fh.Close()
+	m, err := rbac.ReadYAML(fh)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to read matrix file %s %s", c.String("file"), err),
+			2,
+		)
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Unable to create database connection %s", err.Error()),
+			2,
+		)
+	}
+	diff, err := server.NewRoleService(dbh).ApplyRBAC(context.Background(), m, c.Bool("dry-run"))
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to apply roles and permissions %s", err),
+			2,
+		)
+	}
+	if err := rbac.WriteDiff(os.Stdout, diff); err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	switch {
+	case diff.IsEmpty():
+		fmt.Println("roles and permissions are up to date")
+	case c.Bool("dry-run"):
+		fmt.Println("dry run, nothing was changed")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/server"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// tokenLeeway is the allowed clock skew between the token issuer and
+// this service
+const tokenLeeway = 30 * time.Second
+
+// getAuthenticator creates the authenticator from the configured keys and
+// the given key sources. Without any key the server refuses to start,
+// unless the calls are explicitly left unauthenticated, then no
+// authenticator is returned.
+func getAuthenticator(c *cli.Context, dbh *runner.DB, pc *server.PermissionCache, sources ...auth.KeySource) (*server.Authenticator, error) {
+	v := &auth.Verifier{
+		Sources:  sources,
+		Issuer:   c.String("auth-issuer"),
+		Audience: c.String("auth-audience"),
+		Leeway:   tokenLeeway,
+	}
+	if len(c.String("auth-jwks-url")) > 0 {
+		v.Sources = append(v.Sources, auth.NewRemoteKeySet(c.String("auth-jwks-url")))
+	}
+	for _, f := range c.StringSlice("auth-key-file") {
+		ks, err := auth.ReadKeyFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read key file %s %s", f, err)
+		}
+		v.Sources = append(v.Sources, ks)
+	}
+	if len(c.String("auth-hmac-secret")) > 0 {
+		v.Sources = append(v.Sources, auth.HMACKey(c.String("auth-hmac-secret")))
+	}
+	if len(v.Sources) == 0 {
+		if !c.Bool("insecure-no-auth") {
+			return nil, fmt.Errorf(
+				"no token keys are configured, set auth-jwks-url, auth-key-file, auth-hmac-secret or a signing key, or insecure-no-auth to serve unauthenticated calls",
+			)
+		}
+		log.Print("no token keys are configured, the calls are not authenticated")
+		return nil, nil
+	}
+	p := auth.DefaultPolicy()
+	if len(c.String("auth-policy")) > 0 {
+		h, err := os.Open(c.String("auth-policy"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to open policy file %s", err)
+		}
+		defer h.Close()
+		p, err = auth.ReadPolicyYAML(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy %s", err)
+		}
+	}
+	if c.Bool("auth-public-reads") {
+		p.PublicReads = true
+	}
+	return server.NewAuthenticator(dbh, v, p).WithPermissionCache(pc), nil
+}
+
+// getGrpcServer creates the grpc server with the logging interceptors and,
+// with an authenticator, the authorization of every call
+func getGrpcServer(c *cli.Context, authn *server.Authenticator) *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_logrus.UnaryServerInterceptor(getLogger(c)),
+	}
+	if authn != nil {
+		interceptors = append(interceptors, authn.UnaryServerInterceptor())
+	}
+	return grpc.NewServer(grpc_middleware.WithUnaryServerChain(interceptors...))
+}
+
+// authorizeRoutes wraps the custom http routes with the authorization
+func authorizeRoutes(authn *server.Authenticator, routes []*server.HTTPRoute) []*server.HTTPRoute {
+	if authn == nil {
+		return routes
+	}
+	return authn.HTTPRoutes(routes)
+}
+
+// getTokenIssuer creates the issuer of the tokens from the signing key
+// files, the first key signs while the others are only published. The files
+// are read again on SIGHUP, so that the keys can be rotated without a
+// restart. No issuer is returned when no signing key is configured.
+func getTokenIssuer(c *cli.Context) (*server.TokenIssuer, error) {
+	files := c.StringSlice("token-signing-key")
+	if len(files) == 0 {
+		return nil, nil
+	}
+	keys, err := readSigningKeys(files)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := auth.NewSigner(keys...)
+	if err != nil {
+		return nil, err
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			keys, err := readSigningKeys(files)
+			if err == nil {
+				err = signer.Rotate(keys...)
+			}
+			if err != nil {
+				log.Printf("unable to reload the token signing keys %s", err)
+				continue
+			}
+			log.Printf("reloaded %d token signing keys", len(keys))
+		}
+	}()
+	return &server.TokenIssuer{
+		Signer:   signer,
+		Issuer:   c.String("auth-issuer"),
+		Audience: c.String("auth-audience"),
+		TTL:      c.Duration("token-ttl"),
+	}, nil
+}
+
+func readSigningKeys(files []string) ([]*auth.SigningKey, error) {
+	var keys []*auth.SigningKey
+	for _, f := range files {
+		k, err := auth.ReadSigningKeyFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read signing key %s %s", f, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// apiKeyCredentials sends a service account API key as the bearer token of
+// every call
+type apiKeyCredentials string
+
+func (k apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(k)}, nil
+}
+
+// RequireTransportSecurity allows the key on the plain connections between
+// the services of the cluster
+func (k apiKeyCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// dialService connects to the grpc server of a microservice, the calls
+// carry the configured API key
+func dialService(c *cli.Context, host, port string) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if len(c.String("api-key")) > 0 {
+		opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials(c.String("api-key"))))
+	}
+	return grpc.Dial(fmt.Sprintf("%s:%s", host, port), opts...)
+}
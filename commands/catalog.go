@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dictyBase/modware-user/rbac"
+	"github.com/dictyBase/modware-user/server"
+	"github.com/urfave/cli"
+)
+
+// AuditPermissions lists the stored permissions that do not conform to the
+// permission catalog, either the registered one or the one given as a file
+func AuditPermissions(c *cli.Context) error {
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Unable to create database connection %s", err.Error()),
+			2,
+		)
+	}
+	srv := server.NewPermissionService(dbh)
+	var audit *server.CatalogAudit
+	if len(c.String("permission-catalog")) > 0 {
+		cat, err := readCatalog(c.String("permission-catalog"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+		audit, err = srv.AuditPermissionsWith(context.Background(), cat)
+	} else {
+		audit, err = srv.AuditPermissions(context.Background())
+	}
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to audit permissions %s", err),
+			2,
+		)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPERMISSION\tRESOURCE\tPROBLEM")
+	for _, p := range audit.NonConforming {
+		var problems []string
+		for _, v := range p.Violations {
+			problems = append(problems, v.Error())
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.Id, p.Permission, p.Resource, strings.Join(problems, "; "))
+	}
+	if err := tw.Flush(); err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	if len(audit.NonConforming) > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf(
+				"%d of %d permissions do not conform to the catalog",
+				len(audit.NonConforming), audit.Checked,
+			),
+			1,
+		)
+	}
+	return nil
+}
+
+// seedCatalog registers the verbs and resource types of the catalog file
+// that are not in the database yet
+func seedCatalog(srv *server.PermissionService, file string) error {
+	cat, err := readCatalog(file)
+	if err != nil {
+		return err
+	}
+	if err := srv.SeedCatalog(context.Background(), cat); err != nil {
+		return fmt.Errorf("unable to seed permission catalog %s", err)
+	}
+	return nil
+}
+
+func readCatalog(file string) (*rbac.Catalog, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open catalog file %s", err)
+	}
+	defer fh.Close()
+	cat, err := rbac.ReadCatalogYAML(fh)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read catalog file %s %s", file, err)
+	}
+	return cat, nil
+}
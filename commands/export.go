@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dictyBase/modware-user/rbac"
+	"github.com/dictyBase/modware-user/server"
+	"github.com/urfave/cli"
+)
+
+// ExportRBAC writes all roles, permissions and their bindings in the
+// requested format
+func ExportRBAC(c *cli.Context) error {
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Unable to create database connection %s", err.Error()),
+			2,
+		)
+	}
+	m, err := server.NewRoleService(dbh).ExportRBAC(context.Background())
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to export roles and permissions %s", err),
+			2,
+		)
+	}
+	var w io.Writer = os.Stdout
+	if len(c.String("output")) > 0 {
+		fh, err := os.Create(c.String("output"))
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("unable to create output file %s", err),
+				2,
+			)
+		}
+		defer fh.Close()
+		w = fh
+	}
+	if err := rbac.Write(w, c.String("format"), m); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to write roles and permissions %s", err),
+			2,
+		)
+	}
+	return nil
+}
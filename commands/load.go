@@ -15,7 +15,6 @@ import (
 	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
 	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
 	"github.com/urfave/cli"
-	"google.golang.org/grpc"
 )
 
 type UserStatus struct {
@@ -24,10 +23,7 @@ type UserStatus struct {
 }
 
 func LoadUser(c *cli.Context) error {
-	conn, err := grpc.Dial(
-		fmt.Sprintf("%s:%s", c.String("user-grpc-host"), c.String("user-grpc-port")),
-		grpc.WithInsecure(),
-	)
+	conn, err := dialService(c, c.String("user-grpc-host"), c.String("user-grpc-port"))
 	if err != nil {
 		return cli.NewExitError(
 			fmt.Sprintf("cannot connect to grpc server for user microservice %s", err),
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/pressly/goose"
+	"github.com/urfave/cli"
+)
+
+// RunMigration applies the database migrations that are maintained in this
+// repository on top of the dictyuser schema
+func RunMigration(c *cli.Context) error {
+	db, err := getPgxDbHandler(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Unable to create database connection %s", err.Error()),
+			2,
+		)
+	}
+	defer db.Close()
+	if err := goose.SetDialect("postgres"); err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	if err := goose.Up(db, c.String("migrations-dir")); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to run database migration %s", err),
+			2,
+		)
+	}
+	return nil
+}
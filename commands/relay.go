@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dictyBase/modware-user/message/nats"
+	"github.com/dictyBase/modware-user/server"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/urfave/cli"
+)
+
+// RunEventRelay publishes the domain events stored in the outbox until it
+// is killed, the lag of the relay is served over http
+func RunEventRelay(c *cli.Context) error {
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Unable to create database connection %s", err.Error()),
+			2,
+		)
+	}
+	pub, err := nats.NewEventPublisher(c.String("messaging-host"), c.String("messaging-port"))
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to connect to messaging server %s", err),
+			2,
+		)
+	}
+	defer pub.Close()
+	relay := server.NewOutboxRelay(dbh, pub, c.String("event-subject-prefix"))
+	relay.BatchSize = c.Int("relay-batch-size")
+	relay.PollInterval = c.Duration("relay-poll-interval")
+	relay.MinBackoff = c.Duration("relay-min-backoff")
+	relay.MaxBackoff = c.Duration("relay-max-backoff")
+
+	httpMux := runtime.NewServeMux()
+	if err := server.RegisterHTTPRoutes(httpMux, relay.HTTPRoutes()); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to register http routes for the event relay %s", err),
+			2,
+		)
+	}
+	logger := getLogger(c)
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%s", c.String("port")), httpMux); err != nil {
+			logger.Fatalf("unable to serve the relay metrics %s", err)
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx, func(err error) {
+			logger.Errorf("unable to relay the events %s", err)
+		})
+		close(done)
+	}()
+	logger.Infof("starting the event relay, metrics at port %s", c.String("port"))
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	<-ch
+	logger.Info("received kill signal")
+	cancel()
+	<-done
+	logger.Info("stopped relaying the events")
+	return nil
+}
@@ -6,15 +6,12 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/dictyBase/go-genproto/dictybaseapis/pubsub"
 	"github.com/dictyBase/modware-user/message"
 	gclient "github.com/dictyBase/modware-user/message/grpc-client"
 	"github.com/dictyBase/modware-user/message/nats"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 func shutdown(r message.Reply, logger *logrus.Entry) {
@@ -28,56 +25,40 @@ func shutdown(r message.Reply, logger *logrus.Entry) {
 	logger.Info("closed the connections gracefully")
 }
 
-func replyUser(subj string, c message.UserClient, req *pubsub.IdRequest) *pubsub.UserReply {
-	switch subj {
-	case "UserService.Get":
-		u, err := c.Get(req.Id)
-		if err != nil {
-			st, _ := status.FromError(err)
-			return &pubsub.UserReply{
-				Status: st.Proto(),
-				Exist:  false,
-			}
-		}
-		return &pubsub.UserReply{
-			Exist: true,
-			User:  u,
-		}
-	case "UserService.Exist":
-		exist, err := c.Exist(req.Id)
-		if err != nil {
-			st, _ := status.FromError(err)
-			return &pubsub.UserReply{
-				Status: st.Proto(),
-				Exist:  exist,
-			}
-		}
-		return &pubsub.UserReply{
-			Exist: exist,
-		}
-	case "UserService.Delete":
-		deleted, err := c.Delete(req.Id)
-		if err != nil {
-			st, _ := status.FromError(err)
-			return &pubsub.UserReply{
-				Status: st.Proto(),
-				Exist:  deleted,
-			}
-		}
-		return &pubsub.UserReply{
-			Exist: deleted,
-		}
-	default:
-		return &pubsub.UserReply{
-			Status: status.Newf(codes.Internal, "subject %s is not supported", subj).Proto(),
-		}
-	}
+// RunUserReply answers the UserService requests with the user service
+func RunUserReply(c *cli.Context) error {
+	return runReply(c, "user", "UserService.*", func(conn *grpc.ClientConn) *message.Registry {
+		return message.NewUserRegistry(gclient.NewUserClient(conn))
+	})
 }
 
-func RunUserReply(c *cli.Context) error {
-	reply, err := nats.NewReply(
+// RunRoleReply answers the RoleService requests with the role service
+func RunRoleReply(c *cli.Context) error {
+	return runReply(c, "role", "RoleService.*", func(conn *grpc.ClientConn) *message.Registry {
+		return message.NewRoleRegistry(gclient.NewRoleClient(conn))
+	})
+}
+
+// RunPermissionReply answers the PermissionService requests with the
+// permission service
+func RunPermissionReply(c *cli.Context) error {
+	return runReply(c, "permission", "PermissionService.*", func(conn *grpc.ClientConn) *message.Registry {
+		return message.NewPermissionRegistry(gclient.NewPermissionClient(conn))
+	})
+}
+
+// runReply serves the subjects matching the pattern with the registry built
+// over the grpc connection to the given microservice
+func runReply(c *cli.Context, svc, subj string, fn func(*grpc.ClientConn) *message.Registry) error {
+	reply, err := nats.NewReplyWithConfig(
 		c.String("messaging-host"),
 		c.String("messaging-port"),
+		&nats.ReplyConfig{
+			Queue:      c.String("reply-queue-group"),
+			Workers:    c.Int("reply-workers"),
+			MaxPending: c.Int("reply-max-pending"),
+			Timeout:    c.Duration("reply-timeout"),
+		},
 	)
 	if err != nil {
 		return cli.NewExitError(
@@ -85,30 +66,23 @@ func RunUserReply(c *cli.Context) error {
 			2,
 		)
 	}
-	conn, err := grpc.Dial(
-		fmt.Sprintf("%s:%s", c.String("user-grpc-host"), c.String("user-grpc-port")),
-		grpc.WithInsecure(),
-	)
+	conn, err := dialService(c, c.String(svc+"-grpc-host"), c.String(svc+"-grpc-port"))
 	if err != nil {
 		return cli.NewExitError(
-			fmt.Sprintf("cannot connect to grpc server for user microservice %s", err),
+			fmt.Sprintf("cannot connect to grpc server for %s microservice %s", svc, err),
 			2,
 		)
 	}
 	defer conn.Close()
-	err = reply.Start(
-		"UserService.*",
-		gclient.NewUserClient(conn),
-		replyUser,
-	)
-	if err != nil {
+	reg := fn(conn)
+	if err := reply.Start(subj, reg); err != nil {
 		return cli.NewExitError(
 			fmt.Sprintf("cannot start the reply server %s", err),
 			2,
 		)
 	}
 	logger := getLogger(c)
-	logger.Info("starting the reply messaging backend")
+	logger.Infof("starting the reply messaging backend for %v", reg.Subjects())
 	shutdown(reply, logger)
 	return nil
 }
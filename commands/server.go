@@ -11,11 +11,11 @@ import (
 
 	"github.com/dictyBase/apihelpers/aphgrpc"
 	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/message"
+	"github.com/dictyBase/modware-user/message/nats"
 	"github.com/dictyBase/modware-user/server"
 	"github.com/go-chi/cors"
-	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
-	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
-	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/sirupsen/logrus"
@@ -34,13 +34,26 @@ func RunRoleServer(c *cli.Context) error {
 			2,
 		)
 	}
-	grpcS := grpc.NewServer(
-		grpc_middleware.WithUnaryServerChain(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_logrus.UnaryServerInterceptor(getLogger(c)),
-		),
-	)
-	pb.RegisterRoleServiceServer(grpcS, server.NewRoleService(dbh, aphgrpc.BaseURLOption(setApiHost(c))))
+	pub, err := getPublisher(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to connect to messaging server %s", err),
+			2,
+		)
+	}
+	defer pub.Close()
+	// the role service keeps no grants, it only broadcasts the changes
+	pc := server.NewPermissionCache(0, 0, pub)
+	roleSrv := server.NewRoleService(dbh, aphgrpc.BaseURLOption(setApiHost(c))).
+		WithPermissionCache(pc)
+	reqSrv := server.NewRoleRequestService(dbh, aphgrpc.BaseURLOption(setApiHost(c))).
+		WithPermissionCache(pc)
+	authn, err := getAuthenticator(c, dbh, pc)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	grpcS := getGrpcServer(c, authn)
+	pb.RegisterRoleServiceServer(grpcS, roleSrv)
 	reflection.Register(grpcS)
 
 	// http requests muxer
@@ -48,6 +61,13 @@ func RunRoleServer(c *cli.Context) error {
 	httpMux := runtime.NewServeMux(
 		runtime.WithForwardResponseOption(aphgrpc.HandleCreateResponse),
 	)
+	routes := append(roleSrv.HTTPRoutes(), reqSrv.HTTPRoutes()...)
+	if err := server.RegisterHTTPRoutes(httpMux, authorizeRoutes(authn, routes)); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to register http routes for role microservice %s", err),
+			2,
+		)
+	}
 	opts := []grpc.DialOption{grpc.WithInsecure()}
 	endP := fmt.Sprintf(":%s", c.String("port"))
 	err = pb.RegisterRoleServiceHandlerFromEndpoint(context.Background(), httpMux, endP, opts)
@@ -118,13 +138,62 @@ func RunUserServer(c *cli.Context) error {
 			2,
 		)
 	}
-	grpcS := grpc.NewServer(
-		grpc_middleware.WithUnaryServerChain(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_logrus.UnaryServerInterceptor(getLogger(c)),
-		),
-	)
-	pb.RegisterUserServiceServer(grpcS, server.NewUserService(dbh, aphgrpc.BaseURLOption(setApiHost(c))))
+	pub, err := getPublisher(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to connect to messaging server %s", err),
+			2,
+		)
+	}
+	defer pub.Close()
+	// the grants are only cached when the invalidations broadcast by the
+	// role and permission servers can be received
+	size := c.Int("permission-cache-size")
+	if !hasMessaging(c) && size > 0 {
+		log.Printf("permission cache disabled as no messaging server is configured")
+		size = 0
+	}
+	pc := server.NewPermissionCache(size, c.Duration("permission-cache-ttl"), pub)
+	if hasMessaging(c) {
+		sub, err := nats.NewSubscriber(c.String("messaging-host"), c.String("messaging-port"))
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("unable to connect to messaging server %s", err),
+				2,
+			)
+		}
+		defer sub.Close()
+		if err := sub.Subscribe(message.CacheInvalidationSubject, pc.HandleInvalidation); err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("unable to subscribe to permission cache invalidations %s", err),
+				2,
+			)
+		}
+	}
+	userSrv := server.NewUserService(dbh, aphgrpc.BaseURLOption(setApiHost(c))).
+		WithPermissionCache(pc)
+	saSrv := server.NewServiceAccountService(dbh, aphgrpc.BaseURLOption(setApiHost(c))).
+		WithPermissionCache(pc)
+	ti, err := getTokenIssuer(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	var sources []auth.KeySource
+	if ti != nil {
+		userSrv.WithTokenIssuer(ti)
+		// the tokens issued by the service are accepted as well
+		sources = append(sources, ti.Signer)
+	}
+	impSrv := server.NewImpersonationService(
+		dbh, ti, c.Duration("impersonation-max-ttl"),
+		aphgrpc.BaseURLOption(setApiHost(c)),
+	).WithPermissionCache(pc)
+	authn, err := getAuthenticator(c, dbh, pc, sources...)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	grpcS := getGrpcServer(c, authn)
+	pb.RegisterUserServiceServer(grpcS, userSrv)
 	reflection.Register(grpcS)
 
 	// http requests muxer
@@ -132,6 +201,16 @@ func RunUserServer(c *cli.Context) error {
 	httpMux := runtime.NewServeMux(
 		runtime.WithForwardResponseOption(aphgrpc.HandleCreateResponse),
 	)
+	routes := append(userSrv.HTTPRoutes(), saSrv.HTTPRoutes()...)
+	routes = append(routes, impSrv.HTTPRoutes()...)
+	routes = append(routes, server.NewAuditService(dbh, aphgrpc.BaseURLOption(setApiHost(c))).HTTPRoutes()...)
+	routes = append(routes, server.NewWebhookService(dbh, aphgrpc.BaseURLOption(setApiHost(c))).HTTPRoutes()...)
+	if err := server.RegisterHTTPRoutes(httpMux, authorizeRoutes(authn, routes)); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to register http routes for user microservice %s", err),
+			2,
+		)
+	}
 	opts := []grpc.DialOption{grpc.WithInsecure()}
 	endP := fmt.Sprintf(":%s", c.String("port"))
 	err = pb.RegisterUserServiceHandlerFromEndpoint(context.Background(), httpMux, endP, opts)
@@ -202,13 +281,29 @@ func RunPermissionServer(c *cli.Context) error {
 			2,
 		)
 	}
-	grpcS := grpc.NewServer(
-		grpc_middleware.WithUnaryServerChain(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_logrus.UnaryServerInterceptor(getLogger(c)),
-		),
-	)
-	pb.RegisterPermissionServiceServer(grpcS, server.NewPermissionService(dbh, aphgrpc.BaseURLOption(setApiHost(c))))
+	pub, err := getPublisher(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to connect to messaging server %s", err),
+			2,
+		)
+	}
+	defer pub.Close()
+	// the permission service keeps no grants, it only broadcasts the changes
+	pc := server.NewPermissionCache(0, 0, pub)
+	permSrv := server.NewPermissionService(dbh, aphgrpc.BaseURLOption(setApiHost(c))).
+		WithPermissionCache(pc)
+	if len(c.String("permission-catalog")) > 0 {
+		if err := seedCatalog(permSrv, c.String("permission-catalog")); err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+	}
+	authn, err := getAuthenticator(c, dbh, pc)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	grpcS := getGrpcServer(c, authn)
+	pb.RegisterPermissionServiceServer(grpcS, permSrv)
 	reflection.Register(grpcS)
 
 	// http requests muxer
@@ -216,6 +311,12 @@ func RunPermissionServer(c *cli.Context) error {
 	httpMux := runtime.NewServeMux(
 		runtime.WithForwardResponseOption(aphgrpc.HandleCreateResponse),
 	)
+	if err := server.RegisterHTTPRoutes(httpMux, authorizeRoutes(authn, permSrv.HTTPRoutes())); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to register http routes for permission microservice %s", err),
+			2,
+		)
+	}
 	opts := []grpc.DialOption{grpc.WithInsecure()}
 	endP := fmt.Sprintf(":%s", c.String("port"))
 	err = pb.RegisterPermissionServiceHandlerFromEndpoint(context.Background(), httpMux, endP, opts)
@@ -334,3 +435,16 @@ func getLogger(c *cli.Context) *logrus.Entry {
 	}
 	return logrus.NewEntry(log)
 }
+
+// getPublisher connects to the messaging server when it is configured,
+// otherwise the events are discarded
+func getPublisher(c *cli.Context) (message.Publisher, error) {
+	if !hasMessaging(c) {
+		return message.NewNullPublisher(), nil
+	}
+	return nats.NewPublisher(c.String("messaging-host"), c.String("messaging-port"))
+}
+
+func hasMessaging(c *cli.Context) bool {
+	return len(c.String("messaging-host")) > 0 && len(c.String("messaging-port")) > 0
+}
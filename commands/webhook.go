@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dictyBase/modware-user/server"
+	"github.com/urfave/cli"
+)
+
+// RunWebhookDispatcher sends the queued webhook deliveries until it is
+// killed
+func RunWebhookDispatcher(c *cli.Context) error {
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("Unable to create database connection %s", err.Error()),
+			2,
+		)
+	}
+	d := server.NewWebhookDispatcher(dbh)
+	d.Client.Timeout = c.Duration("webhook-timeout")
+	d.BatchSize = c.Int("webhook-batch-size")
+	d.PollInterval = c.Duration("webhook-poll-interval")
+	d.MinBackoff = c.Duration("webhook-min-backoff")
+	d.MaxBackoff = c.Duration("webhook-max-backoff")
+	d.MaxAttempts = c.Int("webhook-max-attempts")
+	d.Lease = c.Duration("webhook-lease")
+
+	logger := getLogger(c)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, func(err error) {
+			logger.Errorf("unable to dispatch the webhook deliveries %s", err)
+		})
+		close(done)
+	}()
+	logger.Info("starting the webhook dispatcher")
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	<-ch
+	logger.Info("received kill signal")
+	cancel()
+	<-done
+	logger.Info("stopped dispatching the webhook deliveries")
+	return nil
+}
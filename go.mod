@@ -18,7 +18,7 @@ require (
 	github.com/mgutz/jo v1.1.0 // indirect
 	github.com/mgutz/to v1.0.0 // indirect
 	github.com/mwitkow/go-proto-validators v0.3.0 // indirect
-	github.com/nats-io/gnatsd v1.4.0 // indirect
+	github.com/nats-io/gnatsd v1.4.0
 	github.com/nats-io/go-nats v1.7.2
 	github.com/pressly/goose v2.7.0+incompatible
 	github.com/shopspring/decimal v0.0.0-20180709203117-cd690d0c9e24 // indirect
@@ -28,14 +28,15 @@ require (
 	github.com/soheilhy/cmux v0.1.5
 	github.com/urfave/cli v1.22.5
 	google.golang.org/appengine v1.6.6 // indirect
-	google.golang.org/genproto v0.0.0-20200806141610-86f49bd18e98 // indirect
+	google.golang.org/genproto v0.0.0-20200806141610-86f49bd18e98
 	google.golang.org/grpc v1.39.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/ini.v1 v1.41.0 // indirect
 	gopkg.in/mgutz/dat.v2 v2.0.0-20171004160617-d76e4f81c4ef
 	gopkg.in/src-d/go-git.v4 v4.13.1
 	gopkg.in/stretchr/testify.v1 v1.2.2 // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
 
 go 1.13
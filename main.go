@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/dictyBase/modware-user/commands"
 	"github.com/dictyBase/modware-user/validate"
@@ -75,6 +76,11 @@ func main() {
 					EnvVar: "USER_API_SERVICE_PORT",
 					Usage:  "grpc port for user service",
 				},
+				cli.StringFlag{
+					Name:   "api-key",
+					EnvVar: "SERVICE_API_KEY",
+					Usage:  "service account API key sent with the grpc calls when they require authentication",
+				},
 				cli.StringFlag{
 					Name:  "data-file",
 					Value: "users.csv",
@@ -98,6 +104,69 @@ func main() {
 					EnvVar: "USER_API_SERVICE_PORT",
 					Usage:  "grpc port for user service",
 				},
+				cli.StringFlag{
+					Name:   "api-key",
+					EnvVar: "SERVICE_API_KEY",
+					Usage:  "service account API key sent with the grpc calls when they require authentication",
+				},
+				cli.StringFlag{
+					Name:   "messaging-host",
+					EnvVar: "NATS_SERVICE_HOST",
+					Usage:  "host address for messaging server",
+					Value:  "nats",
+				},
+				cli.StringFlag{
+					Name:   "messaging-port",
+					EnvVar: "NATS_SERVICE_PORT",
+					Usage:  "port for messaging server",
+				},
+				cli.StringFlag{
+					Name:   "reply-queue-group",
+					EnvVar: "REPLY_QUEUE_GROUP",
+					Usage:  "queue group shared by the replicas, a request is answered by one of them, empty to answer on every replica",
+					Value:  "user-reply",
+				},
+				cli.IntFlag{
+					Name:   "reply-workers",
+					EnvVar: "REPLY_WORKERS",
+					Usage:  "number of requests handled at once",
+					Value:  10,
+				},
+				cli.IntFlag{
+					Name:   "reply-max-pending",
+					EnvVar: "REPLY_MAX_PENDING",
+					Usage:  "number of requests that can wait for a worker, the others are dropped",
+					Value:  1000,
+				},
+				cli.DurationFlag{
+					Name:   "reply-timeout",
+					EnvVar: "REPLY_TIMEOUT",
+					Usage:  "how long a request has to be answered, it bounds the grpc calls made for it",
+					Value:  10 * time.Second,
+				},
+			},
+		},
+		{
+			Name:   "start-role-reply",
+			Usage:  "start the reply messaging(nats) backend for role microservice",
+			Action: commands.RunRoleReply,
+			Before: validate.ValidateRoleReplyArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "role-grpc-host",
+					EnvVar: "ROLE_API_SERVICE_HOST",
+					Usage:  "grpc host address for role service",
+				},
+				cli.StringFlag{
+					Name:   "role-grpc-port",
+					EnvVar: "ROLE_API_SERVICE_PORT",
+					Usage:  "grpc port for role service",
+				},
+				cli.StringFlag{
+					Name:   "api-key",
+					EnvVar: "SERVICE_API_KEY",
+					Usage:  "service account API key sent with the grpc calls when they require authentication",
+				},
 				cli.StringFlag{
 					Name:   "messaging-host",
 					EnvVar: "NATS_SERVICE_HOST",
@@ -109,6 +178,88 @@ func main() {
 					EnvVar: "NATS_SERVICE_PORT",
 					Usage:  "port for messaging server",
 				},
+				cli.StringFlag{
+					Name:   "reply-queue-group",
+					EnvVar: "REPLY_QUEUE_GROUP",
+					Usage:  "queue group shared by the replicas, a request is answered by one of them, empty to answer on every replica",
+					Value:  "role-reply",
+				},
+				cli.IntFlag{
+					Name:   "reply-workers",
+					EnvVar: "REPLY_WORKERS",
+					Usage:  "number of requests handled at once",
+					Value:  10,
+				},
+				cli.IntFlag{
+					Name:   "reply-max-pending",
+					EnvVar: "REPLY_MAX_PENDING",
+					Usage:  "number of requests that can wait for a worker, the others are dropped",
+					Value:  1000,
+				},
+				cli.DurationFlag{
+					Name:   "reply-timeout",
+					EnvVar: "REPLY_TIMEOUT",
+					Usage:  "how long a request has to be answered, it bounds the grpc calls made for it",
+					Value:  10 * time.Second,
+				},
+			},
+		},
+		{
+			Name:   "start-permission-reply",
+			Usage:  "start the reply messaging(nats) backend for permission microservice",
+			Action: commands.RunPermissionReply,
+			Before: validate.ValidatePermissionReplyArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "permission-grpc-host",
+					EnvVar: "PERMISSION_API_SERVICE_HOST",
+					Usage:  "grpc host address for permission service",
+				},
+				cli.StringFlag{
+					Name:   "permission-grpc-port",
+					EnvVar: "PERMISSION_API_SERVICE_PORT",
+					Usage:  "grpc port for permission service",
+				},
+				cli.StringFlag{
+					Name:   "api-key",
+					EnvVar: "SERVICE_API_KEY",
+					Usage:  "service account API key sent with the grpc calls when they require authentication",
+				},
+				cli.StringFlag{
+					Name:   "messaging-host",
+					EnvVar: "NATS_SERVICE_HOST",
+					Usage:  "host address for messaging server",
+					Value:  "nats",
+				},
+				cli.StringFlag{
+					Name:   "messaging-port",
+					EnvVar: "NATS_SERVICE_PORT",
+					Usage:  "port for messaging server",
+				},
+				cli.StringFlag{
+					Name:   "reply-queue-group",
+					EnvVar: "REPLY_QUEUE_GROUP",
+					Usage:  "queue group shared by the replicas, a request is answered by one of them, empty to answer on every replica",
+					Value:  "permission-reply",
+				},
+				cli.IntFlag{
+					Name:   "reply-workers",
+					EnvVar: "REPLY_WORKERS",
+					Usage:  "number of requests handled at once",
+					Value:  10,
+				},
+				cli.IntFlag{
+					Name:   "reply-max-pending",
+					EnvVar: "REPLY_MAX_PENDING",
+					Usage:  "number of requests that can wait for a worker, the others are dropped",
+					Value:  1000,
+				},
+				cli.DurationFlag{
+					Name:   "reply-timeout",
+					EnvVar: "REPLY_TIMEOUT",
+					Usage:  "how long a request has to be answered, it bounds the grpc calls made for it",
+					Value:  10 * time.Second,
+				},
 			},
 		},
 		{
@@ -153,6 +304,56 @@ func main() {
 					Usage: "tcp port at which the role server will be available",
 					Value: "9597",
 				},
+				cli.StringFlag{
+					Name:   "messaging-host",
+					EnvVar: "NATS_SERVICE_HOST",
+					Usage:  "host address for messaging server, events are not published if it is absent",
+				},
+				cli.StringFlag{
+					Name:   "messaging-port",
+					EnvVar: "NATS_SERVICE_PORT",
+					Usage:  "port for messaging server",
+				},
+				cli.StringFlag{
+					Name:   "auth-jwks-url",
+					EnvVar: "AUTH_JWKS_URL",
+					Usage:  "url of the json web key set that verifies the bearer tokens",
+				},
+				cli.StringSliceFlag{
+					Name:   "auth-key-file",
+					EnvVar: "AUTH_KEY_FILE",
+					Usage:  "file with PEM encoded public keys or a json web key set that verify the bearer tokens, can be repeated",
+				},
+				cli.StringFlag{
+					Name:   "auth-hmac-secret",
+					EnvVar: "AUTH_HMAC_SECRET",
+					Usage:  "shared secret that verifies HMAC signed bearer tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-issuer",
+					EnvVar: "AUTH_ISSUER",
+					Usage:  "required issuer of the bearer tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-audience",
+					EnvVar: "AUTH_AUDIENCE",
+					Usage:  "required audience of the bearer tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-policy",
+					EnvVar: "AUTH_POLICY",
+					Usage:  "yaml file mapping the methods to the permissions they require",
+				},
+				cli.BoolFlag{
+					Name:   "auth-public-reads",
+					EnvVar: "AUTH_PUBLIC_READS",
+					Usage:  "allow the methods requiring the read permission to be called without a token",
+				},
+				cli.BoolFlag{
+					Name:   "insecure-no-auth",
+					EnvVar: "INSECURE_NO_AUTH",
+					Usage:  "serve the calls without authentication when no token key is configured, only for local development",
+				},
 			},
 		},
 		{
@@ -197,6 +398,380 @@ func main() {
 					Usage: "tcp port at which the user server will be available",
 					Value: "9596",
 				},
+				cli.StringFlag{
+					Name:   "permission-catalog",
+					EnvVar: "PERMISSION_CATALOG",
+					Usage:  "yaml file with the verbs and resource types that are added to the permission catalog at startup",
+				},
+				cli.StringFlag{
+					Name:   "messaging-host",
+					EnvVar: "NATS_SERVICE_HOST",
+					Usage:  "host address for messaging server, permission cache invalidations are not broadcast if it is absent",
+				},
+				cli.StringFlag{
+					Name:   "messaging-port",
+					EnvVar: "NATS_SERVICE_PORT",
+					Usage:  "port for messaging server",
+				},
+				cli.StringFlag{
+					Name:   "auth-jwks-url",
+					EnvVar: "AUTH_JWKS_URL",
+					Usage:  "url of the json web key set that verifies the bearer tokens",
+				},
+				cli.StringSliceFlag{
+					Name:   "auth-key-file",
+					EnvVar: "AUTH_KEY_FILE",
+					Usage:  "file with PEM encoded public keys or a json web key set that verify the bearer tokens, can be repeated",
+				},
+				cli.StringFlag{
+					Name:   "auth-hmac-secret",
+					EnvVar: "AUTH_HMAC_SECRET",
+					Usage:  "shared secret that verifies HMAC signed bearer tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-issuer",
+					EnvVar: "AUTH_ISSUER",
+					Usage:  "required issuer of the bearer tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-audience",
+					EnvVar: "AUTH_AUDIENCE",
+					Usage:  "required audience of the bearer tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-policy",
+					EnvVar: "AUTH_POLICY",
+					Usage:  "yaml file mapping the methods to the permissions they require",
+				},
+				cli.BoolFlag{
+					Name:   "auth-public-reads",
+					EnvVar: "AUTH_PUBLIC_READS",
+					Usage:  "allow the methods requiring the read permission to be called without a token",
+				},
+				cli.BoolFlag{
+					Name:   "insecure-no-auth",
+					EnvVar: "INSECURE_NO_AUTH",
+					Usage:  "serve the calls without authentication when no token key is configured, only for local development",
+				},
+			},
+		},
+		{
+			Name:   "migrate",
+			Usage:  "apply the database migrations of this service",
+			Action: commands.RunMigration,
+			Before: validate.ValidateMigrateArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "dictyuser-pass",
+					EnvVar: "DICTYUSER_PASSWORD",
+					Usage:  "dictyuser database password",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-db",
+					EnvVar: "DICTYUSER_DB",
+					Usage:  "dictyuser database name",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-user",
+					EnvVar: "DICTYUSER_USER",
+					Usage:  "dictyuser database user",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-host",
+					Value:  "dictycontent-backend",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_HOST",
+					Usage:  "dictyuser database host",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-port",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_PORT",
+					Usage:  "dictyuser database port",
+				},
+				cli.StringFlag{
+					Name:   "migrations-dir",
+					EnvVar: "MIGRATIONS_DIR",
+					Usage:  "folder containing the sql migration files",
+					Value:  "/migrations",
+				},
+			},
+		},
+		{
+			Name:   "export-rbac",
+			Usage:  "export roles, permissions and their bindings as yaml, csv or markdown",
+			Action: commands.ExportRBAC,
+			Before: validate.ValidateExportArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "dictyuser-pass",
+					EnvVar: "DICTYUSER_PASSWORD",
+					Usage:  "dictyuser database password",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-db",
+					EnvVar: "DICTYUSER_DB",
+					Usage:  "dictyuser database name",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-user",
+					EnvVar: "DICTYUSER_USER",
+					Usage:  "dictyuser database user",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-host",
+					Value:  "dictycontent-backend",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_HOST",
+					Usage:  "dictyuser database host",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-port",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_PORT",
+					Usage:  "dictyuser database port",
+				},
+				cli.StringFlag{
+					Name:  "format, f",
+					Usage: "output format, either of yaml, csv or markdown",
+					Value: "yaml",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "output file, by default written to stdout",
+				},
+			},
+		},
+		{
+			Name:   "apply-rbac",
+			Usage:  "apply roles, permissions and their bindings from a yaml file as exported by export-rbac",
+			Action: commands.ApplyRBAC,
+			Before: validate.ValidateApplyArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "dictyuser-pass",
+					EnvVar: "DICTYUSER_PASSWORD",
+					Usage:  "dictyuser database password",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-db",
+					EnvVar: "DICTYUSER_DB",
+					Usage:  "dictyuser database name",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-user",
+					EnvVar: "DICTYUSER_USER",
+					Usage:  "dictyuser database user",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-host",
+					Value:  "dictycontent-backend",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_HOST",
+					Usage:  "dictyuser database host",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-port",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_PORT",
+					Usage:  "dictyuser database port",
+				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Usage: "yaml file with the roles, permissions and their bindings",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only list the changes, nothing is stored",
+				},
+			},
+		},
+		{
+			Name:   "audit-permissions",
+			Usage:  "list the permissions that do not conform to the permission catalog",
+			Action: commands.AuditPermissions,
+			Before: validate.ValidateAuditArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "dictyuser-pass",
+					EnvVar: "DICTYUSER_PASSWORD",
+					Usage:  "dictyuser database password",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-db",
+					EnvVar: "DICTYUSER_DB",
+					Usage:  "dictyuser database name",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-user",
+					EnvVar: "DICTYUSER_USER",
+					Usage:  "dictyuser database user",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-host",
+					Value:  "dictycontent-backend",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_HOST",
+					Usage:  "dictyuser database host",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-port",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_PORT",
+					Usage:  "dictyuser database port",
+				},
+				cli.StringFlag{
+					Name:   "permission-catalog",
+					EnvVar: "PERMISSION_CATALOG",
+					Usage:  "yaml file with the catalog to audit against, by default the registered catalog is used",
+				},
+			},
+		},
+		{
+			Name:   "start-event-relay",
+			Usage:  "publishes the domain events stored in the outbox to the messaging server",
+			Action: commands.RunEventRelay,
+			Before: validate.ValidateRelayArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "dictyuser-pass",
+					EnvVar: "DICTYUSER_PASSWORD",
+					Usage:  "dictyuser database password",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-db",
+					EnvVar: "DICTYUSER_DB",
+					Usage:  "dictyuser database name",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-user",
+					EnvVar: "DICTYUSER_USER",
+					Usage:  "dictyuser database user",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-host",
+					Value:  "dictycontent-backend",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_HOST",
+					Usage:  "dictyuser database host",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-port",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_PORT",
+					Usage:  "dictyuser database port",
+				},
+				cli.StringFlag{
+					Name:   "messaging-host",
+					EnvVar: "NATS_SERVICE_HOST",
+					Usage:  "host address for messaging server",
+				},
+				cli.StringFlag{
+					Name:   "messaging-port",
+					EnvVar: "NATS_SERVICE_PORT",
+					Usage:  "port for messaging server",
+				},
+				cli.StringFlag{
+					Name:   "event-subject-prefix",
+					EnvVar: "EVENT_SUBJECT_PREFIX",
+					Usage:  "prefix of the subjects the domain events are published on",
+					Value:  "UserEvents",
+				},
+				cli.IntFlag{
+					Name:   "relay-batch-size",
+					EnvVar: "RELAY_BATCH_SIZE",
+					Usage:  "number of events published at once",
+					Value:  100,
+				},
+				cli.DurationFlag{
+					Name:   "relay-poll-interval",
+					EnvVar: "RELAY_POLL_INTERVAL",
+					Usage:  "how long to wait for new events once all of them are published",
+					Value:  time.Second,
+				},
+				cli.DurationFlag{
+					Name:   "relay-min-backoff",
+					EnvVar: "RELAY_MIN_BACKOFF",
+					Usage:  "delay before retrying a failed delivery, it doubles with every failure",
+					Value:  time.Second,
+				},
+				cli.DurationFlag{
+					Name:   "relay-max-backoff",
+					EnvVar: "RELAY_MAX_BACKOFF",
+					Usage:  "longest delay between the retries of a failed delivery",
+					Value:  time.Minute,
+				},
+				cli.StringFlag{
+					Name:  "port",
+					Usage: "http port serving the relay metrics",
+					Value: "9598",
+				},
+			},
+		},
+		{
+			Name:   "start-webhook-dispatcher",
+			Usage:  "sends the queued webhook deliveries",
+			Action: commands.RunWebhookDispatcher,
+			Before: validate.ValidateWebhookArgs,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "dictyuser-pass",
+					EnvVar: "DICTYUSER_PASSWORD",
+					Usage:  "dictyuser database password",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-db",
+					EnvVar: "DICTYUSER_DB",
+					Usage:  "dictyuser database name",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-user",
+					EnvVar: "DICTYUSER_USER",
+					Usage:  "dictyuser database user",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-host",
+					Value:  "dictycontent-backend",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_HOST",
+					Usage:  "dictyuser database host",
+				},
+				cli.StringFlag{
+					Name:   "dictyuser-port",
+					EnvVar: "DICTYCONTENT_BACKEND_SERVICE_PORT",
+					Usage:  "dictyuser database port",
+				},
+				cli.IntFlag{
+					Name:   "webhook-batch-size",
+					EnvVar: "WEBHOOK_BATCH_SIZE",
+					Usage:  "number of deliveries sent at once",
+					Value:  20,
+				},
+				cli.DurationFlag{
+					Name:   "webhook-poll-interval",
+					EnvVar: "WEBHOOK_POLL_INTERVAL",
+					Usage:  "how long to wait once no delivery is due",
+					Value:  5 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:   "webhook-timeout",
+					EnvVar: "WEBHOOK_TIMEOUT",
+					Usage:  "how long a webhook has to respond",
+					Value:  10 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:   "webhook-min-backoff",
+					EnvVar: "WEBHOOK_MIN_BACKOFF",
+					Usage:  "delay before retrying a failed delivery, it doubles with every failure",
+					Value:  30 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:   "webhook-max-backoff",
+					EnvVar: "WEBHOOK_MAX_BACKOFF",
+					Usage:  "longest delay between the retries of a failed delivery",
+					Value:  time.Hour,
+				},
+				cli.IntFlag{
+					Name:   "webhook-max-attempts",
+					EnvVar: "WEBHOOK_MAX_ATTEMPTS",
+					Usage:  "number of attempts after which a delivery has failed",
+					Value:  10,
+				},
+				cli.DurationFlag{
+					Name:   "webhook-lease",
+					EnvVar: "WEBHOOK_LEASE",
+					Usage:  "how long a batch is held by the dispatcher sending it, it has to cover the timeouts of the whole batch",
+					Value:  5 * time.Minute,
+				},
 			},
 		},
 		{
@@ -241,6 +816,85 @@ func main() {
 					Usage: "tcp port at which the user server will be available",
 					Value: "9596",
 				},
+				cli.IntFlag{
+					Name:   "permission-cache-size",
+					EnvVar: "PERMISSION_CACHE_SIZE",
+					Usage:  "number of user permission grants kept in memory, 0 disables the cache, it requires the messaging server that broadcasts the invalidations",
+					Value:  10000,
+				},
+				cli.DurationFlag{
+					Name:   "permission-cache-ttl",
+					EnvVar: "PERMISSION_CACHE_TTL",
+					Usage:  "duration for which the cached permission grants are used",
+					Value:  5 * time.Minute,
+				},
+				cli.StringFlag{
+					Name:   "messaging-host",
+					EnvVar: "NATS_SERVICE_HOST",
+					Usage:  "host address for messaging server, permission cache invalidations are neither broadcast nor received if it is absent",
+				},
+				cli.StringFlag{
+					Name:   "messaging-port",
+					EnvVar: "NATS_SERVICE_PORT",
+					Usage:  "port for messaging server",
+				},
+				cli.StringFlag{
+					Name:   "auth-jwks-url",
+					EnvVar: "AUTH_JWKS_URL",
+					Usage:  "url of the json web key set that verifies the bearer tokens",
+				},
+				cli.StringSliceFlag{
+					Name:   "auth-key-file",
+					EnvVar: "AUTH_KEY_FILE",
+					Usage:  "file with PEM encoded public keys or a json web key set that verify the bearer tokens, can be repeated",
+				},
+				cli.StringFlag{
+					Name:   "auth-hmac-secret",
+					EnvVar: "AUTH_HMAC_SECRET",
+					Usage:  "shared secret that verifies HMAC signed bearer tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-issuer",
+					EnvVar: "AUTH_ISSUER",
+					Usage:  "required issuer of the bearer tokens, also the issuer of the issued tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-audience",
+					EnvVar: "AUTH_AUDIENCE",
+					Usage:  "required audience of the bearer tokens, also the audience of the issued tokens",
+				},
+				cli.StringFlag{
+					Name:   "auth-policy",
+					EnvVar: "AUTH_POLICY",
+					Usage:  "yaml file mapping the methods to the permissions they require",
+				},
+				cli.BoolFlag{
+					Name:   "auth-public-reads",
+					EnvVar: "AUTH_PUBLIC_READS",
+					Usage:  "allow the methods requiring the read permission to be called without a token",
+				},
+				cli.BoolFlag{
+					Name:   "insecure-no-auth",
+					EnvVar: "INSECURE_NO_AUTH",
+					Usage:  "serve the calls without authentication when no token key is configured, only for local development",
+				},
+				cli.StringSliceFlag{
+					Name:   "token-signing-key",
+					EnvVar: "TOKEN_SIGNING_KEY",
+					Usage:  "file with a PEM encoded RSA or EC private key that signs the issued tokens, can be repeated, the first key signs while the others are only published, the files are reloaded on SIGHUP",
+				},
+				cli.DurationFlag{
+					Name:   "token-ttl",
+					EnvVar: "TOKEN_TTL",
+					Usage:  "lifetime of the issued tokens",
+					Value:  time.Hour,
+				},
+				cli.DurationFlag{
+					Name:   "impersonation-max-ttl",
+					EnvVar: "IMPERSONATION_MAX_TTL",
+					Usage:  "longest lifetime of an impersonation",
+					Value:  time.Hour,
+				},
 			},
 		},
 	}
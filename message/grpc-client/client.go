@@ -7,6 +7,8 @@ import (
 	"github.com/dictyBase/go-genproto/dictybaseapis/user"
 	"github.com/dictyBase/modware-user/message"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type grpcUserClient struct {
@@ -19,19 +21,117 @@ func NewUserClient(conn *grpc.ClientConn) message.UserClient {
 	}
 }
 
-func (g *grpcUserClient) Get(id int64) (*user.User, error) {
-	return g.client.GetUser(context.Background(), &jsonapi.GetRequest{Id: id})
+func (g *grpcUserClient) Get(ctx context.Context, id int64) (*user.User, error) {
+	return g.client.GetUser(ctx, &jsonapi.GetRequest{Id: id})
 }
 
-func (g *grpcUserClient) Delete(id int64) (bool, error) {
-	_, err := g.client.DeleteUser(context.Background(), &jsonapi.DeleteRequest{Id: id})
+func (g *grpcUserClient) Delete(ctx context.Context, id int64) (bool, error) {
+	_, err := g.client.DeleteUser(ctx, &jsonapi.DeleteRequest{Id: id})
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-func (g *grpcUserClient) Exist(id int64) (bool, error) {
-	resp, err := g.client.ExistUser(context.Background(), &jsonapi.IdRequest{Id: id})
-	return resp.Exist, err
+func (g *grpcUserClient) Exist(ctx context.Context, id int64) (bool, error) {
+	resp, err := g.client.ExistUser(ctx, &jsonapi.IdRequest{Id: id})
+	return resp.GetExist(), err
+}
+
+func (g *grpcUserClient) GetByEmail(ctx context.Context, email string) (*user.User, error) {
+	return g.client.GetUserByEmail(ctx, &jsonapi.GetEmailRequest{Email: email})
+}
+
+func (g *grpcUserClient) Roles(ctx context.Context, id int64) (*user.RoleCollection, error) {
+	return g.client.GetRelatedRoles(ctx, &jsonapi.RelationshipRequest{Id: id})
+}
+
+// GetMany fetches the users one at a time, the missing ones are left out
+func (g *grpcUserClient) GetMany(ctx context.Context, ids []int64) (*user.UserCollection, error) {
+	coll := &user.UserCollection{}
+	for _, id := range ids {
+		u, err := g.client.GetUser(ctx, &jsonapi.GetRequest{Id: id})
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				continue
+			}
+			return coll, err
+		}
+		coll.Data = append(coll.Data, u.Data)
+	}
+	return coll, nil
+}
+
+func (g *grpcUserClient) List(ctx context.Context, req *jsonapi.ListRequest) (*user.UserCollection, error) {
+	return g.client.ListUsers(ctx, req)
+}
+
+type grpcRoleClient struct {
+	client user.RoleServiceClient
+}
+
+func NewRoleClient(conn *grpc.ClientConn) message.RoleClient {
+	return &grpcRoleClient{
+		client: user.NewRoleServiceClient(conn),
+	}
+}
+
+func (g *grpcRoleClient) Get(ctx context.Context, id int64) (*user.Role, error) {
+	return g.client.GetRole(ctx, &jsonapi.GetRequest{Id: id})
+}
+
+// Exist tells whether the role is present, the role service has no
+// dedicated call for it
+func (g *grpcRoleClient) Exist(ctx context.Context, id int64) (bool, error) {
+	_, err := g.Get(ctx, id)
+	return found(err)
+}
+
+func (g *grpcRoleClient) Users(ctx context.Context, req *jsonapi.RelationshipRequestWithPagination) (*user.UserCollection, error) {
+	return g.client.GetRelatedUsers(ctx, req)
+}
+
+func (g *grpcRoleClient) Permissions(ctx context.Context, id int64) (*user.PermissionCollection, error) {
+	return g.client.GetRelatedPermissions(ctx, &jsonapi.RelationshipRequest{Id: id})
+}
+
+func (g *grpcRoleClient) List(ctx context.Context, req *jsonapi.SimpleListRequest) (*user.RoleCollection, error) {
+	return g.client.ListRoles(ctx, req)
+}
+
+type grpcPermissionClient struct {
+	client user.PermissionServiceClient
+}
+
+func NewPermissionClient(conn *grpc.ClientConn) message.PermissionClient {
+	return &grpcPermissionClient{
+		client: user.NewPermissionServiceClient(conn),
+	}
+}
+
+func (g *grpcPermissionClient) Get(ctx context.Context, id int64) (*user.Permission, error) {
+	return g.client.GetPermission(ctx, &jsonapi.GetRequestWithFields{Id: id})
+}
+
+// Exist tells whether the permission is present, the permission service
+// has no dedicated call for it
+func (g *grpcPermissionClient) Exist(ctx context.Context, id int64) (bool, error) {
+	_, err := g.Get(ctx, id)
+	return found(err)
+}
+
+func (g *grpcPermissionClient) List(ctx context.Context, req *jsonapi.SimpleListRequest) (*user.PermissionCollection, error) {
+	return g.client.ListPermissions(ctx, req)
+}
+
+// found turns the error of a lookup into the existence of the resource
+func found(err error) (bool, error) {
+	switch {
+	case err == nil:
+		return true, nil
+	case status.Code(err) == codes.NotFound:
+		return false, nil
+	default:
+		return false, err
+	}
 }
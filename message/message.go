@@ -1,20 +1,95 @@
 package message
 
 import (
-	"github.com/dictyBase/go-genproto/dictybaseapis/pubsub"
+	"context"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
 	"github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/golang/protobuf/proto"
 )
 
+// UserClient, RoleClient and PermissionClient look up the resources of the
+// microservices, the calls are bound to the deadline of the context
 type UserClient interface {
-	Get(int64) (*user.User, error)
-	Delete(int64) (bool, error)
-	Exist(int64) (bool, error)
+	Get(context.Context, int64) (*user.User, error)
+	Delete(context.Context, int64) (bool, error)
+	Exist(context.Context, int64) (bool, error)
+	GetByEmail(context.Context, string) (*user.User, error)
+	// Roles returns the roles assigned to the user
+	Roles(context.Context, int64) (*user.RoleCollection, error)
+	// GetMany returns the existing users among the given ones, in their
+	// order
+	GetMany(context.Context, []int64) (*user.UserCollection, error)
+	List(context.Context, *jsonapi.ListRequest) (*user.UserCollection, error)
 }
 
-type ReplyFn func(string, UserClient, *pubsub.IdRequest) *pubsub.UserReply
+type RoleClient interface {
+	Get(context.Context, int64) (*user.Role, error)
+	Exist(context.Context, int64) (bool, error)
+	// Users returns a page of the users assigned to the role
+	Users(context.Context, *jsonapi.RelationshipRequestWithPagination) (*user.UserCollection, error)
+	// Permissions returns the permissions granted to the role
+	Permissions(context.Context, int64) (*user.PermissionCollection, error)
+	List(context.Context, *jsonapi.SimpleListRequest) (*user.RoleCollection, error)
+}
 
+type PermissionClient interface {
+	Get(context.Context, int64) (*user.Permission, error)
+	Exist(context.Context, int64) (bool, error)
+	List(context.Context, *jsonapi.SimpleListRequest) (*user.PermissionCollection, error)
+}
+
+// Reply answers the requests made on the subjects matching a pattern with
+// the handlers of a registry
 type Reply interface {
-	Publish(string, *pubsub.UserReply)
-	Start(string, UserClient, ReplyFn) error
+	Publish(string, proto.Message)
+	Start(string, *Registry) error
 	Stop() error
 }
+
+// Publisher publishes events to the messaging backend
+type Publisher interface {
+	Publish(string, interface{}) error
+	Close() error
+}
+
+// Flusher is implemented by the publishers that buffer the events, Flush
+// returns once the buffered events reached the messaging server
+type Flusher interface {
+	Flush() error
+}
+
+type nullPublisher struct{}
+
+// NewNullPublisher returns a Publisher that discards all events, it is used
+// when no messaging backend is configured
+func NewNullPublisher() Publisher {
+	return &nullPublisher{}
+}
+
+func (n *nullPublisher) Publish(subj string, v interface{}) error {
+	return nil
+}
+
+func (n *nullPublisher) Close() error {
+	return nil
+}
+
+// Subscriber receives the events published to a subject
+type Subscriber interface {
+	Subscribe(string, func([]byte)) error
+	Close() error
+}
+
+// CacheInvalidationSubject is the subject of the messages that ask every
+// replica to drop cached permissions
+const CacheInvalidationSubject = "AuthorizationCache.Invalidate"
+
+// CacheInvalidation lists the users whose cached permissions are stale, or
+// tells that all of them are stale. Service accounts are listed with their
+// negated ids. Origin identifies the replica that sent the message.
+type CacheInvalidation struct {
+	Origin string  `json:"origin"`
+	Users  []int64 `json:"users,omitempty"`
+	All    bool    `json:"all,omitempty"`
+}
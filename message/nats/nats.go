@@ -1,20 +1,70 @@
 package nats
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
-	"github.com/dictyBase/go-genproto/dictybaseapis/pubsub"
 	"github.com/dictyBase/modware-user/message"
+	"github.com/golang/protobuf/proto"
 	gnats "github.com/nats-io/go-nats"
 	"github.com/nats-io/go-nats/encoders/protobuf"
 )
 
+const (
+	// DefaultReplyWorkers is the number of requests handled at once
+	DefaultReplyWorkers = 10
+	// DefaultReplyMaxPending is the number of received requests that can
+	// wait for a worker
+	DefaultReplyMaxPending = 1000
+	// DefaultReplyTimeout is how long a request has to be answered
+	DefaultReplyTimeout = 10 * time.Second
+)
+
+// ReplyConfig tells how the requests are received and handled.
+//
+// The replies that share a non empty Queue form a queue group, each request
+// is answered by a single member of the group. The requests are handled by
+// up to Workers goroutines. Once all of them are busy the requests wait in
+// the subscription, at most MaxPending of them, the others are dropped and
+// their requesters time out. A request has Timeout from its receipt to be
+// answered, the deadline bounds the calls made by its handler.
+type ReplyConfig struct {
+	Queue      string
+	Workers    int
+	MaxPending int
+	Timeout    time.Duration
+}
+
+// DefaultReplyConfig returns a configuration without queue group
+func DefaultReplyConfig() *ReplyConfig {
+	return &ReplyConfig{
+		Workers:    DefaultReplyWorkers,
+		MaxPending: DefaultReplyMaxPending,
+		Timeout:    DefaultReplyTimeout,
+	}
+}
+
 type natsReply struct {
-	econn *gnats.EncodedConn
-	sub   *gnats.Subscription
+	econn  *gnats.EncodedConn
+	sub    *gnats.Subscription
+	config *ReplyConfig
+	slots  chan struct{}
+	// mu guards stopped, no request is dispatched once the reply stopped
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
 }
 
+// NewReply returns a Reply with the default configuration
 func NewReply(host, port string, options ...gnats.Option) (message.Reply, error) {
+	return NewReplyWithConfig(host, port, DefaultReplyConfig(), options...)
+}
+
+// NewReplyWithConfig returns a Reply that receives and handles the requests
+// as configured
+func NewReplyWithConfig(host, port string, config *ReplyConfig, options ...gnats.Option) (message.Reply, error) {
 	nc, err := gnats.Connect(fmt.Sprintf("nats://%s:%s", host, port), options...)
 	if err != nil {
 		return &natsReply{}, err
@@ -23,20 +73,35 @@ func NewReply(host, port string, options ...gnats.Option) (message.Reply, error)
 	if err != nil {
 		return &natsReply{}, err
 	}
-	return &natsReply{econn: ec}, nil
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	return &natsReply{
+		econn:  ec,
+		config: config,
+		slots:  make(chan struct{}, workers),
+	}, nil
 }
 
-func (n *natsReply) Publish(subj string, urep *pubsub.UserReply) {
-	n.econn.Publish(subj, urep)
+func (n *natsReply) Publish(subj string, rep proto.Message) {
+	n.econn.Publish(subj, rep)
 }
 
-func (n *natsReply) Start(subj string, client message.UserClient, replyFn message.ReplyFn) error {
-	sub, err := n.econn.Subscribe(subj, func(s, rep string, req *pubsub.IdRequest) {
-		n.Publish(rep, replyFn(s, client, req))
+// Start answers the requests on the subjects matching the pattern with the
+// handlers of the registry
+func (n *natsReply) Start(subj string, reg *message.Registry) error {
+	sub, err := n.econn.QueueSubscribe(subj, n.config.Queue, func(m *gnats.Msg) {
+		n.dispatch(m, reg)
 	})
 	if err != nil {
 		return err
 	}
+	if n.config.MaxPending > 0 {
+		if err := sub.SetPendingLimits(n.config.MaxPending, gnats.DefaultSubPendingBytesLimit); err != nil {
+			return err
+		}
+	}
 	if err := n.econn.Flush(); err != nil {
 		return err
 	}
@@ -47,10 +112,51 @@ func (n *natsReply) Start(subj string, client message.UserClient, replyFn messag
 	return nil
 }
 
+// Stop stops receiving requests and waits for the ones being handled to be
+// answered
 func (n *natsReply) Stop() error {
 	if n.sub != nil {
 		n.sub.Unsubscribe()
 	}
+	n.mu.Lock()
+	n.stopped = true
+	n.mu.Unlock()
+	n.wg.Wait()
 	n.econn.Close()
 	return nil
 }
+
+// dispatch hands over the request to a worker. It blocks until a worker is
+// free, so that the requests pile up in the subscription meanwhile. A
+// request whose deadline passes while waiting is answered with a failure.
+func (n *natsReply) dispatch(m *gnats.Msg, reg *message.Registry) {
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	n.wg.Add(1)
+	n.mu.Unlock()
+	ctx, cancel := n.requestContext()
+	select {
+	case n.slots <- struct{}{}:
+	case <-ctx.Done():
+		n.Publish(m.Reply, reg.Handle(ctx, m.Subject, m.Data))
+		cancel()
+		n.wg.Done()
+		return
+	}
+	go func() {
+		defer n.wg.Done()
+		defer func() { <-n.slots }()
+		defer cancel()
+		n.Publish(m.Reply, reg.Handle(ctx, m.Subject, m.Data))
+	}()
+}
+
+func (n *natsReply) requestContext() (context.Context, context.CancelFunc) {
+	if n.config.Timeout > 0 {
+		return context.WithTimeout(context.Background(), n.config.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,11 +18,14 @@ import (
 
 	"github.com/nats-io/go-nats/encoders/protobuf"
 
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
 	"github.com/dictyBase/go-genproto/dictybaseapis/pubsub"
 	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
 	"github.com/dictyBase/modware-user/message"
 	gclient "github.com/dictyBase/modware-user/message/grpc-client"
+	"github.com/dictyBase/modware-user/message/reply"
 	"github.com/dictyBase/modware-user/testutils"
+	"github.com/golang/protobuf/proto"
 	_ "github.com/jackc/pgx/stdlib"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -40,6 +45,8 @@ func runGRPCServer(db *sql.DB) {
 	dbh := runner.NewDB(db, "postgres")
 	grpcS := grpc.NewServer()
 	pb.RegisterUserServiceServer(grpcS, server.NewUserService(dbh))
+	pb.RegisterRoleServiceServer(grpcS, server.NewRoleService(dbh))
+	pb.RegisterPermissionServiceServer(grpcS, server.NewPermissionService(dbh))
 	lis, err := net.Listen("tcp", grpcPort)
 	if err != nil {
 		log.Fatalf("error listening to grpc port %s", err)
@@ -102,50 +109,57 @@ func newNatsRequest(host, port string) (*gnats.EncodedConn, error) {
 	return enc, nil
 }
 
-func replyUser(subj string, c message.UserClient, req *pubsub.IdRequest) *pubsub.UserReply {
-	switch subj {
-	case "UserService.Get":
-		u, err := c.Get(req.Id)
-		if err != nil {
-			st, _ := status.FromError(err)
-			return &pubsub.UserReply{
-				Status: st.Proto(),
-				Exist:  false,
-			}
-		}
-		return &pubsub.UserReply{
-			Exist: true,
-			User:  u,
-		}
-	case "UserService.Exist":
-		exist, err := c.Exist(req.Id)
-		if err != nil {
-			st, _ := status.FromError(err)
-			return &pubsub.UserReply{
-				Status: st.Proto(),
-				Exist:  exist,
-			}
-		}
-		return &pubsub.UserReply{
-			Exist: exist,
-		}
-	case "UserService.Delete":
-		deleted, err := c.Delete(req.Id)
-		if err != nil {
-			st, _ := status.FromError(err)
-			return &pubsub.UserReply{
-				Status: st.Proto(),
-				Exist:  deleted,
-			}
-		}
-		return &pubsub.UserReply{
-			Exist: deleted,
-		}
-	default:
-		return &pubsub.UserReply{
-			Status: status.Newf(codes.Internal, "subject %s is not supported", subj).Proto(),
-		}
+func NewRoleWithUser(role string, u *pb.User) *pb.CreateRoleRequest {
+	return &pb.CreateRoleRequest{
+		Data: &pb.CreateRoleRequest_Data{
+			Type: "roles",
+			Attributes: &pb.RoleAttributes{
+				Role:        role,
+				Description: fmt.Sprintf("Ability to do %s", role),
+			},
+			Relationships: &pb.NewRoleRelationships{
+				Users: &pb.NewRoleRelationships_Users{
+					Data: []*jsonapi.Data{{Id: u.Data.Id, Type: u.Data.Type}},
+				},
+			},
+		},
+	}
+}
+
+func NewPermission(perm, resource string) *pb.CreatePermissionRequest {
+	return &pb.CreatePermissionRequest{
+		Data: &pb.CreatePermissionRequest_Data{
+			Type: "permissions",
+			Attributes: &pb.PermissionAttributes{
+				Permission:  perm,
+				Description: fmt.Sprintf("Ability to %s %s", perm, resource),
+				Resource:    resource,
+			},
+		},
+	}
+}
+
+// startUserReply starts answering the UserService subjects and returns the
+// connection the requests are made with
+func startUserReply(t *testing.T, conn *grpc.ClientConn) (message.Reply, *gnats.EncodedConn) {
+	return startReply(t, "UserService.*", message.NewUserRegistry(gclient.NewUserClient(conn)))
+}
+
+// startReply starts answering the subjects with the registry and returns
+// the connection the requests are made with
+func startReply(t *testing.T, subj string, reg *message.Registry) (message.Reply, *gnats.EncodedConn) {
+	req, err := newNatsRequest(natsHost, natsPort)
+	if err != nil {
+		t.Fatalf("cannot connect to nats %s\n", err)
+	}
+	reply, err := NewReply(natsHost, natsPort)
+	if err != nil {
+		t.Fatalf("could not connect to nats server %s\n", err)
 	}
+	if err := reply.Start(subj, reg); err != nil {
+		t.Fatalf("could not start nats reply subscription %s", err)
+	}
+	return reply, req
 }
 
 func TestUserGetReply(t *testing.T) {
@@ -172,8 +186,7 @@ func TestUserGetReply(t *testing.T) {
 		t.Fatalf("could not connect to nats server %s\n", err)
 	}
 	defer reply.Stop()
-	nclient := gclient.NewUserClient(conn)
-	err = reply.Start("UserService.*", nclient, replyUser)
+	err = reply.Start("UserService.*", message.NewUserRegistry(gclient.NewUserClient(conn)))
 	if err != nil {
 		t.Fatalf("could not start nats reply subscription %s", err)
 	}
@@ -220,8 +233,7 @@ func TestUserExistReply(t *testing.T) {
 		t.Fatalf("could not connect to nats server %s\n", err)
 	}
 	defer reply.Stop()
-	nclient := gclient.NewUserClient(conn)
-	err = reply.Start("UserService.*", nclient, replyUser)
+	err = reply.Start("UserService.*", message.NewUserRegistry(gclient.NewUserClient(conn)))
 	if err != nil {
 		t.Fatalf("could not start nats reply subscription %s", err)
 	}
@@ -259,8 +271,7 @@ func TestUserDeleteReply(t *testing.T) {
 		t.Fatalf("could not connect to nats server %s\n", err)
 	}
 	defer reply.Stop()
-	nclient := gclient.NewUserClient(conn)
-	err = reply.Start("UserService.*", nclient, replyUser)
+	err = reply.Start("UserService.*", message.NewUserRegistry(gclient.NewUserClient(conn)))
 	if err != nil {
 		t.Fatalf("could not start nats reply subscription %s", err)
 	}
@@ -275,3 +286,440 @@ func TestUserDeleteReply(t *testing.T) {
 		t.Fatalf("error in delete user %s", status.ErrorProto(ruser.Status))
 	}
 }
+
+func TestUserGetByEmailReply(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+grpcPort, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+	nuser, err := pb.NewUserServiceClient(conn).CreateUser(context.Background(), NewUser("jerry@seinfeld.org"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	rs, req := startUserReply(t, conn)
+	defer rs.Stop()
+	defer req.Close()
+	ruser := &pubsub.UserReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"UserService.GetByEmail",
+		&jsonapi.GetEmailRequest{Email: "jerry@seinfeld.org"},
+		ruser,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !ruser.Exist || ruser.User.Data.Id != nuser.Data.Id {
+		t.Fatalf("expected user %d, received %v %s", nuser.Data.Id, ruser.User, status.ErrorProto(ruser.Status))
+	}
+	missing := &pubsub.UserReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"UserService.GetByEmail",
+		&jsonapi.GetEmailRequest{Email: "newman@seinfeld.org"},
+		missing,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if missing.Exist || codes.Code(missing.Status.GetCode()) != codes.NotFound {
+		t.Fatalf("expected a missing user, received %v", missing)
+	}
+}
+
+func TestUserRolesReply(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+grpcPort, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+	nuser, err := pb.NewUserServiceClient(conn).CreateUser(context.Background(), NewUser("elaine@benes.org"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(context.Background(), NewRoleWithUser("editor", nuser))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	rs, req := startUserReply(t, conn)
+	defer rs.Stop()
+	defer req.Close()
+	rroles := &reply.RoleReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"UserService.Roles",
+		&pubsub.IdRequest{Id: nuser.Data.Id},
+		rroles,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rroles.Exist {
+		t.Fatalf("error in fetching the roles %s", status.ErrorProto(rroles.Status))
+	}
+	if len(rroles.Roles.Data) != 1 || rroles.Roles.Data[0].Id != role.Data.Id {
+		t.Fatalf("expected the role %d, received %v", role.Data.Id, rroles.Roles.Data)
+	}
+}
+
+func TestUserGetManyReply(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+grpcPort, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+	client := pb.NewUserServiceClient(conn)
+	var ids []int64
+	for _, email := range []string{"george@costanza.org", "frank@costanza.org"} {
+		nuser, err := client.CreateUser(context.Background(), NewUser(email))
+		if err != nil {
+			t.Fatalf("could not store the user %s\n", err)
+		}
+		ids = append(ids, nuser.Data.Id)
+	}
+	rs, req := startUserReply(t, conn)
+	defer rs.Stop()
+	defer req.Close()
+	rusers := &pubsub.UserReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"UserService.GetMany",
+		&reply.IdsRequest{Ids: append(ids, 10000)},
+		rusers,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if rusers.Exist || rusers.Status != nil {
+		t.Fatalf("expected a missing user without error, received %v", rusers)
+	}
+	if len(rusers.Users.Data) != 2 || rusers.Users.Data[0].Id != ids[0] || rusers.Users.Data[1].Id != ids[1] {
+		t.Fatalf("expected the users %v in order, received %v", ids, rusers.Users.Data)
+	}
+	toomany := &pubsub.UserReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"UserService.GetMany",
+		&reply.IdsRequest{Ids: make([]int64, message.MaxGetMany+1)},
+		toomany,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if codes.Code(toomany.Status.GetCode()) != codes.InvalidArgument {
+		t.Fatalf("expected too many ids to be rejected, received %v", toomany)
+	}
+}
+
+func TestUserListReply(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+grpcPort, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+	client := pb.NewUserServiceClient(conn)
+	for _, email := range []string{"cosmo@kramer.org", "babs@kramer.org", "david@puddy.org"} {
+		if _, err := client.CreateUser(context.Background(), NewUser(email)); err != nil {
+			t.Fatalf("could not store the user %s\n", err)
+		}
+	}
+	rs, req := startUserReply(t, conn)
+	defer rs.Stop()
+	defer req.Close()
+	rusers := &pubsub.UserReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"UserService.List",
+		&jsonapi.ListRequest{Filter: "email=@kramer"},
+		rusers,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rusers.Exist {
+		t.Fatalf("error in listing users %s", status.ErrorProto(rusers.Status))
+	}
+	if len(rusers.Users.Data) != 2 {
+		t.Fatalf("expected two users, received %d", len(rusers.Users.Data))
+	}
+	unknown := &pubsub.UserReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"UserService.Rename",
+		&pubsub.IdRequest{Id: 1},
+		unknown,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if unknown.Status == nil || unknown.Exist {
+		t.Fatalf("expected an unsupported subject to fail, received %v", unknown)
+	}
+}
+
+func TestRoleReply(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+grpcPort, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+	nuser, err := pb.NewUserServiceClient(conn).CreateUser(context.Background(), NewUser("jackie@chiles.org"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(context.Background(), NewPermission("edit", "users"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	nr := NewRoleWithUser("lawyer", nuser)
+	nr.Data.Relationships.Permissions = &pb.NewRoleRelationships_Permissions{
+		Data: []*jsonapi.Data{{Id: perm.Data.Id, Type: perm.Data.Type}},
+	}
+	rclient := pb.NewRoleServiceClient(conn)
+	role, err := rclient.CreateRole(context.Background(), nr)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	if _, err := rclient.CreateRole(context.Background(), NewRoleWithUser("client", nuser)); err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	rs, req := startReply(t, "RoleService.*", message.NewRoleRegistry(gclient.NewRoleClient(conn)))
+	defer rs.Stop()
+	defer req.Close()
+
+	rrole := &reply.RoleReply{}
+	err = req.RequestWithContext(context.Background(), "RoleService.Get", &pubsub.IdRequest{Id: role.Data.Id}, rrole)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rrole.Exist || rrole.Role.Data.Attributes.Role != "lawyer" {
+		t.Fatalf("expected the role %d, received %v %s", role.Data.Id, rrole.Role, status.ErrorProto(rrole.Status))
+	}
+	missing := &reply.RoleReply{}
+	err = req.RequestWithContext(context.Background(), "RoleService.Exist", &pubsub.IdRequest{Id: 10000}, missing)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if missing.Exist || missing.Status != nil {
+		t.Fatalf("expected a missing role without error, received %v", missing)
+	}
+	rusers := &pubsub.UserReply{}
+	err = req.RequestWithContext(
+		context.Background(),
+		"RoleService.Users",
+		&jsonapi.RelationshipRequestWithPagination{Id: role.Data.Id},
+		rusers,
+	)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rusers.Exist || len(rusers.Users.Data) != 1 || rusers.Users.Data[0].Id != nuser.Data.Id {
+		t.Fatalf("expected the user %d, received %v %s", nuser.Data.Id, rusers.Users, status.ErrorProto(rusers.Status))
+	}
+	rperms := &reply.PermissionReply{}
+	err = req.RequestWithContext(context.Background(), "RoleService.Permissions", &pubsub.IdRequest{Id: role.Data.Id}, rperms)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rperms.Exist || len(rperms.Permissions.Data) != 1 || rperms.Permissions.Data[0].Id != perm.Data.Id {
+		t.Fatalf("expected the permission %d, received %v %s", perm.Data.Id, rperms.Permissions, status.ErrorProto(rperms.Status))
+	}
+	rroles := &reply.RoleReply{}
+	err = req.RequestWithContext(context.Background(), "RoleService.List", &jsonapi.SimpleListRequest{}, rroles)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rroles.Exist || len(rroles.Roles.Data) != 2 {
+		t.Fatalf("expected two roles, received %v %s", rroles.Roles, status.ErrorProto(rroles.Status))
+	}
+}
+
+func TestPermissionReply(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+grpcPort, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+	client := pb.NewPermissionServiceClient(conn)
+	perm, err := client.CreatePermission(context.Background(), NewPermission("read", "roles"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	if _, err := client.CreatePermission(context.Background(), NewPermission("write", "roles")); err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	rs, req := startReply(t, "PermissionService.*", message.NewPermissionRegistry(gclient.NewPermissionClient(conn)))
+	defer rs.Stop()
+	defer req.Close()
+
+	rperm := &reply.PermissionReply{}
+	err = req.RequestWithContext(context.Background(), "PermissionService.Get", &pubsub.IdRequest{Id: perm.Data.Id}, rperm)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rperm.Exist || rperm.Permission.Data.Attributes.Permission != "read" {
+		t.Fatalf("expected the permission %d, received %v %s", perm.Data.Id, rperm.Permission, status.ErrorProto(rperm.Status))
+	}
+	missing := &reply.PermissionReply{}
+	err = req.RequestWithContext(context.Background(), "PermissionService.Get", &pubsub.IdRequest{Id: 10000}, missing)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if missing.Exist || codes.Code(missing.Status.GetCode()) != codes.NotFound {
+		t.Fatalf("expected a missing permission, received %v", missing)
+	}
+	exist := &reply.PermissionReply{}
+	err = req.RequestWithContext(context.Background(), "PermissionService.Exist", &pubsub.IdRequest{Id: perm.Data.Id}, exist)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !exist.Exist {
+		t.Fatalf("error in checking existence of permission %s", status.ErrorProto(exist.Status))
+	}
+	rperms := &reply.PermissionReply{}
+	err = req.RequestWithContext(context.Background(), "PermissionService.List", &jsonapi.SimpleListRequest{}, rperms)
+	if err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if !rperms.Exist || len(rperms.Permissions.Data) != 2 {
+		t.Fatalf("expected two permissions, received %v %s", rperms.Permissions, status.ErrorProto(rperms.Status))
+	}
+}
+
+// newCountingRegistry answers UserService.Get with the id of the request
+// after calling fn with the deadline of the request
+func newCountingRegistry(fn func(context.Context)) *message.Registry {
+	return message.NewRegistry(func(st *status.Status) proto.Message {
+		return &pubsub.UserReply{Status: st.Proto()}
+	}).Register("UserService.Get", &message.Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			fn(ctx)
+			if err := ctx.Err(); err != nil {
+				return &pubsub.UserReply{Status: status.FromContextError(err).Proto()}
+			}
+			return &pubsub.UserReply{
+				Exist: true,
+				User:  &pb.User{Data: &pb.UserData{Id: req.(*pubsub.IdRequest).Id}},
+			}
+		},
+	})
+}
+
+func TestReplyQueueGroup(t *testing.T) {
+	var handled int64
+	reg := newCountingRegistry(func(context.Context) { atomic.AddInt64(&handled, 1) })
+	for i := 0; i < 3; i++ {
+		rs, err := NewReplyWithConfig(natsHost, natsPort, &ReplyConfig{Queue: "user-reply", Workers: 2, MaxPending: 10})
+		if err != nil {
+			t.Fatalf("could not connect to nats server %s\n", err)
+		}
+		defer rs.Stop()
+		if err := rs.Start("UserService.*", reg); err != nil {
+			t.Fatalf("could not start nats reply subscription %s", err)
+		}
+	}
+	req, err := newNatsRequest(natsHost, natsPort)
+	if err != nil {
+		t.Fatalf("cannot connect to nats %s\n", err)
+	}
+	defer req.Close()
+	for i := int64(1); i <= 20; i++ {
+		ruser := &pubsub.UserReply{}
+		err := req.RequestWithContext(context.Background(), "UserService.Get", &pubsub.IdRequest{Id: i}, ruser)
+		if err != nil {
+			t.Fatalf("error with sending nats request %s", err)
+		}
+		if ruser.User.GetData().GetId() != i {
+			t.Fatalf("expected the user %d, received %v", i, ruser)
+		}
+	}
+	if n := atomic.LoadInt64(&handled); n != 20 {
+		t.Fatalf("expected every request to be handled once, handled %d", n)
+	}
+}
+
+func TestReplyWorkers(t *testing.T) {
+	var busy, most int64
+	var mu sync.Mutex
+	reg := newCountingRegistry(func(context.Context) {
+		n := atomic.AddInt64(&busy, 1)
+		mu.Lock()
+		if n > most {
+			most = n
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt64(&busy, -1)
+	})
+	rs, err := NewReplyWithConfig(natsHost, natsPort, &ReplyConfig{Workers: 3, MaxPending: 100, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("could not connect to nats server %s\n", err)
+	}
+	defer rs.Stop()
+	if err := rs.Start("UserService.*", reg); err != nil {
+		t.Fatalf("could not start nats reply subscription %s", err)
+	}
+	req, err := newNatsRequest(natsHost, natsPort)
+	if err != nil {
+		t.Fatalf("cannot connect to nats %s\n", err)
+	}
+	defer req.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 12)
+	for i := int64(1); i <= 12; i++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			ruser := &pubsub.UserReply{}
+			if err := req.RequestWithContext(context.Background(), "UserService.Get", &pubsub.IdRequest{Id: id}, ruser); err != nil {
+				errs <- err
+				return
+			}
+			if !ruser.Exist {
+				errs <- status.ErrorProto(ruser.Status)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("error in handling the request %s", err)
+	}
+	if most < 2 || most > 3 {
+		t.Fatalf("expected the requests to be handled by at most three workers at once, received %d", most)
+	}
+}
+
+func TestReplyTimeout(t *testing.T) {
+	reg := newCountingRegistry(func(ctx context.Context) { <-ctx.Done() })
+	rs, err := NewReplyWithConfig(natsHost, natsPort, &ReplyConfig{Workers: 1, MaxPending: 10, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not connect to nats server %s\n", err)
+	}
+	defer rs.Stop()
+	if err := rs.Start("UserService.*", reg); err != nil {
+		t.Fatalf("could not start nats reply subscription %s", err)
+	}
+	req, err := newNatsRequest(natsHost, natsPort)
+	if err != nil {
+		t.Fatalf("cannot connect to nats %s\n", err)
+	}
+	defer req.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ruser := &pubsub.UserReply{}
+	if err := req.RequestWithContext(ctx, "UserService.Get", &pubsub.IdRequest{Id: 1}, ruser); err != nil {
+		t.Fatalf("error with sending nats request %s", err)
+	}
+	if ruser.Exist || codes.Code(ruser.Status.GetCode()) != codes.DeadlineExceeded {
+		t.Fatalf("expected the request to exceed its deadline, received %v", ruser)
+	}
+}
@@ -0,0 +1,52 @@
+package nats
+
+import (
+	"fmt"
+
+	"github.com/dictyBase/modware-user/message"
+	gnats "github.com/nats-io/go-nats"
+	"github.com/nats-io/go-nats/encoders/protobuf"
+)
+
+type natsPublisher struct {
+	econn *gnats.EncodedConn
+}
+
+// NewPublisher returns a Publisher that sends json encoded events
+func NewPublisher(host, port string, options ...gnats.Option) (message.Publisher, error) {
+	return newPublisher(host, port, gnats.JSON_ENCODER, options...)
+}
+
+// NewEventPublisher returns a Publisher that sends protobuf encoded events,
+// the published values have to be protobuf messages
+func NewEventPublisher(host, port string, options ...gnats.Option) (message.Publisher, error) {
+	return newPublisher(host, port, protobuf.PROTOBUF_ENCODER, options...)
+}
+
+func newPublisher(host, port, encoder string, options ...gnats.Option) (message.Publisher, error) {
+	nc, err := gnats.Connect(fmt.Sprintf("nats://%s:%s", host, port), options...)
+	if err != nil {
+		return &natsPublisher{}, err
+	}
+	ec, err := gnats.NewEncodedConn(nc, encoder)
+	if err != nil {
+		return &natsPublisher{}, err
+	}
+	return &natsPublisher{econn: ec}, nil
+}
+
+func (n *natsPublisher) Publish(subj string, v interface{}) error {
+	return n.econn.Publish(subj, v)
+}
+
+func (n *natsPublisher) Flush() error {
+	return n.econn.Flush()
+}
+
+func (n *natsPublisher) Close() error {
+	if err := n.econn.Flush(); err != nil {
+		return err
+	}
+	n.econn.Close()
+	return nil
+}
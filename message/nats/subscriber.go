@@ -0,0 +1,45 @@
+package nats
+
+import (
+	"fmt"
+
+	"github.com/dictyBase/modware-user/message"
+	gnats "github.com/nats-io/go-nats"
+)
+
+type natsSubscriber struct {
+	conn *gnats.Conn
+	subs []*gnats.Subscription
+}
+
+// NewSubscriber returns a Subscriber that hands over the raw payload of the
+// received events
+func NewSubscriber(host, port string, options ...gnats.Option) (message.Subscriber, error) {
+	nc, err := gnats.Connect(fmt.Sprintf("nats://%s:%s", host, port), options...)
+	if err != nil {
+		return &natsSubscriber{}, err
+	}
+	return &natsSubscriber{conn: nc}, nil
+}
+
+func (n *natsSubscriber) Subscribe(subj string, fn func([]byte)) error {
+	sub, err := n.conn.Subscribe(subj, func(m *gnats.Msg) {
+		fn(m.Data)
+	})
+	if err != nil {
+		return err
+	}
+	if err := n.conn.Flush(); err != nil {
+		return err
+	}
+	n.subs = append(n.subs, sub)
+	return n.conn.LastError()
+}
+
+func (n *natsSubscriber) Close() error {
+	for _, sub := range n.subs {
+		sub.Unsubscribe()
+	}
+	n.conn.Close()
+	return nil
+}
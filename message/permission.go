@@ -0,0 +1,52 @@
+package message
+
+import (
+	"context"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/go-genproto/dictybaseapis/pubsub"
+	"github.com/dictyBase/modware-user/message/reply"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/status"
+)
+
+// NewPermissionRegistry returns the handlers of the PermissionService
+// subjects, all of them reply with a reply.PermissionReply
+func NewPermissionRegistry(c PermissionClient) *Registry {
+	r := NewRegistry(permissionFailure)
+	r.Register("PermissionService.Get", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			perm, err := c.Get(ctx, req.(*pubsub.IdRequest).Id)
+			if err != nil {
+				return permissionFailure(status.Convert(err))
+			}
+			return &reply.PermissionReply{Exist: true, Permission: perm}
+		},
+	})
+	r.Register("PermissionService.Exist", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			exist, err := c.Exist(ctx, req.(*pubsub.IdRequest).Id)
+			if err != nil {
+				return permissionFailure(status.Convert(err))
+			}
+			return &reply.PermissionReply{Exist: exist}
+		},
+	})
+	r.Register("PermissionService.List", &Handler{
+		Request: func() proto.Message { return &jsonapi.SimpleListRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			perms, err := c.List(ctx, req.(*jsonapi.SimpleListRequest))
+			if err != nil {
+				return permissionFailure(status.Convert(err))
+			}
+			return &reply.PermissionReply{Exist: true, Permissions: perms}
+		},
+	})
+	return r
+}
+
+func permissionFailure(st *status.Status) proto.Message {
+	return &reply.PermissionReply{Status: st.Proto()}
+}
@@ -0,0 +1,66 @@
+package message
+
+import (
+	"context"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Handler answers the requests made on a subject. Request returns the empty
+// request the message is decoded into, Reply answers the decoded request
+// within the deadline of the context.
+type Handler struct {
+	Request func() proto.Message
+	Reply   func(context.Context, proto.Message) proto.Message
+}
+
+// FailureFn returns the reply of a request that could not be handled
+type FailureFn func(*status.Status) proto.Message
+
+// Registry maps the subjects to their handlers
+type Registry struct {
+	handlers map[string]*Handler
+	failure  FailureFn
+}
+
+// NewRegistry creates an empty registry, the failure function replies to
+// the requests on unknown subjects and to the malformed requests
+func NewRegistry(failure FailureFn) *Registry {
+	return &Registry{handlers: make(map[string]*Handler), failure: failure}
+}
+
+// Register adds or replaces the handler of a subject
+func (r *Registry) Register(subj string, h *Handler) *Registry {
+	r.handlers[subj] = h
+	return r
+}
+
+// Subjects returns the registered subjects in alphabetical order
+func (r *Registry) Subjects() []string {
+	subjs := make([]string, 0, len(r.handlers))
+	for s := range r.handlers {
+		subjs = append(subjs, s)
+	}
+	sort.Strings(subjs)
+	return subjs
+}
+
+// Handle decodes the request made on the subject and returns its reply, the
+// request fails without being handled once the context is done
+func (r *Registry) Handle(ctx context.Context, subj string, data []byte) proto.Message {
+	if err := ctx.Err(); err != nil {
+		return r.failure(status.FromContextError(err))
+	}
+	h, ok := r.handlers[subj]
+	if !ok {
+		return r.failure(status.Newf(codes.Internal, "subject %s is not supported", subj))
+	}
+	req := h.Request()
+	if err := proto.Unmarshal(data, req); err != nil {
+		return r.failure(status.Newf(codes.InvalidArgument, "unable to decode request on %s %s", subj, err))
+	}
+	return h.Reply(ctx, req)
+}
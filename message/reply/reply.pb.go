@@ -0,0 +1,359 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.17.3
+// source: reply.proto
+
+package reply
+
+import (
+	user "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	status "google.golang.org/genproto/googleapis/rpc/status"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// IdsRequest asks for several resources at once
+type IdsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ids []int64 `protobuf:"varint,1,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+}
+
+func (x *IdsRequest) Reset() {
+	*x = IdsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reply_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IdsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IdsRequest) ProtoMessage() {}
+
+func (x *IdsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_reply_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IdsRequest.ProtoReflect.Descriptor instead.
+func (*IdsRequest) Descriptor() ([]byte, []int) {
+	return file_reply_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *IdsRequest) GetIds() []int64 {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+// RoleReply carries either a role or a collection of roles, exist is false
+// when the role is missing and status tells why the request failed
+type RoleReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Role   *user.Role           `protobuf:"bytes,1,opt,name=role,proto3" json:"role,omitempty"`
+	Roles  *user.RoleCollection `protobuf:"bytes,2,opt,name=roles,proto3" json:"roles,omitempty"`
+	Status *status.Status       `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Exist  bool                 `protobuf:"varint,4,opt,name=exist,proto3" json:"exist,omitempty"`
+}
+
+func (x *RoleReply) Reset() {
+	*x = RoleReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reply_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RoleReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoleReply) ProtoMessage() {}
+
+func (x *RoleReply) ProtoReflect() protoreflect.Message {
+	mi := &file_reply_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoleReply.ProtoReflect.Descriptor instead.
+func (*RoleReply) Descriptor() ([]byte, []int) {
+	return file_reply_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RoleReply) GetRole() *user.Role {
+	if x != nil {
+		return x.Role
+	}
+	return nil
+}
+
+func (x *RoleReply) GetRoles() *user.RoleCollection {
+	if x != nil {
+		return x.Roles
+	}
+	return nil
+}
+
+func (x *RoleReply) GetStatus() *status.Status {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *RoleReply) GetExist() bool {
+	if x != nil {
+		return x.Exist
+	}
+	return false
+}
+
+// PermissionReply carries either a permission or a collection of
+// permissions, exist is false when the permission is missing and status
+// tells why the request failed
+type PermissionReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Permission  *user.Permission           `protobuf:"bytes,1,opt,name=permission,proto3" json:"permission,omitempty"`
+	Permissions *user.PermissionCollection `protobuf:"bytes,2,opt,name=permissions,proto3" json:"permissions,omitempty"`
+	Status      *status.Status             `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	Exist       bool                       `protobuf:"varint,4,opt,name=exist,proto3" json:"exist,omitempty"`
+}
+
+func (x *PermissionReply) Reset() {
+	*x = PermissionReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_reply_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PermissionReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PermissionReply) ProtoMessage() {}
+
+func (x *PermissionReply) ProtoReflect() protoreflect.Message {
+	mi := &file_reply_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PermissionReply.ProtoReflect.Descriptor instead.
+func (*PermissionReply) Descriptor() ([]byte, []int) {
+	return file_reply_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PermissionReply) GetPermission() *user.Permission {
+	if x != nil {
+		return x.Permission
+	}
+	return nil
+}
+
+func (x *PermissionReply) GetPermissions() *user.PermissionCollection {
+	if x != nil {
+		return x.Permissions
+	}
+	return nil
+}
+
+func (x *PermissionReply) GetStatus() *status.Status {
+	if x != nil {
+		return x.Status
+	}
+	return nil
+}
+
+func (x *PermissionReply) GetExist() bool {
+	if x != nil {
+		return x.Exist
+	}
+	return false
+}
+
+var File_reply_proto protoreflect.FileDescriptor
+
+var file_reply_proto_rawDesc = []byte{
+	0x0a, 0x0b, 0x72, 0x65, 0x70, 0x6c, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x14, 0x64,
+	0x69, 0x63, 0x74, 0x79, 0x62, 0x61, 0x73, 0x65, 0x2e, 0x75, 0x73, 0x65, 0x72, 0x2e, 0x72, 0x65,
+	0x70, 0x6c, 0x79, 0x1a, 0x17, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x72, 0x70, 0x63, 0x2f,
+	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0a, 0x75, 0x73,
+	0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x1e, 0x0a, 0x0a, 0x49, 0x64, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x03, 0x52, 0x03, 0x69, 0x64, 0x73, 0x22, 0xad, 0x01, 0x0a, 0x09, 0x52, 0x6f, 0x6c,
+	0x65, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x28, 0x0a, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x64, 0x69, 0x63, 0x74, 0x79, 0x62, 0x61, 0x73, 0x65,
+	0x2e, 0x75, 0x73, 0x65, 0x72, 0x2e, 0x52, 0x6f, 0x6c, 0x65, 0x52, 0x04, 0x72, 0x6f, 0x6c, 0x65,
+	0x12, 0x34, 0x0a, 0x05, 0x72, 0x6f, 0x6c, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x1e, 0x2e, 0x64, 0x69, 0x63, 0x74, 0x79, 0x62, 0x61, 0x73, 0x65, 0x2e, 0x75, 0x73, 0x65, 0x72,
+	0x2e, 0x52, 0x6f, 0x6c, 0x65, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52,
+	0x05, 0x72, 0x6f, 0x6c, 0x65, 0x73, 0x12, 0x2a, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x72, 0x70, 0x63, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x78, 0x69, 0x73, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x05, 0x65, 0x78, 0x69, 0x73, 0x74, 0x22, 0xd7, 0x01, 0x0a, 0x0f, 0x50, 0x65, 0x72,
+	0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x3a, 0x0a, 0x0a,
+	0x70, 0x65, 0x72, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x64, 0x69, 0x63, 0x74, 0x79, 0x62, 0x61, 0x73, 0x65, 0x2e, 0x75, 0x73, 0x65,
+	0x72, 0x2e, 0x50, 0x65, 0x72, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x0a, 0x70, 0x65,
+	0x72, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x46, 0x0a, 0x0b, 0x70, 0x65, 0x72, 0x6d,
+	0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x24, 0x2e,
+	0x64, 0x69, 0x63, 0x74, 0x79, 0x62, 0x61, 0x73, 0x65, 0x2e, 0x75, 0x73, 0x65, 0x72, 0x2e, 0x50,
+	0x65, 0x72, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x52, 0x0b, 0x70, 0x65, 0x72, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x73,
+	0x12, 0x2a, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x12, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x14, 0x0a, 0x05,
+	0x65, 0x78, 0x69, 0x73, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x65, 0x78, 0x69,
+	0x73, 0x74, 0x42, 0x37, 0x5a, 0x35, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x2f, 0x64, 0x69, 0x63, 0x74, 0x79, 0x42, 0x61, 0x73, 0x65, 0x2f, 0x6d, 0x6f, 0x64, 0x77, 0x61,
+	0x72, 0x65, 0x2d, 0x75, 0x73, 0x65, 0x72, 0x2f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2f,
+	0x72, 0x65, 0x70, 0x6c, 0x79, 0x3b, 0x72, 0x65, 0x70, 0x6c, 0x79, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
+}
+
+var (
+	file_reply_proto_rawDescOnce sync.Once
+	file_reply_proto_rawDescData = file_reply_proto_rawDesc
+)
+
+func file_reply_proto_rawDescGZIP() []byte {
+	file_reply_proto_rawDescOnce.Do(func() {
+		file_reply_proto_rawDescData = protoimpl.X.CompressGZIP(file_reply_proto_rawDescData)
+	})
+	return file_reply_proto_rawDescData
+}
+
+var file_reply_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_reply_proto_goTypes = []interface{}{
+	(*IdsRequest)(nil),                // 0: dictybase.user.reply.IdsRequest
+	(*RoleReply)(nil),                 // 1: dictybase.user.reply.RoleReply
+	(*PermissionReply)(nil),           // 2: dictybase.user.reply.PermissionReply
+	(*user.Role)(nil),                 // 3: dictybase.user.Role
+	(*user.RoleCollection)(nil),       // 4: dictybase.user.RoleCollection
+	(*status.Status)(nil),             // 5: google.rpc.Status
+	(*user.Permission)(nil),           // 6: dictybase.user.Permission
+	(*user.PermissionCollection)(nil), // 7: dictybase.user.PermissionCollection
+}
+var file_reply_proto_depIdxs = []int32{
+	3, // 0: dictybase.user.reply.RoleReply.role:type_name -> dictybase.user.Role
+	4, // 1: dictybase.user.reply.RoleReply.roles:type_name -> dictybase.user.RoleCollection
+	5, // 2: dictybase.user.reply.RoleReply.status:type_name -> google.rpc.Status
+	6, // 3: dictybase.user.reply.PermissionReply.permission:type_name -> dictybase.user.Permission
+	7, // 4: dictybase.user.reply.PermissionReply.permissions:type_name -> dictybase.user.PermissionCollection
+	5, // 5: dictybase.user.reply.PermissionReply.status:type_name -> google.rpc.Status
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_reply_proto_init() }
+func file_reply_proto_init() {
+	if File_reply_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_reply_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*IdsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reply_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RoleReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_reply_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PermissionReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_reply_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_reply_proto_goTypes,
+		DependencyIndexes: file_reply_proto_depIdxs,
+		MessageInfos:      file_reply_proto_msgTypes,
+	}.Build()
+	File_reply_proto = out.File
+	file_reply_proto_rawDesc = nil
+	file_reply_proto_goTypes = nil
+	file_reply_proto_depIdxs = nil
+}
@@ -0,0 +1,74 @@
+package message
+
+import (
+	"context"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/go-genproto/dictybaseapis/pubsub"
+	"github.com/dictyBase/modware-user/message/reply"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/status"
+)
+
+// NewRoleRegistry returns the handlers of the RoleService subjects. The
+// replies are reply.RoleReply messages, except for RoleService.Users that
+// replies with a pubsub.UserReply and RoleService.Permissions that replies
+// with a reply.PermissionReply.
+func NewRoleRegistry(c RoleClient) *Registry {
+	r := NewRegistry(roleFailure)
+	r.Register("RoleService.Get", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			role, err := c.Get(ctx, req.(*pubsub.IdRequest).Id)
+			if err != nil {
+				return roleFailure(status.Convert(err))
+			}
+			return &reply.RoleReply{Exist: true, Role: role}
+		},
+	})
+	r.Register("RoleService.Exist", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			exist, err := c.Exist(ctx, req.(*pubsub.IdRequest).Id)
+			if err != nil {
+				return roleFailure(status.Convert(err))
+			}
+			return &reply.RoleReply{Exist: exist}
+		},
+	})
+	r.Register("RoleService.Users", &Handler{
+		Request: func() proto.Message { return &jsonapi.RelationshipRequestWithPagination{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			users, err := c.Users(ctx, req.(*jsonapi.RelationshipRequestWithPagination))
+			if err != nil {
+				return &pubsub.UserReply{Status: status.Convert(err).Proto()}
+			}
+			return &pubsub.UserReply{Exist: true, Users: users}
+		},
+	})
+	r.Register("RoleService.Permissions", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			perms, err := c.Permissions(ctx, req.(*pubsub.IdRequest).Id)
+			if err != nil {
+				return permissionFailure(status.Convert(err))
+			}
+			return &reply.PermissionReply{Exist: true, Permissions: perms}
+		},
+	})
+	r.Register("RoleService.List", &Handler{
+		Request: func() proto.Message { return &jsonapi.SimpleListRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			roles, err := c.List(ctx, req.(*jsonapi.SimpleListRequest))
+			if err != nil {
+				return roleFailure(status.Convert(err))
+			}
+			return &reply.RoleReply{Exist: true, Roles: roles}
+		},
+	})
+	return r
+}
+
+func roleFailure(st *status.Status) proto.Message {
+	return &reply.RoleReply{Status: st.Proto()}
+}
@@ -0,0 +1,105 @@
+package message
+
+import (
+	"context"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/go-genproto/dictybaseapis/pubsub"
+	"github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/message/reply"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaxGetMany is the largest number of users fetched by a single
+// UserService.GetMany request
+const MaxGetMany = 100
+
+// NewUserRegistry returns the handlers of the UserService subjects. The
+// replies are pubsub.UserReply messages, except for UserService.Roles that
+// replies with a reply.RoleReply.
+func NewUserRegistry(c UserClient) *Registry {
+	r := NewRegistry(func(st *status.Status) proto.Message {
+		return &pubsub.UserReply{Status: st.Proto()}
+	})
+	r.Register("UserService.Get", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			return userReply(c.Get(ctx, req.(*pubsub.IdRequest).Id))
+		},
+	})
+	r.Register("UserService.GetByEmail", &Handler{
+		Request: func() proto.Message { return &jsonapi.GetEmailRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			return userReply(c.GetByEmail(ctx, req.(*jsonapi.GetEmailRequest).Email))
+		},
+	})
+	r.Register("UserService.Exist", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			return existReply(c.Exist(ctx, req.(*pubsub.IdRequest).Id))
+		},
+	})
+	r.Register("UserService.Delete", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			return existReply(c.Delete(ctx, req.(*pubsub.IdRequest).Id))
+		},
+	})
+	r.Register("UserService.Roles", &Handler{
+		Request: func() proto.Message { return &pubsub.IdRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			roles, err := c.Roles(ctx, req.(*pubsub.IdRequest).Id)
+			if err != nil {
+				return &reply.RoleReply{Status: status.Convert(err).Proto()}
+			}
+			return &reply.RoleReply{Exist: true, Roles: roles}
+		},
+	})
+	r.Register("UserService.GetMany", &Handler{
+		Request: func() proto.Message { return &reply.IdsRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			ids := req.(*reply.IdsRequest).Ids
+			if len(ids) > MaxGetMany {
+				return &pubsub.UserReply{
+					Status: status.Newf(
+						codes.InvalidArgument,
+						"at most %d users can be fetched at once", MaxGetMany,
+					).Proto(),
+				}
+			}
+			users, err := c.GetMany(ctx, ids)
+			if err != nil {
+				return &pubsub.UserReply{Status: status.Convert(err).Proto()}
+			}
+			// exist tells whether every requested user was found
+			return &pubsub.UserReply{Exist: len(users.Data) == len(ids), Users: users}
+		},
+	})
+	r.Register("UserService.List", &Handler{
+		Request: func() proto.Message { return &jsonapi.ListRequest{} },
+		Reply: func(ctx context.Context, req proto.Message) proto.Message {
+			users, err := c.List(ctx, req.(*jsonapi.ListRequest))
+			if err != nil {
+				return &pubsub.UserReply{Status: status.Convert(err).Proto()}
+			}
+			return &pubsub.UserReply{Exist: true, Users: users}
+		},
+	})
+	return r
+}
+
+func userReply(u *user.User, err error) proto.Message {
+	if err != nil {
+		return &pubsub.UserReply{Status: status.Convert(err).Proto()}
+	}
+	return &pubsub.UserReply{Exist: true, User: u}
+}
+
+func existReply(exist bool, err error) proto.Message {
+	if err != nil {
+		return &pubsub.UserReply{Status: status.Convert(err).Proto(), Exist: exist}
+	}
+	return &pubsub.UserReply{Exist: exist}
+}
@@ -0,0 +1,38 @@
+-- +goose Up
+CREATE TABLE auth_role_approver (
+    auth_role_approver_id SERIAL PRIMARY KEY,
+    auth_role_id integer NOT NULL REFERENCES auth_role(auth_role_id) ON DELETE CASCADE,
+    approver_role_id integer NOT NULL REFERENCES auth_role(auth_role_id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    UNIQUE (auth_role_id, approver_role_id)
+);
+COMMENT ON TABLE auth_role_approver IS 'Roles whose members may approve or deny requests for a role';
+
+CREATE TABLE auth_role_request (
+    auth_role_request_id SERIAL PRIMARY KEY,
+    auth_user_id integer NOT NULL REFERENCES auth_user(auth_user_id) ON DELETE CASCADE,
+    auth_role_id integer NOT NULL REFERENCES auth_role(auth_role_id) ON DELETE CASCADE,
+    justification text NOT NULL,
+    state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'approved', 'denied')),
+    decided_by integer REFERENCES auth_user(auth_user_id) ON DELETE SET NULL,
+    decision_comment text,
+    decided_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE UNIQUE INDEX auth_role_request_pending_idx
+    ON auth_role_request(auth_user_id, auth_role_id) WHERE state = 'pending';
+COMMENT ON TABLE auth_role_request IS 'Requests of users to be granted a role';
+
+CREATE TABLE auth_role_request_comment (
+    auth_role_request_comment_id SERIAL PRIMARY KEY,
+    auth_role_request_id integer NOT NULL REFERENCES auth_role_request(auth_role_request_id) ON DELETE CASCADE,
+    auth_user_id integer REFERENCES auth_user(auth_user_id) ON DELETE SET NULL,
+    comment text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE auth_role_request_comment;
+DROP TABLE auth_role_request;
+DROP TABLE auth_role_approver;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE auth_role_constraint (
+    auth_role_constraint_id SERIAL PRIMARY KEY,
+    auth_role_id integer NOT NULL REFERENCES auth_role(auth_role_id) ON DELETE CASCADE,
+    conflicting_role_id integer NOT NULL REFERENCES auth_role(auth_role_id) ON DELETE CASCADE,
+    description text,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CHECK (auth_role_id < conflicting_role_id),
+    UNIQUE (auth_role_id, conflicting_role_id)
+);
+COMMENT ON TABLE auth_role_constraint IS 'Pairs of roles that must not be held by the same user, the pair is stored with the lower role id first';
+
+-- +goose Down
+DROP TABLE auth_role_constraint;
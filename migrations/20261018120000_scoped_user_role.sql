@@ -0,0 +1,25 @@
+-- +goose Up
+ALTER TABLE auth_user_role
+    ADD COLUMN resource_type text,
+    ADD COLUMN resource_id text,
+    ADD CONSTRAINT auth_user_role_scope_check
+        CHECK ((resource_type IS NULL) = (resource_id IS NULL));
+ALTER TABLE auth_user_role DROP CONSTRAINT IF EXISTS auth_user_role_auth_user_id_auth_role_id_key;
+-- duplicated global assignments would prevent the unique index
+DELETE FROM auth_user_role dup USING auth_user_role orig
+    WHERE dup.auth_user_role_id > orig.auth_user_role_id
+    AND dup.auth_user_id = orig.auth_user_id
+    AND dup.auth_role_id = orig.auth_role_id;
+CREATE UNIQUE INDEX auth_user_role_scope_idx ON auth_user_role(
+    auth_user_id, auth_role_id, COALESCE(resource_type, ''), COALESCE(resource_id, '')
+);
+COMMENT ON COLUMN auth_user_role.resource_type IS 'Type of the resource the assignment is restricted to, global when empty';
+COMMENT ON COLUMN auth_user_role.resource_id IS 'Identifier of the resource the assignment is restricted to';
+
+-- +goose Down
+DROP INDEX auth_user_role_scope_idx;
+DELETE FROM auth_user_role WHERE resource_type IS NOT NULL;
+ALTER TABLE auth_user_role
+    DROP CONSTRAINT auth_user_role_scope_check,
+    DROP COLUMN resource_id,
+    DROP COLUMN resource_type;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE auth_user_role
+    ADD COLUMN IF NOT EXISTS created_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE auth_role_permission
+    ADD COLUMN IF NOT EXISTS created_at timestamp with time zone NOT NULL DEFAULT now();
+
+-- +goose Down
+-- the columns might be part of the base schema, hence they are kept
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE auth_role_protected (
+    auth_role_id integer PRIMARY KEY REFERENCES auth_role(auth_role_id) ON DELETE CASCADE,
+    reason text,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+COMMENT ON TABLE auth_role_protected IS 'System roles that cannot be renamed or deleted through the API';
+
+CREATE TABLE auth_permission_protected (
+    auth_permission_id integer PRIMARY KEY REFERENCES auth_permission(auth_permission_id) ON DELETE CASCADE,
+    reason text,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+COMMENT ON TABLE auth_permission_protected IS 'System permissions that cannot be changed or deleted through the API';
+
+-- +goose Down
+DROP TABLE auth_permission_protected;
+DROP TABLE auth_role_protected;
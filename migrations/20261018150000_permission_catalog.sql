@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE auth_permission_catalog (
+    auth_permission_catalog_id SERIAL PRIMARY KEY,
+    kind text NOT NULL CHECK (kind IN ('verb', 'resource')),
+    name text NOT NULL,
+    description text,
+    aliases text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    UNIQUE (kind, name)
+);
+COMMENT ON TABLE auth_permission_catalog IS 'Verbs and resource types allowed in permissions, aliases is a comma separated list of names that should be replaced by this one';
+
+-- +goose Down
+DROP TABLE auth_permission_catalog;
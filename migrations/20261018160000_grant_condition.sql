@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE auth_role_permission
+    ADD COLUMN IF NOT EXISTS condition text;
+COMMENT ON COLUMN auth_role_permission.condition IS 'Optional expression on user and request attributes that has to hold for the permission to be granted';
+
+-- +goose Down
+ALTER TABLE auth_role_permission
+    DROP COLUMN IF EXISTS condition;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE auth_role_permission
+    ADD COLUMN IF NOT EXISTS effect text NOT NULL DEFAULT 'allow' CHECK (effect IN ('allow', 'deny'));
+COMMENT ON COLUMN auth_role_permission.effect IS 'Whether the role is allowed or denied the permission, a deny overrides any allow';
+
+-- +goose Down
+ALTER TABLE auth_role_permission
+    DROP COLUMN IF EXISTS effect;
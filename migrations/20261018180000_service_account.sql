@@ -0,0 +1,42 @@
+-- +goose Up
+CREATE TABLE auth_service_account (
+    auth_service_account_id SERIAL PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    description text,
+    is_active boolean NOT NULL DEFAULT true,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+COMMENT ON TABLE auth_service_account IS 'Non human principals, such as other services and scheduled jobs';
+
+CREATE TABLE auth_service_account_key (
+    auth_service_account_key_id SERIAL PRIMARY KEY,
+    auth_service_account_id integer NOT NULL REFERENCES auth_service_account(auth_service_account_id) ON DELETE CASCADE,
+    key_id text NOT NULL UNIQUE,
+    key_hash text NOT NULL,
+    expires_at timestamp with time zone,
+    revoked_at timestamp with time zone,
+    last_used_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+COMMENT ON TABLE auth_service_account_key IS 'API keys of the service accounts, only the hash of the secret is kept';
+COMMENT ON COLUMN auth_service_account_key.key_id IS 'Public part of the key that identifies it';
+
+CREATE TABLE auth_service_account_role (
+    auth_service_account_role_id SERIAL PRIMARY KEY,
+    auth_service_account_id integer NOT NULL REFERENCES auth_service_account(auth_service_account_id) ON DELETE CASCADE,
+    auth_role_id integer NOT NULL REFERENCES auth_role(auth_role_id) ON DELETE CASCADE,
+    resource_type text,
+    resource_id text,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CHECK ((resource_type IS NULL) = (resource_id IS NULL))
+);
+CREATE UNIQUE INDEX auth_service_account_role_scope_idx ON auth_service_account_role(
+    auth_service_account_id, auth_role_id, COALESCE(resource_type, ''), COALESCE(resource_id, '')
+);
+COMMENT ON TABLE auth_service_account_role IS 'Roles of the service accounts, either global or scoped to a resource';
+
+-- +goose Down
+DROP TABLE auth_service_account_role;
+DROP TABLE auth_service_account_key;
+DROP TABLE auth_service_account;
@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE auth_impersonation (
+    auth_impersonation_id SERIAL PRIMARY KEY,
+    admin_user_id integer NOT NULL REFERENCES auth_user(auth_user_id) ON DELETE CASCADE,
+    target_user_id integer NOT NULL REFERENCES auth_user(auth_user_id) ON DELETE CASCADE,
+    reason text NOT NULL,
+    started_at timestamp with time zone NOT NULL DEFAULT now(),
+    expires_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone,
+    ended_by integer REFERENCES auth_user(auth_user_id) ON DELETE SET NULL,
+    CHECK (admin_user_id <> target_user_id)
+);
+CREATE INDEX auth_impersonation_admin_idx ON auth_impersonation(admin_user_id);
+CREATE INDEX auth_impersonation_target_idx ON auth_impersonation(target_user_id);
+COMMENT ON TABLE auth_impersonation IS 'Sessions in which an administrator acts as another user';
+COMMENT ON COLUMN auth_impersonation.ended_at IS 'Time the session was stopped, a session past its expiration without it has lapsed';
+
+-- +goose Down
+DROP TABLE auth_impersonation;
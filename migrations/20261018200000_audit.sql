@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE auth_audit_event (
+    auth_audit_event_id BIGSERIAL PRIMARY KEY,
+    actor_user_id integer,
+    actor_service_account_id integer,
+    impersonator_user_id integer,
+    rpc text NOT NULL,
+    before_value jsonb,
+    after_value jsonb,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+CREATE INDEX auth_audit_event_actor_idx ON auth_audit_event(actor_user_id);
+CREATE INDEX auth_audit_event_created_idx ON auth_audit_event(created_at);
+COMMENT ON TABLE auth_audit_event IS 'Changes made through the user, role and permission services';
+COMMENT ON COLUMN auth_audit_event.actor_user_id IS 'User who made the change, kept without reference so that the record outlives the user';
+COMMENT ON COLUMN auth_audit_event.before_value IS 'State of the primary resource before the change, absent for a creation';
+COMMENT ON COLUMN auth_audit_event.after_value IS 'State of the primary resource after the change, absent for a deletion';
+
+CREATE TABLE auth_audit_event_resource (
+    auth_audit_event_id bigint NOT NULL REFERENCES auth_audit_event(auth_audit_event_id) ON DELETE CASCADE,
+    resource_type text NOT NULL,
+    resource_id bigint NOT NULL,
+    position integer NOT NULL,
+    PRIMARY KEY (auth_audit_event_id, resource_type, resource_id)
+);
+CREATE INDEX auth_audit_event_resource_idx ON auth_audit_event_resource(resource_type, resource_id);
+COMMENT ON TABLE auth_audit_event_resource IS 'Resources changed by an audit event, the primary one along with the related ones';
+COMMENT ON COLUMN auth_audit_event_resource.position IS 'Order of the resource in the event, the primary one comes first';
+
+-- +goose Down
+DROP TABLE auth_audit_event_resource;
+DROP TABLE auth_audit_event;
@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE auth_event_outbox (
+    auth_event_outbox_id BIGSERIAL PRIMARY KEY,
+    event_type text NOT NULL,
+    payload bytea NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    delivered_at timestamp with time zone,
+    attempts integer NOT NULL DEFAULT 0,
+    last_attempt_at timestamp with time zone,
+    last_error text
+);
+CREATE INDEX auth_event_outbox_pending_idx ON auth_event_outbox(auth_event_outbox_id) WHERE delivered_at IS NULL;
+COMMENT ON TABLE auth_event_outbox IS 'Domain events stored along with the changes, they are published by the relay by the order of their ids, which need not be the commit order of the changes';
+COMMENT ON COLUMN auth_event_outbox.event_type IS 'Full name of the protobuf message of the event';
+COMMENT ON COLUMN auth_event_outbox.payload IS 'Protobuf encoded event';
+COMMENT ON COLUMN auth_event_outbox.delivered_at IS 'When the event was published, pending events have none';
+
+-- +goose Down
+DROP TABLE auth_event_outbox;
@@ -0,0 +1,55 @@
+-- +goose Up
+CREATE TABLE auth_webhook (
+    auth_webhook_id SERIAL PRIMARY KEY,
+    url text NOT NULL,
+    event_types text NOT NULL DEFAULT '',
+    secret text NOT NULL,
+    description text,
+    is_active boolean NOT NULL DEFAULT true,
+    created_by integer REFERENCES auth_user(auth_user_id) ON DELETE SET NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp with time zone NOT NULL DEFAULT now()
+);
+COMMENT ON TABLE auth_webhook IS 'Subscriptions of http endpoints to the domain events';
+COMMENT ON COLUMN auth_webhook.event_types IS 'Comma separated list of the names of the subscribed events, an empty list subscribes to every event';
+COMMENT ON COLUMN auth_webhook.secret IS 'Key of the HMAC signature of the deliveries';
+
+CREATE TABLE auth_webhook_delivery (
+    auth_webhook_delivery_id BIGSERIAL PRIMARY KEY,
+    auth_webhook_id integer NOT NULL REFERENCES auth_webhook(auth_webhook_id) ON DELETE CASCADE,
+    event_id text NOT NULL,
+    event_type text NOT NULL,
+    payload text NOT NULL,
+    state text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'delivered', 'failed')),
+    attempts integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamp with time zone NOT NULL DEFAULT now(),
+    leased_until timestamp with time zone,
+    last_response_code integer,
+    last_error text,
+    replay_of bigint REFERENCES auth_webhook_delivery(auth_webhook_delivery_id) ON DELETE SET NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    delivered_at timestamp with time zone
+);
+CREATE INDEX auth_webhook_delivery_webhook_idx ON auth_webhook_delivery(auth_webhook_id);
+CREATE INDEX auth_webhook_delivery_due_idx ON auth_webhook_delivery(next_attempt_at) WHERE state = 'pending';
+COMMENT ON TABLE auth_webhook_delivery IS 'Domain events to be sent to a webhook, they are stored along with the changes';
+COMMENT ON COLUMN auth_webhook_delivery.payload IS 'JSON body of the request, it is signed as stored';
+COMMENT ON COLUMN auth_webhook_delivery.replay_of IS 'Delivery that was sent again by this one';
+COMMENT ON COLUMN auth_webhook_delivery.leased_until IS 'Until when the delivery is being sent by a dispatcher, other dispatchers take it over once the lease expires';
+
+CREATE TABLE auth_webhook_attempt (
+    auth_webhook_attempt_id BIGSERIAL PRIMARY KEY,
+    auth_webhook_delivery_id bigint NOT NULL REFERENCES auth_webhook_delivery(auth_webhook_delivery_id) ON DELETE CASCADE,
+    attempted_at timestamp with time zone NOT NULL DEFAULT now(),
+    response_code integer,
+    response_body text,
+    error text,
+    duration_ms integer NOT NULL DEFAULT 0
+);
+CREATE INDEX auth_webhook_attempt_delivery_idx ON auth_webhook_attempt(auth_webhook_delivery_id);
+COMMENT ON TABLE auth_webhook_attempt IS 'Log of the requests made for the deliveries, attempts without response code failed before a response';
+
+-- +goose Down
+DROP TABLE auth_webhook_attempt;
+DROP TABLE auth_webhook_delivery;
+DROP TABLE auth_webhook;
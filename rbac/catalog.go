@@ -0,0 +1,242 @@
+package rbac
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// CatalogVerb is the kind of catalog entries for the verbs of a
+	// permission
+	CatalogVerb = "verb"
+	// CatalogResource is the kind of catalog entries for the resource types,
+	// the first segment of a resource
+	CatalogResource = "resource"
+	// maxSuggestions is the maximum number of suggestions for an unknown
+	// verb or resource type
+	maxSuggestions = 3
+)
+
+// CatalogKinds are the kinds of entries in a catalog
+var CatalogKinds = []string{CatalogVerb, CatalogResource}
+
+// CatalogEntry is a verb or resource type that can be used in permissions.
+// The aliases are names that mean the same and are suggested to be replaced
+// by the entry.
+type CatalogEntry struct {
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Aliases     []string `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+}
+
+// Catalog is the registry of verbs and resource types of permissions. An
+// empty list of entries of a kind allows any value of that kind.
+type Catalog struct {
+	Verbs     []*CatalogEntry `yaml:"verbs" json:"verbs"`
+	Resources []*CatalogEntry `yaml:"resources" json:"resources"`
+}
+
+// CatalogViolation is a verb or resource type of a permission that is not
+// part of the catalog
+type CatalogViolation struct {
+	Kind        string   `json:"kind"`
+	Value       string   `json:"value"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+func (v *CatalogViolation) Error() string {
+	msg := fmt.Sprintf("%s %s is not in the permission catalog", v.Kind, v.Value)
+	if len(v.Suggestions) > 0 {
+		msg = fmt.Sprintf("%s, did you mean %s", msg, strings.Join(v.Suggestions, " or "))
+	}
+	return msg
+}
+
+// IsValidCatalogKind checks if the kind of catalog entries is supported
+func IsValidCatalogKind(kind string) bool {
+	for _, k := range CatalogKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadCatalogYAML reads and validates a catalog in yaml format
+func ReadCatalogYAML(r io.Reader) (*Catalog, error) {
+	c := &Catalog{}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return c, fmt.Errorf("error in decoding yaml %s", err)
+	}
+	return c, c.Validate()
+}
+
+// Entries returns the entries of the given kind
+func (c *Catalog) Entries(kind string) []*CatalogEntry {
+	if kind == CatalogVerb {
+		return c.Verbs
+	}
+	return c.Resources
+}
+
+// Validate checks that the names and aliases are valid and that none of
+// them is used twice within a kind
+func (c *Catalog) Validate() error {
+	for _, kind := range CatalogKinds {
+		seen := make(map[string]bool)
+		for _, e := range c.Entries(kind) {
+			for _, n := range append([]string{e.Name}, e.Aliases...) {
+				if err := ValidateCatalogName(n); err != nil {
+					return err
+				}
+				if seen[n] {
+					return fmt.Errorf("%s %s is defined more than once", kind, n)
+				}
+				seen[n] = true
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateCatalogName checks if the name can be used as a verb or resource
+// type
+func ValidateCatalogName(name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("catalog name cannot be empty")
+	}
+	if strings.ContainsAny(name, " \t\n:"+Wildcard+VerbSeparator+ResourceSeparator) {
+		return fmt.Errorf("catalog name %q has invalid characters", name)
+	}
+	return nil
+}
+
+// Check returns the verbs and the resource type of the permission pattern
+// that are not in the catalog. The wildcard is always allowed.
+func (c *Catalog) Check(perm, resource string) ([]*CatalogViolation, error) {
+	p, err := ParsePattern(perm, resource)
+	if err != nil {
+		return nil, err
+	}
+	var violations []*CatalogViolation
+	if len(c.Verbs) > 0 {
+		for _, v := range p.verbs {
+			if v != Wildcard && !c.Has(CatalogVerb, v) {
+				violations = append(violations, c.violation(CatalogVerb, v))
+			}
+		}
+	}
+	if rt := p.segments[0]; len(c.Resources) > 0 && rt != Wildcard && !c.Has(CatalogResource, rt) {
+		violations = append(violations, c.violation(CatalogResource, rt))
+	}
+	return violations, nil
+}
+
+// Has checks if the name is an entry of the given kind, aliases are not
+// considered
+func (c *Catalog) Has(kind, name string) bool {
+	for _, e := range c.Entries(kind) {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Suggest returns the entries that were probably meant by the given name.
+// An entry that lists the name as alias comes first, followed by the
+// entries that differ only in case and the ones within a small edit
+// distance.
+func (c *Catalog) Suggest(kind, name string) []string {
+	type candidate struct {
+		name string
+		rank int
+	}
+	var cands []candidate
+	lname := strings.ToLower(name)
+	for _, e := range c.Entries(kind) {
+		rank := -1
+		for _, a := range e.Aliases {
+			if strings.ToLower(a) == lname {
+				rank = 0
+			}
+		}
+		if rank < 0 {
+			d := levenshtein(lname, strings.ToLower(e.Name))
+			if d <= maxDistance(name) {
+				rank = d + 1
+			}
+		}
+		if rank >= 0 {
+			cands = append(cands, candidate{name: e.Name, rank: rank})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].rank != cands[j].rank {
+			return cands[i].rank < cands[j].rank
+		}
+		return cands[i].name < cands[j].name
+	})
+	var sugg []string
+	for i := 0; i < len(cands) && i < maxSuggestions; i++ {
+		sugg = append(sugg, cands[i].name)
+	}
+	return sugg
+}
+
+func (c *Catalog) violation(kind, value string) *CatalogViolation {
+	return &CatalogViolation{
+		Kind:        kind,
+		Value:       value,
+		Suggestions: c.Suggest(kind, value),
+	}
+}
+
+// maxDistance is the edit distance up to which a name is considered a
+// misspelling, short names allow fewer edits
+func maxDistance(name string) int {
+	if len(name) <= 4 {
+		return 1
+	}
+	return 2
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min(n ...int) int {
+	m := n[0]
+	for _, v := range n[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
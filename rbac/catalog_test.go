@@ -0,0 +1,159 @@
+package rbac
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testCatalogYAML = `
+verbs:
+  - name: read
+    description: fetch a resource
+  - name: write
+    aliases:
+      - edit
+      - update
+  - name: delete
+resources:
+  - name: genes
+  - name: stocks
+  - name: users
+`
+
+func testCatalog(t *testing.T) *Catalog {
+	c, err := ReadCatalogYAML(strings.NewReader(testCatalogYAML))
+	if err != nil {
+		t.Fatalf("could not read catalog %s\n", err)
+	}
+	return c
+}
+
+func TestReadCatalogYAML(t *testing.T) {
+	c := testCatalog(t)
+	if len(c.Verbs) != 3 || len(c.Resources) != 3 {
+		t.Fatalf("expected 3 verbs and 3 resources, received %d and %d\n", len(c.Verbs), len(c.Resources))
+	}
+	if !reflect.DeepEqual(c.Verbs[1].Aliases, []string{"edit", "update"}) {
+		t.Fatalf("expected aliases of write, received %v\n", c.Verbs[1].Aliases)
+	}
+	for _, y := range []string{
+		"verbs:\n  - name: read\n  - name: read\n",
+		"verbs:\n  - name: write\n  - name: edit\n    aliases: [write]\n",
+		"verbs:\n  - name: read,write\n",
+		"resources:\n  - name: genes/*\n",
+		"resources:\n  - name: \"\"\n",
+		"verb:\n  - name: read\n",
+	} {
+		if _, err := ReadCatalogYAML(strings.NewReader(y)); err == nil {
+			t.Fatalf("expected error for catalog %q\n", y)
+		}
+	}
+}
+
+func TestCatalogCheck(t *testing.T) {
+	c := testCatalog(t)
+	valid := [][2]string{
+		{"read", "genes"},
+		{"read,write", "genes/*"},
+		{"*", "stocks/strains/*"},
+		{"delete", "*"},
+		{"write", "users/DDB_G0267376"},
+	}
+	for _, v := range valid {
+		violations, err := c.Check(v[0], v[1])
+		if err != nil {
+			t.Fatalf("could not check %s:%s %s\n", v[0], v[1], err)
+		}
+		if len(violations) != 0 {
+			t.Fatalf("expected %s:%s to conform, received %v\n", v[0], v[1], violations[0])
+		}
+	}
+	violations, err := c.Check("Edit,read,remove", "gene/*")
+	if err != nil {
+		t.Fatalf("could not check permission %s\n", err)
+	}
+	if len(violations) != 3 {
+		t.Fatalf("expected 3 violations, received %d\n", len(violations))
+	}
+	expected := []*CatalogViolation{
+		{Kind: CatalogVerb, Value: "Edit", Suggestions: []string{"write"}},
+		{Kind: CatalogVerb, Value: "remove"},
+		{Kind: CatalogResource, Value: "gene", Suggestions: []string{"genes"}},
+	}
+	if !reflect.DeepEqual(violations, expected) {
+		for i, v := range violations {
+			t.Logf("violation %d %+v\n", i, v)
+		}
+		t.Fatal("unexpected violations")
+	}
+	if !strings.Contains(violations[0].Error(), "did you mean write") {
+		t.Fatalf("expected suggestion in error, received %s\n", violations[0])
+	}
+	if _, err := c.Check("read", "genes//go"); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
+
+func TestCatalogEmptyKind(t *testing.T) {
+	c := &Catalog{Resources: []*CatalogEntry{{Name: "genes"}}}
+	violations, err := c.Check("anything", "genes")
+	if err != nil {
+		t.Fatalf("could not check permission %s\n", err)
+	}
+	if len(violations) != 0 {
+		t.Fatal("expected any verb to be allowed without verb entries")
+	}
+	violations, err = (&Catalog{}).Check("anything", "anywhere")
+	if err != nil || len(violations) != 0 {
+		t.Fatal("expected an empty catalog to allow any permission")
+	}
+}
+
+func TestCatalogSuggest(t *testing.T) {
+	c := &Catalog{
+		Verbs: []*CatalogEntry{
+			{Name: "read"},
+			{Name: "reads"},
+			{Name: "write", Aliases: []string{"Edit"}},
+			{Name: "edit"},
+			{Name: "delete"},
+		},
+	}
+	cases := map[string][]string{
+		"edit":   {"write", "edit"},
+		"EDIT":   {"write", "edit"},
+		"Read":   {"read", "reads"},
+		"red":    {"read"},
+		"delte":  {"delete"},
+		"deleet": {"delete"},
+		"wr":     nil,
+		"admin":  nil,
+	}
+	for name, exp := range cases {
+		if s := c.Suggest(CatalogVerb, name); !reflect.DeepEqual(s, exp) {
+			t.Fatalf("expected suggestions %v for %s, received %v\n", exp, name, s)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"abc", "", 3},
+		{"read", "read", 0},
+		{"read", "reed", 1},
+		{"read", "reads", 1},
+		{"delete", "delte", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, c := range cases {
+		if d := levenshtein(c.a, c.b); d != c.d {
+			t.Fatalf("expected distance %d between %s and %s, received %d\n", c.d, c.a, c.b, d)
+		}
+	}
+}
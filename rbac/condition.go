@@ -0,0 +1,657 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Roots of the attribute paths that can be used in a condition
+const (
+	ConditionUser    = "user"
+	ConditionRequest = "request"
+)
+
+const (
+	maxConditionLength = 1024
+	maxConditionDepth  = 32
+)
+
+// Condition is a boolean expression that restricts a role to permission
+// binding. It is evaluated against the attributes of the user and of the
+// request being authorized, for example
+//
+//	user.id == request.owner_id
+//	user.organization == request.organization && request.state in ["new", "open"]
+//
+// The language has no function calls, loops or assignments, so an
+// evaluation always terminates and has no side effects. It supports
+//   - string literals in single or double quotes, numbers, true, false and
+//     null
+//   - attribute paths starting with user or request, an unknown attribute
+//     is null
+//   - lists of literals and paths in square brackets
+//   - the comparisons ==, !=, <, <=, >, >= and in
+//   - the boolean operators &&, || and ! along with parentheses
+//
+// Equality between a number and a string compares the decimal form of the
+// number, as attributes of a request are usually given as strings.
+type Condition struct {
+	src   string
+	root  node
+	paths []string
+}
+
+// ConditionEnv holds the attributes a condition is evaluated against
+type ConditionEnv struct {
+	User    map[string]interface{}
+	Request map[string]interface{}
+}
+
+// ParseCondition compiles a condition and checks that it only refers to
+// user and request attributes
+func ParseCondition(src string) (*Condition, error) {
+	if len(strings.TrimSpace(src)) == 0 {
+		return nil, fmt.Errorf("condition cannot be empty")
+	}
+	if len(src) > maxConditionLength {
+		return nil, fmt.Errorf("condition is longer than %d characters", maxConditionLength)
+	}
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", t.text, t.pos)
+	}
+	c := &Condition{src: src, root: root}
+	seen := make(map[string]bool)
+	for _, path := range p.paths {
+		if !seen[path] {
+			seen[path] = true
+			c.paths = append(c.paths, path)
+		}
+	}
+	sort.Strings(c.paths)
+	return c, nil
+}
+
+// ValidateCondition checks if the condition can be compiled
+func ValidateCondition(src string) error {
+	_, err := ParseCondition(src)
+	return err
+}
+
+// String returns the source of the condition
+func (c *Condition) String() string {
+	return c.src
+}
+
+// Evaluate tells if the condition holds for the attributes. An error is
+// returned for operands of the wrong type, for example a string with &&.
+func (c *Condition) Evaluate(env *ConditionEnv) (bool, error) {
+	v, err := c.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition evaluates to %s instead of a boolean", describe(v))
+	}
+	return b, nil
+}
+
+// Explain lists the values of the attributes the condition refers to
+func (c *Condition) Explain(env *ConditionEnv) string {
+	var parts []string
+	for _, path := range c.paths {
+		parts = append(parts, fmt.Sprintf("%s=%s", path, describe(lookup(env, path))))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// -- lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokDot
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokLBracket, "[", i})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBracket, "]", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case r == '.':
+			tokens = append(tokens, token{tokDot, ".", i})
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				b.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{tokString, b.String(), i})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, string(rs[i:j]), i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, string(rs[i:j]), i})
+			i = j
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(string(rs[i:]), op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+		}
+	}
+	return append(tokens, token{tokEOF, "end of condition", len(rs)}), nil
+}
+
+// -- parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	paths  []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind == tokOp {
+		for _, op := range ops {
+			if t.text == op {
+				return true
+			}
+		}
+	}
+	return t.kind == tokIdent && t.text == "in" && contains(ops, "in")
+}
+
+func (p *parser) parseOr(depth int) (node, error) {
+	if depth > maxConditionDepth {
+		return nil, fmt.Errorf("condition is nested more than %d levels", maxConditionDepth)
+	}
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		p.next()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(depth int) (node, error) {
+	left, err := p.parseNot(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		p.next()
+		right, err := p.parseNot(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot(depth int) (node, error) {
+	if p.isOp("!") {
+		p.next()
+		if depth+1 > maxConditionDepth {
+			return nil, fmt.Errorf("condition is nested more than %d levels", maxConditionDepth)
+		}
+		operand, err := p.parseNot(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison(depth)
+}
+
+func (p *parser) parseComparison(depth int) (node, error) {
+	left, err := p.parseOperand(depth)
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("==", "!=", "<", "<=", ">", ">=", "in") {
+		op := p.next().text
+		right, err := p.parseOperand(depth)
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand(depth int) (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at position %d", c.pos)
+		}
+		return n, nil
+	case tokLBracket:
+		l := &listNode{}
+		if p.peek().kind == tokRBracket {
+			p.next()
+			return l, nil
+		}
+		for {
+			item, err := p.parseOperand(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			l.items = append(l.items, item)
+			sep := p.next()
+			if sep.kind == tokRBracket {
+				return l, nil
+			}
+			if sep.kind != tokComma {
+				return nil, fmt.Errorf("expected , or ] at position %d", sep.pos)
+			}
+		}
+	case tokString:
+		return &literalNode{value: t.text}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at position %d", t.text, t.pos)
+		}
+		return &literalNode{value: f}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		case ConditionUser, ConditionRequest:
+			return p.parsePath(t)
+		default:
+			return nil, fmt.Errorf(
+				"unknown name %s at position %d, attributes start with %s or %s",
+				t.text, t.pos, ConditionUser, ConditionRequest,
+			)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected %s at position %d", t.text, t.pos)
+	}
+}
+
+func (p *parser) parsePath(root token) (node, error) {
+	if d := p.next(); d.kind != tokDot {
+		return nil, fmt.Errorf("expected . after %s at position %d", root.text, d.pos)
+	}
+	attr := p.next()
+	if attr.kind != tokIdent {
+		return nil, fmt.Errorf("expected attribute name at position %d", attr.pos)
+	}
+	path := fmt.Sprintf("%s.%s", root.text, attr.text)
+	p.paths = append(p.paths, path)
+	return &pathNode{path: path}, nil
+}
+
+// -- evaluation
+
+type node interface {
+	eval(env *ConditionEnv) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(env *ConditionEnv) (interface{}, error) {
+	return n.value, nil
+}
+
+type pathNode struct {
+	path string
+}
+
+func (n *pathNode) eval(env *ConditionEnv) (interface{}, error) {
+	return lookup(env, n.path), nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(env *ConditionEnv) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(env *ConditionEnv) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! expects a boolean, received %s", describe(v))
+	}
+	return !b, nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n *logicalNode) eval(env *ConditionEnv) (interface{}, error) {
+	l, err := evalBool(n.op, n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	// short circuit
+	if (n.op == "&&" && !l) || (n.op == "||" && l) {
+		return l, nil
+	}
+	return evalBool(n.op, n.right, env)
+}
+
+func evalBool(op string, n node, env *ConditionEnv) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s expects booleans, received %s", op, describe(v))
+	}
+	return b, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) eval(env *ConditionEnv) (interface{}, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		list, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("in expects a list, received %s", describe(r))
+		}
+		for _, item := range list {
+			if equal(l, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return order(n.op, l, r)
+	}
+}
+
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case float64:
+		switch bv := b.(type) {
+		case float64:
+			return av == bv
+		case string:
+			return formatNumber(av) == bv
+		}
+	case string:
+		switch bv := b.(type) {
+		case string:
+			return av == bv
+		case float64:
+			return av == formatNumber(bv)
+		}
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	}
+	return false
+}
+
+func order(op string, a, b interface{}) (bool, error) {
+	var c int
+	switch av := a.(type) {
+	case float64:
+		bv, ok := toNumber(b)
+		if !ok {
+			return false, fmt.Errorf("%s cannot compare a number with %s", op, describe(b))
+		}
+		c = compareFloat(av, bv)
+	case string:
+		if bv, ok := b.(string); ok {
+			c = strings.Compare(av, bv)
+			break
+		}
+		an, aok := toNumber(av)
+		bn, bok := toNumber(b)
+		if !aok || !bok {
+			return false, fmt.Errorf("%s cannot compare %s with %s", op, describe(a), describe(b))
+		}
+		c = compareFloat(an, bn)
+	default:
+		return false, fmt.Errorf("%s cannot compare %s", op, describe(a))
+	}
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func lookup(env *ConditionEnv, path string) interface{} {
+	if env == nil {
+		return nil
+	}
+	parts := strings.SplitN(path, ".", 2)
+	attrs := env.Request
+	if parts[0] == ConditionUser {
+		attrs = env.User
+	}
+	return normalize(attrs[parts[1]])
+}
+
+// normalize converts the attribute values to the types of the language
+func normalize(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case []string:
+		l := make([]interface{}, len(n))
+		for i, s := range n {
+			l[i] = s
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func describe(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(n)
+	case float64:
+		return formatNumber(n)
+	case bool:
+		return strconv.FormatBool(n)
+	case []interface{}:
+		var items []string
+		for _, i := range n {
+			items = append(items, describe(i))
+		}
+		return fmt.Sprintf("[%s]", strings.Join(items, ", "))
+	default:
+		return fmt.Sprintf("%v", n)
+	}
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package rbac
+
+import (
+	"strings"
+	"testing"
+)
+
+func testConditionEnv() *ConditionEnv {
+	return &ConditionEnv{
+		User: map[string]interface{}{
+			"id":           int64(12),
+			"email":        "dicty@northwestern.edu",
+			"organization": "dictyBase",
+			"is_active":    true,
+			"country":      "",
+		},
+		Request: map[string]interface{}{
+			"owner_id":     "12",
+			"organization": "dictyBase",
+			"state":        "open",
+			"size":         "250",
+		},
+	}
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	cases := map[string]bool{
+		`user.id == request.owner_id`: true,
+		`user.id == 12`:               true,
+		`user.id != 12`:               false,
+		`user.organization == request.organization && user.is_active`:        true,
+		`user.organization == "other" || request.state == 'open'`:            true,
+		`!(user.organization == "other")`:                                    true,
+		`!user.is_active`:                                                    false,
+		`request.state in ["new", "open"]`:                                   true,
+		`request.state in ["closed"]`:                                        false,
+		`request.state in []`:                                                false,
+		`user.id in [1, 12, request.owner_id]`:                               true,
+		`request.size > 100`:                                                 true,
+		`request.size <= 100`:                                                false,
+		`user.id >= 12 && user.id < 13`:                                      true,
+		`request.state < "pending"`:                                          true,
+		`request.missing == null`:                                            true,
+		`user.missing != null`:                                               false,
+		`user.country == ""`:                                                 true,
+		`true && (false || (user.id == 12 && request.state != "closed"))`:    true,
+		`user.is_active == true`:                                             true,
+		`user.organization == "dictyBase" && request.owner_id == "13"`:       false,
+		`request.owner_id == "13" && request.missing > 2`:                    false,
+		`user.email == "dicty@northwestern.edu" || request.missing > 2`:      true,
+		`request.size == -1 || request.size == 250.0`:                        true,
+		`user.organization == "dicty\"Base" || user.organization == 'dicty'`: false,
+	}
+	env := testConditionEnv()
+	for src, exp := range cases {
+		c, err := ParseCondition(src)
+		if err != nil {
+			t.Fatalf("could not parse condition %s %s\n", src, err)
+		}
+		ok, err := c.Evaluate(env)
+		if err != nil {
+			t.Fatalf("could not evaluate condition %s %s\n", src, err)
+		}
+		if ok != exp {
+			t.Fatalf("expected %t for condition %s, received %t\n", exp, src, ok)
+		}
+	}
+}
+
+func TestConditionEvaluateError(t *testing.T) {
+	env := testConditionEnv()
+	for _, src := range []string{
+		`user.email`,
+		`user.email && true`,
+		`!user.email`,
+		`request.state in "open"`,
+		`user.is_active > 1`,
+		`request.state > 1`,
+		`request.missing < 1`,
+	} {
+		c, err := ParseCondition(src)
+		if err != nil {
+			t.Fatalf("could not parse condition %s %s\n", src, err)
+		}
+		if _, err := c.Evaluate(env); err == nil {
+			t.Fatalf("expected evaluation error for condition %s\n", src)
+		}
+	}
+}
+
+func TestParseConditionError(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`   `,
+		`user.id ==`,
+		`user.id = 12`,
+		`group.id == 12`,
+		`os.exit(1)`,
+		`user.id == 12)`,
+		`(user.id == 12`,
+		`user.`,
+		`user.id == "12`,
+		`request.state in ["new" "open"]`,
+		`user.id == 12 12`,
+		`user.id == 12 ; true`,
+		`user.id == 1.2.3`,
+		`in == 1`,
+		strings.Repeat("(", 40) + "true" + strings.Repeat(")", 40),
+		strings.Repeat("!", 40) + "true",
+		"request.a == \"" + strings.Repeat("a", maxConditionLength) + "\"",
+	} {
+		if err := ValidateCondition(src); err == nil {
+			t.Fatalf("expected parse error for condition %q\n", src)
+		}
+	}
+}
+
+func TestConditionExplain(t *testing.T) {
+	c, err := ParseCondition(`user.id == request.owner_id && request.state in ["open"] || user.id == 1`)
+	if err != nil {
+		t.Fatalf("could not parse condition %s\n", err)
+	}
+	exp := `request.owner_id="12", request.state="open", user.id=12`
+	if e := c.Explain(testConditionEnv()); e != exp {
+		t.Fatalf("expected explanation %s, received %s\n", exp, e)
+	}
+	exp = "request.owner_id=null, request.state=null, user.id=null"
+	if e := c.Explain(&ConditionEnv{}); e != exp {
+		t.Fatalf("expected explanation %s, received %s\n", exp, e)
+	}
+}
@@ -0,0 +1,174 @@
+package rbac
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RoleGrant is a grant along with the role it belongs to
+type RoleGrant struct {
+	Role string `json:"role"`
+	*Grant
+}
+
+// MatrixDiff lists the changes that turn a stored matrix into the desired
+// one. Roles and permissions that are stored but absent from the desired
+// matrix are not removed, they are listed as unmanaged.
+type MatrixDiff struct {
+	AddedPermissions     []*Permission `json:"added_permissions,omitempty"`
+	UpdatedPermissions   []*Permission `json:"updated_permissions,omitempty"`
+	AddedRoles           []*Role       `json:"added_roles,omitempty"`
+	UpdatedRoles         []*Role       `json:"updated_roles,omitempty"`
+	AddedGrants          []*RoleGrant  `json:"added_grants,omitempty"`
+	UpdatedGrants        []*RoleGrant  `json:"updated_grants,omitempty"`
+	RemovedGrants        []*RoleGrant  `json:"removed_grants,omitempty"`
+	UnmanagedRoles       []string      `json:"unmanaged_roles,omitempty"`
+	UnmanagedPermissions []string      `json:"unmanaged_permissions,omitempty"`
+}
+
+// IsEmpty tells if applying the desired matrix changes nothing
+func (d *MatrixDiff) IsEmpty() bool {
+	return len(d.AddedPermissions) == 0 && len(d.UpdatedPermissions) == 0 &&
+		len(d.AddedRoles) == 0 && len(d.UpdatedRoles) == 0 &&
+		len(d.AddedGrants) == 0 && len(d.UpdatedGrants) == 0 &&
+		len(d.RemovedGrants) == 0
+}
+
+// Roles returns the names of the roles whose description or grants change
+func (d *MatrixDiff) Roles() []string {
+	seen := make(map[string]bool)
+	var roles []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			roles = append(roles, name)
+		}
+	}
+	for _, r := range d.AddedRoles {
+		add(r.Role)
+	}
+	for _, r := range d.UpdatedRoles {
+		add(r.Role)
+	}
+	for _, gs := range [][]*RoleGrant{d.AddedGrants, d.UpdatedGrants, d.RemovedGrants} {
+		for _, g := range gs {
+			add(g.Role)
+		}
+	}
+	return roles
+}
+
+// Diff compares the stored matrix with the desired one, both are expected
+// to be sorted
+func Diff(current, desired *Matrix) *MatrixDiff {
+	d := &MatrixDiff{}
+	perms := make(map[string]*Permission)
+	for _, p := range current.Permissions {
+		perms[p.Key()] = p
+	}
+	wanted := make(map[string]bool)
+	for _, p := range desired.Permissions {
+		wanted[p.Key()] = true
+		cp, ok := perms[p.Key()]
+		switch {
+		case !ok:
+			d.AddedPermissions = append(d.AddedPermissions, p)
+		case cp.Description != p.Description:
+			d.UpdatedPermissions = append(d.UpdatedPermissions, p)
+		}
+	}
+	for _, p := range current.Permissions {
+		if !wanted[p.Key()] {
+			d.UnmanagedPermissions = append(d.UnmanagedPermissions, p.Key())
+		}
+	}
+	roles := make(map[string]*Role)
+	for _, r := range current.Roles {
+		roles[r.Role] = r
+	}
+	wanted = make(map[string]bool)
+	for _, r := range desired.Roles {
+		wanted[r.Role] = true
+		cr, ok := roles[r.Role]
+		switch {
+		case !ok:
+			d.AddedRoles = append(d.AddedRoles, r)
+			cr = &Role{Role: r.Role}
+		case cr.Description != r.Description:
+			d.UpdatedRoles = append(d.UpdatedRoles, r)
+		}
+		d.diffGrants(cr, r)
+	}
+	for _, r := range current.Roles {
+		if !wanted[r.Role] {
+			d.UnmanagedRoles = append(d.UnmanagedRoles, r.Role)
+		}
+	}
+	return d
+}
+
+// diffGrants adds the changes of the grants of a role, the grants of a role
+// in the desired matrix replace the stored ones
+func (d *MatrixDiff) diffGrants(current, desired *Role) {
+	for _, g := range desired.Permissions {
+		cg := current.Grant(g.Key())
+		switch {
+		case cg == nil:
+			d.AddedGrants = append(d.AddedGrants, &RoleGrant{Role: desired.Role, Grant: g})
+		case cg.Condition != g.Condition || cg.IsDeny() != g.IsDeny():
+			d.UpdatedGrants = append(d.UpdatedGrants, &RoleGrant{Role: desired.Role, Grant: g})
+		}
+	}
+	for _, g := range current.Permissions {
+		if !desired.HasGrant(g.Key()) {
+			d.RemovedGrants = append(d.RemovedGrants, &RoleGrant{Role: desired.Role, Grant: g})
+		}
+	}
+}
+
+// WriteDiff writes the changes one per line, prefixed with + for an
+// addition, ~ for an update and - for a removal
+func WriteDiff(w io.Writer, d *MatrixDiff) error {
+	var b strings.Builder
+	for _, p := range d.AddedPermissions {
+		fmt.Fprintf(&b, "+ permission %s\n", p.Key())
+	}
+	for _, p := range d.UpdatedPermissions {
+		fmt.Fprintf(&b, "~ permission %s description %q\n", p.Key(), p.Description)
+	}
+	for _, r := range d.AddedRoles {
+		fmt.Fprintf(&b, "+ role %s\n", r.Role)
+	}
+	for _, r := range d.UpdatedRoles {
+		fmt.Fprintf(&b, "~ role %s description %q\n", r.Role, r.Description)
+	}
+	for _, g := range d.AddedGrants {
+		fmt.Fprintf(&b, "+ grant %s %s%s\n", g.Role, g.Key(), grantDetail(g.Grant))
+	}
+	for _, g := range d.UpdatedGrants {
+		fmt.Fprintf(&b, "~ grant %s %s%s\n", g.Role, g.Key(), grantDetail(g.Grant))
+	}
+	for _, g := range d.RemovedGrants {
+		fmt.Fprintf(&b, "- grant %s %s\n", g.Role, g.Key())
+	}
+	for _, r := range d.UnmanagedRoles {
+		fmt.Fprintf(&b, "! role %s is not in the matrix and is kept\n", r)
+	}
+	for _, p := range d.UnmanagedPermissions {
+		fmt.Fprintf(&b, "! permission %s is not in the matrix and is kept\n", p)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func grantDetail(g *Grant) string {
+	var s string
+	if g.IsDeny() {
+		s += " " + EffectDeny
+	}
+	if len(g.Condition) > 0 {
+		s += fmt.Sprintf(" if %q", g.Condition)
+	}
+	return s
+}
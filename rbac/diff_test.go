@@ -0,0 +1,76 @@
+package rbac
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	current := testMatrix()
+	current.Permissions = append(current.Permissions, &Permission{Permission: "delete", Resource: "genes"})
+	current.Roles = append(current.Roles, &Role{Role: "visitor"})
+	desired := testMatrix()
+	desired.Permissions = append(desired.Permissions, &Permission{Permission: "read", Resource: "strains"})
+	desired.Permissions[0].Description = "edit and annotate genes"
+	curator := desired.Roles[0]
+	curator.Permissions = []*Grant{
+		{Permission: "write", Resource: "genes", Condition: "user.is_active", Effect: EffectDeny},
+		{Permission: "read", Resource: "strains"},
+	}
+	desired.Roles = append(desired.Roles, &Role{
+		Role:        "reader",
+		Permissions: []*Grant{{Permission: "read", Resource: "genes"}},
+	})
+	desired.Sort()
+	current.Sort()
+	d := Diff(current, desired)
+	if len(d.AddedPermissions) != 1 || d.AddedPermissions[0].Key() != "read:strains" {
+		t.Fatalf("expected read:strains to be added, received %+v", d.AddedPermissions)
+	}
+	if len(d.UpdatedPermissions) != 1 || d.UpdatedPermissions[0].Key() != "write:genes" {
+		t.Fatalf("expected the description of write:genes to change, received %+v", d.UpdatedPermissions)
+	}
+	if len(d.AddedRoles) != 1 || d.AddedRoles[0].Role != "reader" {
+		t.Fatalf("expected role reader to be added, received %+v", d.AddedRoles)
+	}
+	if len(d.AddedGrants) != 2 {
+		t.Fatalf("expected 2 added grants, received %d", len(d.AddedGrants))
+	}
+	if len(d.UpdatedGrants) != 1 || d.UpdatedGrants[0].Key() != "write:genes" {
+		t.Fatalf("expected the grant of write:genes to change, received %+v", d.UpdatedGrants)
+	}
+	if len(d.RemovedGrants) != 1 || d.RemovedGrants[0].Key() != "read:genes" || d.RemovedGrants[0].Role != "curator" {
+		t.Fatalf("expected read:genes to be revoked from curator, received %+v", d.RemovedGrants)
+	}
+	if len(d.UnmanagedRoles) != 1 || len(d.UnmanagedPermissions) != 1 {
+		t.Fatalf("expected visitor and delete:genes to be unmanaged, received %v %v", d.UnmanagedRoles, d.UnmanagedPermissions)
+	}
+	if roles := d.Roles(); len(roles) != 2 {
+		t.Fatalf("expected curator and reader to change, received %v", roles)
+	}
+	var b bytes.Buffer
+	if err := WriteDiff(&b, d); err != nil {
+		t.Fatalf("error in writing the diff %s", err)
+	}
+	for _, l := range []string{
+		"+ permission read:strains",
+		"+ role reader",
+		"~ grant curator write:genes deny if \"user.is_active\"",
+		"- grant curator read:genes",
+		"! role visitor is not in the matrix and is kept",
+	} {
+		if !strings.Contains(b.String(), l) {
+			t.Fatalf("expected line %q in the diff\n%s", l, b.String())
+		}
+	}
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	current, desired := testMatrix(), testMatrix()
+	desired.Roles[0].Members = 0
+	desired.Roles[0].Permissions[1].Effect = EffectAllow
+	if d := Diff(current, desired); !d.IsEmpty() {
+		t.Fatalf("expected no changes, received %+v", d)
+	}
+}
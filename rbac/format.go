@@ -0,0 +1,157 @@
+package rbac
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	FormatYAML     = "yaml"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+)
+
+// Formats are the supported output formats of a matrix
+var Formats = []string{FormatYAML, FormatCSV, FormatMarkdown}
+
+// ContentType returns the media type of the given output format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatMarkdown:
+		return "text/markdown"
+	default:
+		return "application/x-yaml"
+	}
+}
+
+// IsValidFormat checks if the output format is supported
+func IsValidFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the matrix in the given format
+func Write(w io.Writer, format string, m *Matrix) error {
+	m.Sort()
+	switch format {
+	case FormatYAML:
+		return WriteYAML(w, m)
+	case FormatCSV:
+		return WriteCSV(w, m)
+	case FormatMarkdown:
+		return WriteMarkdown(w, m)
+	default:
+		return fmt.Errorf(
+			"format %s is not supported, use one of %s",
+			format, strings.Join(Formats, ","),
+		)
+	}
+}
+
+// WriteYAML writes the matrix in yaml format which can be read back with
+// ReadYAML
+func WriteYAML(w io.Writer, m *Matrix) error {
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error in encoding yaml %s", err)
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// WriteCSV writes the matrix with a row for every role and a column for every
+// permission, the granted cells are marked with an x and the denied ones
+// with deny
+func WriteCSV(w io.Writer, m *Matrix) error {
+	cw := csv.NewWriter(w)
+	header := []string{"role", "members", "scoped_members"}
+	for _, p := range m.Permissions {
+		header = append(header, p.Key())
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range m.Roles {
+		row := []string{
+			r.Role,
+			strconv.FormatInt(r.Members, 10),
+			strconv.FormatInt(r.ScopedMembers, 10),
+		}
+		for _, p := range m.Permissions {
+			switch g := r.Grant(p.Key()); {
+			case g == nil:
+				row = append(row, "")
+			case g.IsDeny():
+				row = append(row, EffectDeny)
+			default:
+				row = append(row, "x")
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteMarkdown writes the matrix as a human readable report
+func WriteMarkdown(w io.Writer, m *Matrix) error {
+	var b strings.Builder
+	b.WriteString("# Roles and permissions\n\n")
+	b.WriteString("## Roles\n\n")
+	b.WriteString("| Role | Description | Members | Scoped members | Permissions |\n")
+	b.WriteString("| --- | --- | ---: | ---: | --- |\n")
+	for _, r := range m.Roles {
+		var keys []string
+		for _, g := range r.Permissions {
+			keys = append(keys, fmt.Sprintf("`%s`%s", g.Key(), mdEffect(g)))
+		}
+		fmt.Fprintf(
+			&b, "| %s | %s | %d | %d | %s |\n",
+			mdEscape(r.Role), mdEscape(r.Description),
+			r.Members, r.ScopedMembers, strings.Join(keys, ", "),
+		)
+	}
+	b.WriteString("\n## Permissions\n\n")
+	b.WriteString("| Permission | Resource | Description | Roles |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, p := range m.Permissions {
+		var roles []string
+		for _, r := range m.Roles {
+			if g := r.Grant(p.Key()); g != nil {
+				roles = append(roles, mdEscape(r.Role)+mdEffect(g))
+			}
+		}
+		fmt.Fprintf(
+			&b, "| %s | %s | %s | %s |\n",
+			mdEscape(p.Permission), mdEscape(p.Resource),
+			mdEscape(p.Description), strings.Join(roles, ", "),
+		)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mdEffect marks the denied grants
+func mdEffect(g *Grant) string {
+	if g.IsDeny() {
+		return " (" + EffectDeny + ")"
+	}
+	return ""
+}
+
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
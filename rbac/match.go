@@ -0,0 +1,255 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// Wildcard matches any verb when used as the permission and any
+	// segment, or any number of trailing segments, when used in a resource
+	Wildcard = "*"
+	// VerbSeparator separates the verbs of a verb set, for example
+	// read,write
+	VerbSeparator = ","
+	// ResourceSeparator separates the segments of a hierarchical resource,
+	// for example stocks/strains
+	ResourceSeparator = "/"
+)
+
+// Pattern is a permission and resource pair that can match more than one
+// requested permission and resource.
+//
+// The permission is either a single verb, a comma separated set of verbs or
+// the * wildcard that matches every verb.
+//
+// The resource is a slash separated path. A * segment in the middle of the
+// path matches exactly one segment, while a trailing * segment matches one
+// or more segments. So genes/* matches genes/DDB_G0267376 and
+// genes/DDB_G0267376/go but not genes, stocks/*/orders matches
+// stocks/strains/orders and a lone * matches every resource. Any other
+// segment has to match literally.
+type Pattern struct {
+	Permission string
+	Resource   string
+	verbs      []string
+	segments   []string
+}
+
+// ParsePattern validates and compiles a permission and resource pattern
+func ParsePattern(perm, resource string) (*Pattern, error) {
+	verbs, err := parseVerbs(perm)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := parseResource(resource)
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{
+		Permission: perm,
+		Resource:   resource,
+		verbs:      verbs,
+		segments:   segments,
+	}, nil
+}
+
+// ValidatePattern checks if the permission and resource form a valid
+// pattern
+func ValidatePattern(perm, resource string) error {
+	_, err := ParsePattern(perm, resource)
+	return err
+}
+
+// Key identifies the pattern in the same way as a permission of a matrix
+func (p *Pattern) Key() string {
+	return PermissionKey(p.Permission, p.Resource)
+}
+
+// Matches checks if the pattern grants the permission on the resource. The
+// requested permission and resource are always literal values, wildcards in
+// them are not expanded.
+func (p *Pattern) Matches(perm, resource string) bool {
+	return p.matchVerb(perm) && p.matchResource(resource)
+}
+
+// IsLiteral tells if the pattern matches a single permission and resource
+func (p *Pattern) IsLiteral() bool {
+	if len(p.verbs) != 1 || p.verbs[0] == Wildcard {
+		return false
+	}
+	for _, s := range p.segments {
+		if s == Wildcard {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Pattern) matchVerb(perm string) bool {
+	for _, v := range p.verbs {
+		if v == Wildcard || v == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pattern) matchResource(resource string) bool {
+	req := strings.Split(resource, ResourceSeparator)
+	last := len(p.segments) - 1
+	for i, s := range p.segments {
+		if i >= len(req) {
+			return false
+		}
+		if i == last && s == Wildcard {
+			// trailing wildcard takes the rest of the path
+			return true
+		}
+		if s != Wildcard && s != req[i] {
+			return false
+		}
+	}
+	return len(req) == len(p.segments)
+}
+
+// Compare orders two patterns by their specificity. It returns a negative
+// number when a is more specific than b, a positive one when b is more
+// specific and zero only for identical patterns. The rules are applied in
+// order until one of them breaks the tie,
+//  1. a resource without wildcards is more specific than one with them
+//  2. a resource with more literal segments is more specific
+//  3. a resource without a trailing wildcard is more specific
+//  4. a single verb is more specific than a verb set, a smaller verb set
+//     is more specific than a larger one and * is the least specific
+//  5. the patterns are compared by their keys
+func Compare(a, b *Pattern) int {
+	if c := compareBool(a.literalResource(), b.literalResource()); c != 0 {
+		return c
+	}
+	if c := b.literalSegments() - a.literalSegments(); c != 0 {
+		return c
+	}
+	if c := compareBool(!a.trailingWildcard(), !b.trailingWildcard()); c != 0 {
+		return c
+	}
+	if c := a.verbRank() - b.verbRank(); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Key(), b.Key())
+}
+
+// SortBySpecificity orders the patterns from the most to the least
+// specific one
+func SortBySpecificity(patterns []*Pattern) {
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return Compare(patterns[i], patterns[j]) < 0
+	})
+}
+
+// Match returns the patterns that grant the permission on the resource
+// ordered from the most to the least specific one
+func Match(patterns []*Pattern, perm, resource string) []*Pattern {
+	var matched []*Pattern
+	for _, p := range patterns {
+		if p.Matches(perm, resource) {
+			matched = append(matched, p)
+		}
+	}
+	SortBySpecificity(matched)
+	return matched
+}
+
+// BestMatch returns the most specific pattern that grants the permission on
+// the resource
+func BestMatch(patterns []*Pattern, perm, resource string) (*Pattern, bool) {
+	matched := Match(patterns, perm, resource)
+	if len(matched) == 0 {
+		return nil, false
+	}
+	return matched[0], true
+}
+
+func (p *Pattern) literalResource() bool {
+	return p.literalSegments() == len(p.segments)
+}
+
+func (p *Pattern) literalSegments() int {
+	var n int
+	for _, s := range p.segments {
+		if s != Wildcard {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *Pattern) trailingWildcard() bool {
+	return p.segments[len(p.segments)-1] == Wildcard
+}
+
+// verbRank is the number of matched verbs, a wildcard ranks below any
+// verb set
+func (p *Pattern) verbRank() int {
+	for _, v := range p.verbs {
+		if v == Wildcard {
+			return int(^uint(0) >> 1)
+		}
+	}
+	return len(p.verbs)
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func parseVerbs(perm string) ([]string, error) {
+	if len(perm) == 0 {
+		return nil, fmt.Errorf("permission cannot be empty")
+	}
+	if perm == Wildcard {
+		return []string{Wildcard}, nil
+	}
+	seen := make(map[string]bool)
+	verbs := strings.Split(perm, VerbSeparator)
+	for _, v := range verbs {
+		switch {
+		case len(v) == 0:
+			return nil, fmt.Errorf("permission %s has an empty verb", perm)
+		case v == Wildcard || strings.Contains(v, Wildcard):
+			return nil, fmt.Errorf("permission %s can only use %s on its own", perm, Wildcard)
+		case strings.ContainsAny(v, " \t\n:"+ResourceSeparator):
+			return nil, fmt.Errorf("permission %s has an invalid verb %q", perm, v)
+		case seen[v]:
+			return nil, fmt.Errorf("permission %s repeats the verb %s", perm, v)
+		}
+		seen[v] = true
+	}
+	return verbs, nil
+}
+
+func parseResource(resource string) ([]string, error) {
+	if len(resource) == 0 {
+		return nil, fmt.Errorf("resource cannot be empty")
+	}
+	segments := strings.Split(resource, ResourceSeparator)
+	for _, s := range segments {
+		switch {
+		case len(s) == 0:
+			return nil, fmt.Errorf("resource %s has an empty segment", resource)
+		case s != Wildcard && strings.Contains(s, Wildcard):
+			return nil, fmt.Errorf("resource %s can only use %s as a whole segment", resource, Wildcard)
+		case strings.ContainsAny(s, " \t\n:"+VerbSeparator):
+			return nil, fmt.Errorf("resource %s has an invalid segment %q", resource, s)
+		}
+	}
+	return segments, nil
+}
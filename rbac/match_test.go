@@ -0,0 +1,239 @@
+package rbac
+
+import (
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	valid := [][2]string{
+		{"read", "genes"},
+		{"*", "*"},
+		{"read,write", "genes"},
+		{"write", "genes/*"},
+		{"write", "stocks/strains/*"},
+		{"read", "stocks/*/orders"},
+		{"read", "*/public"},
+		{"edit", "strain_collection"},
+	}
+	for _, v := range valid {
+		if err := ValidatePattern(v[0], v[1]); err != nil {
+			t.Fatalf("expected %s:%s to be valid, received %s\n", v[0], v[1], err)
+		}
+	}
+	invalid := [][2]string{
+		{"", "genes"},
+		{"read", ""},
+		{"read,", "genes"},
+		{",read", "genes"},
+		{"read,,write", "genes"},
+		{"read,*", "genes"},
+		{"re*", "genes"},
+		{"read,read", "genes"},
+		{"read write", "genes"},
+		{"read:all", "genes"},
+		{"read/all", "genes"},
+		{"read", "genes/"},
+		{"read", "/genes"},
+		{"read", "genes//go"},
+		{"read", "genes*"},
+		{"read", "genes/DDB*"},
+		{"read", "**"},
+		{"read", "genes go"},
+		{"read", "genes:go"},
+		{"read", "genes,go"},
+	}
+	for _, v := range invalid {
+		if err := ValidatePattern(v[0], v[1]); err == nil {
+			t.Fatalf("expected %q:%q to be invalid\n", v[0], v[1])
+		}
+	}
+}
+
+func TestPatternMatches(t *testing.T) {
+	cases := []struct {
+		perm, resource  string
+		reqPerm, reqRes string
+		match           bool
+	}{
+		// literal
+		{"read", "genes", "read", "genes", true},
+		{"read", "genes", "write", "genes", false},
+		{"read", "genes", "read", "gene", false},
+		{"read", "genes", "read", "genes/DDB_G0267376", false},
+		{"read", "genes/DDB_G0267376", "read", "genes", false},
+		{"read", "genes", "Read", "genes", false},
+		// verb wildcard
+		{"*", "genes", "read", "genes", true},
+		{"*", "genes", "delete", "genes", true},
+		{"*", "genes", "delete", "stocks", false},
+		// verb set
+		{"read,write", "genes", "read", "genes", true},
+		{"read,write", "genes", "write", "genes", true},
+		{"read,write", "genes", "delete", "genes", false},
+		{"read,write", "genes", "read,write", "genes", false},
+		// resource wildcard on its own
+		{"read", "*", "read", "genes", true},
+		{"read", "*", "read", "genes/DDB_G0267376", true},
+		{"read", "*", "read", "stocks/strains/DBS0236123", true},
+		{"*", "*", "anything", "any/thing", true},
+		// trailing resource wildcard
+		{"write", "genes/*", "write", "genes/DDB_G0267376", true},
+		{"write", "genes/*", "write", "genes/DDB_G0267376/go", true},
+		{"write", "genes/*", "write", "genes", false},
+		{"write", "genes/*", "write", "genesis/DDB_G0267376", false},
+		{"write", "genes/*", "read", "genes/DDB_G0267376", false},
+		{"write", "stocks/strains/*", "write", "stocks/strains/DBS0236123", true},
+		{"write", "stocks/strains/*", "write", "stocks/plasmids/DBP0000001", false},
+		{"write", "stocks/strains/*", "write", "stocks/strains", false},
+		{"write", "stocks/strains/*", "write", "stocks", false},
+		// wildcard in the middle of a resource
+		{"read", "stocks/*/orders", "read", "stocks/strains/orders", true},
+		{"read", "stocks/*/orders", "read", "stocks/plasmids/orders", true},
+		{"read", "stocks/*/orders", "read", "stocks/strains/DBS0236123/orders", false},
+		{"read", "stocks/*/orders", "read", "stocks/orders", false},
+		{"read", "stocks/*/orders", "read", "stocks/strains/orders/12", false},
+		{"read", "*/public", "read", "genes/public", true},
+		{"read", "*/public", "read", "genes/private", false},
+		{"read", "stocks/*/*", "read", "stocks/strains/orders/12", true},
+		{"read", "stocks/*/*", "read", "stocks/strains", false},
+		// wildcards in the request are literal values
+		{"read", "genes", "*", "genes", false},
+		{"read", "genes", "read", "*", false},
+		{"read", "genes/DDB_G0267376", "read", "genes/*", false},
+		{"read", "genes/*", "read", "genes/*", true},
+		// empty request
+		{"read", "*", "read", "", true},
+		{"read", "genes", "", "genes", false},
+	}
+	for _, c := range cases {
+		p, err := ParsePattern(c.perm, c.resource)
+		if err != nil {
+			t.Fatalf("could not parse pattern %s:%s %s\n", c.perm, c.resource, err)
+		}
+		if m := p.Matches(c.reqPerm, c.reqRes); m != c.match {
+			t.Fatalf(
+				"expected pattern %s:%s to match %s:%s %t, received %t\n",
+				c.perm, c.resource, c.reqPerm, c.reqRes, c.match, m,
+			)
+		}
+	}
+}
+
+func TestIsLiteral(t *testing.T) {
+	cases := map[[2]string]bool{
+		{"read", "genes"}:              true,
+		{"read", "genes/DDB_G0267376"}: true,
+		{"*", "genes"}:                 false,
+		{"read,write", "genes"}:        false,
+		{"read", "genes/*"}:            false,
+		{"read", "*"}:                  false,
+	}
+	for k, v := range cases {
+		p, err := ParsePattern(k[0], k[1])
+		if err != nil {
+			t.Fatalf("could not parse pattern %s:%s %s\n", k[0], k[1], err)
+		}
+		if p.IsLiteral() != v {
+			t.Fatalf("expected literal %t for %s:%s\n", v, k[0], k[1])
+		}
+	}
+}
+
+func mustParse(t *testing.T, perm, resource string) *Pattern {
+	p, err := ParsePattern(perm, resource)
+	if err != nil {
+		t.Fatalf("could not parse pattern %s:%s %s\n", perm, resource, err)
+	}
+	return p
+}
+
+func TestCompare(t *testing.T) {
+	// every pair is ordered from the more to the less specific pattern
+	pairs := [][4]string{
+		// rule 1, literal resource first
+		{"*", "genes", "read", "genes/*"},
+		{"*", "stocks/strains", "read", "stocks/*/orders"},
+		// rule 2, more literal segments
+		{"read", "stocks/strains/*", "read", "stocks/*"},
+		{"read", "stocks/*/orders", "read", "stocks/*"},
+		{"*", "genes/*", "read", "*"},
+		// rule 3, no trailing wildcard
+		{"read", "stocks/*/orders", "read", "stocks/strains/*"},
+		{"read", "*/public", "read", "genes/*"},
+		// rule 4, verbs
+		{"read", "genes", "read,write", "genes"},
+		{"read,write", "genes", "read,write,delete", "genes"},
+		{"read,write,delete", "genes", "*", "genes"},
+		{"read", "genes/*", "*", "genes/*"},
+		// rule 5, keys
+		{"read", "genes", "write", "genes"},
+	}
+	for _, pr := range pairs {
+		a := mustParse(t, pr[0], pr[1])
+		b := mustParse(t, pr[2], pr[3])
+		if Compare(a, b) >= 0 {
+			t.Fatalf("expected %s to be more specific than %s\n", a.Key(), b.Key())
+		}
+		if Compare(b, a) <= 0 {
+			t.Fatalf("expected %s to be less specific than %s\n", b.Key(), a.Key())
+		}
+	}
+	a := mustParse(t, "read,write", "genes/*")
+	if Compare(a, mustParse(t, "read,write", "genes/*")) != 0 {
+		t.Fatal("expected identical patterns to compare equal")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	var patterns []*Pattern
+	for _, p := range [][2]string{
+		{"*", "*"},
+		{"read", "genes"},
+		{"write", "genes/*"},
+		{"read,write", "genes/*"},
+		{"*", "genes/DDB_G0267376"},
+		{"write", "genes/DDB_G0267376"},
+		{"write", "stocks/*"},
+	} {
+		patterns = append(patterns, mustParse(t, p[0], p[1]))
+	}
+	matched := Match(patterns, "write", "genes/DDB_G0267376")
+	expected := []string{
+		"write:genes/DDB_G0267376",
+		"*:genes/DDB_G0267376",
+		"write:genes/*",
+		"read,write:genes/*",
+		"*:*",
+	}
+	if len(matched) != len(expected) {
+		t.Fatalf("expected %d matches, received %d\n", len(expected), len(matched))
+	}
+	for i, m := range matched {
+		if m.Key() != expected[i] {
+			t.Fatalf("expected match %d to be %s, received %s\n", i, expected[i], m.Key())
+		}
+	}
+	best, ok := BestMatch(patterns, "read", "genes")
+	if !ok || best.Key() != "read:genes" {
+		t.Fatalf("expected read:genes as best match, received %v\n", best)
+	}
+	if _, ok := BestMatch(patterns[1:], "delete", "stocks"); ok {
+		t.Fatal("expected no match for delete:stocks")
+	}
+	if m := Match(nil, "read", "genes"); len(m) != 0 {
+		t.Fatal("expected no match without patterns")
+	}
+}
+
+func TestMatrixValidatePattern(t *testing.T) {
+	m := &Matrix{
+		Permissions: []*Permission{{Permission: "write", Resource: "genes/*x"}},
+	}
+	if err := m.Validate(); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+	m.Permissions[0].Resource = "genes/*"
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected valid matrix, received %s\n", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Package rbac provides a storage independent representation of the roles,
+// permissions and their bindings managed by the user microservice.
+package rbac
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Permission is an action allowed on a resource
+type Permission struct {
+	Permission  string `yaml:"permission" json:"permission"`
+	Resource    string `yaml:"resource" json:"resource"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+// Key uniquely identifies a permission within a matrix
+func (p *Permission) Key() string {
+	return PermissionKey(p.Permission, p.Resource)
+}
+
+// Effects of a grant, a denied permission takes precedence over any grant
+// that allows it
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Grant refers to a permission that is bound to a role, optionally
+// restricted by a condition. An empty effect allows the permission.
+type Grant struct {
+	Permission string `yaml:"permission" json:"permission"`
+	Resource   string `yaml:"resource" json:"resource"`
+	Condition  string `yaml:"condition,omitempty" json:"condition,omitempty"`
+	Effect     string `yaml:"effect,omitempty" json:"effect,omitempty"`
+}
+
+// Key uniquely identifies the granted permission within a matrix
+func (g *Grant) Key() string {
+	return PermissionKey(g.Permission, g.Resource)
+}
+
+// IsDeny tells if the grant denies the permission
+func (g *Grant) IsDeny() bool {
+	return g.Effect == EffectDeny
+}
+
+// IsValidEffect checks if the effect of a grant is supported, an empty
+// effect is the same as allow
+func IsValidEffect(effect string) bool {
+	return effect == "" || effect == EffectAllow || effect == EffectDeny
+}
+
+// Role is a named set of granted permissions. Members counts the users
+// holding the role globally, ScopedMembers the ones holding it for some
+// resources only.
+type Role struct {
+	Role          string   `yaml:"role" json:"role"`
+	Description   string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Members       int64    `yaml:"members" json:"members"`
+	ScopedMembers int64    `yaml:"scoped_members,omitempty" json:"scoped_members,omitempty"`
+	Permissions   []*Grant `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+}
+
+// Matrix is the complete set of roles, permissions and role to permission
+// bindings. The members counts of a role are informational and are ignored
+// when a matrix is loaded.
+type Matrix struct {
+	Roles       []*Role       `yaml:"roles" json:"roles"`
+	Permissions []*Permission `yaml:"permissions" json:"permissions"`
+}
+
+// PermissionKey generates the key for a permission and resource pair
+func PermissionKey(perm, resource string) string {
+	return fmt.Sprintf("%s:%s", perm, resource)
+}
+
+// Sort orders roles, permissions and grants so that the output of a matrix
+// is stable
+func (m *Matrix) Sort() {
+	sort.SliceStable(m.Roles, func(i, j int) bool {
+		return m.Roles[i].Role < m.Roles[j].Role
+	})
+	sort.SliceStable(m.Permissions, func(i, j int) bool {
+		return m.Permissions[i].Key() < m.Permissions[j].Key()
+	})
+	for _, r := range m.Roles {
+		grants := r.Permissions
+		sort.SliceStable(grants, func(i, j int) bool {
+			return grants[i].Key() < grants[j].Key()
+		})
+	}
+}
+
+// Validate checks that every granted permission is defined in the matrix,
+// that roles and permissions are not duplicated and that the permissions,
+// conditions and effects are valid
+func (m *Matrix) Validate() error {
+	perms := make(map[string]bool)
+	for _, p := range m.Permissions {
+		if perms[p.Key()] {
+			return fmt.Errorf("permission %s is defined more than once", p.Key())
+		}
+		if err := ValidatePattern(p.Permission, p.Resource); err != nil {
+			return err
+		}
+		perms[p.Key()] = true
+	}
+	roles := make(map[string]bool)
+	for _, r := range m.Roles {
+		if roles[r.Role] {
+			return fmt.Errorf("role %s is defined more than once", r.Role)
+		}
+		roles[r.Role] = true
+		for _, g := range r.Permissions {
+			if !perms[g.Key()] {
+				return fmt.Errorf("role %s refers to undefined permission %s", r.Role, g.Key())
+			}
+			if !IsValidEffect(g.Effect) {
+				return fmt.Errorf("role %s has unknown effect %s for %s", r.Role, g.Effect, g.Key())
+			}
+			if len(g.Condition) > 0 {
+				if err := ValidateCondition(g.Condition); err != nil {
+					return fmt.Errorf("role %s has invalid condition for %s %s", r.Role, g.Key(), err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// HasGrant checks if the role is bound to the permission with the given key
+func (r *Role) HasGrant(key string) bool {
+	return r.Grant(key) != nil
+}
+
+// Grant returns the binding of the role to the permission with the given
+// key, nil if the role is not bound to it
+func (r *Role) Grant(key string) *Grant {
+	for _, g := range r.Permissions {
+		if g.Key() == key {
+			return g
+		}
+	}
+	return nil
+}
+
+// ReadYAML reads a matrix that was written in yaml format
+func ReadYAML(r io.Reader) (*Matrix, error) {
+	m := &Matrix{}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return m, err
+	}
+	if err := yaml.UnmarshalStrict(b, m); err != nil {
+		return m, fmt.Errorf("error in decoding yaml %s", err)
+	}
+	return m, m.Validate()
+}
@@ -0,0 +1,181 @@
+package rbac
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testMatrix() *Matrix {
+	return &Matrix{
+		Roles: []*Role{
+			{
+				Role:          "curator",
+				Description:   "curate | annotate",
+				Members:       12,
+				ScopedMembers: 3,
+				Permissions: []*Grant{
+					{Permission: "write", Resource: "genes", Condition: "user.is_active"},
+					{Permission: "read", Resource: "genes"},
+				},
+			},
+			{
+				Role:    "admin",
+				Members: 2,
+				Permissions: []*Grant{
+					{Permission: "admin", Resource: "users"},
+				},
+			},
+		},
+		Permissions: []*Permission{
+			{Permission: "write", Resource: "genes", Description: "edit genes"},
+			{Permission: "read", Resource: "genes"},
+			{Permission: "admin", Resource: "users"},
+		},
+	}
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	m := testMatrix()
+	var b bytes.Buffer
+	if err := Write(&b, FormatYAML, m); err != nil {
+		t.Fatalf("error in writing yaml %s", err)
+	}
+	nm, err := ReadYAML(&b)
+	if err != nil {
+		t.Fatalf("error in reading yaml %s", err)
+	}
+	if !reflect.DeepEqual(m, nm) {
+		t.Fatalf("expected matrix %+v does not match the read matrix %+v", m, nm)
+	}
+}
+
+func TestReadYAMLUndefinedPermission(t *testing.T) {
+	in := `
+roles:
+- role: curator
+  members: 0
+  permissions:
+  - permission: write
+    resource: genes
+permissions: []
+`
+	if _, err := ReadYAML(strings.NewReader(in)); err == nil {
+		t.Fatal("expected error for undefined permission")
+	}
+}
+
+func TestReadYAMLInvalidCondition(t *testing.T) {
+	in := `
+roles:
+- role: curator
+  members: 0
+  permissions:
+  - permission: write
+    resource: genes
+    condition: group.id == 1
+permissions:
+- permission: write
+  resource: genes
+`
+	if _, err := ReadYAML(strings.NewReader(in)); err == nil {
+		t.Fatal("expected error for invalid condition")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, FormatCSV, testMatrix()); err != nil {
+		t.Fatalf("error in writing csv %s", err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("error in reading csv %s", err)
+	}
+	expected := [][]string{
+		{"role", "members", "scoped_members", "admin:users", "read:genes", "write:genes"},
+		{"admin", "2", "0", "x", "", ""},
+		{"curator", "12", "3", "", "x", "x"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("expected csv %v does not match %v", expected, records)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, FormatMarkdown, testMatrix()); err != nil {
+		t.Fatalf("error in writing markdown %s", err)
+	}
+	out := b.String()
+	for _, s := range []string{
+		"| curator | curate \\| annotate | 12 | 3 | `read:genes`, `write:genes` |",
+		"| write | genes | edit genes | curator |",
+		"| admin | users |  | admin |",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected line %q in markdown output\n%s", s, out)
+		}
+	}
+}
+
+func TestWriteDeny(t *testing.T) {
+	m := testMatrix()
+	m.Permissions = append(m.Permissions, &Permission{Permission: "write", Resource: "genes/front"})
+	m.Roles[0].Permissions = append(
+		m.Roles[0].Permissions,
+		&Grant{Permission: "write", Resource: "genes/front", Effect: EffectDeny},
+	)
+	m.Sort()
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected valid matrix %s", err)
+	}
+	var b bytes.Buffer
+	if err := Write(&b, FormatCSV, m); err != nil {
+		t.Fatalf("error in writing csv %s", err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("error in reading csv %s", err)
+	}
+	expected := []string{"curator", "12", "3", "", "x", "x", "deny"}
+	if !reflect.DeepEqual(records[2], expected) {
+		t.Fatalf("expected csv row %v does not match %v", expected, records[2])
+	}
+	b.Reset()
+	if err := Write(&b, FormatMarkdown, m); err != nil {
+		t.Fatalf("error in writing markdown %s", err)
+	}
+	for _, s := range []string{
+		"`read:genes`, `write:genes`, `write:genes/front` (deny) |",
+		"| write | genes/front |  | curator (deny) |",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected line %q in markdown output\n%s", s, b.String())
+		}
+	}
+	b.Reset()
+	if err := Write(&b, FormatYAML, m); err != nil {
+		t.Fatalf("error in writing yaml %s", err)
+	}
+	nm, err := ReadYAML(&b)
+	if err != nil {
+		t.Fatalf("error in reading yaml %s", err)
+	}
+	if !nm.Roles[1].Grant("write:genes/front").IsDeny() {
+		t.Fatal("expected the deny grant to survive a round trip")
+	}
+	m.Roles[0].Permissions[0].Effect = "block"
+	if err := m.Validate(); err == nil {
+		t.Fatal("expected error for unknown effect")
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, "xml", testMatrix()); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/rbac"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// ApplyRBAC makes the stored roles, permissions and grants match the
+// matrix in a single transaction and returns the changes made. The grants
+// of every role in the matrix replace the stored ones, the roles and
+// permissions absent from it are kept. Nothing is changed in a dry run.
+func (s *RoleService) ApplyRBAC(ctx context.Context, m *rbac.Matrix, dryRun bool) (*rbac.MatrixDiff, error) {
+	if err := m.Validate(); err != nil {
+		return &rbac.MatrixDiff{}, aphgrpc.HandleInsertArgError(ctx, err)
+	}
+	m.Sort()
+	tx, err := s.Dbh.Begin()
+	if err != nil {
+		return &rbac.MatrixDiff{}, aphgrpc.HandleError(ctx, err)
+	}
+	defer tx.AutoRollback()
+	// the stored matrix is read outside of the transaction, the lock keeps
+	// it from changing until the new one is applied
+	_, err = tx.SQL(`
+		LOCK TABLE auth_permission, auth_role, auth_role_permission
+		IN SHARE ROW EXCLUSIVE MODE`).
+		Exec()
+	if err != nil {
+		return &rbac.MatrixDiff{}, aphgrpc.HandleError(ctx, err)
+	}
+	current, err := s.ExportRBAC(ctx)
+	if err != nil {
+		return &rbac.MatrixDiff{}, err
+	}
+	diff := rbac.Diff(current, m)
+	if dryRun || diff.IsEmpty() {
+		return diff, nil
+	}
+	if err := s.applyPermissions(ctx, tx, diff); err != nil {
+		return &rbac.MatrixDiff{}, err
+	}
+	var permIds []*dbPermission
+	err = tx.Select("auth_permission_id", "permission", "resource").
+		From(permDbTable).
+		QueryStructs(&permIds)
+	if err != nil {
+		return &rbac.MatrixDiff{}, aphgrpc.HandleError(ctx, err)
+	}
+	perms := make(map[string]int64)
+	for _, p := range permIds {
+		perms[rbac.PermissionKey(p.Permission, p.Resource)] = p.AuthPermissionId.Int64
+	}
+	var affected []int64
+	for _, name := range diff.Roles() {
+		ids, err := s.applyRole(ctx, tx, diff, name, perms)
+		if err != nil {
+			return &rbac.MatrixDiff{}, err
+		}
+		affected = append(affected, ids...)
+	}
+	if err := tx.Commit(); err != nil {
+		return &rbac.MatrixDiff{}, aphgrpc.HandleError(ctx, err)
+	}
+	s.cache.invalidate(affected...)
+	return diff, nil
+}
+
+// applyPermissions stores the added permissions and the changed
+// descriptions
+func (s *RoleService) applyPermissions(ctx context.Context, tx *runner.Tx, diff *rbac.MatrixDiff) error {
+	for _, p := range diff.AddedPermissions {
+		if err := checkCatalog(tx, p.Permission, p.Resource); err != nil {
+			return err
+		}
+		au, err := newAudit(ctx, tx, roleRPC("ApplyRBAC"), "permissions", 0)
+		if err != nil {
+			return err
+		}
+		var id int64
+		err = tx.InsertInto(permDbTable).
+			Columns("permission", "resource", "description").
+			Values(p.Permission, p.Resource, nullString(p.Description)).
+			Returning("auth_permission_id").
+			QueryScalar(&id)
+		if err != nil {
+			return aphgrpc.HandleInsertError(ctx, err)
+		}
+		if err := au.created(id).record(tx); err != nil {
+			return err
+		}
+	}
+	for _, p := range diff.UpdatedPermissions {
+		var id int64
+		err := tx.Select("auth_permission_id").
+			From(permDbTable).
+			Where("permission = $1 AND resource = $2", p.Permission, p.Resource).
+			QueryScalar(&id)
+		if err != nil {
+			return aphgrpc.HandleError(ctx, err)
+		}
+		au, err := newAudit(ctx, tx, roleRPC("ApplyRBAC"), "permissions", id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Update(permDbTable).
+			Set("description", nullString(p.Description)).
+			Where("auth_permission_id = $1", id).
+			Exec()
+		if err != nil {
+			return aphgrpc.HandleUpdateError(ctx, err)
+		}
+		if err := au.record(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyRole stores the changes of a role and its grants as a single audited
+// change, it returns the users whose grants changed
+func (s *RoleService) applyRole(ctx context.Context, tx *runner.Tx, diff *rbac.MatrixDiff, name string, perms map[string]int64) ([]int64, error) {
+	var id int64
+	err := tx.Select("auth_role_id").
+		From(roleDbTable).
+		Where("role = $1", name).
+		QueryScalar(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, aphgrpc.HandleError(ctx, err)
+	}
+	au, err := newAudit(ctx, tx, roleRPC("ApplyRBAC"), "roles", id)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range diff.AddedRoles {
+		if r.Role != name {
+			continue
+		}
+		err := tx.InsertInto(roleDbTable).
+			Columns("role", "description").
+			Values(r.Role, r.Description).
+			Returning("auth_role_id").
+			QueryScalar(&id)
+		if err != nil {
+			return nil, aphgrpc.HandleInsertError(ctx, err)
+		}
+		au.created(id)
+	}
+	for _, r := range diff.UpdatedRoles {
+		if r.Role != name {
+			continue
+		}
+		_, err := tx.Update(roleDbTable).
+			Set("description", r.Description).
+			Where("auth_role_id = $1", id).
+			Exec()
+		if err != nil {
+			return nil, aphgrpc.HandleUpdateError(ctx, err)
+		}
+	}
+	for _, g := range diff.AddedGrants {
+		if g.Role != name {
+			continue
+		}
+		_, err := tx.InsertInto("auth_role_permission").
+			Columns("auth_role_id", "auth_permission_id", "effect", "condition").
+			Values(id, perms[g.Key()], grantEffect(g.Grant), nullString(g.Condition)).
+			Exec()
+		if err != nil {
+			return nil, aphgrpc.HandleInsertError(ctx, err)
+		}
+		au.relate("permissions", perms[g.Key()])
+	}
+	for _, g := range diff.UpdatedGrants {
+		if g.Role != name {
+			continue
+		}
+		_, err := tx.Update("auth_role_permission").
+			Set("effect", grantEffect(g.Grant)).
+			Set("condition", nullString(g.Condition)).
+			Where("auth_role_id = $1 AND auth_permission_id = $2", id, perms[g.Key()]).
+			Exec()
+		if err != nil {
+			return nil, aphgrpc.HandleUpdateError(ctx, err)
+		}
+		au.relate("permissions", perms[g.Key()])
+	}
+	for _, g := range diff.RemovedGrants {
+		if g.Role != name {
+			continue
+		}
+		_, err := tx.DeleteFrom("auth_role_permission").
+			Where("auth_role_id = $1 AND auth_permission_id = $2", id, perms[g.Key()]).
+			Exec()
+		if err != nil {
+			return nil, aphgrpc.HandleUpdateError(ctx, err)
+		}
+		au.relate("permissions", perms[g.Key()])
+	}
+	if err := au.record(tx); err != nil {
+		return nil, err
+	}
+	members, err := s.cache.roleUsers(tx, id)
+	if err != nil {
+		return nil, aphgrpc.HandleError(ctx, err)
+	}
+	return members, nil
+}
+
+// grantEffect is the stored effect of a grant, which allows the permission
+// unless it is denied
+func grantEffect(g *rbac.Grant) string {
+	if g.IsDeny() {
+		return rbac.EffectDeny
+	}
+	return rbac.EffectAllow
+}
+
+// nullString stores an empty value as null
+func nullString(s string) dat.NullString {
+	if len(s) == 0 {
+		return dat.NullString{}
+	}
+	return dat.NullStringFrom(s)
+}
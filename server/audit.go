@@ -0,0 +1,540 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const (
+	auditDbTable         = "auth_audit_event"
+	auditResourceDbTable = "auth_audit_event_resource"
+)
+
+const (
+	// DefaultAuditLimit is the number of events listed when no limit is
+	// given
+	DefaultAuditLimit = 100
+	// MaxAuditLimit is the largest number of events listed at once
+	MaxAuditLimit = 1000
+)
+
+// auditSnapshots are the queries returning the state of a resource as a
+// json document, the relationships are part of the state so that a changed
+// assignment shows up in the difference of the before and after values
+var auditSnapshots = map[string]string{
+	"users": `
+		SELECT to_jsonb(u) || jsonb_build_object(
+			'info', (
+				SELECT to_jsonb(i) - 'auth_user_id'
+				FROM auth_user_info i
+				WHERE i.auth_user_id = u.auth_user_id
+			),
+			'roles', (
+				SELECT COALESCE(jsonb_agg(jsonb_build_object(
+					'role_id', ur.auth_role_id,
+					'resource_type', ur.resource_type,
+					'resource_id', ur.resource_id
+				) ORDER BY ur.auth_role_id, ur.resource_type, ur.resource_id), '[]')
+				FROM auth_user_role ur
+				WHERE ur.auth_user_id = u.auth_user_id
+			)
+		)
+		FROM auth_user u
+		WHERE u.auth_user_id = $1`,
+	"roles": `
+		SELECT to_jsonb(r) || jsonb_build_object(
+			'protected', EXISTS (
+				SELECT 1 FROM auth_role_protected p
+				WHERE p.auth_role_id = r.auth_role_id
+			),
+			'permissions', (
+				SELECT COALESCE(jsonb_agg(jsonb_build_object(
+					'permission_id', rp.auth_permission_id,
+					'effect', rp.effect,
+					'condition', rp.condition
+				) ORDER BY rp.auth_permission_id), '[]')
+				FROM auth_role_permission rp
+				WHERE rp.auth_role_id = r.auth_role_id
+			),
+			'users', (
+				SELECT COALESCE(jsonb_agg(jsonb_build_object(
+					'user_id', ur.auth_user_id,
+					'resource_type', ur.resource_type,
+					'resource_id', ur.resource_id
+				) ORDER BY ur.auth_user_id, ur.resource_type, ur.resource_id), '[]')
+				FROM auth_user_role ur
+				WHERE ur.auth_role_id = r.auth_role_id
+			)
+		)
+		FROM auth_role r
+		WHERE r.auth_role_id = $1`,
+	"permissions": `
+		SELECT to_jsonb(p) || jsonb_build_object(
+			'protected', EXISTS (
+				SELECT 1 FROM auth_permission_protected pp
+				WHERE pp.auth_permission_id = p.auth_permission_id
+			)
+		)
+		FROM auth_permission p
+		WHERE p.auth_permission_id = $1`,
+	"role_constraints": `
+		SELECT to_jsonb(c)
+		FROM auth_role_constraint c
+		WHERE c.auth_role_constraint_id = $1`,
+	"catalog_entries": `
+		SELECT to_jsonb(c)
+		FROM auth_permission_catalog c
+		WHERE c.auth_permission_catalog_id = $1`,
+	"role_requests": `
+		SELECT to_jsonb(r)
+		FROM auth_role_request r
+		WHERE r.auth_role_request_id = $1`,
+}
+
+// AuditResource identifies a resource changed by an audited call
+type AuditResource struct {
+	Type string `json:"type"`
+	Id   int64  `json:"id"`
+}
+
+// AuditEvent records a change along with who made it. The before and
+// after values are the state of the first resource, the other resources
+// are the ones related by the change.
+type AuditEvent struct {
+	Id                    int64            `json:"id"`
+	ActorUserId           int64            `json:"actor_user_id,omitempty"`
+	ActorServiceAccountId int64            `json:"actor_service_account_id,omitempty"`
+	ImpersonatorUserId    int64            `json:"impersonator_user_id,omitempty"`
+	RPC                   string           `json:"rpc"`
+	Resources             []*AuditResource `json:"resources"`
+	Before                json.RawMessage  `json:"before,omitempty"`
+	After                 json.RawMessage  `json:"after,omitempty"`
+	CreatedAt             time.Time        `json:"created_at"`
+}
+
+// AuditEventCollection lists audit events, the latest first
+type AuditEventCollection struct {
+	Data []*AuditEvent `json:"data"`
+	// NextBefore is the cursor of the next page, it is absent on the last
+	// one
+	NextBefore int64 `json:"next_before,omitempty"`
+}
+
+// AuditEventFilter restricts the listed events, the zero values match
+// every event. A resource id is only matched along with its type.
+type AuditEventFilter struct {
+	ActorUserId           int64
+	ActorServiceAccountId int64
+	RPC                   string
+	ResourceType          string
+	ResourceId            int64
+	Since                 time.Time
+	Until                 time.Time
+	// Before lists the events older than the one with this id
+	Before int64
+	Limit  int64
+}
+
+type dbAuditEvent struct {
+	AuthAuditEventId      int64         `db:"auth_audit_event_id"`
+	ActorUserId           dat.NullInt64 `db:"actor_user_id"`
+	ActorServiceAccountId dat.NullInt64 `db:"actor_service_account_id"`
+	ImpersonatorUserId    dat.NullInt64 `db:"impersonator_user_id"`
+	RPC                   string        `db:"rpc"`
+	Resources             []byte        `db:"resources"`
+	BeforeValue           []byte        `db:"before_value"`
+	AfterValue            []byte        `db:"after_value"`
+	CreatedAt             time.Time     `db:"created_at"`
+}
+
+// audit collects a change made by a call, it is recorded in the
+// transaction of the change
+type audit struct {
+	ctx       context.Context
+	rpc       string
+	resources []*AuditResource
+	before    json.RawMessage
+	after     json.RawMessage
+	// assignments made by the change that the state of the primary
+	// resource does not show
+	assignments []*assignmentChange
+	// id and at identify the recorded event
+	id int64
+	at time.Time
+}
+
+// newAudit starts the audit of a change to the resource, the state of an
+// existing resource is taken as the before value. A new resource is given
+// without id, which is set with created once known.
+func newAudit(ctx context.Context, conn runner.Connection, rpc, typ string, id int64) (*audit, error) {
+	a := &audit{ctx: ctx, rpc: rpc, resources: []*AuditResource{{Type: typ, Id: id}}}
+	if id == 0 {
+		return a, nil
+	}
+	before, err := auditSnapshot(conn, typ, id)
+	if err != nil {
+		return nil, aphgrpc.HandleError(ctx, err)
+	}
+	a.before = before
+	return a, nil
+}
+
+// beginAudit starts the transaction of an audited change, the caller has to
+// roll it back unless it is committed through the audit
+func beginAudit(ctx context.Context, dbh *runner.DB, rpc, typ string, id int64) (*runner.Tx, *audit, error) {
+	tx, err := dbh.Begin()
+	if err != nil {
+		return nil, nil, aphgrpc.HandleError(ctx, err)
+	}
+	a, err := newAudit(ctx, tx, rpc, typ, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	return tx, a, nil
+}
+
+// commit records the event and commits the transaction of the change
+func (a *audit) commit(tx *runner.Tx) error {
+	if err := a.record(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return aphgrpc.HandleError(a.ctx, err)
+	}
+	return nil
+}
+
+// created sets the id of a new primary resource
+func (a *audit) created(id int64) *audit {
+	a.resources[0].Id = id
+	return a
+}
+
+// relate adds resources related by the change
+func (a *audit) relate(typ string, ids ...int64) *audit {
+	for _, id := range ids {
+		a.resources = append(a.resources, &AuditResource{Type: typ, Id: id})
+	}
+	return a
+}
+
+// assign adds role assignments made by the change to other resources than
+// the primary one
+func (a *audit) assign(changes ...*assignmentChange) *audit {
+	a.assignments = append(a.assignments, changes...)
+	return a
+}
+
+// values sets the before and after values of a change that has no stored
+// snapshot
+func (a *audit) values(before, after interface{}) error {
+	for _, v := range []struct {
+		src interface{}
+		dst *json.RawMessage
+	}{{before, &a.before}, {after, &a.after}} {
+		if v.src == nil {
+			continue
+		}
+		b, err := json.Marshal(v.src)
+		if err != nil {
+			return aphgrpc.HandleError(a.ctx, err)
+		}
+		*v.dst = b
+	}
+	return nil
+}
+
+// record stores the event along with the domain events derived from it,
+// the state of the primary resource is taken as the after value unless it
+// was set already
+func (a *audit) record(conn runner.Connection) error {
+	primary := a.resources[0]
+	if a.after == nil && primary.Id != 0 {
+		after, err := auditSnapshot(conn, primary.Type, primary.Id)
+		if err != nil {
+			return aphgrpc.HandleError(a.ctx, err)
+		}
+		a.after = after
+	}
+	var actor, account, impersonator dat.NullInt64
+	if p, ok := auth.FromContext(a.ctx); ok {
+		if p.IsServiceAccount() {
+			account = dat.NullInt64From(p.ServiceAccountId)
+		} else {
+			actor = dat.NullInt64From(p.UserId)
+		}
+		if p.IsImpersonated() {
+			impersonator = dat.NullInt64From(p.Impersonator.UserId)
+		}
+	}
+	err := conn.InsertInto(auditDbTable).
+		Columns(
+			"actor_user_id", "actor_service_account_id", "impersonator_user_id",
+			"rpc", "before_value", "after_value",
+		).
+		Values(actor, account, impersonator, a.rpc, nullJSON(a.before), nullJSON(a.after)).
+		Returning("auth_audit_event_id", "created_at").
+		QueryScalar(&a.id, &a.at)
+	if err != nil {
+		return aphgrpc.HandleInsertError(a.ctx, err)
+	}
+	id := a.id
+	seen := make(map[AuditResource]bool)
+	for i, r := range a.resources {
+		if r.Id == 0 || seen[*r] {
+			continue
+		}
+		seen[*r] = true
+		_, err := conn.InsertInto(auditResourceDbTable).
+			Columns("auth_audit_event_id", "resource_type", "resource_id", "position").
+			Values(id, r.Type, r.Id, i).
+			Exec()
+		if err != nil {
+			return aphgrpc.HandleInsertError(a.ctx, err)
+		}
+	}
+	events, err := auditEvents(conn, a)
+	if err != nil {
+		return err
+	}
+	return storeEvents(a.ctx, conn, events)
+}
+
+// auditSnapshot returns the state of a resource, nothing for a resource
+// that does not exist or has no snapshot query
+func auditSnapshot(conn runner.Connection, typ string, id int64) (json.RawMessage, error) {
+	query, ok := auditSnapshots[typ]
+	if !ok {
+		return nil, nil
+	}
+	var b []byte
+	err := conn.SQL(query, id).QueryScalar(&b)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+func nullJSON(b json.RawMessage) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+func userRPC(method string) string {
+	return "/dictybase.user.UserService/" + method
+}
+
+func roleRPC(method string) string {
+	return "/dictybase.user.RoleService/" + method
+}
+
+func permissionRPC(method string) string {
+	return "/dictybase.user.PermissionService/" + method
+}
+
+// AuditService lists the changes recorded by the user, role and permission
+// services
+type AuditService struct {
+	*aphgrpc.Service
+}
+
+func auditServiceOptions() *aphgrpc.ServiceOptions {
+	return &aphgrpc.ServiceOptions{
+		Resource:   "audit_events",
+		PathPrefix: "audit_events",
+	}
+}
+
+// NewAuditService creates the service
+func NewAuditService(dbh *runner.DB, opt ...aphgrpc.Option) *AuditService {
+	so := auditServiceOptions()
+	for _, optfn := range opt {
+		optfn(so)
+	}
+	srv := &aphgrpc.Service{Dbh: dbh}
+	aphgrpc.AssignFieldsToStructs(so, srv)
+	return &AuditService{Service: srv}
+}
+
+// HTTPRoutes returns the audit endpoints
+func (s *AuditService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/audit_events", Handler: s.listHandler},
+	}
+}
+
+// ListAuditEvents returns the recorded events matching the filter, the
+// latest first
+func (s *AuditService) ListAuditEvents(ctx context.Context, r *AuditEventFilter) (*AuditEventCollection, error) {
+	limit := r.Limit
+	switch {
+	case limit < 0:
+		return &AuditEventCollection{}, status.Error(codes.InvalidArgument, "limit cannot be negative")
+	case limit == 0:
+		limit = DefaultAuditLimit
+	case limit > MaxAuditLimit:
+		limit = MaxAuditLimit
+	}
+	if r.ResourceId != 0 && len(r.ResourceType) == 0 {
+		return &AuditEventCollection{}, status.Error(codes.InvalidArgument, "a resource id requires the resource type")
+	}
+	var conds []string
+	var args []interface{}
+	cond := func(expr string, v interface{}) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(expr, len(args)))
+	}
+	if r.ActorUserId != 0 {
+		cond("e.actor_user_id = $%d", r.ActorUserId)
+	}
+	if r.ActorServiceAccountId != 0 {
+		cond("e.actor_service_account_id = $%d", r.ActorServiceAccountId)
+	}
+	if len(r.RPC) > 0 {
+		cond("e.rpc = $%d", r.RPC)
+	}
+	if len(r.ResourceType) > 0 {
+		args = append(args, r.ResourceType)
+		exists := fmt.Sprintf("er.resource_type = $%d", len(args))
+		if r.ResourceId != 0 {
+			args = append(args, r.ResourceId)
+			exists += fmt.Sprintf(" AND er.resource_id = $%d", len(args))
+		}
+		conds = append(conds, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM auth_audit_event_resource er
+			WHERE er.auth_audit_event_id = e.auth_audit_event_id
+			AND %s
+		)`, exists))
+	}
+	if !r.Since.IsZero() {
+		cond("e.created_at >= $%d", r.Since)
+	}
+	if !r.Until.IsZero() {
+		cond("e.created_at < $%d", r.Until)
+	}
+	if r.Before != 0 {
+		cond("e.auth_audit_event_id < $%d", r.Before)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, limit+1)
+	var dbrows []*dbAuditEvent
+	err := s.Dbh.SQL(fmt.Sprintf(`
+		SELECT e.auth_audit_event_id, e.actor_user_id, e.actor_service_account_id,
+			e.impersonator_user_id, e.rpc, e.before_value, e.after_value, e.created_at,
+			(
+				SELECT COALESCE(jsonb_agg(jsonb_build_object(
+					'type', er.resource_type, 'id', er.resource_id
+				) ORDER BY er.position), '[]')
+				FROM auth_audit_event_resource er
+				WHERE er.auth_audit_event_id = e.auth_audit_event_id
+			) resources
+		FROM auth_audit_event e
+		%s
+		ORDER BY e.auth_audit_event_id DESC
+		LIMIT $%d`, where, len(args)), args...).
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &AuditEventCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	coll := &AuditEventCollection{Data: make([]*AuditEvent, 0)}
+	if int64(len(dbrows)) > limit {
+		dbrows = dbrows[:limit]
+		coll.NextBefore = dbrows[limit-1].AuthAuditEventId
+	}
+	for _, d := range dbrows {
+		e, err := dbToAuditEvent(d)
+		if err != nil {
+			return &AuditEventCollection{}, aphgrpc.HandleError(ctx, err)
+		}
+		coll.Data = append(coll.Data, e)
+	}
+	return coll, nil
+}
+
+func dbToAuditEvent(d *dbAuditEvent) (*AuditEvent, error) {
+	e := &AuditEvent{
+		Id:                    d.AuthAuditEventId,
+		ActorUserId:           aphgrpc.NullToInt64(d.ActorUserId),
+		ActorServiceAccountId: aphgrpc.NullToInt64(d.ActorServiceAccountId),
+		ImpersonatorUserId:    aphgrpc.NullToInt64(d.ImpersonatorUserId),
+		RPC:                   d.RPC,
+		Before:                d.BeforeValue,
+		After:                 d.AfterValue,
+		CreatedAt:             d.CreatedAt,
+	}
+	if err := json.Unmarshal(d.Resources, &e.Resources); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// -- HTTP handlers
+
+func (s *AuditService) listHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	q := r.URL.Query()
+	f := &AuditEventFilter{RPC: q.Get("rpc"), ResourceType: q.Get("resource_type")}
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"actor_user_id", &f.ActorUserId},
+		{"actor_service_account_id", &f.ActorServiceAccountId},
+		{"resource_id", &f.ResourceId},
+		{"before", &f.Before},
+		{"limit", &f.Limit},
+	} {
+		v := q.Get(p.name)
+		if len(v) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid %s %s", p.name, v))
+			return
+		}
+		*p.dst = n
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &f.Since},
+		{"until", &f.Until},
+	} {
+		v := q.Get(p.name)
+		if len(v) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid %s %s, expected RFC 3339 time", p.name, v))
+			return
+		}
+		*p.dst = t
+	}
+	coll, err := s.ListAuditEvents(r.Context(), f)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestAuditEvents(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("read", "users"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("reader", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	admin, err := pb.NewUserServiceClient(conn).CreateUser(context.Background(), NewUser("admin@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not store the admin %s\n", err)
+	}
+
+	dbh := runner.NewDB(db, "postgres")
+	ctx := auth.NewContext(context.Background(), &auth.Principal{UserId: admin.Data.Id, Email: "admin@gmail.com"})
+	us := NewUserService(dbh)
+	usr, err := us.CreateUser(ctx, NewUser("audited@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not create the user %s\n", err)
+	}
+	upd := NewUser("renamed@gmail.com")
+	_, err = us.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id: usr.Data.Id,
+		Data: &pb.UpdateUserRequest_Data{
+			Type:       "users",
+			Id:         usr.Data.Id,
+			Attributes: upd.Data.Attributes,
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not update the user %s\n", err)
+	}
+	_, err = NewRoleService(dbh).CreateUserRelationship(
+		ctx,
+		&jsonapi.DataCollection{Id: role.Data.Id, Data: []*jsonapi.Data{{Type: "users", Id: usr.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not assign the role %s\n", err)
+	}
+	if _, err := us.DeleteUser(ctx, &jsonapi.DeleteRequest{Id: usr.Data.Id}); err != nil {
+		t.Fatalf("could not delete the user %s\n", err)
+	}
+
+	as := NewAuditService(dbh)
+	coll, err := as.ListAuditEvents(context.Background(), &AuditEventFilter{ResourceType: "users", ResourceId: usr.Data.Id})
+	if err != nil {
+		t.Fatalf("could not list the audit events %s\n", err)
+	}
+	rpcs := []string{
+		userRPC("DeleteUser"),
+		roleRPC("CreateUserRelationship"),
+		userRPC("UpdateUser"),
+		userRPC("CreateUser"),
+	}
+	if len(coll.Data) != len(rpcs) {
+		t.Fatalf("expected %d events of the user, received %d\n", len(rpcs), len(coll.Data))
+	}
+	for i, e := range coll.Data {
+		if e.RPC != rpcs[i] {
+			t.Fatalf("expected %s as event %d, received %s\n", rpcs[i], i, e.RPC)
+		}
+		if e.ActorUserId != admin.Data.Id {
+			t.Fatalf("expected the admin as actor of %s, received %d\n", e.RPC, e.ActorUserId)
+		}
+	}
+	if coll.Data[3].Before != nil || coll.Data[0].After != nil {
+		t.Fatal("expected no before value for a creation and no after value for a deletion")
+	}
+	var before, after struct {
+		Email string `json:"email"`
+		Roles []struct {
+			RoleId int64 `json:"role_id"`
+		} `json:"roles"`
+	}
+	if err := json.Unmarshal(coll.Data[2].Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(coll.Data[2].After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if before.Email != "audited@gmail.com" || after.Email != "renamed@gmail.com" {
+		t.Fatalf("expected the email change, received %s and %s\n", before.Email, after.Email)
+	}
+	assign := coll.Data[1]
+	if len(assign.Resources) != 2 || assign.Resources[0].Type != "roles" || assign.Resources[0].Id != role.Data.Id {
+		t.Fatalf("expected the role and the user as resources, received %+v\n", assign.Resources)
+	}
+
+	coll, err = as.ListAuditEvents(context.Background(), &AuditEventFilter{ActorUserId: admin.Data.Id, Limit: 2})
+	if err != nil {
+		t.Fatalf("could not list the events of the actor %s\n", err)
+	}
+	if len(coll.Data) != 2 || coll.NextBefore == 0 {
+		t.Fatalf("expected a first page of two events, received %d\n", len(coll.Data))
+	}
+	coll, err = as.ListAuditEvents(context.Background(), &AuditEventFilter{ActorUserId: admin.Data.Id, Before: coll.NextBefore})
+	if err != nil {
+		t.Fatalf("could not list the next page %s\n", err)
+	}
+	if len(coll.Data) != 2 || coll.NextBefore != 0 {
+		t.Fatalf("expected the last page of two events, received %d\n", len(coll.Data))
+	}
+	coll, err = as.ListAuditEvents(context.Background(), &AuditEventFilter{RPC: permissionRPC("CreatePermission")})
+	if err != nil {
+		t.Fatalf("could not list the events of the rpc %s\n", err)
+	}
+	if len(coll.Data) != 1 || coll.Data[0].ActorUserId != 0 {
+		t.Fatalf("expected the permission created without actor, received %+v\n", coll.Data)
+	}
+	coll, err = as.ListAuditEvents(context.Background(), &AuditEventFilter{Until: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("could not list the events of the time range %s\n", err)
+	}
+	if len(coll.Data) != 0 {
+		t.Fatalf("expected no event an hour ago, received %d\n", len(coll.Data))
+	}
+	if _, err := as.ListAuditEvents(context.Background(), &AuditEventFilter{ResourceId: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a resource id without type to be rejected, received %v\n", err)
+	}
+}
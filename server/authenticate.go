@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dictyBase/modware-user/auth"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// authorizationKey is the metadata key of the bearer token, the grpc
+// gateway forwards the Authorization header under it
+const authorizationKey = "authorization"
+
+const bearerPrefix = "bearer "
+
+// Authenticator verifies the bearer token of every call and checks that
+// its user holds the permission the method requires according to the
+// policy. Calls to public methods are allowed without a token, calls to
+// authenticated methods only need a valid one. Impersonated users are
+// refused the sensitive methods.
+type Authenticator struct {
+	dbh      *runner.DB
+	verifier *auth.Verifier
+	policy   *auth.Policy
+	cache    *PermissionCache
+}
+
+type dbPrincipal struct {
+	AuthUserId int64  `db:"auth_user_id"`
+	Email      string `db:"email"`
+	IsActive   bool   `db:"is_active"`
+}
+
+// NewAuthenticator creates an authenticator, the permissions of the users
+// are resolved from the role assignments in the database
+func NewAuthenticator(dbh *runner.DB, v *auth.Verifier, p *auth.Policy) *Authenticator {
+	return &Authenticator{dbh: dbh, verifier: v, policy: p}
+}
+
+// WithPermissionCache resolves the permissions through the cache
+func (a *Authenticator) WithPermissionCache(c *PermissionCache) *Authenticator {
+	a.cache = c
+	return a
+}
+
+// UnaryServerInterceptor authorizes the grpc calls, including the ones
+// forwarded by the http gateway
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var token string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(authorizationKey); len(v) > 0 {
+				token = v[0]
+			}
+		}
+		actx, err := a.Authorize(ctx, info.FullMethod, token)
+		if err != nil {
+			return nil, err
+		}
+		return handler(actx, req)
+	}
+}
+
+// HTTPRoutes wraps the handlers of the routes that are served outside of
+// the grpc gateway with the authorization of the route
+func (a *Authenticator) HTTPRoutes(routes []*HTTPRoute) []*HTTPRoute {
+	wrapped := make([]*HTTPRoute, 0, len(routes))
+	for _, rt := range routes {
+		method := auth.HTTPMethod(rt.Method, rt.Path)
+		handler := rt.Handler
+		wrapped = append(wrapped, &HTTPRoute{
+			Method: rt.Method,
+			Path:   rt.Path,
+			Handler: func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+				ctx, err := a.Authorize(r.Context(), method, r.Header.Get("Authorization"))
+				if err != nil {
+					writeHTTPError(w, err)
+					return
+				}
+				handler(w, r.WithContext(ctx), params)
+			},
+		})
+	}
+	return wrapped
+}
+
+// Authorize checks the authorization header value of a call to the method
+// and returns the context with the authenticated principal
+func (a *Authenticator) Authorize(ctx context.Context, method, authorization string) (context.Context, error) {
+	rule, err := a.policy.Rule(method)
+	if err != nil {
+		return ctx, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if len(authorization) == 0 {
+		if rule.Public {
+			return ctx, nil
+		}
+		return ctx, status.Error(codes.Unauthenticated, "bearer token is required")
+	}
+	if !strings.HasPrefix(strings.ToLower(authorization), bearerPrefix) {
+		return ctx, status.Error(codes.Unauthenticated, "authorization is not a bearer token")
+	}
+	p, err := a.authenticate(strings.TrimSpace(authorization[len(bearerPrefix):]))
+	if err != nil {
+		return ctx, err
+	}
+	actx := auth.NewContext(ctx, p)
+	tagPrincipal(actx, p)
+	if p.IsImpersonated() && rule.Sensitive {
+		return ctx, status.Errorf(
+			codes.PermissionDenied,
+			"%s cannot be called while impersonating user %d", method, p.UserId,
+		)
+	}
+	if rule.Public || rule.Authenticated {
+		return actx, nil
+	}
+	d, err := resolvePermission(a.dbh, a.cache, &PermissionCheck{
+		UserId:           p.UserId,
+		ServiceAccountId: p.ServiceAccountId,
+		Permission:       rule.Permission,
+		Resource:         rule.Resource,
+		Context:          map[string]string{"method": method},
+	})
+	if err != nil {
+		return ctx, status.Error(codes.Internal, err.Error())
+	}
+	if !d.Allowed {
+		who := fmt.Sprintf("user %d", p.UserId)
+		if p.IsServiceAccount() {
+			who = fmt.Sprintf("service account %s", p.Name)
+		}
+		return ctx, status.Errorf(
+			codes.PermissionDenied,
+			"%s lacks the %s permission on %s",
+			who, rule.Permission, rule.Resource,
+		)
+	}
+	return actx, nil
+}
+
+// authenticate finds the principal of a bearer credential, which is either
+// a service account API key or a json web token of a user
+func (a *Authenticator) authenticate(credential string) (*auth.Principal, error) {
+	if strings.HasPrefix(credential, APIKeyPrefix) {
+		dbsa, err := authenticateAPIKey(a.dbh, credential)
+		if err != nil {
+			return nil, err
+		}
+		return &auth.Principal{
+			ServiceAccountId: dbsa.AuthServiceAccountId,
+			Name:             dbsa.Name,
+		}, nil
+	}
+	claims, err := a.verifier.Verify(credential)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token %s", err)
+	}
+	return a.principal(claims)
+}
+
+// principal finds the active user of the token. The subject is either the
+// id or the email of the user, the email claim is used when the subject is
+// neither.
+func (a *Authenticator) principal(c *auth.Claims) (*auth.Principal, error) {
+	q := a.dbh.Select("auth_user_id", "CAST(email AS TEXT) email", "is_active").From("auth_user")
+	if id, err := strconv.ParseInt(c.Subject, 10, 64); err == nil {
+		q = q.Where("auth_user_id = $1", id)
+	} else if strings.Contains(c.Subject, "@") {
+		q = q.Where("email = $1", c.Subject)
+	} else {
+		q = q.Where("email = $1", c.Email)
+	}
+	var dbrows []*dbPrincipal
+	if err := q.QueryStructs(&dbrows); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(dbrows) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "user %s of the token is unknown", c.Subject)
+	}
+	if !dbrows[0].IsActive {
+		return nil, status.Errorf(codes.Unauthenticated, "user %d is not active", dbrows[0].AuthUserId)
+	}
+	p := &auth.Principal{
+		UserId: dbrows[0].AuthUserId,
+		Email:  dbrows[0].Email,
+		Claims: c,
+	}
+	if c.Actor != nil {
+		if err := a.impersonation(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// impersonation sets the administrator of an impersonation token. The
+// session of the token id has to belong to the user and the actor of the
+// token and must neither be stopped nor expired, so that stopping it takes
+// effect right away.
+func (a *Authenticator) impersonation(p *auth.Principal) error {
+	c := p.Claims
+	id, err := strconv.ParseInt(c.Id, 10, 64)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "impersonation token has no valid id")
+	}
+	dbi, err := getImpersonation(a.dbh, id)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "impersonation %d of the token is unknown", id)
+	}
+	if dbi.TargetUserId != p.UserId || strconv.FormatInt(dbi.AdminUserId, 10) != c.Actor.Subject {
+		return status.Errorf(codes.Unauthenticated, "impersonation %d does not match the token", id)
+	}
+	if state := impersonationState(dbi, time.Now()); state != ImpersonationActive {
+		return status.Errorf(codes.Unauthenticated, "impersonation %d is %s", id, state)
+	}
+	admin, err := getPrincipal(a.dbh, dbi.AdminUserId)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !admin.IsActive {
+		return status.Errorf(codes.Unauthenticated, "user %d is not active", admin.AuthUserId)
+	}
+	p.Impersonator = &auth.Principal{UserId: admin.AuthUserId, Email: admin.Email}
+	p.ImpersonationId = id
+	return nil
+}
+
+// tagPrincipal adds the identities of the request to the logged tags
+func tagPrincipal(ctx context.Context, p *auth.Principal) {
+	tags := grpc_ctxtags.Extract(ctx)
+	if p.IsServiceAccount() {
+		tags.Set("auth.service_account_id", p.ServiceAccountId)
+		return
+	}
+	tags.Set("auth.user_id", p.UserId)
+	if p.IsImpersonated() {
+		tags.Set("auth.impersonator_id", p.Impersonator.UserId)
+		tags.Set("auth.impersonation_id", p.ImpersonationId)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func hs256Token(t *testing.T, secret string, c *auth.Claims) string {
+	h := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := h + "." + base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return "Bearer " + input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticatorAuthorize(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("read", "users"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("reader", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(
+		context.Background(),
+		NewUserWithRole("reader@gmail.com", role),
+	)
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	a := NewAuthenticator(
+		runner.NewDB(db, "postgres"),
+		&auth.Verifier{Sources: []auth.KeySource{auth.HMACKey("secret")}},
+		auth.DefaultPolicy(),
+	)
+	exp := time.Now().Add(time.Hour).Unix()
+	byId := hs256Token(t, "secret", &auth.Claims{Subject: fmt.Sprintf("%d", usr.Data.Id), ExpiresAt: exp})
+	byEmail := hs256Token(t, "secret", &auth.Claims{Subject: "reader@gmail.com", ExpiresAt: exp})
+	ctx, err := a.Authorize(context.Background(), "/dictybase.user.UserService/GetUser", byId)
+	if err != nil {
+		t.Fatalf("expected GetUser to be allowed, received %s\n", err)
+	}
+	p, ok := auth.FromContext(ctx)
+	if !ok || p.UserId != usr.Data.Id || p.Email != "reader@gmail.com" {
+		t.Fatalf("expected the principal of the user, received %+v\n", p)
+	}
+	if _, err := a.Authorize(context.Background(), "GET /users/{id}/role_assignments", byEmail); err != nil {
+		t.Fatalf("expected the route to be allowed for the email subject, received %s\n", err)
+	}
+	for _, tc := range []struct {
+		method, token string
+		code          codes.Code
+	}{
+		{"/dictybase.user.UserService/UpdateUser", byId, codes.PermissionDenied},
+		{"/dictybase.user.UserService/GetUser", "", codes.Unauthenticated},
+		{"/dictybase.user.UserService/GetUser", "Basic cmVhZGVyOnNlY3JldA==", codes.Unauthenticated},
+		{"/dictybase.user.UserService/GetUser", hs256Token(t, "guess", &auth.Claims{Subject: "1", ExpiresAt: exp}), codes.Unauthenticated},
+		{"/dictybase.user.UserService/GetUser", hs256Token(t, "secret", &auth.Claims{Subject: "nobody@gmail.com", ExpiresAt: exp}), codes.Unauthenticated},
+	} {
+		_, err := a.Authorize(context.Background(), tc.method, tc.token)
+		if status.Code(err) != tc.code {
+			t.Fatalf("expected %s for %s, received %v\n", tc.code, tc.method, err)
+		}
+	}
+	if _, err := a.Authorize(context.Background(), "/dictybase.user.UserService/Healthz", ""); err != nil {
+		t.Fatalf("expected the health check to be public, received %s\n", err)
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/modware-user/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const requestParamPrefix = rbac.ConditionRequest + "."
+
+type dbRoleAssignment struct {
+	AuthRoleId   int64          `db:"auth_role_id"`
+	Role         string         `db:"role"`
+	ResourceType dat.NullString `db:"resource_type"`
+	ResourceId   dat.NullString `db:"resource_id"`
+}
+
+// dbRoleGrant is a permission pattern granted through a role assignment
+type dbRoleGrant struct {
+	dbRoleAssignment
+	Permission string         `db:"permission"`
+	Resource   string         `db:"resource"`
+	Condition  dat.NullString `db:"condition"`
+	Effect     string         `db:"effect"`
+}
+
+// RoleAssignment is a role held by an user, either globally or within the
+// scope of a single resource
+type RoleAssignment struct {
+	RoleId int64  `json:"role_id"`
+	Role   string `json:"role"`
+	Scope  *Scope `json:"scope,omitempty"`
+}
+
+// RoleAssignmentCollection lists all roles held by an user
+type RoleAssignmentCollection struct {
+	Data []*RoleAssignment `json:"data"`
+}
+
+// PermissionCheck asks whether an user holds a permission on a resource.
+// The permission and resource are literal values that are matched against
+// the stored permission patterns. Without a resource id only the global
+// role assignments are considered. The context holds the attributes of
+// the request that are available to the conditions of the grants. The
+// roles of the service account are checked instead of the ones of the user
+// when it is set.
+type PermissionCheck struct {
+	UserId           int64
+	ServiceAccountId int64
+	Permission       string
+	Resource         string
+	ResourceId       string
+	Context          map[string]string
+}
+
+// PermissionDecision is the outcome of a permission check along with the
+// role assignments that grant the permission and the most specific of the
+// matching permission patterns. A permission that is denied through any
+// role assignment is not allowed, the denying assignments and the most
+// specific deny pattern are reported along with the overridden grants. The
+// conditions explain every conditional grant that was considered, whether
+// or not it was satisfied.
+type PermissionDecision struct {
+	Allowed    bool                    `json:"allowed"`
+	Grants     []*RoleAssignment       `json:"grants"`
+	Match      *rbac.Grant             `json:"match,omitempty"`
+	Denied     []*RoleAssignment       `json:"denied,omitempty"`
+	Deny       *rbac.Grant             `json:"deny,omitempty"`
+	Conditions []*ConditionExplanation `json:"conditions,omitempty"`
+}
+
+// ListRoleAssignments returns every role of the user with its scope
+func (s *UserService) ListRoleAssignments(ctx context.Context, r *jsonapi.IdRequest) (*RoleAssignmentCollection, error) {
+	var dbrows []*dbRoleAssignment
+	err := s.Dbh.Select(
+		"role.auth_role_id", "role.role",
+		"auth_user_role.resource_type", "auth_user_role.resource_id",
+	).From(`
+			auth_user_role
+			JOIN auth_role role
+			ON auth_user_role.auth_role_id = role.auth_role_id
+		`).
+		Where("auth_user_role.auth_user_id = $1", r.Id).
+		OrderBy("role.role", "auth_user_role.resource_type", "auth_user_role.resource_id").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &RoleAssignmentCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	return &RoleAssignmentCollection{Data: dbToRoleAssignments(dbrows)}, nil
+}
+
+// CheckPermission resolves whether the user holds the permission on the
+// resource through any of the global assignments or the assignments
+// scoped to the resource
+func (s *UserService) CheckPermission(ctx context.Context, r *PermissionCheck) (*PermissionDecision, error) {
+	if len(r.Permission) == 0 || len(r.Resource) == 0 {
+		return &PermissionDecision{}, status.Error(codes.InvalidArgument, "permission and resource are required")
+	}
+	exists, err := s.existsResource(r.UserId)
+	if err != nil {
+		return &PermissionDecision{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return &PermissionDecision{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("user id %d not found", r.UserId))
+	}
+	d, err := resolvePermission(s.Dbh, s.cache, r)
+	if err != nil {
+		return &PermissionDecision{}, aphgrpc.HandleError(ctx, err)
+	}
+	return d, nil
+}
+
+// resolvePermission matches the permission patterns granted through the
+// global and resource scoped assignments of the user against the requested
+// permission and resource. The grants are ordered by the specificity of
+// their patterns, the most specific one is reported as the match. A grant
+// with a condition only matches when the condition holds for the user and
+// request attributes. Any matching deny grant overrides the allowing ones.
+func resolvePermission(conn runner.Connection, pc *PermissionCache, r *PermissionCheck) (*PermissionDecision, error) {
+	dbrows, err := pc.grants(conn, r)
+	if err != nil {
+		return nil, err
+	}
+	var allowed, denied []*matchedGrant
+	var explained []*ConditionExplanation
+	ev := &conditionEvaluator{conn: conn, check: r}
+	for _, g := range dbrows {
+		p, err := rbac.ParsePattern(g.Permission, g.Resource)
+		if err != nil {
+			// patterns are validated when stored, rows that predate the
+			// validation never match
+			continue
+		}
+		if !p.Matches(r.Permission, r.Resource) {
+			continue
+		}
+		if g.Condition.Valid {
+			x, err := ev.evaluate(g)
+			if err != nil {
+				return nil, err
+			}
+			explained = append(explained, x)
+			if !x.Satisfied {
+				continue
+			}
+		}
+		if g.Effect == rbac.EffectDeny {
+			denied = append(denied, &matchedGrant{pattern: p, row: g})
+		} else {
+			allowed = append(allowed, &matchedGrant{pattern: p, row: g})
+		}
+	}
+	sortMatchedGrants(allowed)
+	sortMatchedGrants(denied)
+	d := &PermissionDecision{
+		// a deny takes precedence over any allow, however specific
+		Allowed:    len(allowed) > 0 && len(denied) == 0,
+		Grants:     matchedAssignments(allowed),
+		Conditions: explained,
+	}
+	if d.Allowed {
+		d.Match = allowed[0].grant()
+	}
+	if len(denied) > 0 {
+		d.Denied = matchedAssignments(denied)
+		d.Deny = denied[0].grant()
+	}
+	return d, nil
+}
+
+// loadGrants fetches the permission patterns granted through the global
+// assignments of the user or service account and, with a resource id, the
+// assignments scoped to the resource
+func loadGrants(conn runner.Connection, r *PermissionCheck) ([]*dbRoleGrant, error) {
+	table, column, id := "auth_user_role", "auth_user_id", r.UserId
+	if r.ServiceAccountId > 0 {
+		table, column, id = "auth_service_account_role", "auth_service_account_id", r.ServiceAccountId
+	}
+	scope := "assignment.resource_type IS NULL"
+	args := []interface{}{id}
+	if len(r.ResourceId) > 0 {
+		scope = `(assignment.resource_type IS NULL OR
+			(assignment.resource_type = $2 AND assignment.resource_id = $3))`
+		args = append(args, r.Resource, r.ResourceId)
+	}
+	var dbrows []*dbRoleGrant
+	err := conn.Select(
+		"DISTINCT role.auth_role_id", "role.role",
+		"assignment.resource_type", "assignment.resource_id",
+		"perm.permission", "perm.resource",
+		"auth_role_permission.condition", "auth_role_permission.effect",
+	).From(fmt.Sprintf(`
+			%s assignment
+			JOIN auth_role role
+			ON assignment.auth_role_id = role.auth_role_id
+			JOIN auth_role_permission
+			ON auth_role_permission.auth_role_id = role.auth_role_id
+			JOIN auth_permission perm
+			ON perm.auth_permission_id = auth_role_permission.auth_permission_id
+		`, table)).
+		Where(fmt.Sprintf("assignment.%s = $1 AND %s", column, scope), args...).
+		OrderBy("role.role").
+		QueryStructs(&dbrows)
+	return dbrows, err
+}
+
+type matchedGrant struct {
+	pattern *rbac.Pattern
+	row     *dbRoleGrant
+}
+
+func (m *matchedGrant) grant() *rbac.Grant {
+	g := &rbac.Grant{
+		Permission: m.pattern.Permission,
+		Resource:   m.pattern.Resource,
+		Condition:  m.row.Condition.String,
+	}
+	if m.row.Effect == rbac.EffectDeny {
+		g.Effect = rbac.EffectDeny
+	}
+	return g
+}
+
+// sortMatchedGrants orders the grants from the most to the least specific
+// pattern
+func sortMatchedGrants(matched []*matchedGrant) {
+	sort.SliceStable(matched, func(i, j int) bool {
+		return rbac.Compare(matched[i].pattern, matched[j].pattern) < 0
+	})
+}
+
+// matchedAssignments returns the distinct role assignments of the grants
+func matchedAssignments(matched []*matchedGrant) []*RoleAssignment {
+	ra := make([]*RoleAssignment, 0)
+	seen := make(map[string]bool)
+	for _, m := range matched {
+		key := fmt.Sprintf(
+			"%d:%s:%s", m.row.AuthRoleId,
+			aphgrpc.NullToString(m.row.ResourceType),
+			aphgrpc.NullToString(m.row.ResourceId),
+		)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		ra = append(ra, dbToRoleAssignment(&m.row.dbRoleAssignment))
+	}
+	return ra
+}
+
+func dbToRoleAssignments(dbrows []*dbRoleAssignment) []*RoleAssignment {
+	ra := make([]*RoleAssignment, 0)
+	for _, d := range dbrows {
+		ra = append(ra, dbToRoleAssignment(d))
+	}
+	return ra
+}
+
+func dbToRoleAssignment(d *dbRoleAssignment) *RoleAssignment {
+	a := &RoleAssignment{RoleId: d.AuthRoleId, Role: d.Role}
+	if d.ResourceType.Valid {
+		a.Scope = &Scope{
+			ResourceType: d.ResourceType.String,
+			ResourceId:   aphgrpc.NullToString(d.ResourceId),
+		}
+	}
+	return a
+}
+
+// -- HTTP handlers
+
+func (s *UserService) listRoleAssignmentsHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	coll, err := s.ListRoleAssignments(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *UserService) checkPermissionHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	check := &PermissionCheck{
+		UserId:     id,
+		Permission: q.Get("permission"),
+		Resource:   q.Get("resource"),
+		ResourceId: q.Get("resource_id"),
+		Context:    make(map[string]string),
+	}
+	// request attributes for the conditions are passed as request.<name>
+	for k := range q {
+		if strings.HasPrefix(k, requestParamPrefix) {
+			check.Context[strings.TrimPrefix(k, requestParamPrefix)] = q.Get(k)
+		}
+	}
+	d, err := s.CheckPermission(r.Context(), check)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
@@ -0,0 +1,400 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestScopedRoleAssignment(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("edit", "strain_collection"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("curator", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	uclient := pb.NewUserServiceClient(conn)
+	usr, err := uclient.CreateUser(context.Background(), NewUser("curator@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	sctx := metadata.AppendToOutgoingContext(
+		context.Background(),
+		ScopeResourceTypeKey, "strain_collection",
+		ScopeResourceIdKey, "12",
+	)
+	_, err = uclient.CreateRoleRelationship(
+		sctx,
+		&jsonapi.DataCollection{Id: usr.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: role.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not create the scoped relationship with role %s\n", err)
+	}
+	groles, err := uclient.GetRelatedRoles(context.Background(), &jsonapi.RelationshipRequest{Id: usr.Data.Id})
+	if err != nil {
+		t.Fatalf("could not fetch the global roles %s\n", err)
+	}
+	if len(groles.Data) != 0 {
+		t.Fatalf("expected no global roles, received %d\n", len(groles.Data))
+	}
+	sroles, err := uclient.GetRelatedRoles(sctx, &jsonapi.RelationshipRequest{Id: usr.Data.Id})
+	if err != nil {
+		t.Fatalf("could not fetch the scoped roles %s\n", err)
+	}
+	if len(sroles.Data) != 1 {
+		t.Fatalf("expected 1 scoped role, received %d\n", len(sroles.Data))
+	}
+
+	s := NewUserService(runner.NewDB(db, "postgres"))
+	ra, err := s.ListRoleAssignments(context.Background(), &jsonapi.IdRequest{Id: usr.Data.Id})
+	if err != nil {
+		t.Fatalf("could not list the role assignments %s\n", err)
+	}
+	if len(ra.Data) != 1 || ra.Data[0].Scope == nil || ra.Data[0].Scope.ResourceId != "12" {
+		t.Fatalf("expected a single assignment scoped to 12, received %+v\n", ra.Data)
+	}
+	cases := []struct {
+		resourceId string
+		allowed    bool
+	}{
+		{"12", true},
+		{"13", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		d, err := s.CheckPermission(context.Background(), &PermissionCheck{
+			UserId:     usr.Data.Id,
+			Permission: "edit",
+			Resource:   "strain_collection",
+			ResourceId: c.resourceId,
+		})
+		if err != nil {
+			t.Fatalf("could not check the permission %s\n", err)
+		}
+		if d.Allowed != c.allowed {
+			t.Fatalf("expected allowed %t for resource id %q, received %t\n", c.allowed, c.resourceId, d.Allowed)
+		}
+	}
+	_, err = uclient.DeleteRoleRelationship(
+		sctx,
+		&jsonapi.DataCollection{Id: usr.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: role.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not delete the scoped relationship %s\n", err)
+	}
+	ra, err = s.ListRoleAssignments(context.Background(), &jsonapi.IdRequest{Id: usr.Data.Id})
+	if err != nil {
+		t.Fatalf("could not list the role assignments %s\n", err)
+	}
+	if len(ra.Data) != 0 {
+		t.Fatalf("expected no assignments, received %d\n", len(ra.Data))
+	}
+}
+
+func TestCheckPermissionPattern(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	pclient := pb.NewPermissionServiceClient(conn)
+	_, err = pclient.CreatePermission(context.Background(), NewPermission("write", "genes/*x"))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for invalid pattern, received %s\n", err)
+	}
+	wild, err := pclient.CreatePermission(context.Background(), NewPermission("read,write", "genes/*"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("annotator", wild),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(
+		context.Background(),
+		NewUserWithRole("annotator@gmail.com", role),
+	)
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	s := NewUserService(runner.NewDB(db, "postgres"))
+	d, err := s.CheckPermission(context.Background(), &PermissionCheck{
+		UserId:     usr.Data.Id,
+		Permission: "write",
+		Resource:   "genes/DDB_G0267376",
+	})
+	if err != nil {
+		t.Fatalf("could not check the permission %s\n", err)
+	}
+	if !d.Allowed || d.Match == nil || d.Match.Resource != "genes/*" {
+		t.Fatalf("expected write on genes/DDB_G0267376 through genes/*, received %+v\n", d)
+	}
+	for _, req := range [][2]string{{"delete", "genes/DDB_G0267376"}, {"write", "genes"}} {
+		d, err := s.CheckPermission(context.Background(), &PermissionCheck{
+			UserId:     usr.Data.Id,
+			Permission: req[0],
+			Resource:   req[1],
+		})
+		if err != nil {
+			t.Fatalf("could not check the permission %s\n", err)
+		}
+		if d.Allowed {
+			t.Fatalf("expected %s on %s to be denied\n", req[0], req[1])
+		}
+	}
+}
+
+func TestCheckPermissionCondition(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("write", "genes/*"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("curator", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(
+		context.Background(),
+		NewUserWithRole("curator@gmail.com", role),
+	)
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	rs := NewRoleService(runner.NewDB(db, "postgres"))
+	_, err = rs.SetPermissionCondition(context.Background(), &GrantCondition{
+		RoleId:       role.Data.Id,
+		PermissionId: perm.Data.Id,
+		Condition:    "user.organization == request.organization &&",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for invalid condition, received %s\n", err)
+	}
+	_, err = rs.SetPermissionCondition(context.Background(), &GrantCondition{
+		RoleId:       role.Data.Id,
+		PermissionId: perm.Data.Id + 100,
+		Condition:    "user.is_active",
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound for unbound permission, received %s\n", err)
+	}
+	cond := `user.organization == request.organization && request.state in ["new", "open"]`
+	_, err = rs.SetPermissionCondition(context.Background(), &GrantCondition{
+		RoleId:       role.Data.Id,
+		PermissionId: perm.Data.Id,
+		Condition:    cond,
+	})
+	if err != nil {
+		t.Fatalf("could not set the condition %s\n", err)
+	}
+	gc, err := rs.GetPermissionCondition(context.Background(), &GrantCondition{
+		RoleId:       role.Data.Id,
+		PermissionId: perm.Data.Id,
+	})
+	if err != nil {
+		t.Fatalf("could not fetch the condition %s\n", err)
+	}
+	if gc.Condition != cond {
+		t.Fatalf("expected condition %s, received %s\n", cond, gc.Condition)
+	}
+
+	s := NewUserService(runner.NewDB(db, "postgres"))
+	check := &PermissionCheck{
+		UserId:     usr.Data.Id,
+		Permission: "write",
+		Resource:   "genes/DDB_G0267376",
+		Context:    map[string]string{"organization": "Gadd organization", "state": "open"},
+	}
+	d, err := s.CheckPermission(context.Background(), check)
+	if err != nil {
+		t.Fatalf("could not check the permission %s\n", err)
+	}
+	if !d.Allowed || d.Match.Condition != cond {
+		t.Fatalf("expected the conditional grant to match, received %+v\n", d)
+	}
+	if len(d.Conditions) != 1 || !d.Conditions[0].Satisfied {
+		t.Fatalf("expected one satisfied condition, received %+v\n", d.Conditions)
+	}
+	check.Context["state"] = "closed"
+	d, err = s.CheckPermission(context.Background(), check)
+	if err != nil {
+		t.Fatalf("could not check the permission %s\n", err)
+	}
+	if d.Allowed || len(d.Conditions) != 1 || d.Conditions[0].Satisfied {
+		t.Fatalf("expected the permission to be denied by the condition, received %+v\n", d)
+	}
+	if !strings.Contains(d.Conditions[0].Attributes, `request.state="closed"`) {
+		t.Fatalf("expected the attributes in the explanation, received %s\n", d.Conditions[0].Attributes)
+	}
+
+	m, err := rs.ExportRBAC(context.Background())
+	if err != nil {
+		t.Fatalf("could not export %s\n", err)
+	}
+	if m.Roles[0].Permissions[0].Condition != cond {
+		t.Fatalf("expected condition in the export, received %+v\n", m.Roles[0].Permissions[0])
+	}
+}
+
+func TestCheckPermissionDeny(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	pclient := pb.NewPermissionServiceClient(conn)
+	all, err := pclient.CreatePermission(context.Background(), NewPermission("write", "pages/*"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	front, err := pclient.CreatePermission(context.Background(), NewPermission("write", "pages/front"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	rclient := pb.NewRoleServiceClient(conn)
+	role, err := rclient.CreateRole(context.Background(), NewRoleWithPermission("curator", all))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	_, err = rclient.CreatePermissionRelationship(
+		metadata.AppendToOutgoingContext(context.Background(), GrantEffectKey, "block"),
+		&jsonapi.DataCollection{Id: role.Data.Id, Data: []*jsonapi.Data{{Type: "permissions", Id: front.Data.Id}}},
+	)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for unknown effect, received %s\n", err)
+	}
+	_, err = rclient.CreatePermissionRelationship(
+		metadata.AppendToOutgoingContext(context.Background(), GrantEffectKey, "deny"),
+		&jsonapi.DataCollection{Id: role.Data.Id, Data: []*jsonapi.Data{{Type: "permissions", Id: front.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not deny the permission %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(
+		context.Background(),
+		NewUserWithRole("curator@gmail.com", role),
+	)
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	s := NewUserService(runner.NewDB(db, "postgres"))
+	d, err := s.CheckPermission(context.Background(), &PermissionCheck{
+		UserId:     usr.Data.Id,
+		Permission: "write",
+		Resource:   "pages/about",
+	})
+	if err != nil {
+		t.Fatalf("could not check the permission %s\n", err)
+	}
+	if !d.Allowed || d.Deny != nil {
+		t.Fatalf("expected write on pages/about to be allowed, received %+v\n", d)
+	}
+	d, err = s.CheckPermission(context.Background(), &PermissionCheck{
+		UserId:     usr.Data.Id,
+		Permission: "write",
+		Resource:   "pages/front",
+	})
+	if err != nil {
+		t.Fatalf("could not check the permission %s\n", err)
+	}
+	if d.Allowed || d.Deny == nil || d.Deny.Resource != "pages/front" {
+		t.Fatalf("expected write on pages/front to be denied, received %+v\n", d)
+	}
+	if len(d.Grants) != 1 || len(d.Denied) != 1 {
+		t.Fatalf("expected the overridden grant and the deny, received %+v\n", d)
+	}
+
+	var header metadata.MD
+	_, err = rclient.GetRelatedPermissions(
+		context.Background(),
+		&jsonapi.RelationshipRequest{Id: role.Data.Id},
+		grpc.Header(&header),
+	)
+	if err != nil {
+		t.Fatalf("could not fetch related permissions %s\n", err)
+	}
+	if v := header.Get(DeniedPermissionsKey); len(v) != 1 || v[0] != strconv.FormatInt(front.Data.Id, 10) {
+		t.Fatalf("expected denied permission %d in the header, received %v\n", front.Data.Id, v)
+	}
+	rs := NewRoleService(runner.NewDB(db, "postgres"))
+	gc, err := rs.GetGrantedPermissions(context.Background(), &jsonapi.RelationshipRequest{Id: role.Data.Id})
+	if err != nil {
+		t.Fatalf("could not fetch granted permissions %s\n", err)
+	}
+	effects := make(map[int64]string)
+	for _, gp := range gc.Data {
+		effects[gp.Id] = gp.Grant.Effect
+	}
+	if effects[all.Data.Id] != "allow" || effects[front.Data.Id] != "deny" {
+		t.Fatalf("expected allow and deny effects, received %v\n", effects)
+	}
+	m, err := rs.ExportRBAC(context.Background())
+	if err != nil {
+		t.Fatalf("could not export %s\n", err)
+	}
+	if g := m.Roles[0].Grant("write:pages/front"); g == nil || !g.IsDeny() {
+		t.Fatalf("expected the deny in the export, received %+v\n", m.Roles[0].Permissions)
+	}
+	if _, err := rs.SetPermissionEffect(context.Background(), &GrantEffect{
+		RoleId:       role.Data.Id,
+		PermissionId: front.Data.Id,
+		Effect:       "allow",
+	}); err != nil {
+		t.Fatalf("could not change the effect %s\n", err)
+	}
+	d, err = s.CheckPermission(context.Background(), &PermissionCheck{
+		UserId:     usr.Data.Id,
+		Permission: "write",
+		Resource:   "pages/front",
+	})
+	if err != nil {
+		t.Fatalf("could not check the permission %s\n", err)
+	}
+	if !d.Allowed || d.Match.Resource != "pages/front" {
+		t.Fatalf("expected write on pages/front to be allowed, received %+v\n", d)
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+type dbBulkUser struct {
+	AuthUserId int64  `db:"auth_user_id"`
+	Email      string `db:"email"`
+}
+
+// BulkRoleRequest grants or revokes a role for every user matching the
+// filter. The filter uses the same syntax and attributes as the filter of
+// ListUsers, for example is_active==true;organization=@consortium
+type BulkRoleRequest struct {
+	Id     int64  `json:"-"`
+	Filter string `json:"filter"`
+	// DryRun only reports the users that would be changed
+	DryRun bool `json:"dry_run"`
+	// Scope restricts the assignments to a resource, global when absent
+	Scope *Scope `json:"scope,omitempty"`
+}
+
+// BulkUser is an user affected by a bulk operation
+type BulkUser struct {
+	Id    int64  `json:"id"`
+	Email string `json:"email"`
+}
+
+// BulkRoleResult reports the users matching the filter and the ones whose
+// assignments were, or in a dry run would be, changed
+type BulkRoleResult struct {
+	Matched int64       `json:"matched"`
+	Changed int64       `json:"changed"`
+	DryRun  bool        `json:"dry_run"`
+	Users   []*BulkUser `json:"users"`
+}
+
+// BulkGrantRole assigns the role to all matching users who do not hold it
+// yet, in a single transaction
+func (s *RoleService) BulkGrantRole(ctx context.Context, r *BulkRoleRequest) (*BulkRoleResult, error) {
+	return s.bulkChange(ctx, r, true)
+}
+
+// BulkRevokeRole removes the role from all matching users who hold it, in
+// a single transaction
+func (s *RoleService) BulkRevokeRole(ctx context.Context, r *BulkRoleRequest) (*BulkRoleResult, error) {
+	return s.bulkChange(ctx, r, false)
+}
+
+func (s *RoleService) bulkChange(ctx context.Context, r *BulkRoleRequest, grant bool) (*BulkRoleResult, error) {
+	if len(r.Filter) == 0 {
+		return &BulkRoleResult{}, status.Error(codes.InvalidArgument, "a filter is required for bulk operations")
+	}
+	if err := r.Scope.Validate(); err != nil {
+		return &BulkRoleResult{}, err
+	}
+	exists, err := s.existsResource(r.Id)
+	if err != nil {
+		return &BulkRoleResult{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return &BulkRoleResult{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", r.Id))
+	}
+	tx, err := s.Dbh.Begin()
+	if err != nil {
+		return &BulkRoleResult{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	defer tx.AutoRollback()
+	matched, err := filterUsers(tx, r.Filter)
+	if err != nil {
+		return &BulkRoleResult{}, err
+	}
+	holders, err := roleHolders(tx, r.Id, r.Scope)
+	if err != nil {
+		return &BulkRoleResult{}, aphgrpc.HandleError(ctx, err)
+	}
+	res := &BulkRoleResult{
+		Matched: int64(len(matched)),
+		DryRun:  r.DryRun,
+		Users:   make([]*BulkUser, 0),
+	}
+	for _, u := range matched {
+		if holders[u.AuthUserId] != grant {
+			res.Users = append(res.Users, &BulkUser{Id: u.AuthUserId, Email: u.Email})
+		}
+	}
+	res.Changed = int64(len(res.Users))
+	if r.DryRun || res.Changed == 0 {
+		return res, nil
+	}
+	rpc := roleRPC("BulkRevokeRole")
+	if grant {
+		rpc = roleRPC("BulkGrantRole")
+	}
+	au, err := newAudit(ctx, tx, rpc, "roles", r.Id)
+	if err != nil {
+		return &BulkRoleResult{}, err
+	}
+	var ids []int64
+	for _, u := range res.Users {
+		ids = append(ids, u.Id)
+	}
+	if grant {
+		if err := checkMembersRoleConstraints(ctx, tx, r.Id, ids); err != nil {
+			return &BulkRoleResult{}, err
+		}
+	}
+	for _, id := range ids {
+		if grant {
+			err = insertUserRole(tx, id, r.Id, r.Scope)
+		} else {
+			where, args := scopedWhere(
+				"auth_user_role",
+				"auth_user_role.auth_user_id = $1 AND auth_user_role.auth_role_id = $2",
+				r.Scope, id, r.Id,
+			)
+			_, err = tx.DeleteFrom("auth_user_role").Where(where, args...).Exec()
+		}
+		if err != nil {
+			return &BulkRoleResult{}, aphgrpc.HandleUpdateError(ctx, err)
+		}
+	}
+	if err := au.relate("users", ids...).record(tx); err != nil {
+		return &BulkRoleResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return &BulkRoleResult{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	s.cache.invalidate(ids...)
+	return res, nil
+}
+
+// filterUsers returns the users matching a ListUsers filter expression
+func filterUsers(conn runner.Connection, filter string) ([]*dbBulkUser, error) {
+	usrSrv := NewUserService(nil)
+	params, _, err := aphgrpc.ValidateAndParseListParams(usrSrv, &jsonapi.ListRequest{Filter: filter})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !params.HasFilter {
+		return nil, status.Errorf(codes.InvalidArgument, "unable to parse filter %s", filter)
+	}
+	var dbrows []*dbBulkUser
+	err = conn.SQL(
+		fmt.Sprintf(`
+			SELECT auth_user.auth_user_id, CAST(auth_user.email AS TEXT) email
+			FROM auth_user
+			%s
+			%s
+			ORDER BY auth_user.auth_user_id`,
+			usrTablesJoin,
+			aphgrpc.FilterToWhereClause(usrSrv, params.Filters),
+		),
+		aphgrpc.FilterToBindValue(params.Filters)...,
+	).QueryStructs(&dbrows)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return dbrows, nil
+}
+
+// roleHolders returns the ids of the users holding the role in the scope
+func roleHolders(conn runner.Connection, roleId int64, sc *Scope) (map[int64]bool, error) {
+	var ids []int64
+	where, args := scopedWhere("auth_user_role", "auth_user_role.auth_role_id = $1", sc, roleId)
+	err := conn.Select("auth_user_id").
+		From("auth_user_role").
+		Where(where, args...).
+		QuerySlice(&ids)
+	holders := make(map[int64]bool)
+	for _, id := range ids {
+		holders[id] = true
+	}
+	return holders, err
+}
+
+// -- HTTP handlers
+
+func (s *RoleService) bulkGrantHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	s.bulkHandler(w, r, params, s.BulkGrantRole)
+}
+
+func (s *RoleService) bulkRevokeHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	s.bulkHandler(w, r, params, s.BulkRevokeRole)
+}
+
+func (s *RoleService) bulkHandler(
+	w http.ResponseWriter, r *http.Request, params map[string]string,
+	fn func(context.Context, *BulkRoleRequest) (*BulkRoleResult, error),
+) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	br := &BulkRoleRequest{}
+	if err := readJSON(r, br); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	br.Id = id
+	res, err := fn(r.Context(), br)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestBulkGrantAndRevokeRole(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(context.Background(), NewRole("consortium"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	uclient := pb.NewUserServiceClient(conn)
+	for _, email := range []string{"first@gmail.com", "second@gmail.com"} {
+		_, err := uclient.CreateUser(context.Background(), NewUser(email))
+		if err != nil {
+			t.Fatalf("could not store the user %s\n", err)
+		}
+	}
+	outsider := NewUser("outsider@gmail.com")
+	outsider.Data.Attributes.Organization = "Elsewhere"
+	if _, err := uclient.CreateUser(context.Background(), outsider); err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+
+	s := NewRoleService(runner.NewDB(db, "postgres"))
+	req := &BulkRoleRequest{Id: role.Data.Id, Filter: "organization=@Gadd", DryRun: true}
+	res, err := s.BulkGrantRole(context.Background(), req)
+	if err != nil {
+		t.Fatalf("could not run the bulk grant %s\n", err)
+	}
+	if res.Matched != 2 || res.Changed != 2 || !res.DryRun {
+		t.Fatalf("expected 2 users in the dry run, received %+v\n", res)
+	}
+	count, err := s.getRelatedUsersCount(role.Data.Id)
+	if err != nil {
+		t.Fatalf("could not count the members %s\n", err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no members after the dry run, received %d\n", count)
+	}
+	req.DryRun = false
+	res, err = s.BulkGrantRole(context.Background(), req)
+	if err != nil {
+		t.Fatalf("could not run the bulk grant %s\n", err)
+	}
+	if res.Changed != 2 || len(res.Users) != 2 {
+		t.Fatalf("expected 2 users to be changed, received %+v\n", res)
+	}
+	res, err = s.BulkGrantRole(context.Background(), req)
+	if err != nil {
+		t.Fatalf("could not run the bulk grant %s\n", err)
+	}
+	if res.Changed != 0 {
+		t.Fatalf("expected no change on repeated grant, received %d\n", res.Changed)
+	}
+	res, err = s.BulkRevokeRole(
+		context.Background(),
+		&BulkRoleRequest{Id: role.Data.Id, Filter: "email=@first"},
+	)
+	if err != nil {
+		t.Fatalf("could not run the bulk revoke %s\n", err)
+	}
+	if res.Changed != 1 || res.Users[0].Email != "first@gmail.com" {
+		t.Fatalf("expected first@gmail.com to be revoked, received %+v\n", res)
+	}
+	_, err = s.BulkGrantRole(context.Background(), &BulkRoleRequest{Id: role.Data.Id})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument without filter, received %s\n", err)
+	}
+}
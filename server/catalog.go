@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/rbac"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const catalogDbTable = "auth_permission_catalog"
+
+type dbCatalogEntry struct {
+	Kind        string         `db:"kind"`
+	Name        string         `db:"name"`
+	Description dat.NullString `db:"description"`
+	Aliases     string         `db:"aliases"`
+}
+
+// CatalogEntryRequest adds or removes a verb or resource type of the
+// permission catalog
+type CatalogEntryRequest struct {
+	Kind        string   `json:"kind"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+}
+
+// NonConformingPermission is a stored permission that uses verbs or a
+// resource type outside of the catalog
+type NonConformingPermission struct {
+	Id         int64                    `json:"id"`
+	Permission string                   `json:"permission"`
+	Resource   string                   `json:"resource"`
+	Violations []*rbac.CatalogViolation `json:"violations"`
+}
+
+// CatalogAudit lists all stored permissions that do not conform to the
+// catalog
+type CatalogAudit struct {
+	Checked       int64                      `json:"checked"`
+	NonConforming []*NonConformingPermission `json:"non_conforming"`
+}
+
+// GetCatalog returns the registry of verbs and resource types
+func (s *PermissionService) GetCatalog(ctx context.Context) (*rbac.Catalog, error) {
+	c, err := getCatalog(s.Dbh)
+	if err != nil {
+		return &rbac.Catalog{}, aphgrpc.HandleError(ctx, err)
+	}
+	return c, nil
+}
+
+// AddCatalogEntry registers a verb or resource type
+func (s *PermissionService) AddCatalogEntry(ctx context.Context, r *CatalogEntryRequest) (*rbac.CatalogEntry, error) {
+	if !rbac.IsValidCatalogKind(r.Kind) {
+		return &rbac.CatalogEntry{}, aphgrpc.HandleInsertArgError(
+			ctx,
+			fmt.Errorf("kind %s is not supported, use one of %s", r.Kind, strings.Join(rbac.CatalogKinds, ",")),
+		)
+	}
+	c, err := getCatalog(s.Dbh)
+	if err != nil {
+		return &rbac.CatalogEntry{}, aphgrpc.HandleError(ctx, err)
+	}
+	if c.Has(r.Kind, r.Name) {
+		return &rbac.CatalogEntry{}, aphgrpc.HandleExistError(ctx, fmt.Errorf("%s %s is already in the catalog", r.Kind, r.Name))
+	}
+	e := &rbac.CatalogEntry{Name: r.Name, Description: r.Description, Aliases: r.Aliases}
+	if r.Kind == rbac.CatalogVerb {
+		c.Verbs = append(c.Verbs, e)
+	} else {
+		c.Resources = append(c.Resources, e)
+	}
+	if err := c.Validate(); err != nil {
+		return &rbac.CatalogEntry{}, aphgrpc.HandleInsertArgError(ctx, err)
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, permissionRPC("AddCatalogEntry"), "catalog_entries", 0)
+	if err != nil {
+		return &rbac.CatalogEntry{}, err
+	}
+	defer tx.AutoRollback()
+	id, err := insertCatalogEntry(tx, r.Kind, e)
+	if err != nil {
+		return &rbac.CatalogEntry{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	if err := au.created(id).commit(tx); err != nil {
+		return &rbac.CatalogEntry{}, err
+	}
+	return e, nil
+}
+
+// DeleteCatalogEntry removes a verb or resource type, the permissions that
+// use it are left as they are and show up in the audit
+func (s *PermissionService) DeleteCatalogEntry(ctx context.Context, r *CatalogEntryRequest) (*empty.Empty, error) {
+	var ids []int64
+	err := s.Dbh.Select("auth_permission_catalog_id").
+		From(catalogDbTable).
+		Where("kind = $1 AND name = $2", r.Kind, r.Name).
+		QuerySlice(&ids)
+	if err != nil {
+		return &empty.Empty{}, aphgrpc.HandleError(ctx, err)
+	}
+	if len(ids) == 0 {
+		return &empty.Empty{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("%s %s is not in the catalog", r.Kind, r.Name))
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, permissionRPC("DeleteCatalogEntry"), "catalog_entries", ids[0])
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	_, err = tx.DeleteFrom(catalogDbTable).
+		Where("auth_permission_catalog_id = $1", ids[0]).
+		Exec()
+	if err != nil {
+		return &empty.Empty{}, aphgrpc.HandleDeleteError(ctx, err)
+	}
+	if err := au.commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	return &empty.Empty{}, nil
+}
+
+// SeedCatalog adds the entries of the catalog that are not yet registered,
+// the existing entries are left untouched
+func (s *PermissionService) SeedCatalog(ctx context.Context, c *rbac.Catalog) error {
+	if err := c.Validate(); err != nil {
+		return aphgrpc.HandleInsertArgError(ctx, err)
+	}
+	existing, err := getCatalog(s.Dbh)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	tx, err := s.Dbh.Begin()
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	defer tx.AutoRollback()
+	au, err := newAudit(ctx, tx, permissionRPC("SeedCatalog"), "catalog_entries", 0)
+	if err != nil {
+		return err
+	}
+	added := &rbac.Catalog{}
+	for _, kind := range rbac.CatalogKinds {
+		for _, e := range c.Entries(kind) {
+			if existing.Has(kind, e.Name) {
+				continue
+			}
+			id, err := insertCatalogEntry(tx, kind, e)
+			if err != nil {
+				return aphgrpc.HandleInsertError(ctx, err)
+			}
+			au.relate("catalog_entries", id)
+			if kind == rbac.CatalogVerb {
+				added.Verbs = append(added.Verbs, e)
+			} else {
+				added.Resources = append(added.Resources, e)
+			}
+		}
+	}
+	if len(added.Verbs)+len(added.Resources) == 0 {
+		return nil
+	}
+	if err := au.values(nil, added); err != nil {
+		return err
+	}
+	if err := au.record(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return aphgrpc.HandleInsertError(ctx, err)
+	}
+	return nil
+}
+
+// AuditPermissions checks every stored permission against the catalog
+func (s *PermissionService) AuditPermissions(ctx context.Context) (*CatalogAudit, error) {
+	c, err := getCatalog(s.Dbh)
+	if err != nil {
+		return &CatalogAudit{}, aphgrpc.HandleError(ctx, err)
+	}
+	return s.AuditPermissionsWith(ctx, c)
+}
+
+// AuditPermissionsWith checks every stored permission against the given
+// catalog instead of the registered one
+func (s *PermissionService) AuditPermissionsWith(ctx context.Context, c *rbac.Catalog) (*CatalogAudit, error) {
+	var dbrows []*dbPermission
+	err := s.Dbh.Select("auth_permission_id", "permission", "resource").
+		From(permDbTable).
+		OrderBy("auth_permission_id").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &CatalogAudit{}, aphgrpc.HandleError(ctx, err)
+	}
+	audit := &CatalogAudit{
+		Checked:       int64(len(dbrows)),
+		NonConforming: make([]*NonConformingPermission, 0),
+	}
+	for _, d := range dbrows {
+		violations, err := c.Check(d.Permission, d.Resource)
+		if err != nil {
+			// permissions stored before the pattern validation
+			violations = []*rbac.CatalogViolation{
+				{Kind: "pattern", Value: err.Error()},
+			}
+		}
+		if len(violations) > 0 {
+			audit.NonConforming = append(audit.NonConforming, &NonConformingPermission{
+				Id:         aphgrpc.NullToInt64(d.AuthPermissionId),
+				Permission: d.Permission,
+				Resource:   d.Resource,
+				Violations: violations,
+			})
+		}
+	}
+	return audit, nil
+}
+
+// checkCatalog returns an InvalidArgument error, with the suggested
+// replacements as details, if the permission does not conform to the
+// catalog
+func checkCatalog(conn runner.Connection, perm, resource string) error {
+	c, err := getCatalog(conn)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	violations, err := c.Check(perm, resource)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	br := &errdetails.BadRequest{}
+	var msgs []string
+	for _, v := range violations {
+		field := "permission"
+		if v.Kind == rbac.CatalogResource {
+			field = "resource"
+		}
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: v.Error(),
+		})
+		msgs = append(msgs, v.Error())
+	}
+	st := status.New(codes.InvalidArgument, strings.Join(msgs, "; "))
+	dst, err := st.WithDetails(br)
+	if err != nil {
+		return st.Err()
+	}
+	return dst.Err()
+}
+
+func getCatalog(conn runner.Connection) (*rbac.Catalog, error) {
+	var dbrows []*dbCatalogEntry
+	err := conn.Select("kind", "name", "description", "aliases").
+		From(catalogDbTable).
+		OrderBy("kind", "name").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return nil, err
+	}
+	c := &rbac.Catalog{
+		Verbs:     make([]*rbac.CatalogEntry, 0),
+		Resources: make([]*rbac.CatalogEntry, 0),
+	}
+	for _, d := range dbrows {
+		e := &rbac.CatalogEntry{
+			Name:        d.Name,
+			Description: aphgrpc.NullToString(d.Description),
+		}
+		if len(d.Aliases) > 0 {
+			e.Aliases = strings.Split(d.Aliases, ",")
+		}
+		if d.Kind == rbac.CatalogVerb {
+			c.Verbs = append(c.Verbs, e)
+		} else {
+			c.Resources = append(c.Resources, e)
+		}
+	}
+	return c, nil
+}
+
+func insertCatalogEntry(conn runner.Connection, kind string, e *rbac.CatalogEntry) (int64, error) {
+	var id int64
+	err := conn.InsertInto(catalogDbTable).
+		Columns("kind", "name", "description", "aliases").
+		Values(kind, e.Name, dat.NullStringFrom(e.Description), strings.Join(e.Aliases, ",")).
+		Returning("auth_permission_catalog_id").
+		QueryScalar(&id)
+	return id, err
+}
+
+// -- HTTP handlers
+
+func (s *PermissionService) getCatalogHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	c, err := s.GetCatalog(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *PermissionService) addCatalogEntryHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	req := &CatalogEntryRequest{}
+	if err := readJSON(r, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	e, err := s.AddCatalogEntry(r.Context(), req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, e)
+}
+
+func (s *PermissionService) deleteCatalogEntryHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	_, err := s.DeleteCatalogEntry(r.Context(), &CatalogEntryRequest{
+		Kind: params["kind"],
+		Name: params["name"],
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *PermissionService) auditCatalogHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	a, err := s.AuditPermissions(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/rbac"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestPermissionCatalog(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewPermissionServiceClient(conn)
+	// stored before the catalog exists
+	legacy, err := client.CreatePermission(context.Background(), NewPermission("Edit", "genes"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	s := NewPermissionService(runner.NewDB(db, "postgres"))
+	err = s.SeedCatalog(context.Background(), &rbac.Catalog{
+		Verbs: []*rbac.CatalogEntry{
+			{Name: "read"},
+			{Name: "write", Aliases: []string{"edit", "update"}},
+		},
+		Resources: []*rbac.CatalogEntry{{Name: "genes"}},
+	})
+	if err != nil {
+		t.Fatalf("could not seed the catalog %s\n", err)
+	}
+	// seeding again keeps the registered entries
+	err = s.SeedCatalog(context.Background(), &rbac.Catalog{
+		Verbs: []*rbac.CatalogEntry{{Name: "read", Description: "changed"}},
+	})
+	if err != nil {
+		t.Fatalf("could not seed the catalog again %s\n", err)
+	}
+	if _, err := s.AddCatalogEntry(
+		context.Background(),
+		&CatalogEntryRequest{Kind: rbac.CatalogResource, Name: "stocks"},
+	); err != nil {
+		t.Fatalf("could not add catalog entry %s\n", err)
+	}
+	_, err = s.AddCatalogEntry(
+		context.Background(),
+		&CatalogEntryRequest{Kind: rbac.CatalogVerb, Name: "read"},
+	)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for duplicate entry, received %s\n", err)
+	}
+	_, err = s.AddCatalogEntry(
+		context.Background(),
+		&CatalogEntryRequest{Kind: "action", Name: "read"},
+	)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for unknown kind, received %s\n", err)
+	}
+	cat, err := s.GetCatalog(context.Background())
+	if err != nil {
+		t.Fatalf("could not fetch the catalog %s\n", err)
+	}
+	if len(cat.Verbs) != 2 || len(cat.Resources) != 2 {
+		t.Fatalf("expected 2 verbs and 2 resources, received %d and %d\n", len(cat.Verbs), len(cat.Resources))
+	}
+
+	_, err = client.CreatePermission(context.Background(), NewPermission("edit", "gene/*"))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, received %s\n", err)
+	}
+	if !strings.Contains(status.Convert(err).Message(), "did you mean write") {
+		t.Fatalf("expected suggestion in the error, received %s\n", err)
+	}
+	var fields []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	if strings.Join(fields, ",") != "permission,resource" {
+		t.Fatalf("expected permission and resource violations, received %v\n", fields)
+	}
+	if _, err := client.CreatePermission(context.Background(), NewPermission("read,write", "genes/*")); err != nil {
+		t.Fatalf("could not store a conforming permission %s\n", err)
+	}
+
+	audit, err := s.AuditPermissions(context.Background())
+	if err != nil {
+		t.Fatalf("could not audit the permissions %s\n", err)
+	}
+	if audit.Checked != 2 || len(audit.NonConforming) != 1 {
+		t.Fatalf("expected one of two permissions to be non conforming, received %+v\n", audit)
+	}
+	if audit.NonConforming[0].Id != legacy.Data.Id {
+		t.Fatalf("expected permission %d to be non conforming\n", legacy.Data.Id)
+	}
+	if _, err := s.DeleteCatalogEntry(
+		context.Background(),
+		&CatalogEntryRequest{Kind: rbac.CatalogResource, Name: "unknown"},
+	); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, received %s\n", err)
+	}
+}
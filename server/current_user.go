@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CurrentUserRequest fetches the authenticated user, the include and
+// fields parameters work as for GetUser
+type CurrentUserRequest struct {
+	Include string
+	Fields  string
+}
+
+// CurrentUserUpdate holds the attributes users can change in their own
+// profile, the absent ones are kept. Email, activation and roles are only
+// changed through UpdateUser.
+type CurrentUserUpdate struct {
+	FirstName     *string `json:"first_name"`
+	LastName      *string `json:"last_name"`
+	Organization  *string `json:"organization"`
+	GroupName     *string `json:"group_name"`
+	FirstAddress  *string `json:"first_address"`
+	SecondAddress *string `json:"second_address"`
+	City          *string `json:"city"`
+	State         *string `json:"state"`
+	Zipcode       *string `json:"zipcode"`
+	Country       *string `json:"country"`
+	Phone         *string `json:"phone"`
+}
+
+// CurrentUserPermissions are the global roles of the authenticated user and
+// the unconditional permission patterns they allow and deny, the same ones
+// that are part of an issued token
+type CurrentUserPermissions struct {
+	UserId            int64             `json:"user_id"`
+	Roles             []*RoleAssignment `json:"roles"`
+	Permissions       []string          `json:"permissions"`
+	DeniedPermissions []string          `json:"denied_permissions"`
+}
+
+// GetCurrentUser returns the user of the request credentials
+func (s *UserService) GetCurrentUser(ctx context.Context, r *CurrentUserRequest) (*user.User, error) {
+	id, err := currentUserId(ctx)
+	if err != nil {
+		return &user.User{}, err
+	}
+	return s.GetUser(ctx, &jsonapi.GetRequest{Id: id, Include: r.Include, Fields: r.Fields})
+}
+
+// UpdateCurrentUser changes the self editable attributes of the user of the
+// request credentials
+func (s *UserService) UpdateCurrentUser(ctx context.Context, r *CurrentUserUpdate) (*user.User, error) {
+	id, err := currentUserId(ctx)
+	if err != nil {
+		return &user.User{}, err
+	}
+	u, err := s.GetUser(ctx, &jsonapi.GetRequest{Id: id})
+	if err != nil {
+		return &user.User{}, err
+	}
+	cur := u.Data.Attributes
+	// the stored user information is replaced as a whole, so the absent
+	// attributes are filled in from the current ones
+	attr := &user.UserAttributes{}
+	for _, f := range []struct {
+		src *string
+		cur string
+		dst *string
+	}{
+		{r.FirstName, cur.FirstName, &attr.FirstName},
+		{r.LastName, cur.LastName, &attr.LastName},
+		{r.Organization, cur.Organization, &attr.Organization},
+		{r.GroupName, cur.GroupName, &attr.GroupName},
+		{r.FirstAddress, cur.FirstAddress, &attr.FirstAddress},
+		{r.SecondAddress, cur.SecondAddress, &attr.SecondAddress},
+		{r.City, cur.City, &attr.City},
+		{r.State, cur.State, &attr.State},
+		{r.Zipcode, cur.Zipcode, &attr.Zipcode},
+		{r.Country, cur.Country, &attr.Country},
+		{r.Phone, cur.Phone, &attr.Phone},
+	} {
+		*f.dst = f.cur
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	return s.UpdateUser(ctx, &user.UpdateUserRequest{
+		Id: id,
+		Data: &user.UpdateUserRequest_Data{
+			Type:       s.GetResourceName(),
+			Id:         id,
+			Attributes: attr,
+		},
+	})
+}
+
+// GetCurrentUserPermissions returns the roles and permissions of the user of
+// the request credentials
+func (s *UserService) GetCurrentUserPermissions(ctx context.Context) (*CurrentUserPermissions, error) {
+	id, err := currentUserId(ctx)
+	if err != nil {
+		return &CurrentUserPermissions{}, err
+	}
+	var dbrows []*dbRoleAssignment
+	err = s.Dbh.Select(
+		"role.auth_role_id", "role.role",
+		"auth_user_role.resource_type", "auth_user_role.resource_id",
+	).From(`
+			auth_user_role
+			JOIN auth_role role
+			ON auth_user_role.auth_role_id = role.auth_role_id
+		`).
+		Where("auth_user_role.auth_user_id = $1 AND auth_user_role.resource_type IS NULL", id).
+		OrderBy("role.role").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &CurrentUserPermissions{}, aphgrpc.HandleError(ctx, err)
+	}
+	grants, err := s.cache.grants(s.Dbh, &PermissionCheck{UserId: id})
+	if err != nil {
+		return &CurrentUserPermissions{}, aphgrpc.HandleError(ctx, err)
+	}
+	allowed, denied := effectivePermissions(grants)
+	return &CurrentUserPermissions{
+		UserId:            id,
+		Roles:             dbToRoleAssignments(dbrows),
+		Permissions:       allowed,
+		DeniedPermissions: denied,
+	}, nil
+}
+
+// currentUserId returns the id of the authenticated user of the context
+func currentUserId(ctx context.Context) (int64, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return 0, status.Error(codes.Unauthenticated, "the request has no authenticated user")
+	}
+	if p.IsServiceAccount() {
+		return 0, status.Errorf(codes.FailedPrecondition, "service account %s has no user profile", p.Name)
+	}
+	return p.UserId, nil
+}
+
+// -- HTTP handlers
+
+func (s *UserService) getCurrentUserHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	q := r.URL.Query()
+	u, err := s.GetCurrentUser(r.Context(), &CurrentUserRequest{
+		Include: q.Get("include"),
+		Fields:  q.Get("fields"),
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeUser(w, u)
+}
+
+func (s *UserService) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	cu := &CurrentUserUpdate{}
+	if err := readJSON(r, cu); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	u, err := s.UpdateCurrentUser(r.Context(), cu)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeUser(w, u)
+}
+
+func (s *UserService) getCurrentUserPermissionsHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	cp, err := s.GetCurrentUserPermissions(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cp)
+}
+
+func writeUser(w http.ResponseWriter, u *user.User) {
+	b, err := marshalProto(u)
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
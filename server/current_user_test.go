@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestCurrentUser(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("read", "users"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("reader", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(
+		context.Background(),
+		NewUserWithRole("me@gmail.com", role),
+	)
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+
+	s := NewUserService(runner.NewDB(db, "postgres"))
+	if _, err := s.GetCurrentUser(context.Background(), &CurrentUserRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a request without principal to be rejected, received %v\n", err)
+	}
+	sactx := auth.NewContext(context.Background(), &auth.Principal{ServiceAccountId: 1, Name: "sync"})
+	if _, err := s.GetCurrentUser(sactx, &CurrentUserRequest{}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a service account to be rejected, received %v\n", err)
+	}
+
+	ctx := auth.NewContext(context.Background(), &auth.Principal{UserId: usr.Data.Id, Email: "me@gmail.com"})
+	u, err := s.GetCurrentUser(ctx, &CurrentUserRequest{Include: "roles"})
+	if err != nil {
+		t.Fatalf("could not get the current user %s\n", err)
+	}
+	if u.Data.Id != usr.Data.Id || u.Data.Attributes.Email != "me@gmail.com" {
+		t.Fatalf("expected the user of the principal, received %+v\n", u.Data)
+	}
+	if len(u.Included) != 1 {
+		t.Fatalf("expected the role to be included, received %d\n", len(u.Included))
+	}
+
+	city := "Chicago"
+	u, err = s.UpdateCurrentUser(ctx, &CurrentUserUpdate{City: &city})
+	if err != nil {
+		t.Fatalf("could not update the current user %s\n", err)
+	}
+	if u.Data.Attributes.City != city || u.Data.Attributes.Phone != "435-234-8791" {
+		t.Fatalf("expected only the city to change, received %+v\n", u.Data.Attributes)
+	}
+	if !u.Data.Attributes.IsActive || u.Data.Attributes.Email != "me@gmail.com" {
+		t.Fatalf("expected email and activation to be kept, received %+v\n", u.Data.Attributes)
+	}
+
+	cp, err := s.GetCurrentUserPermissions(ctx)
+	if err != nil {
+		t.Fatalf("could not get the current permissions %s\n", err)
+	}
+	if len(cp.Roles) != 1 || cp.Roles[0].Role != "reader" {
+		t.Fatalf("expected the reader role, received %+v\n", cp.Roles)
+	}
+	if len(cp.Permissions) != 1 || cp.Permissions[0] != "read:users" {
+		t.Fatalf("expected the read:users permission, received %v\n", cp.Permissions)
+	}
+}
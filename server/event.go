@@ -0,0 +1,529 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/message/event"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// DefaultEventSubjectPrefix is the prefix of the subjects the domain events
+// are published on when none is configured
+const DefaultEventSubjectPrefix = "UserEvents"
+
+// EventSubject returns the subject an event is published on, which is made
+// of the prefix and the name of its message, for example
+// UserEvents.RoleAssigned
+func EventSubject(prefix string, ev proto.Message) string {
+	return fmt.Sprintf("%s.%s", prefix, ev.ProtoReflect().Descriptor().Name())
+}
+
+// assignmentChange is a role assignment that was made or removed by a
+// change, the scope is empty for a global one
+type assignmentChange struct {
+	UserId       int64          `json:"user_id" db:"auth_user_id"`
+	RoleId       int64          `json:"role_id" db:"auth_role_id"`
+	ResourceType dat.NullString `json:"resource_type" db:"resource_type"`
+	ResourceId   dat.NullString `json:"resource_id" db:"resource_id"`
+	revoked      bool
+}
+
+func (c *assignmentChange) key() string {
+	return fmt.Sprintf(
+		"%d/%d/%s/%s",
+		c.UserId, c.RoleId, c.ResourceType.String, c.ResourceId.String,
+	)
+}
+
+// The snapshots of the audited resources, see auditSnapshots
+
+type userSnapshot struct {
+	AuthUserId int64               `json:"auth_user_id"`
+	Email      string              `json:"email"`
+	FirstName  string              `json:"first_name"`
+	LastName   string              `json:"last_name"`
+	IsActive   bool                `json:"is_active"`
+	Roles      []*assignmentChange `json:"roles"`
+}
+
+type grantSnapshot struct {
+	PermissionId int64           `json:"permission_id"`
+	Effect       json.RawMessage `json:"effect"`
+	Condition    json.RawMessage `json:"condition"`
+}
+
+type roleSnapshot struct {
+	AuthRoleId  int64               `json:"auth_role_id"`
+	Role        string              `json:"role"`
+	Description string              `json:"description"`
+	Permissions []*grantSnapshot    `json:"permissions"`
+	Users       []*assignmentChange `json:"users"`
+}
+
+type permissionSnapshot struct {
+	AuthPermissionId int64          `json:"auth_permission_id"`
+	Permission       string         `json:"permission"`
+	Resource         string         `json:"resource"`
+	Description      dat.NullString `json:"description"`
+}
+
+type roleRequestSnapshot struct {
+	AuthRoleRequestId int64         `json:"auth_role_request_id"`
+	AuthUserId        int64         `json:"auth_user_id"`
+	AuthRoleId        int64         `json:"auth_role_id"`
+	Justification     string        `json:"justification"`
+	State             string        `json:"state"`
+	DecidedBy         dat.NullInt64 `json:"decided_by"`
+}
+
+// eventBuilder derives the events of an audited change from the before and
+// after states of its primary resource
+type eventBuilder struct {
+	conn        runner.Connection
+	audit       *audit
+	events      []proto.Message
+	assignments []*assignmentChange
+	roles       map[int64]*event.Role
+}
+
+// auditEvents returns the domain events of a recorded change, in the order
+// they are published
+func auditEvents(conn runner.Connection, a *audit) ([]proto.Message, error) {
+	b := &eventBuilder{
+		conn:        conn,
+		audit:       a,
+		assignments: a.assignments,
+		roles:       make(map[int64]*event.Role),
+	}
+	var err error
+	switch a.resources[0].Type {
+	case "users":
+		err = b.userEvents()
+	case "roles":
+		err = b.roleEvents()
+	case "permissions":
+		err = b.permissionEvents()
+	case "role_requests":
+		err = b.roleRequestEvents()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := b.assignmentEvents(); err != nil {
+		return nil, err
+	}
+	return b.events, nil
+}
+
+func (b *eventBuilder) meta() *event.Meta {
+	m := &event.Meta{
+		Id:           fmt.Sprintf("%d-%d", b.audit.id, len(b.events)+1),
+		OccurredAt:   timestamppb.New(b.audit.at),
+		Rpc:          b.audit.rpc,
+		Actor:        &event.Actor{},
+		AuditEventId: b.audit.id,
+	}
+	if p, ok := auth.FromContext(b.audit.ctx); ok {
+		if p.IsServiceAccount() {
+			m.Actor.ServiceAccountId = p.ServiceAccountId
+		} else {
+			m.Actor.UserId = p.UserId
+		}
+		if p.IsImpersonated() {
+			m.Actor.ImpersonatorId = p.Impersonator.UserId
+		}
+	}
+	return m
+}
+
+func (b *eventBuilder) userEvents() error {
+	before, after := &userSnapshot{}, &userSnapshot{}
+	if err := b.decode(before, after); err != nil {
+		return err
+	}
+	switch {
+	case b.audit.before == nil:
+		b.events = append(b.events, &event.UserCreated{Meta: b.meta(), User: userToEvent(after)})
+	case b.audit.after == nil:
+		// the assignments of the user are removed along with it, they are
+		// diffed against an empty after state
+		b.events = append(b.events, &event.UserDeleted{Meta: b.meta(), User: userToEvent(before)})
+	default:
+		fields, err := changedFields(
+			b.audit.before, b.audit.after,
+			"auth_user_id", "roles", "created_at", "updated_at",
+		)
+		if err != nil {
+			return aphgrpc.HandleError(b.audit.ctx, err)
+		}
+		if len(fields) > 0 {
+			b.events = append(b.events, &event.UserUpdated{
+				Meta:          b.meta(),
+				User:          userToEvent(after),
+				ChangedFields: fields,
+			})
+		}
+		switch {
+		case !before.IsActive && after.IsActive:
+			b.events = append(b.events, &event.UserActivated{Meta: b.meta(), User: userToEvent(after)})
+		case before.IsActive && !after.IsActive:
+			b.events = append(b.events, &event.UserDeactivated{Meta: b.meta(), User: userToEvent(after)})
+		}
+	}
+	for _, s := range []*userSnapshot{before, after} {
+		for _, c := range s.Roles {
+			c.UserId = s.AuthUserId
+		}
+	}
+	b.diffAssignments(before.Roles, after.Roles)
+	return nil
+}
+
+func (b *eventBuilder) roleEvents() error {
+	before, after := &roleSnapshot{}, &roleSnapshot{}
+	if err := b.decode(before, after); err != nil {
+		return err
+	}
+	switch {
+	case b.audit.before == nil:
+		b.events = append(b.events, &event.RoleCreated{Meta: b.meta(), Role: roleToEvent(after)})
+	case b.audit.after == nil:
+		// the assignments of the role are removed along with it, they are
+		// diffed against an empty after state
+		b.events = append(b.events, &event.RoleDeleted{Meta: b.meta(), Role: roleToEvent(before)})
+		role := roleToEvent(before)
+		b.roles[role.Id] = role
+		for _, c := range before.Users {
+			c.RoleId = before.AuthRoleId
+		}
+		b.diffAssignments(before.Users, nil)
+		return nil
+	default:
+		fields, err := changedFields(
+			b.audit.before, b.audit.after,
+			"auth_role_id", "permissions", "users", "created_at", "updated_at",
+		)
+		if err != nil {
+			return aphgrpc.HandleError(b.audit.ctx, err)
+		}
+		if len(fields) > 0 {
+			b.events = append(b.events, &event.RoleUpdated{
+				Meta:          b.meta(),
+				Role:          roleToEvent(after),
+				ChangedFields: fields,
+			})
+		}
+	}
+	role := roleToEvent(after)
+	b.roles[role.Id] = role
+	if err := b.grantEvents(role, before.Permissions, after.Permissions); err != nil {
+		return err
+	}
+	for _, s := range []*roleSnapshot{before, after} {
+		for _, c := range s.Users {
+			c.RoleId = s.AuthRoleId
+		}
+	}
+	b.diffAssignments(before.Users, after.Users)
+	return nil
+}
+
+func (b *eventBuilder) permissionEvents() error {
+	before, after := &permissionSnapshot{}, &permissionSnapshot{}
+	if err := b.decode(before, after); err != nil {
+		return err
+	}
+	ev := &event.PermissionChanged{Permission: permissionToEvent(after)}
+	switch {
+	case b.audit.before == nil:
+		ev.Change = event.PermissionChanged_CREATED
+	case b.audit.after == nil:
+		ev.Change = event.PermissionChanged_DELETED
+		ev.Permission = permissionToEvent(before)
+	default:
+		fields, err := changedFields(
+			b.audit.before, b.audit.after,
+			"auth_permission_id", "created_at", "updated_at",
+		)
+		if err != nil {
+			return aphgrpc.HandleError(b.audit.ctx, err)
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		ev.Change = event.PermissionChanged_UPDATED
+	}
+	ev.Meta = b.meta()
+	b.events = append(b.events, ev)
+	return nil
+}
+
+// roleRequestEvents adds the creation or the decision of a role request,
+// the approvers of a new request are looked up in the transaction of the
+// change
+func (b *eventBuilder) roleRequestEvents() error {
+	if b.audit.after == nil {
+		return nil
+	}
+	before, after := &roleRequestSnapshot{}, &roleRequestSnapshot{}
+	if err := b.decode(before, after); err != nil {
+		return err
+	}
+	role, err := b.role(after.AuthRoleId)
+	if err != nil {
+		return err
+	}
+	req := &event.RoleRequest{
+		Id:            after.AuthRoleRequestId,
+		UserId:        after.AuthUserId,
+		Role:          role,
+		Justification: after.Justification,
+		DecidedBy:     after.DecidedBy.Int64,
+	}
+	switch {
+	case b.audit.before == nil:
+		approvers, err := roleApproverUserIds(b.conn, after.AuthRoleId)
+		if err != nil {
+			return aphgrpc.HandleError(b.audit.ctx, err)
+		}
+		b.events = append(b.events, &event.RoleRequestCreated{
+			Meta:      b.meta(),
+			Request:   req,
+			Approvers: approvers,
+		})
+	case before.State == after.State:
+	case after.State == RoleRequestApproved:
+		b.events = append(b.events, &event.RoleRequestApproved{Meta: b.meta(), Request: req})
+	case after.State == RoleRequestDenied:
+		b.events = append(b.events, &event.RoleRequestDenied{Meta: b.meta(), Request: req})
+	}
+	return nil
+}
+
+// grantEvents adds the changes of the permissions granted to a role
+func (b *eventBuilder) grantEvents(role *event.Role, before, after []*grantSnapshot) error {
+	prev := make(map[int64]*grantSnapshot)
+	for _, g := range before {
+		prev[g.PermissionId] = g
+	}
+	var changes []*event.PermissionChanged
+	for _, g := range after {
+		p, ok := prev[g.PermissionId]
+		delete(prev, g.PermissionId)
+		switch {
+		case !ok:
+			changes = append(changes, &event.PermissionChanged{
+				Change:     event.PermissionChanged_GRANTED,
+				Permission: &event.Permission{Id: g.PermissionId},
+			})
+		case !bytes.Equal(p.Effect, g.Effect) || !bytes.Equal(p.Condition, g.Condition):
+			changes = append(changes, &event.PermissionChanged{
+				Change:     event.PermissionChanged_GRANT_UPDATED,
+				Permission: &event.Permission{Id: g.PermissionId},
+			})
+		}
+	}
+	for _, g := range before {
+		if _, ok := prev[g.PermissionId]; ok {
+			changes = append(changes, &event.PermissionChanged{
+				Change:     event.PermissionChanged_REVOKED,
+				Permission: &event.Permission{Id: g.PermissionId},
+			})
+		}
+	}
+	for _, ev := range changes {
+		perm := &dbPermission{}
+		err := b.conn.Select("auth_permission_id", "permission", "resource", "description").
+			From(permDbTable).
+			Where("auth_permission_id = $1", ev.Permission.Id).
+			QueryStruct(perm)
+		if err != nil {
+			return aphgrpc.HandleError(b.audit.ctx, err)
+		}
+		ev.Meta = b.meta()
+		ev.Role = role
+		ev.Permission = &event.Permission{
+			Id:          ev.Permission.Id,
+			Permission:  perm.Permission,
+			Resource:    perm.Resource,
+			Description: perm.Description.String,
+		}
+		b.events = append(b.events, ev)
+	}
+	return nil
+}
+
+// diffAssignments adds the assignments found in only one of the before and
+// after states
+func (b *eventBuilder) diffAssignments(before, after []*assignmentChange) {
+	prev := make(map[string]bool)
+	for _, c := range before {
+		prev[c.key()] = true
+	}
+	next := make(map[string]bool)
+	for _, c := range after {
+		next[c.key()] = true
+		if !prev[c.key()] {
+			b.assignments = append(b.assignments, c)
+		}
+	}
+	for _, c := range before {
+		if !next[c.key()] {
+			c.revoked = true
+			b.assignments = append(b.assignments, c)
+		}
+	}
+}
+
+func (b *eventBuilder) assignmentEvents() error {
+	for _, c := range b.assignments {
+		role, err := b.role(c.RoleId)
+		if err != nil {
+			return err
+		}
+		var scope *event.Scope
+		if c.ResourceType.Valid {
+			scope = &event.Scope{
+				ResourceType: c.ResourceType.String,
+				ResourceId:   c.ResourceId.String,
+			}
+		}
+		if c.revoked {
+			b.events = append(b.events, &event.RoleRevoked{
+				Meta:   b.meta(),
+				UserId: c.UserId,
+				Role:   role,
+				Scope:  scope,
+			})
+			continue
+		}
+		b.events = append(b.events, &event.RoleAssigned{
+			Meta:   b.meta(),
+			UserId: c.UserId,
+			Role:   role,
+			Scope:  scope,
+		})
+	}
+	return nil
+}
+
+func (b *eventBuilder) role(id int64) (*event.Role, error) {
+	if r, ok := b.roles[id]; ok {
+		return r, nil
+	}
+	dbr := &dbRole{}
+	err := b.conn.Select("auth_role_id", "role", "description").
+		From(roleDbTable).
+		Where("auth_role_id = $1", id).
+		QueryStruct(dbr)
+	if err != nil {
+		return nil, aphgrpc.HandleError(b.audit.ctx, err)
+	}
+	r := &event.Role{Id: dbr.AuthRoleId, Role: dbr.Role, Description: dbr.Description}
+	b.roles[id] = r
+	return r, nil
+}
+
+// decode reads the before and after snapshots, a missing one leaves its
+// value untouched
+func (b *eventBuilder) decode(before, after interface{}) error {
+	for _, v := range []struct {
+		src json.RawMessage
+		dst interface{}
+	}{{b.audit.before, before}, {b.audit.after, after}} {
+		if v.src == nil {
+			continue
+		}
+		if err := json.Unmarshal(v.src, v.dst); err != nil {
+			return aphgrpc.HandleError(b.audit.ctx, err)
+		}
+	}
+	return nil
+}
+
+// changedFields returns the sorted names of the attributes that differ
+// between two snapshots, the attributes of a nested object are compared as
+// if they were top level ones
+func changedFields(before, after json.RawMessage, skip ...string) ([]string, error) {
+	prev, err := flattenSnapshot(before, skip)
+	if err != nil {
+		return nil, err
+	}
+	next, err := flattenSnapshot(after, skip)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for k, v := range next {
+		if !bytes.Equal(prev[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range prev {
+		if _, ok := next[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func flattenSnapshot(b json.RawMessage, skip []string) (map[string]json.RawMessage, error) {
+	m := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, err
+	}
+	for _, k := range skip {
+		delete(m, k)
+	}
+	flat := make(map[string]json.RawMessage)
+	for k, v := range m {
+		if len(v) == 0 || v[0] != '{' {
+			flat[k] = v
+			continue
+		}
+		nested, err := flattenSnapshot(v, skip)
+		if err != nil {
+			return flat, err
+		}
+		for nk, nv := range nested {
+			flat[nk] = nv
+		}
+	}
+	return flat, nil
+}
+
+func userToEvent(s *userSnapshot) *event.User {
+	return &event.User{
+		Id:        s.AuthUserId,
+		Email:     s.Email,
+		FirstName: s.FirstName,
+		LastName:  s.LastName,
+		IsActive:  s.IsActive,
+	}
+}
+
+func roleToEvent(s *roleSnapshot) *event.Role {
+	return &event.Role{
+		Id:          s.AuthRoleId,
+		Role:        s.Role,
+		Description: s.Description,
+	}
+}
+
+func permissionToEvent(s *permissionSnapshot) *event.Permission {
+	return &event.Permission{
+		Id:          s.AuthPermissionId,
+		Permission:  s.Permission,
+		Resource:    s.Resource,
+		Description: s.Description.String,
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/message/event"
+	"github.com/dictyBase/modware-user/message/nats"
+	"github.com/dictyBase/modware-user/testutils"
+	natsd "github.com/nats-io/gnatsd/server"
+	natstest "github.com/nats-io/gnatsd/test"
+	gnats "github.com/nats-io/go-nats"
+	"google.golang.org/protobuf/proto"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// receiveEvent relays the pending events and decodes the next one received
+func receiveEvent(t *testing.T, relay *OutboxRelay, ch chan *gnats.Msg, subj string, ev proto.Message) {
+	if _, err := relay.Relay(); err != nil {
+		t.Fatalf("could not relay the events %s\n", err)
+	}
+	select {
+	case m := <-ch:
+		if m.Subject != subj {
+			t.Fatalf("expected event on %s, received %s\n", subj, m.Subject)
+		}
+		if err := proto.Unmarshal(m.Data, ev); err != nil {
+			t.Fatalf("could not decode the event on %s %s\n", subj, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for the event on %s\n", subj)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	opts := natstest.DefaultTestOptions
+	opts.Port = natsd.RANDOM_PORT
+	ns := natstest.RunServer(&opts)
+	defer ns.Shutdown()
+	addr := ns.Addr().(*net.TCPAddr)
+	nc, err := gnats.Connect(fmt.Sprintf("nats://%s", addr))
+	if err != nil {
+		t.Fatalf("could not connect to the nats server %s\n", err)
+	}
+	defer nc.Close()
+	ch := make(chan *gnats.Msg, 64)
+	if _, err := nc.ChanSubscribe("userevents.>", ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := nats.NewEventPublisher(addr.IP.String(), strconv.Itoa(addr.Port))
+	if err != nil {
+		t.Fatalf("could not create the publisher %s\n", err)
+	}
+	defer pub.Close()
+	dbh := runner.NewDB(db, "postgres")
+	relay := NewOutboxRelay(dbh, pub, "userevents")
+	ctx := auth.NewContext(context.Background(), &auth.Principal{UserId: 42})
+	ps := NewPermissionService(dbh)
+	perm, err := ps.CreatePermission(ctx, NewPermission("read", "users"))
+	if err != nil {
+		t.Fatalf("could not create the permission %s\n", err)
+	}
+	pc := &event.PermissionChanged{}
+	receiveEvent(t, relay, ch, "userevents.PermissionChanged", pc)
+	if pc.Change != event.PermissionChanged_CREATED || pc.Permission.Id != perm.Data.Id || pc.Meta.Actor.UserId != 42 {
+		t.Fatalf("unexpected permission event %+v\n", pc)
+	}
+
+	rs := NewRoleService(dbh)
+	role, err := rs.CreateRole(ctx, NewRoleWithPermission("reader", perm))
+	if err != nil {
+		t.Fatalf("could not create the role %s\n", err)
+	}
+	rc := &event.RoleCreated{}
+	receiveEvent(t, relay, ch, "userevents.RoleCreated", rc)
+	if rc.Role.Role != "reader" {
+		t.Fatalf("unexpected role event %+v\n", rc)
+	}
+	receiveEvent(t, relay, ch, "userevents.PermissionChanged", pc)
+	if pc.Change != event.PermissionChanged_GRANTED || pc.Role.Id != role.Data.Id || pc.Permission.Resource != "users" {
+		t.Fatalf("unexpected grant event %+v\n", pc)
+	}
+
+	us := NewUserService(dbh)
+	usr, err := us.CreateUser(ctx, NewUserWithRole("evented@gmail.com", role))
+	if err != nil {
+		t.Fatalf("could not create the user %s\n", err)
+	}
+	uc := &event.UserCreated{}
+	receiveEvent(t, relay, ch, "userevents.UserCreated", uc)
+	if uc.User.Id != usr.Data.Id || uc.User.Email != "evented@gmail.com" || uc.Meta.AuditEventId == 0 {
+		t.Fatalf("unexpected user event %+v\n", uc)
+	}
+	ra := &event.RoleAssigned{}
+	receiveEvent(t, relay, ch, "userevents.RoleAssigned", ra)
+	if ra.UserId != usr.Data.Id || ra.Role.Role != "reader" || ra.Scope != nil {
+		t.Fatalf("unexpected assignment event %+v\n", ra)
+	}
+
+	upd := NewUser("evented@gmail.com")
+	upd.Data.Attributes.City = "Evanston"
+	_, err = us.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id: usr.Data.Id,
+		Data: &pb.UpdateUserRequest_Data{
+			Type:       "users",
+			Id:         usr.Data.Id,
+			Attributes: upd.Data.Attributes,
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not update the user %s\n", err)
+	}
+	uu := &event.UserUpdated{}
+	receiveEvent(t, relay, ch, "userevents.UserUpdated", uu)
+	if len(uu.ChangedFields) != 1 || uu.ChangedFields[0] != "city" || uu.User.Id != usr.Data.Id {
+		t.Fatalf("expected the city as changed field, received %v\n", uu.ChangedFields)
+	}
+
+	_, err = rs.DeleteUserRelationship(
+		ctx,
+		&jsonapi.DataCollection{Id: role.Data.Id, Data: []*jsonapi.Data{{Type: "users", Id: usr.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not revoke the role %s\n", err)
+	}
+	rr := &event.RoleRevoked{}
+	receiveEvent(t, relay, ch, "userevents.RoleRevoked", rr)
+	if rr.UserId != usr.Data.Id || rr.Role.Id != role.Data.Id {
+		t.Fatalf("unexpected revocation event %+v\n", rr)
+	}
+
+	_, err = us.CreateRoleRelationship(
+		ctx,
+		&jsonapi.DataCollection{Id: usr.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: role.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not assign the role %s\n", err)
+	}
+	receiveEvent(t, relay, ch, "userevents.RoleAssigned", ra)
+	if _, err := us.DeleteUser(ctx, &jsonapi.DeleteRequest{Id: usr.Data.Id}); err != nil {
+		t.Fatalf("could not delete the user %s\n", err)
+	}
+	ud := &event.UserDeleted{}
+	receiveEvent(t, relay, ch, "userevents.UserDeleted", ud)
+	if ud.User.Email != "evented@gmail.com" {
+		t.Fatalf("unexpected deletion event %+v\n", ud)
+	}
+	receiveEvent(t, relay, ch, "userevents.RoleRevoked", rr)
+	if rr.UserId != usr.Data.Id || rr.Role.Id != role.Data.Id {
+		t.Fatalf("expected the assignment to be revoked with the user %+v\n", rr)
+	}
+	if _, err := relay.Relay(); err != nil {
+		t.Fatalf("could not relay the events %s\n", err)
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("expected no more events, received one on %s\n", m.Subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
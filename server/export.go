@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExportRBAC collects all roles, permissions, role to permission bindings
+// with their conditions and effects and the number of global and scoped
+// members of every role
+func (s *RoleService) ExportRBAC(ctx context.Context) (*rbac.Matrix, error) {
+	m := &rbac.Matrix{}
+	dbroles, err := s.getAllRows(ctx)
+	if err != nil {
+		return m, aphgrpc.HandleError(ctx, err)
+	}
+	dbperms, err := NewPermissionService(s.Dbh).getAllRows(ctx)
+	if err != nil {
+		return m, aphgrpc.HandleError(ctx, err)
+	}
+	for _, dperm := range dbperms {
+		m.Permissions = append(m.Permissions, &rbac.Permission{
+			Permission:  dperm.Permission,
+			Resource:    dperm.Resource,
+			Description: aphgrpc.NullToString(dperm.Description),
+		})
+	}
+	for _, drole := range dbroles {
+		count, err := s.getRelatedUsersCount(drole.AuthRoleId)
+		if err != nil {
+			return m, aphgrpc.HandleError(ctx, err)
+		}
+		scoped, err := s.getScopedMembersCount(drole.AuthRoleId)
+		if err != nil {
+			return m, aphgrpc.HandleError(ctx, err)
+		}
+		pdata, err := s.getPermissionResourceData(drole.AuthRoleId)
+		if err != nil {
+			return m, aphgrpc.HandleError(ctx, err)
+		}
+		grants, err := getRoleGrants(s.Dbh, drole.AuthRoleId)
+		if err != nil {
+			return m, aphgrpc.HandleError(ctx, err)
+		}
+		role := &rbac.Role{
+			Role:          drole.Role,
+			Description:   drole.Description,
+			Members:       count,
+			ScopedMembers: scoped,
+		}
+		for _, p := range pdata {
+			g := &rbac.Grant{
+				Permission: p.Attributes.Permission,
+				Resource:   p.Attributes.Resource,
+			}
+			if dg, ok := grants[p.Id]; ok {
+				g.Condition = dg.Condition
+				g.Effect = dg.Effect
+			}
+			role.Permissions = append(role.Permissions, g)
+		}
+		m.Roles = append(m.Roles, role)
+	}
+	m.Sort()
+	return m, nil
+}
+
+func (s *RoleService) exportRBACHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = rbac.FormatYAML
+	}
+	if !rbac.IsValidFormat(format) {
+		writeHTTPError(w, status.Errorf(codes.InvalidArgument, "format %s is not supported", format))
+		return
+	}
+	m, err := s.ExportRBAC(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	var b bytes.Buffer
+	if err := rbac.Write(&b, format, m); err != nil {
+		writeHTTPError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", rbac.ContentType(format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/rbac"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestExportRBAC(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	permClient := pb.NewPermissionServiceClient(conn)
+	perm, err := permClient.CreatePermission(context.Background(), NewPermission("write", "genes"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	_, err = permClient.CreatePermission(context.Background(), NewPermission("read", "genes"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(context.Background(), NewUser("curator@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	client := pb.NewRoleServiceClient(conn)
+	nrole, err := client.CreateRole(context.Background(), NewRoleWithPermission("curator", perm))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	_, err = client.CreateUserRelationship(
+		context.Background(),
+		&jsonapi.DataCollection{
+			Id:   nrole.Data.Id,
+			Data: []*jsonapi.Data{{Type: "users", Id: usr.Data.Id}},
+		},
+	)
+	if err != nil {
+		t.Fatalf("could not create the relationship with user %s\n", err)
+	}
+	susr, err := pb.NewUserServiceClient(conn).CreateUser(context.Background(), NewUser("strains@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	sctx := metadata.AppendToOutgoingContext(
+		context.Background(),
+		ScopeResourceTypeKey, "strain_collection",
+		ScopeResourceIdKey, "12",
+	)
+	_, err = client.CreateUserRelationship(
+		sctx,
+		&jsonapi.DataCollection{
+			Id:   nrole.Data.Id,
+			Data: []*jsonapi.Data{{Type: "users", Id: susr.Data.Id}},
+		},
+	)
+	if err != nil {
+		t.Fatalf("could not create the scoped relationship with user %s\n", err)
+	}
+	var header metadata.MD
+	grole, err := client.GetRole(
+		context.Background(),
+		&jsonapi.GetRequest{Id: nrole.Data.Id, Include: "users"},
+		grpc.Header(&header),
+	)
+	if err != nil {
+		t.Fatalf("could not fetch the role with its users %s\n", err)
+	}
+	if len(grole.Data.Relationships.Users.Data) != 2 {
+		t.Fatalf("expected 2 included users, received %d\n", len(grole.Data.Relationships.Users.Data))
+	}
+	scope := fmt.Sprintf("%d:%d:strain_collection/12", nrole.Data.Id, susr.Data.Id)
+	if v := header.Get(ScopedUsersKey); len(v) != 1 || v[0] != scope {
+		t.Fatalf("expected scoped user %s in the header, received %v\n", scope, v)
+	}
+	_, err = client.CreateRole(context.Background(), NewRole("visitor"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+
+	m, err := NewRoleService(runner.NewDB(db, "postgres")).ExportRBAC(context.Background())
+	if err != nil {
+		t.Fatalf("could not export roles and permissions %s\n", err)
+	}
+	if len(m.Permissions) != 2 {
+		t.Fatalf("expected 2 permissions, received %d\n", len(m.Permissions))
+	}
+	if len(m.Roles) != 2 {
+		t.Fatalf("expected 2 roles, received %d\n", len(m.Roles))
+	}
+	curator := m.Roles[0]
+	if curator.Role != "curator" {
+		t.Fatalf("expected role curator, received %s\n", curator.Role)
+	}
+	if curator.Members != 1 {
+		t.Fatalf("expected 1 member of curator, received %d\n", curator.Members)
+	}
+	if curator.ScopedMembers != 1 {
+		t.Fatalf("expected 1 scoped member of curator, received %d\n", curator.ScopedMembers)
+	}
+	if !curator.HasGrant(rbac.PermissionKey("write", "genes")) {
+		t.Fatal("expected curator to be granted write:genes")
+	}
+	if curator.HasGrant(rbac.PermissionKey("read", "genes")) {
+		t.Fatal("expected curator not to be granted read:genes")
+	}
+	if len(m.Roles[1].Permissions) != 0 {
+		t.Fatalf("expected no permissions for visitor, received %d\n", len(m.Roles[1].Permissions))
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected a valid matrix %s\n", err)
+	}
+}
+
+func TestApplyRBAC(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	rs := NewRoleService(runner.NewDB(db, "postgres"))
+	m := &rbac.Matrix{
+		Roles: []*rbac.Role{
+			{
+				Role:        "curator",
+				Description: "curates genes",
+				Permissions: []*rbac.Grant{
+					{Permission: "write", Resource: "genes", Condition: "user.is_active"},
+					{Permission: "read", Resource: "genes"},
+				},
+			},
+		},
+		Permissions: []*rbac.Permission{
+			{Permission: "write", Resource: "genes"},
+			{Permission: "read", Resource: "genes"},
+		},
+	}
+	diff, err := rs.ApplyRBAC(context.Background(), m, true)
+	if err != nil {
+		t.Fatalf("could not diff the matrix %s\n", err)
+	}
+	if len(diff.AddedRoles) != 1 || len(diff.AddedPermissions) != 2 || len(diff.AddedGrants) != 2 {
+		t.Fatalf("expected a role, 2 permissions and 2 grants to be added, received %+v\n", diff)
+	}
+	stored, err := rs.ExportRBAC(context.Background())
+	if err != nil {
+		t.Fatalf("could not export roles and permissions %s\n", err)
+	}
+	if len(stored.Roles) != 0 || len(stored.Permissions) != 0 {
+		t.Fatal("expected nothing to be stored in a dry run")
+	}
+	if _, err := rs.ApplyRBAC(context.Background(), m, false); err != nil {
+		t.Fatalf("could not apply the matrix %s\n", err)
+	}
+	stored, err = rs.ExportRBAC(context.Background())
+	if err != nil {
+		t.Fatalf("could not export roles and permissions %s\n", err)
+	}
+	if len(stored.Roles) != 1 || len(stored.Permissions) != 2 {
+		t.Fatalf("expected the matrix to be stored, received %+v\n", stored)
+	}
+	g := stored.Roles[0].Grant(rbac.PermissionKey("write", "genes"))
+	if g == nil || g.Condition != "user.is_active" {
+		t.Fatalf("expected the conditional grant of write:genes, received %+v\n", g)
+	}
+
+	m.Roles[0].Permissions = []*rbac.Grant{
+		{Permission: "read", Resource: "genes", Effect: rbac.EffectDeny},
+	}
+	diff, err = rs.ApplyRBAC(context.Background(), m, false)
+	if err != nil {
+		t.Fatalf("could not apply the matrix %s\n", err)
+	}
+	if len(diff.UpdatedGrants) != 1 || len(diff.RemovedGrants) != 1 {
+		t.Fatalf("expected a grant to be updated and one removed, received %+v\n", diff)
+	}
+	stored, err = rs.ExportRBAC(context.Background())
+	if err != nil {
+		t.Fatalf("could not export roles and permissions %s\n", err)
+	}
+	curator := stored.Roles[0]
+	if len(curator.Permissions) != 1 || !curator.Permissions[0].IsDeny() {
+		t.Fatalf("expected only the denied read:genes, received %+v\n", curator.Permissions)
+	}
+	diff, err = rs.ApplyRBAC(context.Background(), m, false)
+	if err != nil {
+		t.Fatalf("could not apply the matrix %s\n", err)
+	}
+	if !diff.IsEmpty() {
+		t.Fatalf("expected no changes for an applied matrix, received %+v\n", diff)
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/rbac"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// GrantCondition is the condition attached to the binding of a permission
+// to a role. An empty condition means the permission is granted
+// unconditionally.
+type GrantCondition struct {
+	RoleId       int64  `json:"role_id"`
+	PermissionId int64  `json:"permission_id"`
+	Condition    string `json:"condition"`
+}
+
+// ConditionExplanation reports how the condition of a grant was evaluated
+// during a permission check
+type ConditionExplanation struct {
+	RoleId     int64  `json:"role_id"`
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+	Resource   string `json:"resource"`
+	Condition  string `json:"condition"`
+	Satisfied  bool   `json:"satisfied"`
+	// Attributes lists the values of the attributes used by the condition
+	Attributes string `json:"attributes,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// GetPermissionCondition returns the condition of a role to permission
+// binding
+func (s *RoleService) GetPermissionCondition(ctx context.Context, r *GrantCondition) (*GrantCondition, error) {
+	var conds []dat.NullString
+	err := s.Dbh.Select("condition").
+		From("auth_role_permission").
+		Where("auth_role_id = $1 AND auth_permission_id = $2", r.RoleId, r.PermissionId).
+		QuerySlice(&conds)
+	if err != nil {
+		return &GrantCondition{}, aphgrpc.HandleError(ctx, err)
+	}
+	if len(conds) == 0 {
+		return &GrantCondition{}, aphgrpc.HandleNotFoundError(
+			ctx,
+			fmt.Errorf("permission %d is not bound to role %d", r.PermissionId, r.RoleId),
+		)
+	}
+	return &GrantCondition{
+		RoleId:       r.RoleId,
+		PermissionId: r.PermissionId,
+		Condition:    aphgrpc.NullToString(conds[0]),
+	}, nil
+}
+
+// SetPermissionCondition validates and stores the condition of a role to
+// permission binding, an empty condition removes it
+func (s *RoleService) SetPermissionCondition(ctx context.Context, r *GrantCondition) (*GrantCondition, error) {
+	if len(r.Condition) > 0 {
+		if err := rbac.ValidateCondition(r.Condition); err != nil {
+			return &GrantCondition{}, aphgrpc.HandleUpdateArgError(ctx, fmt.Errorf("invalid condition %s", err))
+		}
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("SetPermissionCondition"), "roles", r.RoleId)
+	if err != nil {
+		return &GrantCondition{}, err
+	}
+	defer tx.AutoRollback()
+	res, err := tx.Update("auth_role_permission").
+		Set("condition", dat.NullStringFrom(r.Condition)).
+		Where("auth_role_id = $1 AND auth_permission_id = $2", r.RoleId, r.PermissionId).
+		Exec()
+	if err != nil {
+		return &GrantCondition{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	if res.RowsAffected == 0 {
+		return &GrantCondition{}, aphgrpc.HandleNotFoundError(
+			ctx,
+			fmt.Errorf("permission %d is not bound to role %d", r.PermissionId, r.RoleId),
+		)
+	}
+	if err := au.relate("permissions", r.PermissionId).commit(tx); err != nil {
+		return &GrantCondition{}, err
+	}
+	s.cache.invalidateUsers(s.cache.roleUsers(s.Dbh, r.RoleId))
+	return r, nil
+}
+
+// userAttributes collects the attributes of the user that are available to
+// conditions as user.<name>
+func userAttributes(conn runner.Connection, id int64) (map[string]interface{}, error) {
+	dusr := new(dbUser)
+	err := conn.SQL(
+		fmt.Sprintf("%s %s", usrTableStmt, "WHERE auth_user.auth_user_id = $1"),
+		id,
+	).QueryStruct(dusr)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"id":             dusr.AuthUserId,
+		"email":          dusr.Email,
+		"first_name":     dusr.FirstName,
+		"last_name":      dusr.LastName,
+		"is_active":      dusr.IsActive,
+		"organization":   nullToAttribute(dusr.Organization),
+		"group_name":     nullToAttribute(dusr.GroupName),
+		"first_address":  nullToAttribute(dusr.FirstAddress),
+		"second_address": nullToAttribute(dusr.SecondAddress),
+		"city":           nullToAttribute(dusr.City),
+		"state":          nullToAttribute(dusr.State),
+		"zipcode":        nullToAttribute(dusr.Zipcode),
+		"country":        nullToAttribute(dusr.Country),
+		"phone":          nullToAttribute(dusr.Phone),
+	}, nil
+}
+
+// principalAttributes collects the user.<name> attributes of the user or
+// service account of the permission check
+func principalAttributes(conn runner.Connection, r *PermissionCheck) (map[string]interface{}, error) {
+	if r.ServiceAccountId > 0 {
+		return serviceAccountAttributes(conn, r.ServiceAccountId)
+	}
+	return userAttributes(conn, r.UserId)
+}
+
+func nullToAttribute(n dat.NullString) interface{} {
+	if !n.Valid {
+		return nil
+	}
+	return n.String
+}
+
+// requestAttributes collects the attributes of the permission check that
+// are available to conditions as request.<name>. The permission, resource
+// and resource_id of the check cannot be overridden by the context.
+func requestAttributes(r *PermissionCheck) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	for k, v := range r.Context {
+		attrs[k] = v
+	}
+	attrs["permission"] = r.Permission
+	attrs["resource"] = r.Resource
+	if len(r.ResourceId) > 0 {
+		attrs["resource_id"] = r.ResourceId
+	}
+	return attrs
+}
+
+// conditionEvaluator evaluates the conditions of the grants of a single
+// permission check, the user attributes are only fetched when a condition
+// has to be evaluated
+type conditionEvaluator struct {
+	conn  runner.Connection
+	check *PermissionCheck
+	env   *rbac.ConditionEnv
+}
+
+func (e *conditionEvaluator) evaluate(g *dbRoleGrant) (*ConditionExplanation, error) {
+	x := &ConditionExplanation{
+		RoleId:     g.AuthRoleId,
+		Role:       g.Role,
+		Permission: g.Permission,
+		Resource:   g.Resource,
+		Condition:  g.Condition.String,
+	}
+	if e.env == nil {
+		attrs, err := principalAttributes(e.conn, e.check)
+		if err != nil {
+			return nil, err
+		}
+		e.env = &rbac.ConditionEnv{User: attrs, Request: requestAttributes(e.check)}
+	}
+	c, err := rbac.ParseCondition(g.Condition.String)
+	if err != nil {
+		// conditions are validated when stored, an invalid one never holds
+		x.Error = err.Error()
+		return x, nil
+	}
+	x.Attributes = c.Explain(e.env)
+	ok, err := c.Evaluate(e.env)
+	if err != nil {
+		x.Error = err.Error()
+		return x, nil
+	}
+	x.Satisfied = ok
+	return x, nil
+}
+
+// -- HTTP handlers
+
+func grantConditionFromParams(params map[string]string) (*GrantCondition, error) {
+	roleId, err := pathParamToID(params, "id")
+	if err != nil {
+		return nil, err
+	}
+	permId, err := pathParamToID(params, "permission_id")
+	if err != nil {
+		return nil, err
+	}
+	return &GrantCondition{RoleId: roleId, PermissionId: permId}, nil
+}
+
+func (s *RoleService) getPermissionConditionHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	gc, err := grantConditionFromParams(params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	c, err := s.GetPermissionCondition(r.Context(), gc)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *RoleService) setPermissionConditionHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	gc, err := grantConditionFromParams(params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	body := &GrantCondition{}
+	if err := readJSON(r, body); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	gc.Condition = body.Condition
+	c, err := s.SetPermissionCondition(r.Context(), gc)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/rbac"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// GrantEffectKey is the metadata key that sets the effect of the bindings
+// created by CreatePermissionRelationship and UpdatePermissionRelationship.
+// Through the HTTP gateway it is given as the Grpc-Metadata-Grant-Effect
+// header. Without it the permissions are allowed.
+const GrantEffectKey = "grant-effect"
+
+// DeniedPermissionsKey is the header metadata key of GetRelatedPermissions
+// that lists the comma separated ids of the permissions the role is denied,
+// as the protocol buffer definition of a permission has no place for it
+const DeniedPermissionsKey = "denied-permissions"
+
+// GrantEffect is the effect of the binding of a permission to a role
+type GrantEffect struct {
+	RoleId       int64  `json:"role_id"`
+	PermissionId int64  `json:"permission_id"`
+	Effect       string `json:"effect"`
+}
+
+// GrantedPermission is a permission bound to a role along with the
+// condition and effect of the binding
+type GrantedPermission struct {
+	*user.PermissionData
+	Grant *rbac.Grant
+}
+
+// GrantedPermissionCollection lists the permissions bound to a role
+type GrantedPermissionCollection struct {
+	Data  []*GrantedPermission
+	Links *jsonapi.Links
+}
+
+type dbGrant struct {
+	AuthPermissionId int64          `db:"auth_permission_id"`
+	Condition        dat.NullString `db:"condition"`
+	Effect           string         `db:"effect"`
+}
+
+// SetPermissionEffect changes a role to permission binding to allow or
+// deny the permission
+func (s *RoleService) SetPermissionEffect(ctx context.Context, r *GrantEffect) (*GrantEffect, error) {
+	if err := validateEffect(r.Effect); err != nil {
+		return &GrantEffect{}, aphgrpc.HandleUpdateArgError(ctx, err)
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("SetPermissionEffect"), "roles", r.RoleId)
+	if err != nil {
+		return &GrantEffect{}, err
+	}
+	defer tx.AutoRollback()
+	res, err := tx.Update("auth_role_permission").
+		Set("effect", r.Effect).
+		Where("auth_role_id = $1 AND auth_permission_id = $2", r.RoleId, r.PermissionId).
+		Exec()
+	if err != nil {
+		return &GrantEffect{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	if res.RowsAffected == 0 {
+		return &GrantEffect{}, aphgrpc.HandleNotFoundError(
+			ctx,
+			fmt.Errorf("permission %d is not bound to role %d", r.PermissionId, r.RoleId),
+		)
+	}
+	if err := au.relate("permissions", r.PermissionId).commit(tx); err != nil {
+		return &GrantEffect{}, err
+	}
+	s.cache.invalidateUsers(s.cache.roleUsers(s.Dbh, r.RoleId))
+	return r, nil
+}
+
+// GetGrantedPermissions is GetRelatedPermissions with the condition and
+// effect of every binding. It is served at /roles/{id}/grants, leaving
+// /roles/{id}/permissions to the generated endpoint.
+func (s *RoleService) GetGrantedPermissions(ctx context.Context, r *jsonapi.RelationshipRequest) (*GrantedPermissionCollection, error) {
+	coll, err := s.GetRelatedPermissions(ctx, r)
+	if err != nil {
+		return &GrantedPermissionCollection{}, err
+	}
+	grants, err := getRoleGrants(s.Dbh, r.Id)
+	if err != nil {
+		return &GrantedPermissionCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	gc := &GrantedPermissionCollection{
+		Data:  make([]*GrantedPermission, 0),
+		Links: coll.Links,
+	}
+	for _, pd := range coll.Data {
+		g := &rbac.Grant{
+			Permission: pd.Attributes.Permission,
+			Resource:   pd.Attributes.Resource,
+			Effect:     rbac.EffectAllow,
+		}
+		if dg, ok := grants[pd.Id]; ok {
+			g.Condition = dg.Condition
+			if dg.IsDeny() {
+				g.Effect = rbac.EffectDeny
+			}
+		}
+		gc.Data = append(gc.Data, &GrantedPermission{PermissionData: pd, Grant: g})
+	}
+	return gc, nil
+}
+
+// getRoleGrants returns the condition and effect of the permissions bound
+// to the role keyed by permission id. The effect is left empty for the
+// allowed permissions.
+func getRoleGrants(conn runner.Connection, roleId int64) (map[int64]*rbac.Grant, error) {
+	var dbrows []*dbGrant
+	err := conn.Select("auth_permission_id", "condition", "effect").
+		From("auth_role_permission").
+		Where("auth_role_id = $1", roleId).
+		QueryStructs(&dbrows)
+	if err != nil {
+		return nil, err
+	}
+	grants := make(map[int64]*rbac.Grant)
+	for _, d := range dbrows {
+		g := &rbac.Grant{Condition: aphgrpc.NullToString(d.Condition)}
+		if d.Effect == rbac.EffectDeny {
+			g.Effect = rbac.EffectDeny
+		}
+		grants[d.AuthPermissionId] = g
+	}
+	return grants, nil
+}
+
+// setDeniedPermissionsHeader sends the ids of the denied permissions of the
+// role as header metadata
+func setDeniedPermissionsHeader(ctx context.Context, conn runner.Connection, roleId int64) error {
+	var ids []int64
+	err := conn.Select("auth_permission_id").
+		From("auth_role_permission").
+		Where("auth_role_id = $1 AND effect = $2", roleId, rbac.EffectDeny).
+		OrderBy("auth_permission_id").
+		QuerySlice(&ids)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	var sids []string
+	for _, id := range ids {
+		sids = append(sids, strconv.FormatInt(id, 10))
+	}
+	// fails only outside of a grpc call, where there is nobody to send the
+	// header to
+	grpc.SetHeader(ctx, metadata.Pairs(DeniedPermissionsKey, strings.Join(sids, ",")))
+	return nil
+}
+
+// effectFromContext reads the effect of new bindings from the incoming
+// metadata, allow is returned in the absence of any
+func effectFromContext(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return rbac.EffectAllow, nil
+	}
+	v := md.Get(GrantEffectKey)
+	if len(v) == 0 || len(v[0]) == 0 {
+		return rbac.EffectAllow, nil
+	}
+	if err := validateEffect(v[0]); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return v[0], nil
+}
+
+func validateEffect(effect string) error {
+	if effect != rbac.EffectAllow && effect != rbac.EffectDeny {
+		return fmt.Errorf("effect %q is not supported, use %s or %s", effect, rbac.EffectAllow, rbac.EffectDeny)
+	}
+	return nil
+}
+
+// -- JSON encoding
+
+// MarshalJSON adds the condition and effect of the binding as meta of the
+// permission
+func (gp *GrantedPermission) MarshalJSON() ([]byte, error) {
+	b, err := marshalProto(gp.PermissionData)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	meta := map[string]string{"effect": gp.Grant.Effect}
+	if len(gp.Grant.Condition) > 0 {
+		meta["condition"] = gp.Grant.Condition
+	}
+	if doc["meta"], err = json.Marshal(meta); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func (gc *GrantedPermissionCollection) MarshalJSON() ([]byte, error) {
+	links, err := marshalProto(gc.Links)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		Data  []*GrantedPermission `json:"data"`
+		Links json.RawMessage      `json:"links"`
+	}{gc.Data, links})
+}
+
+// -- HTTP handlers
+
+func (s *RoleService) grantedPermissionsHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	gc, err := s.GetGrantedPermissions(r.Context(), &jsonapi.RelationshipRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, gc)
+}
+
+func (s *RoleService) setPermissionEffectHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	gc, err := grantConditionFromParams(params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	body := &GrantEffect{}
+	if err := readJSON(r, body); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	e, err := s.SetPermissionEffect(r.Context(), &GrantEffect{
+		RoleId:       gc.RoleId,
+		PermissionId: gc.PermissionId,
+		Effect:       body.Effect,
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway/httprule"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPRoute is a HTTP endpoint that is served by the grpc gateway muxer
+// along with the routes generated from the protocol buffer definitions
+type HTTPRoute struct {
+	Method string
+	// Path is a template in google.api.http syntax, for example
+	// /roles/{id}/statistics
+	Path    string
+	Handler runtime.HandlerFunc
+}
+
+// RegisterHTTPRoutes adds the routes to the muxer. The routes have to be
+// registered before the generated handlers, otherwise any overlapping
+// generated route takes precedence.
+func RegisterHTTPRoutes(mux *runtime.ServeMux, routes []*HTTPRoute) error {
+	for _, r := range routes {
+		c, err := httprule.Parse(r.Path)
+		if err != nil {
+			return fmt.Errorf("error in parsing path %s %s", r.Path, err)
+		}
+		tmpl := c.Compile()
+		pat, err := runtime.NewPattern(tmpl.Version, tmpl.OpCodes, tmpl.Pool, tmpl.Verb)
+		if err != nil {
+			return fmt.Errorf("error in creating pattern for path %s %s", r.Path, err)
+		}
+		mux.Handle(r.Method, pat, r.Handler)
+	}
+	return nil
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	aphgrpc.JSONAPIError(w, metadata.MD{}, status.Convert(err))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return status.Errorf(codes.InvalidArgument, "error in decoding request body %s", err)
+	}
+	return nil
+}
+
+func pathParamToID(params map[string]string, name string) (int64, error) {
+	id, err := strconv.ParseInt(params[name], 10, 64)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s %s", name, params[name])
+	}
+	return id, nil
+}
+
+func queryParamToID(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if len(v) == 0 {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %s %s", name, v)
+	}
+	return id, nil
+}
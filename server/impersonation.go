@@ -0,0 +1,442 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/modware-user/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const impersonationDbTable = "auth_impersonation"
+
+// DefaultImpersonationTTL is the lifetime of an impersonation that does not
+// ask for a duration
+const DefaultImpersonationTTL = 15 * time.Minute
+
+// States of an impersonation
+const (
+	ImpersonationActive  = "active"
+	ImpersonationStopped = "stopped"
+	ImpersonationExpired = "expired"
+)
+
+var impersonationCols = []string{
+	"auth_impersonation_id",
+	"admin_user_id",
+	"target_user_id",
+	"reason",
+	"started_at",
+	"expires_at",
+	"ended_at",
+	"ended_by",
+}
+
+type dbImpersonation struct {
+	AuthImpersonationId int64         `db:"auth_impersonation_id"`
+	AdminUserId         int64         `db:"admin_user_id"`
+	TargetUserId        int64         `db:"target_user_id"`
+	Reason              string        `db:"reason"`
+	StartedAt           time.Time     `db:"started_at"`
+	ExpiresAt           time.Time     `db:"expires_at"`
+	EndedAt             dat.NullTime  `db:"ended_at"`
+	EndedBy             dat.NullInt64 `db:"ended_by"`
+}
+
+// Impersonation is a session in which an administrator acts as another
+// user
+type Impersonation struct {
+	Id           int64      `json:"id"`
+	AdminUserId  int64      `json:"admin_user_id"`
+	TargetUserId int64      `json:"target_user_id"`
+	Reason       string     `json:"reason"`
+	State        string     `json:"state"`
+	StartedAt    time.Time  `json:"started_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
+	EndedBy      int64      `json:"ended_by,omitempty"`
+}
+
+// ImpersonationCollection lists impersonations, the latest first
+type ImpersonationCollection struct {
+	Data []*Impersonation `json:"data"`
+}
+
+// NewImpersonation starts acting as the target user. Without a duration
+// the impersonation lasts for DefaultImpersonationTTL, no impersonation
+// lasts longer than the maximum of the service.
+type NewImpersonation struct {
+	TargetUserId int64  `json:"target_user_id"`
+	Reason       string `json:"reason"`
+	Duration     int64  `json:"duration_seconds"`
+}
+
+// ImpersonationToken is the token that acts as the target user along with
+// its impersonation
+type ImpersonationToken struct {
+	*IssuedToken
+	Impersonation *Impersonation `json:"impersonation"`
+}
+
+// ImpersonationFilter restricts the listed impersonations, the zero values
+// match every impersonation
+type ImpersonationFilter struct {
+	AdminUserId  int64
+	TargetUserId int64
+	Active       bool
+}
+
+// ImpersonationService lets administrators act as other users. Every
+// impersonation is recorded along with its start and end, the requests made
+// under it carry both identities and the sensitive methods of the policy
+// are refused.
+type ImpersonationService struct {
+	*aphgrpc.Service
+	issuer *TokenIssuer
+	maxTTL time.Duration
+	cache  *PermissionCache
+}
+
+func impersonationServiceOptions() *aphgrpc.ServiceOptions {
+	return &aphgrpc.ServiceOptions{
+		Resource:   "impersonations",
+		PathPrefix: "impersonations",
+	}
+}
+
+// NewImpersonationService creates the service, the impersonation tokens are
+// signed by the issuer and last at most for maxTTL
+func NewImpersonationService(dbh *runner.DB, ti *TokenIssuer, maxTTL time.Duration, opt ...aphgrpc.Option) *ImpersonationService {
+	so := impersonationServiceOptions()
+	for _, optfn := range opt {
+		optfn(so)
+	}
+	srv := &aphgrpc.Service{Dbh: dbh}
+	aphgrpc.AssignFieldsToStructs(so, srv)
+	return &ImpersonationService{Service: srv, issuer: ti, maxTTL: maxTTL}
+}
+
+// WithPermissionCache sets the cache the permissions of the target users
+// are read from
+func (s *ImpersonationService) WithPermissionCache(c *PermissionCache) *ImpersonationService {
+	s.cache = c
+	return s
+}
+
+// HTTPRoutes returns the impersonation endpoints
+func (s *ImpersonationService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/impersonations", Handler: s.listHandler},
+		{Method: "POST", Path: "/impersonations", Handler: s.startHandler},
+		{Method: "GET", Path: "/impersonations/{id}", Handler: s.getHandler},
+		{Method: "POST", Path: "/impersonations/{id}/stop", Handler: s.stopHandler},
+	}
+}
+
+// StartImpersonation records the impersonation of the target user by the
+// administrator of the request and returns the token that acts as the
+// target
+func (s *ImpersonationService) StartImpersonation(ctx context.Context, r *NewImpersonation) (*ImpersonationToken, error) {
+	if s.issuer == nil {
+		return &ImpersonationToken{}, status.Error(codes.Unimplemented, "no token signing key is configured")
+	}
+	admin, err := impersonatingAdmin(ctx)
+	if err != nil {
+		return &ImpersonationToken{}, err
+	}
+	if len(strings.TrimSpace(r.Reason)) == 0 {
+		return &ImpersonationToken{}, status.Error(codes.InvalidArgument, "a reason is required")
+	}
+	if r.TargetUserId == admin.UserId {
+		return &ImpersonationToken{}, status.Error(codes.InvalidArgument, "users cannot impersonate themselves")
+	}
+	ttl, err := s.ttl(r.Duration)
+	if err != nil {
+		return &ImpersonationToken{}, err
+	}
+	target, err := getPrincipal(s.Dbh, r.TargetUserId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ImpersonationToken{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("user id %d not found", r.TargetUserId))
+		}
+		return &ImpersonationToken{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !target.IsActive {
+		return &ImpersonationToken{}, status.Errorf(codes.FailedPrecondition, "user %d is not active", target.AuthUserId)
+	}
+	tx, err := s.Dbh.Begin()
+	if err != nil {
+		return &ImpersonationToken{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	defer tx.AutoRollback()
+	dbi := &dbImpersonation{}
+	err = tx.InsertInto(impersonationDbTable).
+		Columns("admin_user_id", "target_user_id", "reason", "expires_at").
+		Values(admin.UserId, target.AuthUserId, r.Reason, time.Now().Add(ttl)).
+		Returning(impersonationCols...).
+		QueryStruct(dbi)
+	if err != nil {
+		return &ImpersonationToken{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	it, err := s.issuer.issue(tx, s.cache, target, ttl, func(c *auth.Claims) {
+		c.Id = strconv.FormatInt(dbi.AuthImpersonationId, 10)
+		c.Actor = &auth.Actor{
+			Subject: strconv.FormatInt(admin.UserId, 10),
+			Email:   admin.Email,
+		}
+	})
+	if err != nil {
+		return &ImpersonationToken{}, aphgrpc.HandleError(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return &ImpersonationToken{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	return &ImpersonationToken{IssuedToken: it, Impersonation: dbToImpersonation(dbi, time.Now())}, nil
+}
+
+// StopImpersonation ends an active impersonation, its token is refused from
+// then on. Only the impersonating administrator can stop it, also with the
+// impersonation token itself.
+func (s *ImpersonationService) StopImpersonation(ctx context.Context, r *jsonapi.IdRequest) (*Impersonation, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return &Impersonation{}, status.Error(codes.Unauthenticated, "the request has no authenticated user")
+	}
+	caller := p.UserId
+	if p.IsImpersonated() {
+		caller = p.Impersonator.UserId
+	}
+	dbi, err := getImpersonation(s.Dbh, r.Id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &Impersonation{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("impersonation id %d not found", r.Id))
+		}
+		return &Impersonation{}, aphgrpc.HandleError(ctx, err)
+	}
+	if p.IsServiceAccount() || dbi.AdminUserId != caller {
+		return &Impersonation{}, status.Errorf(
+			codes.PermissionDenied,
+			"impersonation %d can only be stopped by its administrator", r.Id,
+		)
+	}
+	if state := impersonationState(dbi, time.Now()); state != ImpersonationActive {
+		return &Impersonation{}, status.Errorf(codes.FailedPrecondition, "impersonation %d is already %s", r.Id, state)
+	}
+	err = s.Dbh.Update(impersonationDbTable).
+		Set("ended_at", time.Now()).
+		Set("ended_by", caller).
+		Where("auth_impersonation_id = $1 AND ended_at IS NULL", r.Id).
+		Returning(impersonationCols...).
+		QueryStruct(dbi)
+	if err != nil {
+		return &Impersonation{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	return dbToImpersonation(dbi, time.Now()), nil
+}
+
+// GetImpersonation returns a single impersonation
+func (s *ImpersonationService) GetImpersonation(ctx context.Context, r *jsonapi.IdRequest) (*Impersonation, error) {
+	dbi, err := getImpersonation(s.Dbh, r.Id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &Impersonation{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("impersonation id %d not found", r.Id))
+		}
+		return &Impersonation{}, aphgrpc.HandleError(ctx, err)
+	}
+	return dbToImpersonation(dbi, time.Now()), nil
+}
+
+// ListImpersonations returns the recorded impersonations matching the
+// filter
+func (s *ImpersonationService) ListImpersonations(ctx context.Context, r *ImpersonationFilter) (*ImpersonationCollection, error) {
+	var conds []string
+	var args []interface{}
+	if r.AdminUserId != 0 {
+		args = append(args, r.AdminUserId)
+		conds = append(conds, fmt.Sprintf("admin_user_id = $%d", len(args)))
+	}
+	if r.TargetUserId != 0 {
+		args = append(args, r.TargetUserId)
+		conds = append(conds, fmt.Sprintf("target_user_id = $%d", len(args)))
+	}
+	if r.Active {
+		conds = append(conds, "ended_at IS NULL AND expires_at > now()")
+	}
+	q := s.Dbh.Select(impersonationCols...).From(impersonationDbTable)
+	if len(conds) > 0 {
+		q = q.Where(strings.Join(conds, " AND "), args...)
+	}
+	var dbrows []*dbImpersonation
+	err := q.OrderBy("started_at DESC", "auth_impersonation_id DESC").QueryStructs(&dbrows)
+	if err != nil {
+		return &ImpersonationCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	coll := &ImpersonationCollection{Data: make([]*Impersonation, 0)}
+	now := time.Now()
+	for _, dbi := range dbrows {
+		coll.Data = append(coll.Data, dbToImpersonation(dbi, now))
+	}
+	return coll, nil
+}
+
+// All helper functions
+
+func (s *ImpersonationService) ttl(seconds int64) (time.Duration, error) {
+	switch {
+	case seconds < 0:
+		return 0, status.Error(codes.InvalidArgument, "duration cannot be negative")
+	case seconds == 0:
+		if s.maxTTL > 0 && DefaultImpersonationTTL > s.maxTTL {
+			return s.maxTTL, nil
+		}
+		return DefaultImpersonationTTL, nil
+	}
+	ttl := time.Duration(seconds) * time.Second
+	if s.maxTTL > 0 && ttl > s.maxTTL {
+		return 0, status.Errorf(codes.InvalidArgument, "impersonations last at most %s", s.maxTTL)
+	}
+	return ttl, nil
+}
+
+// impersonatingAdmin returns the user of the request, who has to act on
+// its own behalf
+func impersonatingAdmin(ctx context.Context) (*auth.Principal, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "the request has no authenticated user")
+	}
+	if p.IsServiceAccount() {
+		return nil, status.Errorf(codes.FailedPrecondition, "service account %s cannot impersonate", p.Name)
+	}
+	if p.IsImpersonated() {
+		return nil, status.Error(codes.FailedPrecondition, "an impersonation cannot be started while impersonating")
+	}
+	return p, nil
+}
+
+func getPrincipal(conn runner.Connection, userId int64) (*dbPrincipal, error) {
+	dbp := &dbPrincipal{}
+	err := conn.Select("auth_user_id", "CAST(email AS TEXT) email", "is_active").
+		From("auth_user").
+		Where("auth_user_id = $1", userId).
+		QueryStruct(dbp)
+	return dbp, err
+}
+
+func getImpersonation(conn runner.Connection, id int64) (*dbImpersonation, error) {
+	dbi := &dbImpersonation{}
+	err := conn.Select(impersonationCols...).
+		From(impersonationDbTable).
+		Where("auth_impersonation_id = $1", id).
+		QueryStruct(dbi)
+	return dbi, err
+}
+
+func impersonationState(dbi *dbImpersonation, now time.Time) string {
+	switch {
+	case dbi.EndedAt.Valid:
+		return ImpersonationStopped
+	case !now.Before(dbi.ExpiresAt):
+		return ImpersonationExpired
+	}
+	return ImpersonationActive
+}
+
+func dbToImpersonation(dbi *dbImpersonation, now time.Time) *Impersonation {
+	i := &Impersonation{
+		Id:           dbi.AuthImpersonationId,
+		AdminUserId:  dbi.AdminUserId,
+		TargetUserId: dbi.TargetUserId,
+		Reason:       dbi.Reason,
+		State:        impersonationState(dbi, now),
+		StartedAt:    dbi.StartedAt,
+		ExpiresAt:    dbi.ExpiresAt,
+		EndedBy:      aphgrpc.NullToInt64(dbi.EndedBy),
+	}
+	if dbi.EndedAt.Valid {
+		t := dbi.EndedAt.Time
+		i.EndedAt = &t
+	}
+	return i
+}
+
+// -- HTTP handlers
+
+func (s *ImpersonationService) listHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	f := &ImpersonationFilter{}
+	var err error
+	if f.AdminUserId, err = queryParamToID(r, "admin_user_id"); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if f.TargetUserId, err = queryParamToID(r, "target_user_id"); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if v := r.URL.Query().Get("active"); len(v) > 0 {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid active %s", v))
+			return
+		}
+		f.Active = active
+	}
+	coll, err := s.ListImpersonations(r.Context(), f)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *ImpersonationService) startHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	ni := &NewImpersonation{}
+	if err := readJSON(r, ni); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	it, err := s.StartImpersonation(r.Context(), ni)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, it)
+}
+
+func (s *ImpersonationService) getHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	i, err := s.GetImpersonation(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, i)
+}
+
+func (s *ImpersonationService) stopHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	i, err := s.StopImpersonation(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, i)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestImpersonation(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("read", "users"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("reader", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	client := pb.NewUserServiceClient(conn)
+	admin, err := client.CreateUser(context.Background(), NewUserWithRole("admin@gmail.com", role))
+	if err != nil {
+		t.Fatalf("could not store the admin %s\n", err)
+	}
+	target, err := client.CreateUser(context.Background(), NewUserWithRole("target@gmail.com", role))
+	if err != nil {
+		t.Fatalf("could not store the target user %s\n", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := auth.NewSigner(&auth.SigningKey{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbh := runner.NewDB(db, "postgres")
+	s := NewImpersonationService(dbh, &TokenIssuer{Signer: signer, TTL: time.Hour}, time.Hour)
+	actx := auth.NewContext(context.Background(), &auth.Principal{UserId: admin.Data.Id, Email: "admin@gmail.com"})
+	for _, tc := range []struct {
+		ctx  context.Context
+		ni   *NewImpersonation
+		code codes.Code
+	}{
+		{context.Background(), &NewImpersonation{TargetUserId: target.Data.Id, Reason: "support"}, codes.Unauthenticated},
+		{actx, &NewImpersonation{TargetUserId: target.Data.Id}, codes.InvalidArgument},
+		{actx, &NewImpersonation{TargetUserId: admin.Data.Id, Reason: "support"}, codes.InvalidArgument},
+		{actx, &NewImpersonation{TargetUserId: target.Data.Id, Reason: "support", Duration: 7200}, codes.InvalidArgument},
+		{actx, &NewImpersonation{TargetUserId: 984, Reason: "support"}, codes.NotFound},
+	} {
+		if _, err := s.StartImpersonation(tc.ctx, tc.ni); status.Code(err) != tc.code {
+			t.Fatalf("expected %s for %+v, received %v\n", tc.code, tc.ni, err)
+		}
+	}
+	it, err := s.StartImpersonation(actx, &NewImpersonation{TargetUserId: target.Data.Id, Reason: "support"})
+	if err != nil {
+		t.Fatalf("could not start the impersonation %s\n", err)
+	}
+	if it.ExpiresIn != int64(DefaultImpersonationTTL/time.Second) || it.Impersonation.State != ImpersonationActive {
+		t.Fatalf("unexpected impersonation token %+v %+v\n", it.IssuedToken, it.Impersonation)
+	}
+
+	a := NewAuthenticator(dbh, &auth.Verifier{Sources: []auth.KeySource{signer}}, auth.DefaultPolicy())
+	token := "Bearer " + it.Token
+	ctx, err := a.Authorize(context.Background(), "/dictybase.user.UserService/GetUser", token)
+	if err != nil {
+		t.Fatalf("expected GetUser to be allowed while impersonating, received %s\n", err)
+	}
+	p, _ := auth.FromContext(ctx)
+	if p.UserId != target.Data.Id || !p.IsImpersonated() || p.Impersonator.UserId != admin.Data.Id {
+		t.Fatalf("expected the target impersonated by the admin, received %+v\n", p)
+	}
+	if p.Claims.Actor.Subject != fmt.Sprintf("%d", admin.Data.Id) {
+		t.Fatalf("expected the admin as actor, received %+v\n", p.Claims.Actor)
+	}
+	for _, method := range []string{"PATCH /users/me", "POST /impersonations", "/dictybase.user.UserService/DeleteUser"} {
+		if _, err := a.Authorize(context.Background(), method, token); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected %s to be refused while impersonating, received %v\n", method, err)
+		}
+	}
+	if _, err := s.StartImpersonation(ctx, &NewImpersonation{TargetUserId: admin.Data.Id, Reason: "nested"}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a nested impersonation to be rejected, received %v\n", err)
+	}
+
+	tctx := auth.NewContext(context.Background(), &auth.Principal{UserId: target.Data.Id})
+	if _, err := s.StopImpersonation(tctx, &jsonapi.IdRequest{Id: it.Impersonation.Id}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected the target to be unable to stop it, received %v\n", err)
+	}
+	imp, err := s.StopImpersonation(ctx, &jsonapi.IdRequest{Id: it.Impersonation.Id})
+	if err != nil {
+		t.Fatalf("could not stop the impersonation %s\n", err)
+	}
+	if imp.State != ImpersonationStopped || imp.EndedBy != admin.Data.Id || imp.EndedAt == nil {
+		t.Fatalf("expected the impersonation to be stopped by the admin, received %+v\n", imp)
+	}
+	if _, err := a.Authorize(context.Background(), "/dictybase.user.UserService/GetUser", token); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the token of a stopped impersonation to be refused, received %v\n", err)
+	}
+	if _, err := s.StopImpersonation(actx, &jsonapi.IdRequest{Id: it.Impersonation.Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a stopped impersonation to stay stopped, received %v\n", err)
+	}
+	coll, err := s.ListImpersonations(context.Background(), &ImpersonationFilter{AdminUserId: admin.Data.Id})
+	if err != nil {
+		t.Fatalf("could not list the impersonations %s\n", err)
+	}
+	if len(coll.Data) != 1 || coll.Data[0].Reason != "support" {
+		t.Fatalf("expected the recorded impersonation, received %+v\n", coll.Data)
+	}
+	coll, err = s.ListImpersonations(context.Background(), &ImpersonationFilter{Active: true})
+	if err != nil {
+		t.Fatalf("could not list the active impersonations %s\n", err)
+	}
+	if len(coll.Data) != 0 {
+		t.Fatalf("expected no active impersonation, received %d\n", len(coll.Data))
+	}
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/message"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const outboxDbTable = "auth_event_outbox"
+
+const (
+	// DefaultOutboxBatchSize is the number of events relayed at once
+	DefaultOutboxBatchSize = 100
+	// DefaultOutboxPollInterval is how long the relay waits for new events
+	// once the outbox is drained
+	DefaultOutboxPollInterval = time.Second
+	// DefaultOutboxMinBackoff is the delay before the first retry of a
+	// failed delivery
+	DefaultOutboxMinBackoff = time.Second
+	// DefaultOutboxMaxBackoff is the longest delay between the retries
+	DefaultOutboxMaxBackoff = time.Minute
+)
+
+type dbOutboxEvent struct {
+	AuthEventOutboxId int64  `db:"auth_event_outbox_id"`
+	EventType         string `db:"event_type"`
+	Payload           []byte `db:"payload"`
+}
+
+// storeEvents adds the domain events of a change to the outbox and queues
+// them for the subscribed webhooks, in the transaction of the change
+func storeEvents(ctx context.Context, conn runner.Connection, events []proto.Message) error {
+	for _, ev := range events {
+		payload, err := proto.Marshal(ev)
+		if err != nil {
+			return aphgrpc.HandleError(ctx, err)
+		}
+		_, err = conn.InsertInto(outboxDbTable).
+			Columns("event_type", "payload").
+			Values(string(ev.ProtoReflect().Descriptor().FullName()), payload).
+			Exec()
+		if err != nil {
+			return aphgrpc.HandleInsertError(ctx, err)
+		}
+		if err := queueWebhookDeliveries(conn, ev); err != nil {
+			return aphgrpc.HandleInsertError(ctx, err)
+		}
+	}
+	return nil
+}
+
+// OutboxStats describes how far the relay is behind the changes
+type OutboxStats struct {
+	// Pending is the number of events waiting to be published
+	Pending int64 `json:"pending" db:"pending"`
+	// LagSeconds is the age of the oldest pending event
+	LagSeconds float64 `json:"lag_seconds" db:"lag_seconds"`
+	// Delivered and Failures count the events published and the failed
+	// attempts since the relay started
+	Delivered       int64      `json:"delivered"`
+	Failures        int64      `json:"failures"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+}
+
+// OutboxRelay publishes the domain events stored in the outbox by the order
+// of their ids. A failed delivery is retried after a delay that doubles
+// from MinBackoff up to MaxBackoff, the events that follow it wait for it.
+// An event can be delivered more than once when the relay fails after
+// publishing it, the consumers drop the duplicates by the id of the event.
+//
+// The events of a change are published in the order they were built. The
+// ids are taken from a sequence before the change commits, so the events of
+// concurrent changes can be published in another order than the changes
+// committed in, an event committed after a later id was relayed is
+// published in the next batch. Consumers must not rely on the order of the
+// events of distinct changes, the audit event id and time of the meta of
+// an event tell which change it belongs to.
+//
+// Concurrent relays deliver the events one batch at a time, the pending
+// events are locked while they are published.
+type OutboxRelay struct {
+	dbh          *runner.DB
+	publisher    message.Publisher
+	prefix       string
+	BatchSize    int
+	PollInterval time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	mu           sync.Mutex
+	stats        OutboxStats
+}
+
+// NewOutboxRelay creates a relay publishing the events on the subjects with
+// the given prefix
+func NewOutboxRelay(dbh *runner.DB, pub message.Publisher, prefix string) *OutboxRelay {
+	if len(prefix) == 0 {
+		prefix = DefaultEventSubjectPrefix
+	}
+	return &OutboxRelay{
+		dbh:          dbh,
+		publisher:    pub,
+		prefix:       prefix,
+		BatchSize:    DefaultOutboxBatchSize,
+		PollInterval: DefaultOutboxPollInterval,
+		MinBackoff:   DefaultOutboxMinBackoff,
+		MaxBackoff:   DefaultOutboxMaxBackoff,
+	}
+}
+
+// HTTPRoutes returns the endpoints exposing the state of the relay
+func (r *OutboxRelay) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/outbox/stats", Handler: r.statsHandler},
+		{Method: "GET", Path: "/metrics", Handler: r.metricsHandler},
+	}
+}
+
+// Run relays the events until the context is done, the errors are handed
+// over to the report function before being retried
+func (r *OutboxRelay) Run(ctx context.Context, report func(error)) {
+	var backoff time.Duration
+	for {
+		n, err := r.Relay()
+		wait := r.PollInterval
+		switch {
+		case err != nil:
+			backoff = r.nextBackoff(backoff)
+			wait = backoff
+			if report != nil {
+				report(err)
+			}
+		case n == r.BatchSize:
+			backoff = 0
+			wait = 0
+		default:
+			backoff = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Relay publishes a batch of pending events and returns how many were
+// delivered. The delivery stops at the first failed event, which is
+// recorded along with its error.
+func (r *OutboxRelay) Relay() (int, error) {
+	tx, err := r.dbh.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.AutoRollback()
+	var rows []*dbOutboxEvent
+	err = tx.SQL(`
+		SELECT auth_event_outbox_id, event_type, payload
+		FROM auth_event_outbox
+		WHERE delivered_at IS NULL
+		ORDER BY auth_event_outbox_id
+		LIMIT $1
+		FOR UPDATE`, r.BatchSize).
+		QueryStructs(&rows)
+	if err != nil {
+		return 0, err
+	}
+	var delivered []int64
+	var failed *dbOutboxEvent
+	var perr error
+	for _, row := range rows {
+		if perr = r.publish(row); perr != nil {
+			failed = row
+			break
+		}
+		delivered = append(delivered, row.AuthEventOutboxId)
+	}
+	if f, ok := r.publisher.(message.Flusher); ok && len(delivered) > 0 {
+		// nothing is known to be delivered when the buffered events do not
+		// reach the server, they are all published again
+		if err := f.Flush(); err != nil {
+			delivered, failed, perr = nil, rows[0], err
+		}
+	}
+	for _, id := range delivered {
+		_, err := tx.SQL(`
+			UPDATE auth_event_outbox
+			SET delivered_at = now(), attempts = attempts + 1, last_attempt_at = now()
+			WHERE auth_event_outbox_id = $1`, id).
+			Exec()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if failed != nil {
+		_, err := tx.SQL(`
+			UPDATE auth_event_outbox
+			SET attempts = attempts + 1, last_attempt_at = now(), last_error = $2
+			WHERE auth_event_outbox_id = $1`, failed.AuthEventOutboxId, perr.Error()).
+			Exec()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	r.record(len(delivered), perr)
+	return len(delivered), perr
+}
+
+// Stats returns the lag of the relay along with its counters
+func (r *OutboxRelay) Stats() (*OutboxStats, error) {
+	s := &OutboxStats{}
+	err := r.dbh.SQL(`
+		SELECT count(*) pending,
+		CAST(COALESCE(EXTRACT(EPOCH FROM now() - min(created_at)), 0) AS double precision) lag_seconds
+		FROM auth_event_outbox
+		WHERE delivered_at IS NULL`).
+		QueryStruct(s)
+	if err != nil {
+		return s, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.Delivered = r.stats.Delivered
+	s.Failures = r.stats.Failures
+	s.LastError = r.stats.LastError
+	s.LastDeliveredAt = r.stats.LastDeliveredAt
+	return s, nil
+}
+
+// All helper functions
+
+// publish decodes a stored event and publishes it, an event whose message
+// is unknown fails like any other delivery
+func (r *OutboxRelay) publish(row *dbOutboxEvent) error {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(row.EventType))
+	if err != nil {
+		return fmt.Errorf("unknown event %d of type %s %s", row.AuthEventOutboxId, row.EventType, err)
+	}
+	ev := mt.New().Interface()
+	if err := proto.Unmarshal(row.Payload, ev); err != nil {
+		return fmt.Errorf("unable to decode event %d %s", row.AuthEventOutboxId, err)
+	}
+	return r.publisher.Publish(EventSubject(r.prefix, ev), ev)
+}
+
+func (r *OutboxRelay) record(delivered int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if delivered > 0 {
+		now := time.Now()
+		r.stats.Delivered += int64(delivered)
+		r.stats.LastDeliveredAt = &now
+	}
+	if err != nil {
+		r.stats.Failures++
+		r.stats.LastError = err.Error()
+	}
+}
+
+func (r *OutboxRelay) nextBackoff(prev time.Duration) time.Duration {
+	next := prev * 2
+	if next < r.MinBackoff {
+		next = r.MinBackoff
+	}
+	if next > r.MaxBackoff {
+		next = r.MaxBackoff
+	}
+	return next
+}
+
+// -- HTTP handlers
+
+func (r *OutboxRelay) statsHandler(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	s, err := r.Stats()
+	if err != nil {
+		writeHTTPError(w, aphgrpc.HandleError(req.Context(), err))
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// metricsHandler exposes the stats in the prometheus text format
+func (r *OutboxRelay) metricsHandler(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	s, err := r.Stats()
+	if err != nil {
+		writeHTTPError(w, aphgrpc.HandleError(req.Context(), err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"user_event_outbox_pending", "gauge", "Events waiting to be published", float64(s.Pending)},
+		{"user_event_outbox_lag_seconds", "gauge", "Age of the oldest pending event", s.LagSeconds},
+		{"user_event_outbox_delivered_total", "counter", "Events published by the relay", float64(s.Delivered)},
+		{"user_event_outbox_failures_total", "counter", "Failed deliveries of the relay", float64(s.Failures)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
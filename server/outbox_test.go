@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/message/event"
+	"github.com/dictyBase/modware-user/testutils"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+type flakyPublisher struct {
+	down     bool
+	subjects []string
+	events   []interface{}
+}
+
+func (p *flakyPublisher) Publish(subj string, v interface{}) error {
+	if p.down {
+		return errors.New("messaging server is down")
+	}
+	p.subjects = append(p.subjects, subj)
+	p.events = append(p.events, v)
+	return nil
+}
+
+func (p *flakyPublisher) Close() error {
+	return nil
+}
+
+func TestOutboxRelay(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	dbh := runner.NewDB(db, "postgres")
+	ctx := auth.NewContext(context.Background(), &auth.Principal{UserId: 42})
+	ps := NewPermissionService(dbh)
+	for _, res := range []string{"users", "roles"} {
+		if _, err := ps.CreatePermission(ctx, NewPermission("read", res)); err != nil {
+			t.Fatalf("could not create the permission %s\n", err)
+		}
+	}
+
+	pub := &flakyPublisher{down: true}
+	relay := NewOutboxRelay(dbh, pub, "")
+	if n, err := relay.Relay(); err == nil || n != 0 {
+		t.Fatalf("expected the delivery to fail, received %d delivered and %v\n", n, err)
+	}
+	stats, err := relay.Stats()
+	if err != nil {
+		t.Fatalf("could not get the stats %s\n", err)
+	}
+	if stats.Pending != 2 || stats.Failures != 1 || stats.LastError != "messaging server is down" || stats.LagSeconds <= 0 {
+		t.Fatalf("expected two pending events after a failure, received %+v\n", stats)
+	}
+	var attempts int
+	err = dbh.SQL(`
+		SELECT attempts FROM auth_event_outbox
+		ORDER BY auth_event_outbox_id LIMIT 1`).
+		QueryScalar(&attempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected the failed attempt to be recorded, received %d\n", attempts)
+	}
+
+	pub.down = false
+	relay.BatchSize = 1
+	for i, want := range []int{1, 1, 0} {
+		n, err := relay.Relay()
+		if err != nil {
+			t.Fatalf("could not relay the events %s\n", err)
+		}
+		if n != want {
+			t.Fatalf("expected %d events in batch %d, received %d\n", want, i, n)
+		}
+	}
+	if len(pub.events) != 2 || pub.subjects[0] != "UserEvents.PermissionChanged" {
+		t.Fatalf("expected two permission events, received %v\n", pub.subjects)
+	}
+	for i, res := range []string{"users", "roles"} {
+		pc, ok := pub.events[i].(*event.PermissionChanged)
+		if !ok || pc.Permission.Resource != res || pc.Meta.Actor.UserId != 42 {
+			t.Fatalf("expected the permission of %s as event %d, received %v\n", res, i, pub.events[i])
+		}
+	}
+	stats, err = relay.Stats()
+	if err != nil {
+		t.Fatalf("could not get the stats %s\n", err)
+	}
+	if stats.Pending != 0 || stats.Delivered != 2 || stats.LagSeconds != 0 || stats.LastDeliveredAt == nil {
+		t.Fatalf("expected every event to be delivered, received %+v\n", stats)
+	}
+
+	relay.MinBackoff, relay.MaxBackoff = time.Second, 4*time.Second
+	var backoff time.Duration
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if backoff = relay.nextBackoff(backoff); backoff != want {
+			t.Fatalf("expected a backoff of %s, received %s\n", want, backoff)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/dictyBase/apihelpers/aphgrpc"
 	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
 	"github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/rbac"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/golang/protobuf/ptypes/empty"
@@ -42,6 +43,7 @@ type dbPermission struct {
 
 type PermissionService struct {
 	*aphgrpc.Service
+	cache *PermissionCache
 }
 
 func permissionServiceOptions() *aphgrpc.ServiceOptions {
@@ -61,6 +63,7 @@ func permissionServiceOptions() *aphgrpc.ServiceOptions {
 			"updated_at":  fmt.Sprintf("%s.updated_at", permDbTable),
 		},
 		ReqAttrs: []string{"Permission", "Resource"},
+		Include:  []string{"roles", "users"},
 	}
 }
 
@@ -71,7 +74,32 @@ func NewPermissionService(dbh *runner.DB, opt ...aphgrpc.Option) *PermissionServ
 	}
 	srv := &aphgrpc.Service{Dbh: dbh}
 	aphgrpc.AssignFieldsToStructs(so, srv)
-	return &PermissionService{srv}
+	return &PermissionService{Service: srv}
+}
+
+// WithPermissionCache sets the cache of permission grants that is
+// invalidated by the changes made through the service
+func (s *PermissionService) WithPermissionCache(c *PermissionCache) *PermissionService {
+	s.cache = c
+	return s
+}
+
+// HTTPRoutes returns the permission endpoints that are not part of the
+// protocol buffer definitions
+func (s *PermissionService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/permissions", Handler: s.listPermissionsHandler},
+		{Method: "GET", Path: "/permissions/statistics", Handler: s.statisticsHandler},
+		{Method: "GET", Path: "/permissions/catalog", Handler: s.getCatalogHandler},
+		{Method: "POST", Path: "/permissions/catalog", Handler: s.addCatalogEntryHandler},
+		{Method: "GET", Path: "/permissions/catalog/audit", Handler: s.auditCatalogHandler},
+		{Method: "DELETE", Path: "/permissions/catalog/{kind}/{name}", Handler: s.deleteCatalogEntryHandler},
+		{Method: "GET", Path: "/permissions/{id}", Handler: s.getPermissionHandler},
+		{Method: "GET", Path: "/permissions/{id}/roles", Handler: s.relatedRolesHandler},
+		{Method: "GET", Path: "/permissions/{id}/users", Handler: s.relatedUsersHandler},
+		{Method: "GET", Path: "/permissions/{id}/protection", Handler: protectionHandler(s.GetPermissionProtection)},
+		{Method: "PUT", Path: "/permissions/{id}/protection", Handler: setProtectionHandler(s.SetPermissionProtection)},
+	}
 }
 
 func (s *PermissionService) GetPermission(ctx context.Context, r *jsonapi.GetRequestWithFields) (*user.Permission, error) {
@@ -144,12 +172,23 @@ func (s *PermissionService) ListPermissions(ctx context.Context, r *jsonapi.Simp
 }
 
 func (s *PermissionService) CreatePermission(ctx context.Context, r *user.CreatePermissionRequest) (*user.Permission, error) {
+	if err := rbac.ValidatePattern(r.Data.Attributes.Permission, r.Data.Attributes.Resource); err != nil {
+		return &user.Permission{}, aphgrpc.HandleInsertArgError(ctx, err)
+	}
+	if err := checkCatalog(s.Dbh, r.Data.Attributes.Permission, r.Data.Attributes.Resource); err != nil {
+		return &user.Permission{}, err
+	}
 	dbperm := s.attrTodbPermission(r.Data.Attributes)
 	pcolumns := aphgrpc.GetDefinedTags(dbperm, "db")
 	allcolumns := append(permissionCols, "auth_permission_id")
 	newdbPerm := &dbPermission{}
+	tx, au, err := beginAudit(ctx, s.Dbh, permissionRPC("CreatePermission"), "permissions", 0)
+	if err != nil {
+		return &user.Permission{}, err
+	}
+	defer tx.AutoRollback()
 	if len(pcolumns) > 0 {
-		err := s.Dbh.InsertInto(permDbTable).
+		err := tx.InsertInto(permDbTable).
 			Columns(pcolumns...).
 			Record(dbperm).
 			Returning(allcolumns...).
@@ -159,6 +198,9 @@ func (s *PermissionService) CreatePermission(ctx context.Context, r *user.Create
 			return &user.Permission{}, status.Error(codes.Internal, err.Error())
 		}
 	}
+	if err := au.created(aphgrpc.NullToInt64(newdbPerm.AuthPermissionId)).commit(tx); err != nil {
+		return &user.Permission{}, err
+	}
 	grpc.SetTrailer(ctx, metadata.Pairs("method", "POST"))
 	return s.buildResource(
 		context.TODO(),
@@ -178,13 +220,50 @@ func (s *PermissionService) UpdatePermission(ctx context.Context, r *user.Update
 	}
 	dbperm := s.attrTodbPermission(r.Data.Attributes)
 	permMap := aphgrpc.GetDefinedTagsWithValue(dbperm, "db")
+	_, hasPerm := permMap["permission"]
+	_, hasRes := permMap["resource"]
+	if hasPerm || hasRes {
+		prev := &dbPermission{}
+		err := s.Dbh.Select("permission", "resource").From("auth_permission").
+			Where("auth_permission_id = $1", r.Id).QueryStruct(prev)
+		if err != nil {
+			return &user.Permission{}, aphgrpc.HandleError(ctx, err)
+		}
+		next := *prev
+		if hasPerm {
+			next.Permission = r.Data.Attributes.Permission
+		}
+		if hasRes {
+			next.Resource = r.Data.Attributes.Resource
+		}
+		if err := rbac.ValidatePattern(next.Permission, next.Resource); err != nil {
+			return &user.Permission{}, aphgrpc.HandleUpdateArgError(ctx, err)
+		}
+		if err := checkCatalog(s.Dbh, next.Permission, next.Resource); err != nil {
+			return &user.Permission{}, err
+		}
+		if next.Permission != prev.Permission || next.Resource != prev.Resource {
+			if err := permProtection.check(ctx, s.Dbh, r.Id, "renamed"); err != nil {
+				return &user.Permission{}, err
+			}
+		}
+	}
 	if len(permMap) > 0 {
-		_, err := s.Dbh.Update("auth_permission").SetMap(permMap).
+		tx, au, err := beginAudit(ctx, s.Dbh, permissionRPC("UpdatePermission"), "permissions", r.Data.Id)
+		if err != nil {
+			return &user.Permission{}, err
+		}
+		defer tx.AutoRollback()
+		_, err = tx.Update("auth_permission").SetMap(permMap).
 			Where("auth_permission_id = $1", r.Data.Id).Exec()
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
 			return &user.Permission{}, status.Error(codes.Internal, err.Error())
 		}
+		if err := au.commit(tx); err != nil {
+			return &user.Permission{}, err
+		}
+		s.cache.invalidateUsers(s.cache.permissionUsers(s.Dbh, r.Data.Id))
 	}
 	return s.buildResource(context.TODO(), r.Data.Id, r.Data.Attributes), nil
 }
@@ -198,11 +277,25 @@ func (s *PermissionService) DeletePermission(ctx context.Context, r *jsonapi.Del
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
-	_, err = s.Dbh.DeleteFrom("auth_permission").Where("auth_permission_id = $1", r.Id).Exec()
+	if err := permProtection.check(ctx, s.Dbh, r.Id, "deleted"); err != nil {
+		return &empty.Empty{}, err
+	}
+	// the bindings are gone along with the permission
+	users, uerr := s.cache.permissionUsers(s.Dbh, r.Id)
+	tx, au, err := beginAudit(ctx, s.Dbh, permissionRPC("DeletePermission"), "permissions", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	_, err = tx.DeleteFrom("auth_permission").Where("auth_permission_id = $1", r.Id).Exec()
 	if err != nil {
 		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
 		return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 	}
+	if err := au.commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidateUsers(users, uerr)
 	return &empty.Empty{}, nil
 }
 
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dictyBase/modware-user/cache"
+	"github.com/dictyBase/modware-user/message"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// PermissionCache keeps the permission grants of the users that were
+// recently checked, so that a permission check does not have to query the
+// role assignments and bindings every time. The role, permission and user
+// services drop the grants of the affected users whenever they change an
+// assignment or binding, and broadcast the change so that the caches of the
+// other replicas and services are invalidated as well.
+//
+// A nil cache does nothing, a cache without capacity only broadcasts the
+// changes, which is what the role and permission services need.
+type PermissionCache struct {
+	lru       *cache.LRU
+	publisher message.Publisher
+	// origin identifies the replica, the broadcasts sent by it are ignored
+	// when they come back
+	origin string
+}
+
+// PermissionCacheStats are the counters of the cache
+type PermissionCacheStats struct {
+	*cache.Stats
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// NewPermissionCache creates a cache holding the grants of up to size user
+// and scope combinations for the given duration. The invalidations are
+// broadcast through the publisher.
+func NewPermissionCache(size int, ttl time.Duration, pub message.Publisher) *PermissionCache {
+	host, _ := os.Hostname()
+	return &PermissionCache{
+		lru:       cache.New(size, ttl),
+		publisher: pub,
+		origin:    fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+// Stats returns the counters of the cache
+func (c *PermissionCache) Stats() *PermissionCacheStats {
+	if c == nil {
+		return &PermissionCacheStats{Stats: &cache.Stats{}}
+	}
+	s := c.lru.Stats()
+	return &PermissionCacheStats{Stats: s, HitRatio: s.HitRatio()}
+}
+
+// HandleInvalidation applies an invalidation broadcast by another replica,
+// it is meant to be subscribed to message.CacheInvalidationSubject
+func (c *PermissionCache) HandleInvalidation(data []byte) {
+	if c == nil {
+		return
+	}
+	inv := &message.CacheInvalidation{}
+	if err := json.Unmarshal(data, inv); err != nil || inv.Origin == c.origin {
+		return
+	}
+	if inv.All {
+		c.lru.Purge()
+		return
+	}
+	c.lru.RemoveGroups(inv.Users...)
+}
+
+// invalidate drops the grants of the users and broadcasts it. A failed
+// broadcast leaves the other replicas with stale grants until they expire.
+func (c *PermissionCache) invalidate(ids ...int64) {
+	if c == nil || len(ids) == 0 {
+		return
+	}
+	c.lru.RemoveGroups(ids...)
+	c.publisher.Publish(message.CacheInvalidationSubject, &message.CacheInvalidation{
+		Origin: c.origin,
+		Users:  ids,
+	})
+}
+
+// invalidateAll drops every cached grant and broadcasts it
+func (c *PermissionCache) invalidateAll() {
+	if c == nil {
+		return
+	}
+	c.lru.Purge()
+	c.publisher.Publish(message.CacheInvalidationSubject, &message.CacheInvalidation{
+		Origin: c.origin,
+		All:    true,
+	})
+}
+
+// invalidateUsers drops the grants of the users found by roleUsers or
+// permissionUsers, every grant is dropped when they could not be found
+func (c *PermissionCache) invalidateUsers(ids []int64, err error) {
+	if err != nil {
+		c.invalidateAll()
+		return
+	}
+	c.invalidate(ids...)
+}
+
+// roleUsers returns the users and the groups of the service accounts
+// holding the role in any scope, whose grants change along with the role.
+// Nothing is queried without a cache.
+func (c *PermissionCache) roleUsers(conn runner.Connection, roleId int64) ([]int64, error) {
+	var ids []int64
+	if c == nil {
+		return ids, nil
+	}
+	err := conn.SQL(`
+		SELECT auth_user_id FROM auth_user_role WHERE auth_role_id = $1
+		UNION
+		SELECT -auth_service_account_id FROM auth_service_account_role WHERE auth_role_id = $1`,
+		roleId,
+	).QuerySlice(&ids)
+	return ids, err
+}
+
+// permissionUsers returns the users and the groups of the service accounts
+// holding any role the permission is bound to. Nothing is queried without
+// a cache.
+func (c *PermissionCache) permissionUsers(conn runner.Connection, permId int64) ([]int64, error) {
+	var ids []int64
+	if c == nil {
+		return ids, nil
+	}
+	err := conn.SQL(
+		fmt.Sprintf(`
+			SELECT auth_user_id FROM auth_user WHERE %s
+			UNION
+			SELECT -sarole.auth_service_account_id
+			FROM auth_service_account_role sarole
+			JOIN auth_role_permission
+			ON auth_role_permission.auth_role_id = sarole.auth_role_id
+			WHERE auth_role_permission.auth_permission_id = $1`,
+			permUsersClause,
+		),
+		permId,
+	).QuerySlice(&ids)
+	return ids, err
+}
+
+// serviceAccountGroup is the cache group of the grants of a service
+// account, the negated id keeps it apart from the groups of the users
+func serviceAccountGroup(id int64) int64 {
+	return -id
+}
+
+// grants returns the grants of the user for the scope of the check, they
+// are loaded from the database when they are not cached
+func (c *PermissionCache) grants(conn runner.Connection, r *PermissionCheck) ([]*dbRoleGrant, error) {
+	if c == nil {
+		return loadGrants(conn, r)
+	}
+	group := r.UserId
+	if r.ServiceAccountId > 0 {
+		group = serviceAccountGroup(r.ServiceAccountId)
+	}
+	key := fmt.Sprintf("%d|", group)
+	if len(r.ResourceId) > 0 {
+		key = fmt.Sprintf("%d|%s|%s", group, r.Resource, r.ResourceId)
+	}
+	if v, ok := c.lru.Get(key); ok {
+		return v.([]*dbRoleGrant), nil
+	}
+	tok := c.lru.Begin()
+	dbrows, err := loadGrants(conn, r)
+	if err != nil {
+		return nil, err
+	}
+	c.lru.Add(tok, group, key, dbrows)
+	return dbrows, nil
+}
+
+// -- HTTP handlers
+
+func (s *UserService) permissionCacheStatsHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	writeJSON(w, http.StatusOK, s.cache.Stats())
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/message"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestPermissionCache(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("write", "pages/*"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("curator", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(
+		context.Background(),
+		NewUserWithRole("curator@gmail.com", role),
+	)
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	pc := NewPermissionCache(10, time.Minute, message.NewNullPublisher())
+	dbh := runner.NewDB(db, "postgres")
+	s := NewUserService(dbh).WithPermissionCache(pc)
+	rs := NewRoleService(dbh).WithPermissionCache(pc)
+	check := &PermissionCheck{UserId: usr.Data.Id, Permission: "write", Resource: "pages/front"}
+	for i := 0; i < 2; i++ {
+		d, err := s.CheckPermission(context.Background(), check)
+		if err != nil {
+			t.Fatalf("could not check the permission %s\n", err)
+		}
+		if !d.Allowed {
+			t.Fatalf("expected write on pages/front to be allowed, received %+v\n", d)
+		}
+	}
+	if st := pc.Stats(); st.Hits != 1 || st.Misses != 1 || st.Size != 1 {
+		t.Fatalf("expected a miss followed by a hit, received %+v\n", st.Stats)
+	}
+
+	if _, err := rs.SetPermissionEffect(context.Background(), &GrantEffect{
+		RoleId:       role.Data.Id,
+		PermissionId: perm.Data.Id,
+		Effect:       "deny",
+	}); err != nil {
+		t.Fatalf("could not change the effect %s\n", err)
+	}
+	if st := pc.Stats(); st.Invalidations != 1 || st.Size != 0 {
+		t.Fatalf("expected the grants of the user to be invalidated, received %+v\n", st.Stats)
+	}
+	d, err := s.CheckPermission(context.Background(), check)
+	if err != nil {
+		t.Fatalf("could not check the permission %s\n", err)
+	}
+	if d.Allowed {
+		t.Fatalf("expected write on pages/front to be denied, received %+v\n", d)
+	}
+
+	// an invalidation broadcast by another replica
+	data, err := json.Marshal(&message.CacheInvalidation{Origin: "replica", Users: []int64{usr.Data.Id}})
+	if err != nil {
+		t.Fatalf("could not encode the invalidation %s\n", err)
+	}
+	pc.HandleInvalidation(data)
+	if st := pc.Stats(); st.Invalidations != 2 || st.Size != 0 {
+		t.Fatalf("expected the broadcast to invalidate the grants, received %+v\n", st.Stats)
+	}
+}
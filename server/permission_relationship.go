@@ -0,0 +1,538 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RelatedRoleCollection is a page of the roles bound to a permission. The
+// protocol buffer definition of a role collection has no pagination
+// metadata.
+type RelatedRoleCollection struct {
+	Data  []*user.RoleData
+	Links *jsonapi.PaginationLinks
+	Meta  *jsonapi.Meta
+}
+
+// PermissionRelationships links a permission to the roles it is bound to
+// and to the users holding those roles
+type PermissionRelationships struct {
+	Roles *user.ExistingUserRelationships_Roles
+	Users *user.ExistingRoleRelationships_Users
+}
+
+// PermissionResource is a permission along with its relationships, which
+// the protocol buffer definition of a permission lacks
+type PermissionResource struct {
+	*user.PermissionData
+	Relationships *PermissionRelationships
+}
+
+// PermissionDocument is a permission with its included roles and users
+type PermissionDocument struct {
+	Data     *PermissionResource
+	Links    *jsonapi.Links
+	Included []proto.Message
+}
+
+// PermissionCollectionDocument is a list of permissions with their
+// included roles and users
+type PermissionCollectionDocument struct {
+	Data     []*PermissionResource
+	Links    *jsonapi.Links
+	Included []proto.Message
+}
+
+// GetRelatedRoles returns a page of the roles the permission is bound to
+func (s *PermissionService) GetRelatedRoles(ctx context.Context, r *jsonapi.RelationshipRequestWithPagination) (*RelatedRoleCollection, error) {
+	if err := s.checkRelated(ctx, r.Id); err != nil {
+		return &RelatedRoleCollection{}, err
+	}
+	var count int64
+	err := s.Dbh.Select("COUNT(*)").
+		From("auth_role_permission").
+		Where("auth_permission_id = $1", r.Id).
+		QueryScalar(&count)
+	if err != nil {
+		return &RelatedRoleCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	pagenum, pagesize := relatedPageParams(r)
+	rdata, err := s.getRoleResourceData(r.Id, pagenum, pagesize)
+	if err != nil {
+		return &RelatedRoleCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	pageLinks, pages := s.GetRelatedPagination(r.Id, count, pagenum, pagesize, "roles")
+	return &RelatedRoleCollection{
+		Data:  rdata,
+		Links: pageLinks,
+		Meta:  relatedPageMeta(count, pages, pagenum, pagesize),
+	}, nil
+}
+
+// GetRelatedUsers returns a page of the users holding any role the
+// permission is bound to, in any scope
+func (s *PermissionService) GetRelatedUsers(ctx context.Context, r *jsonapi.RelationshipRequestWithPagination) (*user.UserCollection, error) {
+	if err := s.checkRelated(ctx, r.Id); err != nil {
+		return &user.UserCollection{}, err
+	}
+	var count int64
+	err := s.Dbh.SQL(
+		fmt.Sprintf("SELECT COUNT(*) FROM auth_user WHERE %s", permUsersClause),
+		r.Id,
+	).QueryScalar(&count)
+	if err != nil {
+		return &user.UserCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	pagenum, pagesize := relatedPageParams(r)
+	udata, err := s.getUserResourceData(r.Id, pagenum, pagesize)
+	if err != nil {
+		return &user.UserCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	pageLinks, pages := s.GetRelatedPagination(r.Id, count, pagenum, pagesize, "users")
+	return &user.UserCollection{
+		Data:  udata,
+		Links: pageLinks,
+		Meta:  relatedPageMeta(count, pages, pagenum, pagesize),
+	}, nil
+}
+
+// GetPermissionWithIncludes is GetPermission with support for the include
+// parameter
+func (s *PermissionService) GetPermissionWithIncludes(ctx context.Context, r *jsonapi.GetRequest) (*PermissionDocument, error) {
+	params, md, err := aphgrpc.ValidateAndParseGetParams(s, r)
+	if err != nil {
+		grpc.SetTrailer(ctx, md)
+		return &PermissionDocument{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	perm, err := s.GetPermission(ctx, &jsonapi.GetRequestWithFields{Id: r.Id, Fields: r.Fields})
+	if err != nil {
+		return &PermissionDocument{}, err
+	}
+	doc := &PermissionDocument{
+		Data:  s.buildRelatedResource(perm.Data),
+		Links: perm.Links,
+	}
+	if params.HasInclude {
+		inc, err := s.includeRelated(params.Includes, doc.Data)
+		if err != nil {
+			return &PermissionDocument{}, aphgrpc.HandleError(ctx, err)
+		}
+		doc.Included = inc
+	}
+	return doc, nil
+}
+
+// ListPermissionsWithIncludes is ListPermissions with support for the
+// include parameter
+func (s *PermissionService) ListPermissionsWithIncludes(ctx context.Context, r *jsonapi.SimpleListRequest) (*PermissionCollectionDocument, error) {
+	params, md, err := aphgrpc.ValidateAndParseSimpleListParams(s, r)
+	if err != nil {
+		grpc.SetTrailer(ctx, md)
+		return &PermissionCollectionDocument{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	coll, err := s.ListPermissions(ctx, r)
+	if err != nil {
+		return &PermissionCollectionDocument{}, err
+	}
+	doc := &PermissionCollectionDocument{Links: coll.Links}
+	for _, pd := range coll.Data {
+		doc.Data = append(doc.Data, s.buildRelatedResource(pd))
+	}
+	if params.HasInclude {
+		seen := make(map[string]bool)
+		for _, pr := range doc.Data {
+			inc, err := s.includeRelated(params.Includes, pr)
+			if err != nil {
+				return &PermissionCollectionDocument{}, aphgrpc.HandleError(ctx, err)
+			}
+			// a role or user related to more than one permission is
+			// included only once
+			for _, m := range inc {
+				key := includedKey(m)
+				if !seen[key] {
+					seen[key] = true
+					doc.Included = append(doc.Included, m)
+				}
+			}
+		}
+	}
+	return doc, nil
+}
+
+// permUsersClause matches the users holding any role bound to the
+// permission
+const permUsersClause = `auth_user.auth_user_id IN (
+	SELECT auth_user_role.auth_user_id
+	FROM auth_user_role
+	JOIN auth_role_permission
+	ON auth_role_permission.auth_role_id = auth_user_role.auth_role_id
+	WHERE auth_role_permission.auth_permission_id = $1
+)`
+
+func (s *PermissionService) checkRelated(ctx context.Context, id int64) error {
+	exists, err := s.existsResource(id)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("permission id %d not found", id))
+	}
+	return nil
+}
+
+// getRoleResourceData returns the roles bound to the permission, a zero
+// pagesize returns all of them
+func (s *PermissionService) getRoleResourceData(id, pagenum, pagesize int64) ([]*user.RoleData, error) {
+	var dbrows []*dbRole
+	err := s.Dbh.SQL(
+		fmt.Sprintf(`
+			SELECT role.*
+			FROM auth_role role
+			JOIN auth_role_permission
+			ON auth_role_permission.auth_role_id = role.auth_role_id
+			WHERE auth_role_permission.auth_permission_id = $1
+			ORDER BY role.auth_role_id
+			%s`, pageClause(pagenum, pagesize)),
+		id,
+	).QueryStructs(&dbrows)
+	if err != nil {
+		return nil, err
+	}
+	return NewRoleService(s.Dbh).dbToCollResourceData(context.TODO(), dbrows), nil
+}
+
+// getUserResourceData returns the users holding any role bound to the
+// permission, a zero pagesize returns all of them
+func (s *PermissionService) getUserResourceData(id, pagenum, pagesize int64) ([]*user.UserData, error) {
+	var dbrows []*dbUser
+	err := s.Dbh.SQL(
+		fmt.Sprintf(`
+			SELECT auth_user.auth_user_id,
+				CAST(auth_user.email AS TEXT),
+				auth_user.first_name,
+				auth_user.last_name,
+				auth_user.is_active,
+				auth_user.created_at,
+				auth_user.updated_at,
+				auth_user_info.*
+			FROM auth_user
+			JOIN auth_user_info
+			ON auth_user_info.auth_user_id = auth_user.auth_user_id
+			WHERE %s
+			ORDER BY auth_user.auth_user_id
+			%s`, permUsersClause, pageClause(pagenum, pagesize)),
+		id,
+	).QueryStructs(&dbrows)
+	if err != nil {
+		return nil, err
+	}
+	return NewUserService(s.Dbh).dbToCollResourceData(context.TODO(), dbrows), nil
+}
+
+func (s *PermissionService) buildRelatedResource(pd *user.PermissionData) *PermissionResource {
+	return &PermissionResource{
+		PermissionData: pd,
+		Relationships: &PermissionRelationships{
+			Roles: &user.ExistingUserRelationships_Roles{
+				Links: &jsonapi.Links{
+					Self:    aphgrpc.GenSelfRelationshipLink(s, "roles", pd.Id),
+					Related: aphgrpc.GenRelatedRelationshipLink(s, "roles", pd.Id),
+				},
+			},
+			Users: &user.ExistingRoleRelationships_Users{
+				Links: &jsonapi.Links{
+					Self:    aphgrpc.GenSelfRelationshipLink(s, "users", pd.Id),
+					Related: aphgrpc.GenRelatedRelationshipLink(s, "users", pd.Id),
+				},
+			},
+		},
+	}
+}
+
+// includeRelated fills in the resource identifiers of the included
+// relationships and returns the related resources
+func (s *PermissionService) includeRelated(includes []string, pr *PermissionResource) ([]proto.Message, error) {
+	var inc []proto.Message
+	for _, i := range includes {
+		switch i {
+		case "roles":
+			roles, err := s.getRoleResourceData(pr.Id, 0, 0)
+			if err != nil {
+				return inc, err
+			}
+			pr.Relationships.Roles.Data = make([]*jsonapi.Data, 0)
+			for _, r := range roles {
+				pr.Relationships.Roles.Data = append(
+					pr.Relationships.Roles.Data,
+					&jsonapi.Data{Type: r.Type, Id: r.Id},
+				)
+				inc = append(inc, r)
+			}
+		case "users":
+			users, err := s.getUserResourceData(pr.Id, 0, 0)
+			if err != nil {
+				return inc, err
+			}
+			pr.Relationships.Users.Data = make([]*jsonapi.Data, 0)
+			for _, u := range users {
+				pr.Relationships.Users.Data = append(
+					pr.Relationships.Users.Data,
+					&jsonapi.Data{Type: u.Type, Id: u.Id},
+				)
+				inc = append(inc, u)
+			}
+		}
+	}
+	return inc, nil
+}
+
+func includedKey(m proto.Message) string {
+	switch d := m.(type) {
+	case *user.RoleData:
+		return fmt.Sprintf("%s:%d", d.Type, d.Id)
+	case *user.UserData:
+		return fmt.Sprintf("%s:%d", d.Type, d.Id)
+	default:
+		return m.String()
+	}
+}
+
+func relatedPageParams(r *jsonapi.RelationshipRequestWithPagination) (int64, int64) {
+	pagenum, pagesize := r.Pagenum, r.Pagesize
+	if pagenum == 0 {
+		pagenum = aphgrpc.DefaultPagenum
+	}
+	if pagesize == 0 {
+		pagesize = aphgrpc.DefaultPagesize
+	}
+	return pagenum, pagesize
+}
+
+func relatedPageMeta(count, pages, pagenum, pagesize int64) *jsonapi.Meta {
+	return &jsonapi.Meta{
+		Pagination: &jsonapi.Pagination{
+			Records: count,
+			Total:   pages,
+			Size:    pagesize,
+			Number:  pagenum,
+		},
+	}
+}
+
+func pageClause(pagenum, pagesize int64) string {
+	if pagesize == 0 {
+		return ""
+	}
+	return fmt.Sprintf("LIMIT %d OFFSET %d", pagesize, (pagenum-1)*pagesize)
+}
+
+// -- JSON encoding
+
+// protoMarshaler encodes the protocol buffer parts of the documents in the
+// same way as the grpc gateway
+var protoMarshaler = &runtime.JSONPb{OrigName: true}
+
+func marshalProto(m proto.Message) (json.RawMessage, error) {
+	b, err := protoMarshaler.Marshal(m)
+	return json.RawMessage(b), err
+}
+
+func marshalProtos(ms []proto.Message) ([]json.RawMessage, error) {
+	raws := make([]json.RawMessage, 0)
+	for _, m := range ms {
+		b, err := marshalProto(m)
+		if err != nil {
+			return raws, err
+		}
+		raws = append(raws, b)
+	}
+	return raws, nil
+}
+
+// MarshalJSON adds the relationships to the attributes and links of the
+// permission
+func (pr *PermissionResource) MarshalJSON() ([]byte, error) {
+	b, err := marshalProto(pr.PermissionData)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	rels := make(map[string]json.RawMessage)
+	if pr.Relationships.Roles != nil {
+		if rels["roles"], err = marshalProto(pr.Relationships.Roles); err != nil {
+			return nil, err
+		}
+	}
+	if pr.Relationships.Users != nil {
+		if rels["users"], err = marshalProto(pr.Relationships.Users); err != nil {
+			return nil, err
+		}
+	}
+	if doc["relationships"], err = json.Marshal(rels); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func (d *PermissionDocument) MarshalJSON() ([]byte, error) {
+	links, err := marshalProto(d.Links)
+	if err != nil {
+		return nil, err
+	}
+	inc, err := marshalProtos(d.Included)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		Data     *PermissionResource `json:"data"`
+		Links    json.RawMessage     `json:"links"`
+		Included []json.RawMessage   `json:"included,omitempty"`
+	}{d.Data, links, inc})
+}
+
+func (d *PermissionCollectionDocument) MarshalJSON() ([]byte, error) {
+	links, err := marshalProto(d.Links)
+	if err != nil {
+		return nil, err
+	}
+	inc, err := marshalProtos(d.Included)
+	if err != nil {
+		return nil, err
+	}
+	data := d.Data
+	if data == nil {
+		data = make([]*PermissionResource, 0)
+	}
+	return json.Marshal(&struct {
+		Data     []*PermissionResource `json:"data"`
+		Links    json.RawMessage       `json:"links"`
+		Included []json.RawMessage     `json:"included,omitempty"`
+	}{data, links, inc})
+}
+
+func (c *RelatedRoleCollection) MarshalJSON() ([]byte, error) {
+	data := make([]proto.Message, 0)
+	for _, r := range c.Data {
+		data = append(data, r)
+	}
+	raws, err := marshalProtos(data)
+	if err != nil {
+		return nil, err
+	}
+	links, err := marshalProto(c.Links)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := marshalProto(c.Meta)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		Data  []json.RawMessage `json:"data"`
+		Links json.RawMessage   `json:"links"`
+		Meta  json.RawMessage   `json:"meta"`
+	}{raws, links, meta})
+}
+
+// -- HTTP handlers
+
+func (s *PermissionService) getPermissionHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	doc, err := s.GetPermissionWithIncludes(r.Context(), &jsonapi.GetRequest{
+		Id:      id,
+		Include: q.Get("include"),
+		Fields:  q.Get("fields"),
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func (s *PermissionService) listPermissionsHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	q := r.URL.Query()
+	doc, err := s.ListPermissionsWithIncludes(r.Context(), &jsonapi.SimpleListRequest{
+		Include: q.Get("include"),
+		Fields:  q.Get("fields"),
+		Filter:  q.Get("filter"),
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func (s *PermissionService) relatedRolesHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	req, err := relatedRequest(r, params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	coll, err := s.GetRelatedRoles(r.Context(), req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *PermissionService) relatedUsersHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	req, err := relatedRequest(r, params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	coll, err := s.GetRelatedUsers(r.Context(), req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	b, err := marshalProto(coll)
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+func relatedRequest(r *http.Request, params map[string]string) (*jsonapi.RelationshipRequestWithPagination, error) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		return nil, err
+	}
+	req := &jsonapi.RelationshipRequestWithPagination{Id: id}
+	for name, v := range map[string]*int64{"pagenum": &req.Pagenum, "pagesize": &req.Pagesize} {
+		q := r.URL.Query().Get(name)
+		if len(q) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(q, 10, 64)
+		if err != nil || n < 1 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s %s", name, q)
+		}
+		*v = n
+	}
+	return req, nil
+}
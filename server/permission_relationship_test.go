@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestPermissionRelationships(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("delete", "users"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	rclient := pb.NewRoleServiceClient(conn)
+	var roles []*pb.Role
+	for _, name := range []string{"admin", "user-manager"} {
+		role, err := rclient.CreateRole(context.Background(), NewRoleWithPermission(name, perm))
+		if err != nil {
+			t.Fatalf("could not store the role %s\n", err)
+		}
+		roles = append(roles, role)
+	}
+	uclient := pb.NewUserServiceClient(conn)
+	for _, email := range []string{"admin@gmail.com", "manager@gmail.com", "both@gmail.com"} {
+		role := roles[0]
+		if email == "manager@gmail.com" {
+			role = roles[1]
+		}
+		usr, err := uclient.CreateUser(context.Background(), NewUserWithRole(email, role))
+		if err != nil {
+			t.Fatalf("could not store the user %s\n", err)
+		}
+		if email == "both@gmail.com" {
+			_, err := uclient.CreateRoleRelationship(
+				context.Background(),
+				&jsonapi.DataCollection{Id: usr.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: roles[1].Data.Id}}},
+			)
+			if err != nil {
+				t.Fatalf("could not create the relationship with role %s\n", err)
+			}
+		}
+	}
+	if _, err := uclient.CreateUser(context.Background(), NewUser("nobody@gmail.com")); err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+
+	s := NewPermissionService(runner.NewDB(db, "postgres"))
+	rcoll, err := s.GetRelatedRoles(
+		context.Background(),
+		&jsonapi.RelationshipRequestWithPagination{Id: perm.Data.Id, Pagenum: 1, Pagesize: 1},
+	)
+	if err != nil {
+		t.Fatalf("could not fetch the related roles %s\n", err)
+	}
+	if len(rcoll.Data) != 1 || rcoll.Meta.Pagination.Records != 2 || rcoll.Meta.Pagination.Total != 2 {
+		t.Fatalf("expected first page of two roles, received %d roles and %+v\n", len(rcoll.Data), rcoll.Meta.Pagination)
+	}
+	ucoll, err := s.GetRelatedUsers(
+		context.Background(),
+		&jsonapi.RelationshipRequestWithPagination{Id: perm.Data.Id},
+	)
+	if err != nil {
+		t.Fatalf("could not fetch the related users %s\n", err)
+	}
+	if len(ucoll.Data) != 3 || ucoll.Meta.Pagination.Records != 3 {
+		t.Fatalf("expected three distinct users, received %d\n", len(ucoll.Data))
+	}
+	_, err = s.GetRelatedUsers(
+		context.Background(),
+		&jsonapi.RelationshipRequestWithPagination{Id: perm.Data.Id + 100},
+	)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound for missing permission, received %s\n", err)
+	}
+
+	doc, err := s.GetPermissionWithIncludes(
+		context.Background(),
+		&jsonapi.GetRequest{Id: perm.Data.Id, Include: "roles,users"},
+	)
+	if err != nil {
+		t.Fatalf("could not fetch the permission with includes %s\n", err)
+	}
+	if len(doc.Data.Relationships.Roles.Data) != 2 || len(doc.Data.Relationships.Users.Data) != 3 {
+		t.Fatalf(
+			"expected two roles and three users, received %d and %d\n",
+			len(doc.Data.Relationships.Roles.Data),
+			len(doc.Data.Relationships.Users.Data),
+		)
+	}
+	if len(doc.Included) != 5 {
+		t.Fatalf("expected five included resources, received %d\n", len(doc.Included))
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("could not encode the document %s\n", err)
+	}
+	var out struct {
+		Data struct {
+			Attributes    map[string]interface{} `json:"attributes"`
+			Relationships map[string]interface{} `json:"relationships"`
+		} `json:"data"`
+		Included []interface{} `json:"included"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatalf("could not decode the document %s\n", err)
+	}
+	if out.Data.Attributes["permission"] != "delete" {
+		t.Fatalf("expected permission attribute delete, received %v\n", out.Data.Attributes)
+	}
+	if _, ok := out.Data.Relationships["users"]; !ok || len(out.Included) != 5 {
+		t.Fatalf("expected relationships and included in the document, received %s\n", b)
+	}
+
+	_, err = s.GetPermissionWithIncludes(
+		context.Background(),
+		&jsonapi.GetRequest{Id: perm.Data.Id, Include: "groups"},
+	)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for unknown include, received %s\n", err)
+	}
+	ldoc, err := s.ListPermissionsWithIncludes(
+		context.Background(),
+		&jsonapi.SimpleListRequest{Include: "roles"},
+	)
+	if err != nil {
+		t.Fatalf("could not list the permissions with includes %s\n", err)
+	}
+	if len(ldoc.Data) != 1 || len(ldoc.Included) != 2 {
+		t.Fatalf("expected one permission with two roles, received %d and %d\n", len(ldoc.Data), len(ldoc.Included))
+	}
+}
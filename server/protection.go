@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// Protection marks a role or permission as a system one that cannot be
+// renamed or deleted through the API
+type Protection struct {
+	Id        int64  `json:"-"`
+	Protected bool   `json:"protected"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// protectedTable describes where the protection of a resource is stored
+type protectedTable struct {
+	table  string
+	column string
+	kind   string
+}
+
+var (
+	roleProtection = &protectedTable{
+		table:  "auth_role_protected",
+		column: "auth_role_id",
+		kind:   "role",
+	}
+	permProtection = &protectedTable{
+		table:  "auth_permission_protected",
+		column: "auth_permission_id",
+		kind:   "permission",
+	}
+)
+
+func (p *protectedTable) get(conn runner.Connection, id int64) (*Protection, error) {
+	var reasons []dat.NullString
+	err := conn.Select("reason").
+		From(p.table).
+		Where(fmt.Sprintf("%s = $1", p.column), id).
+		QuerySlice(&reasons)
+	if err != nil {
+		return nil, err
+	}
+	pr := &Protection{Id: id}
+	if len(reasons) > 0 {
+		pr.Protected = true
+		pr.Reason = aphgrpc.NullToString(reasons[0])
+	}
+	return pr, nil
+}
+
+func (p *protectedTable) set(conn runner.Connection, pr *Protection) error {
+	_, err := conn.DeleteFrom(p.table).
+		Where(fmt.Sprintf("%s = $1", p.column), pr.Id).
+		Exec()
+	if err != nil || !pr.Protected {
+		return err
+	}
+	_, err = conn.InsertInto(p.table).
+		Columns(p.column, "reason").
+		Values(pr.Id, dat.NullStringFrom(pr.Reason)).
+		Exec()
+	return err
+}
+
+// check returns a FailedPrecondition error if the resource is protected
+func (p *protectedTable) check(ctx context.Context, conn runner.Connection, id int64, action string) error {
+	pr, err := p.get(conn, id)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if pr.Protected {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"%s %d is protected and cannot be %s", p.kind, id, action,
+		)
+	}
+	return nil
+}
+
+func (s *RoleService) GetRoleProtection(ctx context.Context, id int64) (*Protection, error) {
+	exists, err := s.existsResource(id)
+	if err != nil {
+		return &Protection{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return &Protection{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", id))
+	}
+	pr, err := roleProtection.get(s.Dbh, id)
+	if err != nil {
+		return &Protection{}, aphgrpc.HandleError(ctx, err)
+	}
+	return pr, nil
+}
+
+// SetRoleProtection marks or unmarks a role as protected
+func (s *RoleService) SetRoleProtection(ctx context.Context, r *Protection) (*Protection, error) {
+	if _, err := s.GetRoleProtection(ctx, r.Id); err != nil {
+		return &Protection{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("SetRoleProtection"), "roles", r.Id)
+	if err != nil {
+		return &Protection{}, err
+	}
+	defer tx.AutoRollback()
+	if err := roleProtection.set(tx, r); err != nil {
+		return &Protection{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	if err := au.commit(tx); err != nil {
+		return &Protection{}, err
+	}
+	return s.GetRoleProtection(ctx, r.Id)
+}
+
+func (s *PermissionService) GetPermissionProtection(ctx context.Context, id int64) (*Protection, error) {
+	exists, err := s.existsResource(id)
+	if err != nil {
+		return &Protection{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return &Protection{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("permission id %d not found", id))
+	}
+	pr, err := permProtection.get(s.Dbh, id)
+	if err != nil {
+		return &Protection{}, aphgrpc.HandleError(ctx, err)
+	}
+	return pr, nil
+}
+
+// SetPermissionProtection marks or unmarks a permission as protected
+func (s *PermissionService) SetPermissionProtection(ctx context.Context, r *Protection) (*Protection, error) {
+	if _, err := s.GetPermissionProtection(ctx, r.Id); err != nil {
+		return &Protection{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, permissionRPC("SetPermissionProtection"), "permissions", r.Id)
+	if err != nil {
+		return &Protection{}, err
+	}
+	defer tx.AutoRollback()
+	if err := permProtection.set(tx, r); err != nil {
+		return &Protection{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	if err := au.commit(tx); err != nil {
+		return &Protection{}, err
+	}
+	return s.GetPermissionProtection(ctx, r.Id)
+}
+
+// -- HTTP handlers
+
+func protectionHandler(fn func(context.Context, int64) (*Protection, error)) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		id, err := pathParamToID(params, "id")
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		pr, err := fn(r.Context(), id)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pr)
+	}
+}
+
+func setProtectionHandler(fn func(context.Context, *Protection) (*Protection, error)) func(http.ResponseWriter, *http.Request, map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		id, err := pathParamToID(params, "id")
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		pr := &Protection{}
+		if err := readJSON(r, pr); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		pr.Id = id
+		pr, err = fn(r.Context(), pr)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pr)
+	}
+}
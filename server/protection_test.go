@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestRoleProtection(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewRoleServiceClient(conn)
+	nrole, err := client.CreateRole(context.Background(), NewRole("superuser"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	s := NewRoleService(runner.NewDB(db, "postgres"))
+	pr, err := s.SetRoleProtection(
+		context.Background(),
+		&Protection{Id: nrole.Data.Id, Protected: true, Reason: "system role"},
+	)
+	if err != nil {
+		t.Fatalf("could not protect the role %s\n", err)
+	}
+	if !pr.Protected || pr.Reason != "system role" {
+		t.Fatalf("expected protected role, received %+v\n", pr)
+	}
+	_, err = client.UpdateRole(context.Background(), &pb.UpdateRoleRequest{
+		Id: nrole.Data.Id,
+		Data: &pb.UpdateRoleRequest_Data{
+			Type:       nrole.Data.Type,
+			Id:         nrole.Data.Id,
+			Attributes: &pb.RoleAttributes{Role: "root", Description: "renamed"},
+		},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for rename, received %s\n", err)
+	}
+	_, err = client.UpdateRole(context.Background(), &pb.UpdateRoleRequest{
+		Id: nrole.Data.Id,
+		Data: &pb.UpdateRoleRequest_Data{
+			Type:       nrole.Data.Type,
+			Id:         nrole.Data.Id,
+			Attributes: &pb.RoleAttributes{Role: "superuser", Description: "all access"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected description update of protected role, received %s\n", err)
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(DeleteForceKey, "true"))
+	_, err = client.DeleteRole(ctx, &jsonapi.DeleteRequest{Id: nrole.Data.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for deleting protected role, received %s\n", err)
+	}
+	_, err = s.SetRoleProtection(context.Background(), &Protection{Id: nrole.Data.Id})
+	if err != nil {
+		t.Fatalf("could not unprotect the role %s\n", err)
+	}
+	_, err = client.DeleteRole(context.Background(), &jsonapi.DeleteRequest{Id: nrole.Data.Id})
+	if err != nil {
+		t.Fatalf("could not delete the unprotected role %s\n", err)
+	}
+}
+
+func TestPermissionProtection(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewPermissionServiceClient(conn)
+	nperm, err := client.CreatePermission(context.Background(), NewPermission("admin", "dictybase"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	s := NewPermissionService(runner.NewDB(db, "postgres"))
+	if _, err := s.SetPermissionProtection(
+		context.Background(),
+		&Protection{Id: nperm.Data.Id, Protected: true},
+	); err != nil {
+		t.Fatalf("could not protect the permission %s\n", err)
+	}
+	_, err = client.UpdatePermission(context.Background(), &pb.UpdatePermissionRequest{
+		Id: nperm.Data.Id,
+		Data: &pb.UpdatePermissionRequest_Data{
+			Type:       nperm.Data.Type,
+			Id:         nperm.Data.Id,
+			Attributes: &pb.PermissionAttributes{Permission: "write", Resource: "dictybase"},
+		},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for rename, received %s\n", err)
+	}
+	_, err = client.DeletePermission(context.Background(), &jsonapi.DeleteRequest{Id: nperm.Data.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for delete, received %s\n", err)
+	}
+}
+
+func TestRoleDeleteInUse(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	rclient := pb.NewRoleServiceClient(conn)
+	curator, err := rclient.CreateRole(context.Background(), NewRole("curator"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	editor, err := rclient.CreateRole(context.Background(), NewRole("editor"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	uclient := pb.NewUserServiceClient(conn)
+	usr, err := uclient.CreateUser(context.Background(), NewUserWithRole("curator@gmail.com", curator))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+
+	_, err = rclient.DeleteRole(context.Background(), &jsonapi.DeleteRequest{Id: curator.Data.Id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, received %s\n", err)
+	}
+	var found bool
+	for _, d := range status.Convert(err).Details() {
+		if pf, ok := d.(*errdetails.PreconditionFailure); ok {
+			for _, v := range pf.Violations {
+				if v.Type == RoleInUseViolation {
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected the affected users in the error details")
+	}
+
+	s := NewRoleService(runner.NewDB(db, "postgres"))
+	_, err = s.DeleteRoleWithOptions(
+		context.Background(),
+		&RoleDeletion{Id: curator.Data.Id, ReassignTo: curator.Data.Id},
+	)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for reassigning to itself, received %s\n", err)
+	}
+	res, err := s.DeleteRoleWithOptions(
+		context.Background(),
+		&RoleDeletion{Id: curator.Data.Id, ReassignTo: editor.Data.Id},
+	)
+	if err != nil {
+		t.Fatalf("could not delete the role with reassign %s\n", err)
+	}
+	if len(res.Users) != 1 || res.Users[0].Id != usr.Data.Id {
+		t.Fatalf("expected user %d to be affected, received %+v\n", usr.Data.Id, res.Users)
+	}
+	ok, err := hasRole(s.Dbh, usr.Data.Id, editor.Data.Id)
+	if err != nil {
+		t.Fatalf("could not check the role of user %s\n", err)
+	}
+	if !ok {
+		t.Fatal("expected the user to be reassigned to the editor role")
+	}
+
+	other, err := rclient.CreateRole(context.Background(), NewRoleWithUser("reviewer", usr))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(DeleteForceKey, "true"))
+	if _, err := rclient.DeleteRole(ctx, &jsonapi.DeleteRequest{Id: other.Data.Id}); err != nil {
+		t.Fatalf("could not force delete the role %s\n", err)
+	}
+}
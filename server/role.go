@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 
 	"google.golang.org/grpc"
@@ -37,6 +38,7 @@ type dbRole struct {
 
 type RoleService struct {
 	*aphgrpc.Service
+	cache *PermissionCache
 }
 
 func roleServiceOptions() *aphgrpc.ServiceOptions {
@@ -65,7 +67,36 @@ func NewRoleService(dbh *runner.DB, opt ...aphgrpc.Option) *RoleService {
 	}
 	srv := &aphgrpc.Service{Dbh: dbh}
 	aphgrpc.AssignFieldsToStructs(so, srv)
-	return &RoleService{srv}
+	return &RoleService{Service: srv}
+}
+
+// WithPermissionCache sets the cache of permission grants that is
+// invalidated by the changes made through the service
+func (s *RoleService) WithPermissionCache(c *PermissionCache) *RoleService {
+	s.cache = c
+	return s
+}
+
+// HTTPRoutes returns the role endpoints that are not part of the protocol
+// buffer definitions
+func (s *RoleService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/roles/export", Handler: s.exportRBACHandler},
+		{Method: "GET", Path: "/roles/statistics", Handler: s.statisticsHandler},
+		{Method: "POST", Path: "/roles/{id}/bulk_grant", Handler: s.bulkGrantHandler},
+		{Method: "POST", Path: "/roles/{id}/bulk_revoke", Handler: s.bulkRevokeHandler},
+		{Method: "GET", Path: "/roles/constraints", Handler: s.listConstraintsHandler},
+		{Method: "POST", Path: "/roles/constraints", Handler: s.createConstraintHandler},
+		{Method: "DELETE", Path: "/roles/constraints/{id}", Handler: s.deleteConstraintHandler},
+		{Method: "GET", Path: "/roles/constraints/violations", Handler: s.listViolationsHandler},
+		{Method: "GET", Path: "/roles/{id}/protection", Handler: protectionHandler(s.GetRoleProtection)},
+		{Method: "PUT", Path: "/roles/{id}/protection", Handler: setProtectionHandler(s.SetRoleProtection)},
+		{Method: "DELETE", Path: "/roles/{id}", Handler: s.deleteRoleHandler},
+		{Method: "GET", Path: "/roles/{id}/grants", Handler: s.grantedPermissionsHandler},
+		{Method: "GET", Path: "/roles/{id}/permissions/{permission_id}/condition", Handler: s.getPermissionConditionHandler},
+		{Method: "PUT", Path: "/roles/{id}/permissions/{permission_id}/condition", Handler: s.setPermissionConditionHandler},
+		{Method: "PUT", Path: "/roles/{id}/permissions/{permission_id}/effect", Handler: s.setPermissionEffectHandler},
+	}
 }
 
 func (s *RoleService) GetRole(ctx context.Context, r *jsonapi.GetRequest) (*user.Role, error) {
@@ -85,6 +116,9 @@ func (s *RoleService) GetRole(ctx context.Context, r *jsonapi.GetRequest) (*user
 		if err != nil {
 			return &user.Role{}, aphgrpc.HandleError(ctx, err)
 		}
+		if err := setIncludedScopesHeader(ctx, s.Dbh, params, r.Id); err != nil {
+			return &user.Role{}, aphgrpc.HandleError(ctx, err)
+		}
 		return role, nil
 	case params.HasFields:
 		role, err := s.getResourceWithSelectedAttr(gctx, r.Id)
@@ -101,6 +135,9 @@ func (s *RoleService) GetRole(ctx context.Context, r *jsonapi.GetRequest) (*user
 		if err != nil {
 			return &user.Role{}, aphgrpc.HandleError(ctx, err)
 		}
+		if err := setIncludedScopesHeader(ctx, s.Dbh, params, r.Id); err != nil {
+			return &user.Role{}, aphgrpc.HandleError(ctx, err)
+		}
 		return role, nil
 	default:
 		role, err := s.getResource(gctx, r.Id)
@@ -112,9 +149,13 @@ func (s *RoleService) GetRole(ctx context.Context, r *jsonapi.GetRequest) (*user
 }
 
 func (s *RoleService) GetRelatedUsers(ctx context.Context, r *jsonapi.RelationshipRequestWithPagination) (*user.UserCollection, error) {
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &user.UserCollection{}, err
+	}
 	// For pagination based data retreival
 	// 1. Get count of all rows
-	count, err := s.getRelatedUsersCount(r.Id)
+	count, err := s.getScopedRelatedUsersCount(r.Id, sc)
 	if err != nil {
 		return &user.UserCollection{}, aphgrpc.HandleError(ctx, err)
 	}
@@ -134,7 +175,7 @@ func (s *RoleService) GetRelatedUsers(ctx context.Context, r *jsonapi.Relationsh
 		pagenum = aphgrpc.DefaultPagenum
 		pagesize = aphgrpc.DefaultPagesize
 	}
-	udata, err := s.getUserResourceDataWithPagination(r.Id, pagenum, pagesize)
+	udata, err := s.getUserResourceDataWithPagination(r.Id, sc, pagenum, pagesize)
 	if err != nil {
 		return &user.UserCollection{}, aphgrpc.HandleError(ctx, err)
 	}
@@ -158,6 +199,9 @@ func (s *RoleService) GetRelatedPermissions(ctx context.Context, r *jsonapi.Rela
 	if err != nil {
 		return &user.PermissionCollection{}, aphgrpc.HandleError(ctx, err)
 	}
+	if err := setDeniedPermissionsHeader(ctx, s.Dbh, r.Id); err != nil {
+		return &user.PermissionCollection{}, aphgrpc.HandleError(ctx, err)
+	}
 	return &user.PermissionCollection{
 		Data: pdata,
 		Links: &jsonapi.Links{
@@ -186,7 +230,7 @@ func (s *RoleService) ListRoles(ctx context.Context, r *jsonapi.SimpleListReques
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
-		r, err := s.dbToCollResourceWithRel(lctx, dbRoles)
+		r, err := s.dbToCollResourceWithScopes(ctx, lctx, dbRoles)
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
@@ -202,7 +246,7 @@ func (s *RoleService) ListRoles(ctx context.Context, r *jsonapi.SimpleListReques
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
-		r, err := s.dbToCollResourceWithRel(lctx, dbRoles)
+		r, err := s.dbToCollResourceWithScopes(ctx, lctx, dbRoles)
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
@@ -212,7 +256,7 @@ func (s *RoleService) ListRoles(ctx context.Context, r *jsonapi.SimpleListReques
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
-		r, err := s.dbToCollResourceWithRel(lctx, dbRoles)
+		r, err := s.dbToCollResourceWithScopes(ctx, lctx, dbRoles)
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
@@ -234,7 +278,7 @@ func (s *RoleService) ListRoles(ctx context.Context, r *jsonapi.SimpleListReques
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
-		r, err := s.dbToCollResourceWithRel(lctx, dbRoles)
+		r, err := s.dbToCollResourceWithScopes(ctx, lctx, dbRoles)
 		if err != nil {
 			return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 		}
@@ -249,10 +293,16 @@ func (s *RoleService) ListRoles(ctx context.Context, r *jsonapi.SimpleListReques
 }
 
 func (s *RoleService) CreateRole(ctx context.Context, r *user.CreateRoleRequest) (*user.Role, error) {
+	rstruct := structs.New(r).Field("Data").Field("Relationships")
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("CreateRole"), "roles", 0)
+	if err != nil {
+		return &user.Role{}, err
+	}
+	defer tx.AutoRollback()
 	dbrole := s.attrTodbRole(r.Data.Attributes)
 	rcolumns := aphgrpc.GetDefinedTags(dbrole, "db")
 	if len(rcolumns) > 0 {
-		err := s.Dbh.InsertInto("auth_role").
+		err := tx.InsertInto("auth_role").
 			Columns(rcolumns...).
 			Record(dbrole).
 			Returning(roleCols...).
@@ -263,11 +313,16 @@ func (s *RoleService) CreateRole(ctx context.Context, r *user.CreateRoleRequest)
 		}
 	}
 	roleId := dbrole.AuthRoleId
-	rstruct := structs.New(r).Field("Data").Field("Relationships")
+	var members []int64
 	if !rstruct.IsZero() {
 		if !rstruct.Field("Users").IsZero() {
+			members = dataToIds(r.Data.Relationships.Users.Data)
+			err := checkMembersRoleConstraints(ctx, tx, roleId, members)
+			if err != nil {
+				return &user.Role{}, err
+			}
 			for _, u := range r.Data.Relationships.Users.Data {
-				_, err := s.Dbh.InsertInto("auth_user_role").
+				_, err := tx.InsertInto("auth_user_role").
 					Columns("auth_user_id", "auth_role_id").
 					Values(u.Id, roleId).Exec()
 				if err != nil {
@@ -275,10 +330,11 @@ func (s *RoleService) CreateRole(ctx context.Context, r *user.CreateRoleRequest)
 					return &user.Role{}, status.Error(codes.Internal, err.Error())
 				}
 			}
+			au.relate("users", members...)
 		}
 		if !rstruct.Field("Permissions").IsZero() {
 			for _, p := range r.Data.Relationships.Permissions.Data {
-				_, err := s.Dbh.InsertInto("auth_role_permission").
+				_, err := tx.InsertInto("auth_role_permission").
 					Columns("auth_role_id", "auth_permission_id").
 					Values(roleId, p.Id).Exec()
 				if err != nil {
@@ -286,8 +342,13 @@ func (s *RoleService) CreateRole(ctx context.Context, r *user.CreateRoleRequest)
 					return &user.Role{}, status.Error(codes.Internal, err.Error())
 				}
 			}
+			au.relate("permissions", dataToIds(r.Data.Relationships.Permissions.Data)...)
 		}
 	}
+	if err := au.created(roleId).commit(tx); err != nil {
+		return &user.Role{}, err
+	}
+	s.cache.invalidate(members...)
 	grpc.SetTrailer(ctx, metadata.Pairs("method", "POST"))
 	return s.buildResource(context.TODO(), roleId, s.dbToResourceAttributes(dbrole)), nil
 }
@@ -304,19 +365,34 @@ func (s *RoleService) CreateUserRelationship(ctx context.Context, r *jsonapi.Dat
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("CreateUserRelationship"), "roles", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	if err := checkMembersRoleConstraints(ctx, tx, r.Id, dataToIds(r.Data)); err != nil {
+		return &empty.Empty{}, err
+	}
 	for _, ud := range r.Data {
-		res, err := s.Dbh.Select("aurole.auth_user_role_id").
+		where, args := scopedWhere(
+			"aurole",
+			"aurole.auth_role_id = $1 AND aurole.auth_user_id = $2",
+			sc, r.Id, ud.Id,
+		)
+		res, err := tx.Select("aurole.auth_user_role_id").
 			From("auth_user_role aurole").
-			Where("aurole.auth_role_id = $1 AND aurole.auth_user_role_id = $2", r.Id, ud.Id).
+			Where(where, args...).
 			Exec()
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 		if res.RowsAffected != 1 {
-			_, err := s.Dbh.InsertInto("auth_user_role").
-				Columns("auth_role_id", "auth_user_id").
-				Values(r.Id, ud.Id).Exec()
+			err := insertUserRole(tx, ud.Id, r.Id, sc)
 			if err != nil {
 				grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
 				return &empty.Empty{}, status.Error(codes.Internal, err.Error())
@@ -330,6 +406,10 @@ func (s *RoleService) CreateUserRelationship(ctx context.Context, r *jsonapi.Dat
 				)
 		}
 	}
+	if err := au.relate("users", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidate(dataToIds(r.Data)...)
 	grpc.SetTrailer(ctx, metadata.Pairs("method", "POST_NO_CONTENT"))
 	return &empty.Empty{}, nil
 }
@@ -346,8 +426,17 @@ func (s *RoleService) CreatePermissionRelationship(ctx context.Context, r *jsona
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
+	effect, err := effectFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("CreatePermissionRelationship"), "roles", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
 	for _, pd := range r.Data {
-		res, err := s.Dbh.Select("auth_role_permission.auth_role_permission_id").
+		res, err := tx.Select("auth_role_permission.auth_role_permission_id").
 			From("auth_role_permission").
 			Where("auth_role_permission.auth_role_id = $1 AND auth_role_permission.auth_permission_id = $2", r.Id, pd.Id).
 			Exec()
@@ -356,9 +445,9 @@ func (s *RoleService) CreatePermissionRelationship(ctx context.Context, r *jsona
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 		if res.RowsAffected != 1 {
-			_, err := s.Dbh.InsertInto("auth_role_permission").
-				Columns("auth_role_id", "auth_permission_id").
-				Values(r.Id, pd.Id).Exec()
+			_, err := tx.InsertInto("auth_role_permission").
+				Columns("auth_role_id", "auth_permission_id", "effect").
+				Values(r.Id, pd.Id, effect).Exec()
 			if err != nil {
 				grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
 				return &empty.Empty{}, status.Error(codes.Internal, err.Error())
@@ -373,6 +462,10 @@ func (s *RoleService) CreatePermissionRelationship(ctx context.Context, r *jsona
 		}
 
 	}
+	if err := au.relate("permissions", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidateUsers(s.cache.roleUsers(s.Dbh, r.Id))
 	grpc.SetTrailer(ctx, metadata.Pairs("method", "POST_NO_CONTENT"))
 	return &empty.Empty{}, nil
 }
@@ -388,8 +481,41 @@ func (s *RoleService) UpdateRole(ctx context.Context, r *user.UpdateRoleRequest)
 	}
 	dbrole := s.attrTodbRole(r.Data.Attributes)
 	rmap := aphgrpc.GetDefinedTagsWithValue(dbrole, "db")
+	rstruct := structs.New(r).Field("Data").Field("Relationships")
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("UpdateRole"), "roles", r.Data.Id)
+	if err != nil {
+		return &user.Role{}, err
+	}
+	defer tx.AutoRollback()
+	// the role stays locked until it is updated, so that it cannot be
+	// protected after the name is checked
+	var current string
+	err = tx.SQL(`
+		SELECT role FROM auth_role
+		WHERE auth_role_id = $1
+		FOR UPDATE`, r.Id).
+		QueryScalar(&current)
+	if err == sql.ErrNoRows {
+		return &user.Role{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", r.Id))
+	}
+	if err != nil {
+		return &user.Role{}, aphgrpc.HandleError(ctx, err)
+	}
+	if name, ok := rmap["role"]; ok && name != current {
+		if err := roleProtection.check(ctx, tx, r.Id, "renamed"); err != nil {
+			return &user.Role{}, err
+		}
+	}
+	// the members are replaced, both the former and the new ones change
+	members, merr := s.cache.roleUsers(tx, r.Data.Id)
+	if !rstruct.IsZero() && !rstruct.Field("Users").IsZero() {
+		err := checkMembersRoleConstraints(ctx, tx, r.Data.Id, dataToIds(r.Data.Relationships.Users.Data))
+		if err != nil {
+			return &user.Role{}, err
+		}
+	}
 	if len(rmap) > 0 {
-		err := s.Dbh.Update(roleDbTable).SetMap(rmap).
+		err := tx.Update(roleDbTable).SetMap(rmap).
 			Where("auth_role_id = $1", r.Data.Id).Returning(roleCols...).
 			QueryStruct(dbrole)
 		if err != nil {
@@ -397,22 +523,23 @@ func (s *RoleService) UpdateRole(ctx context.Context, r *user.UpdateRoleRequest)
 			return &user.Role{}, status.Error(codes.Internal, err.Error())
 		}
 	}
-	rstruct := structs.New(r).Field("Data").Field("Relationships")
 	if !rstruct.IsZero() {
 		if !rstruct.Field("Users").IsZero() {
+			members = append(members, dataToIds(r.Data.Relationships.Users.Data)...)
 			for _, u := range r.Data.Relationships.Users.Data {
-				_, err := s.Dbh.Update("auth_user_role").
+				_, err := tx.Update("auth_user_role").
 					Set("auth_user_id", u.Id).
-					Where("auth_role_id = $1", r.Data.Id).Exec()
+					Where("auth_role_id = $1 AND resource_type IS NULL", r.Data.Id).Exec()
 				if err != nil {
 					grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
 					return &user.Role{}, status.Error(codes.Internal, err.Error())
 				}
 			}
+			au.relate("users", dataToIds(r.Data.Relationships.Users.Data)...)
 		}
 		if !rstruct.Field("Permissions").IsZero() {
 			for _, p := range r.Data.Relationships.Permissions.Data {
-				_, err := s.Dbh.Update("auth_role_permission").
+				_, err := tx.Update("auth_role_permission").
 					Set("auth_permission_id", p.Id).
 					Where("auth_role_id = $1", r.Data.Id).Exec()
 				if err != nil {
@@ -420,8 +547,15 @@ func (s *RoleService) UpdateRole(ctx context.Context, r *user.UpdateRoleRequest)
 					return &user.Role{}, status.Error(codes.Internal, err.Error())
 				}
 			}
+			au.relate("permissions", dataToIds(r.Data.Relationships.Permissions.Data)...)
 		}
 	}
+	if err := au.commit(tx); err != nil {
+		return &user.Role{}, err
+	}
+	if !rstruct.IsZero() {
+		s.cache.invalidateUsers(members, merr)
+	}
 	return s.buildResource(context.TODO(), dbrole.AuthRoleId, s.dbToResourceAttributes(dbrole)), nil
 }
 
@@ -437,22 +571,38 @@ func (s *RoleService) UpdateUserRelationship(ctx context.Context, r *jsonapi.Dat
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
-	_, err = s.Dbh.DeleteFrom("auth_user_role").
-		Where("auth_user_role.auth_role_id = $1", r.Id).
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	members, merr := s.cache.roleUsers(s.Dbh, r.Id)
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("UpdateUserRelationship"), "roles", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	if err := checkMembersRoleConstraints(ctx, tx, r.Id, dataToIds(r.Data)); err != nil {
+		return &empty.Empty{}, err
+	}
+	where, args := scopedWhere("auth_user_role", "auth_user_role.auth_role_id = $1", sc, r.Id)
+	_, err = tx.DeleteFrom("auth_user_role").
+		Where(where, args...).
 		Exec()
 	if err != nil {
 		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
 		return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 	}
 	for _, ud := range r.Data {
-		_, err := s.Dbh.InsertInto("auth_user_role").
-			Columns("auth_role_id", "auth_user_id").
-			Values(r.Id, ud.Id).Exec()
+		err := insertUserRole(tx, ud.Id, r.Id, sc)
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 	}
+	if err := au.relate("users", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidateUsers(append(members, dataToIds(r.Data)...), merr)
 	return &empty.Empty{}, nil
 }
 
@@ -468,7 +618,16 @@ func (s *RoleService) UpdatePermissionRelationship(ctx context.Context, r *jsona
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
-	_, err = s.Dbh.DeleteFrom("auth_role_permission").
+	effect, err := effectFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("UpdatePermissionRelationship"), "roles", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	_, err = tx.DeleteFrom("auth_role_permission").
 		Where("auth_role_permission.auth_role_id = $1", r.Id).
 		Exec()
 	if err != nil {
@@ -476,30 +635,28 @@ func (s *RoleService) UpdatePermissionRelationship(ctx context.Context, r *jsona
 		return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 	}
 	for _, pd := range r.Data {
-		_, err := s.Dbh.InsertInto("auth_role_permission").
-			Columns("auth_role_id", "auth_permission_id").
-			Values(r.Id, pd.Id).Exec()
+		_, err := tx.InsertInto("auth_role_permission").
+			Columns("auth_role_id", "auth_permission_id", "effect").
+			Values(r.Id, pd.Id, effect).Exec()
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 	}
+	if err := au.relate("permissions", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidateUsers(s.cache.roleUsers(s.Dbh, r.Id))
 	return &empty.Empty{}, nil
 }
 
 func (s *RoleService) DeleteRole(ctx context.Context, r *jsonapi.DeleteRequest) (*empty.Empty, error) {
-	result, err := s.existsResource(r.Id)
-	if err != nil {
-		return &empty.Empty{}, aphgrpc.HandleError(ctx, err)
-	}
-	if !result {
-		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
-		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
+	del := &RoleDeletion{Id: r.Id}
+	if err := deleteOptionsFromContext(ctx, del); err != nil {
+		return &empty.Empty{}, err
 	}
-	_, err = s.Dbh.DeleteFrom(roleDbTable).Where("auth_role_id = $1", r.Id).Exec()
-	if err != nil {
-		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
-		return &empty.Empty{}, status.Error(codes.Internal, err.Error())
+	if _, err := s.DeleteRoleWithOptions(ctx, del); err != nil {
+		return &empty.Empty{}, err
 	}
 	return &empty.Empty{}, nil
 }
@@ -516,15 +673,33 @@ func (s *RoleService) DeleteUserRelationship(ctx context.Context, r *jsonapi.Dat
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("DeleteUserRelationship"), "roles", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
 	for _, ud := range r.Data {
-		_, err := s.Dbh.DeleteFrom("auth_user_role").
-			Where("auth_user_role.auth_role_id = $1 AND auth_user_role.auth_user_id = $2", r.Id, ud.Id).
+		where, args := scopedWhere(
+			"auth_user_role",
+			"auth_user_role.auth_role_id = $1 AND auth_user_role.auth_user_id = $2",
+			sc, r.Id, ud.Id,
+		)
+		_, err := tx.DeleteFrom("auth_user_role").
+			Where(where, args...).
 			Exec()
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 	}
+	if err := au.relate("users", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidate(dataToIds(r.Data)...)
 	return &empty.Empty{}, nil
 }
 
@@ -540,8 +715,13 @@ func (s *RoleService) DeletePermissionRelationship(ctx context.Context, r *jsona
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("DeletePermissionRelationship"), "roles", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
 	for _, pd := range r.Data {
-		_, err := s.Dbh.DeleteFrom("auth_role_permission").
+		_, err := tx.DeleteFrom("auth_role_permission").
 			Where("auth_role_permission.auth_role_id = $1 AND auth_role_permission.auth_permission_id = $2", r.Id, pd.Id).
 			Exec()
 		if err != nil {
@@ -549,6 +729,10 @@ func (s *RoleService) DeletePermissionRelationship(ctx context.Context, r *jsona
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 	}
+	if err := au.relate("permissions", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidateUsers(s.cache.roleUsers(s.Dbh, r.Id))
 	return &empty.Empty{}, nil
 }
 
@@ -662,17 +846,37 @@ func (s *RoleService) getPermissionResourceData(id int64) ([]*user.PermissionDat
 }
 
 func (s *RoleService) getRelatedUsersCount(id int64) (int64, error) {
+	return s.getScopedRelatedUsersCount(id, nil)
+}
+
+func (s *RoleService) getScopedRelatedUsersCount(id int64, sc *Scope) (int64, error) {
 	var count int64
+	where, args := scopedWhere("auth_user_role", "auth_user_role.auth_role_id = $1", sc, id)
 	err := s.Dbh.Select("COUNT(*)").From(`
 		auth_user_role
 		JOIN auth_user
 		ON auth_user_role.auth_user_id = auth_user.auth_user_id
 		JOIN auth_user_info
 		ON auth_user_info.auth_user_id = auth_user.auth_user_id
-		`).Where("auth_user_role.auth_role_id = $1", id).QueryScalar(&count)
+		`).Where(where, args...).QueryScalar(&count)
+	return count, err
+}
+
+// getScopedMembersCount counts the users holding the role for some
+// resources
+func (s *RoleService) getScopedMembersCount(id int64) (int64, error) {
+	var count int64
+	err := s.Dbh.Select("COUNT(DISTINCT auth_user_role.auth_user_id)").From(`
+		auth_user_role
+		JOIN auth_user
+		ON auth_user_role.auth_user_id = auth_user.auth_user_id
+		`).Where("auth_user_role.auth_role_id = $1 AND auth_user_role.resource_type IS NOT NULL", id).
+		QueryScalar(&count)
 	return count, err
 }
 
+// getUserResourceData returns the users holding the role globally or for
+// some resources
 func (s *RoleService) getUserResourceData(id int64) ([]*user.UserData, error) {
 	var dbrows []*dbUser
 	var udata []*user.UserData
@@ -686,12 +890,13 @@ func (s *RoleService) getUserResourceData(id int64) ([]*user.UserData, error) {
 				auth_user.created_at,
 				auth_user.updated_at,
 				uinfo.*
-				FROM auth_user_role
-				JOIN auth_user
-				ON auth_user_role.auth_user_id = auth_user.auth_user_id
+				FROM auth_user
 				JOIN auth_user_info uinfo
 				ON uinfo.auth_user_id = auth_user.auth_user_id
-				WHERE auth_user_role.auth_role_id = $1`, id).
+				WHERE auth_user.auth_user_id IN (
+					SELECT auth_user_id FROM auth_user_role
+					WHERE auth_role_id = $1
+				)`, id).
 		QueryStructs(&dbrows)
 	if err != nil {
 		return udata, err
@@ -701,12 +906,13 @@ func (s *RoleService) getUserResourceData(id int64) ([]*user.UserData, error) {
 	).dbToCollResourceData(context.TODO(), dbrows), nil
 }
 
-func (s *RoleService) getUserResourceDataWithPagination(id, pagenum, pagesize int64) ([]*user.UserData, error) {
+func (s *RoleService) getUserResourceDataWithPagination(id int64, sc *Scope, pagenum, pagesize int64) ([]*user.UserData, error) {
 	var dbrows []*dbUser
 	var udata []*user.UserData
+	where, args := scopedWhere("auth_user_role", "auth_user_role.auth_role_id = $1", sc, id)
 	err := s.Dbh.SQL(
 		fmt.Sprintf(
-			"%s LIMIT %d OFFSET %d",
+			"%s WHERE %s LIMIT %d OFFSET %d",
 			`SELECT auth_user.auth_user_id,
 				CAST(auth_user.email AS TEXT),
 				auth_user.first_name,
@@ -717,11 +923,11 @@ func (s *RoleService) getUserResourceDataWithPagination(id, pagenum, pagesize in
 				JOIN auth_user
 				ON auth_user_role.auth_user_id = auth_user.auth_user_id
 				JOIN auth_user_info
-				ON auth_user_info.auth_user_id = auth_user.auth_user_id
-				WHERE auth_user_role.auth_role_id = $1`,
+				ON auth_user_info.auth_user_id = auth_user.auth_user_id`,
+			where,
 			pagesize,
 			(pagenum-1)*pagesize,
-		), id).QueryStructs(&dbrows)
+		), args...).QueryStructs(&dbrows)
 	if err != nil {
 		return udata, err
 	}
@@ -849,6 +1055,21 @@ func (s *RoleService) dbToCollResource(ctx context.Context, dbrows []*dbRole) *u
 	}
 }
 
+// dbToCollResourceWithScopes builds the collection with its relationships
+// and sends the scopes of the included users as header metadata
+func (s *RoleService) dbToCollResourceWithScopes(ctx, lctx context.Context, dbrows []*dbRole) (*user.RoleCollection, error) {
+	rc, err := s.dbToCollResourceWithRel(lctx, dbrows)
+	if err != nil {
+		return rc, err
+	}
+	params := lctx.Value(aphgrpc.ContextKeyParams).(*aphgrpc.JSONAPIParams)
+	var ids []int64
+	for _, r := range dbrows {
+		ids = append(ids, r.AuthRoleId)
+	}
+	return rc, setIncludedScopesHeader(ctx, s.Dbh, params, ids...)
+}
+
 func (s *RoleService) dbToCollResourceWithRel(ctx context.Context, dbrows []*dbRole) (*user.RoleCollection, error) {
 	params, ok := ctx.Value(aphgrpc.ContextKeyParams).(*aphgrpc.JSONAPIParams)
 	if !ok {
@@ -0,0 +1,493 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const (
+	roleConstraintDbTable = "auth_role_constraint"
+	// RoleConflictViolation is the type of the precondition failure that
+	// is returned when a role assignment violates a constraint
+	RoleConflictViolation = "ROLE_CONFLICT"
+)
+
+type dbRoleConstraint struct {
+	AuthRoleConstraintId int64          `db:"auth_role_constraint_id"`
+	AuthRoleId           int64          `db:"auth_role_id"`
+	Role                 string         `db:"role"`
+	ConflictingRoleId    int64          `db:"conflicting_role_id"`
+	ConflictingRole      string         `db:"conflicting_role"`
+	Description          dat.NullString `db:"description"`
+	CreatedAt            dat.NullTime   `db:"created_at"`
+}
+
+type dbRoleViolation struct {
+	AuthUserId      int64  `db:"auth_user_id"`
+	Email           string `db:"email"`
+	Role            string `db:"role"`
+	ConflictingRole string `db:"conflicting_role"`
+}
+
+// RoleConstraintAttributes are the attributes of a constraint between two
+// mutually exclusive roles
+type RoleConstraintAttributes struct {
+	RoleId            int64     `json:"role_id"`
+	Role              string    `json:"role"`
+	ConflictingRoleId int64     `json:"conflicting_role_id"`
+	ConflictingRole   string    `json:"conflicting_role"`
+	Description       string    `json:"description,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// RoleConstraintData is the primary data of a role constraint resource
+type RoleConstraintData struct {
+	Type       string                    `json:"type"`
+	Id         int64                     `json:"id"`
+	Attributes *RoleConstraintAttributes `json:"attributes"`
+}
+
+// RoleConstraint is a pair of roles that cannot be held by the same user
+type RoleConstraint struct {
+	Data *RoleConstraintData `json:"data"`
+}
+
+// RoleConstraintCollection is a list of role constraints
+type RoleConstraintCollection struct {
+	Data []*RoleConstraintData `json:"data"`
+}
+
+// NewRoleConstraint contains the attributes for creating a role constraint
+type NewRoleConstraint struct {
+	RoleId            int64  `json:"role_id"`
+	ConflictingRoleId int64  `json:"conflicting_role_id"`
+	Description       string `json:"description"`
+}
+
+// RoleViolation is an user who currently holds two mutually exclusive roles
+type RoleViolation struct {
+	UserId          int64  `json:"user_id"`
+	Email           string `json:"email"`
+	Role            string `json:"role"`
+	ConflictingRole string `json:"conflicting_role"`
+}
+
+// RoleViolationReport lists all existing violations of role constraints
+type RoleViolationReport struct {
+	Data []*RoleViolation `json:"data"`
+}
+
+func (s *RoleService) CreateRoleConstraint(ctx context.Context, r *NewRoleConstraint) (*RoleConstraint, error) {
+	if r.RoleId == r.ConflictingRoleId {
+		return &RoleConstraint{}, aphgrpc.HandleInsertArgError(ctx, fmt.Errorf("a role cannot conflict with itself"))
+	}
+	for _, id := range []int64{r.RoleId, r.ConflictingRoleId} {
+		exists, err := s.existsResource(id)
+		if err != nil {
+			return &RoleConstraint{}, aphgrpc.HandleError(ctx, err)
+		}
+		if !exists {
+			return &RoleConstraint{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", id))
+		}
+	}
+	// the pair is kept in a canonical order so that a constraint is stored
+	// only once irrespective of the order it is given
+	first, second := r.RoleId, r.ConflictingRoleId
+	if first > second {
+		first, second = second, first
+	}
+	var count int64
+	err := s.Dbh.Select("COUNT(*)").
+		From(roleConstraintDbTable).
+		Where("auth_role_id = $1 AND conflicting_role_id = $2", first, second).
+		QueryScalar(&count)
+	if err != nil {
+		return &RoleConstraint{}, aphgrpc.HandleError(ctx, err)
+	}
+	if count > 0 {
+		return &RoleConstraint{}, aphgrpc.HandleExistError(
+			ctx,
+			fmt.Errorf("constraint between roles %d and %d already exists", first, second),
+		)
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("CreateRoleConstraint"), "role_constraints", 0)
+	if err != nil {
+		return &RoleConstraint{}, err
+	}
+	defer tx.AutoRollback()
+	var id int64
+	err = tx.InsertInto(roleConstraintDbTable).
+		Columns("auth_role_id", "conflicting_role_id", "description").
+		Values(first, second, dat.NullStringFrom(r.Description)).
+		Returning("auth_role_constraint_id").
+		QueryScalar(&id)
+	if err != nil {
+		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
+		return &RoleConstraint{}, status.Error(codes.Internal, err.Error())
+	}
+	if err := au.created(id).relate("roles", first, second).commit(tx); err != nil {
+		return &RoleConstraint{}, err
+	}
+	all, err := getRoleConstraints(s.Dbh)
+	if err != nil {
+		return &RoleConstraint{}, aphgrpc.HandleError(ctx, err)
+	}
+	for _, dc := range all {
+		if dc.AuthRoleConstraintId == id {
+			return &RoleConstraint{Data: s.constraintToResourceData(dc)}, nil
+		}
+	}
+	return &RoleConstraint{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("constraint id %d not found", id))
+}
+
+func (s *RoleService) ListRoleConstraints(ctx context.Context) (*RoleConstraintCollection, error) {
+	all, err := getRoleConstraints(s.Dbh)
+	if err != nil {
+		return &RoleConstraintCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	coll := &RoleConstraintCollection{Data: make([]*RoleConstraintData, 0)}
+	for _, dc := range all {
+		coll.Data = append(coll.Data, s.constraintToResourceData(dc))
+	}
+	return coll, nil
+}
+
+func (s *RoleService) DeleteRoleConstraint(ctx context.Context, r *jsonapi.DeleteRequest) (*empty.Empty, error) {
+	tx, au, err := beginAudit(ctx, s.Dbh, roleRPC("DeleteRoleConstraint"), "role_constraints", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	var dbrows []*dbRoleConstraint
+	err = tx.SQL(`
+		DELETE FROM auth_role_constraint
+		WHERE auth_role_constraint_id = $1
+		RETURNING auth_role_constraint_id, auth_role_id, conflicting_role_id`, r.Id).
+		QueryStructs(&dbrows)
+	if err != nil {
+		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
+		return &empty.Empty{}, status.Error(codes.Internal, err.Error())
+	}
+	if len(dbrows) == 0 {
+		return &empty.Empty{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("constraint id %d not found", r.Id))
+	}
+	au.relate("roles", dbrows[0].AuthRoleId, dbrows[0].ConflictingRoleId)
+	if err := au.commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	return &empty.Empty{}, nil
+}
+
+// ListRoleViolations reports the users who already hold a pair of
+// mutually exclusive roles, for example the ones assigned before the
+// constraint was defined. A pair held in several scopes is reported once.
+func (s *RoleService) ListRoleViolations(ctx context.Context) (*RoleViolationReport, error) {
+	var dbrows []*dbRoleViolation
+	err := s.Dbh.Select(
+		"ur1.auth_user_id", "usr.email",
+		"r1.role", "r2.role conflicting_role",
+	).Distinct().From(`
+			auth_role_constraint rc
+			JOIN auth_user_role ur1 ON ur1.auth_role_id = rc.auth_role_id
+			JOIN auth_user_role ur2 ON ur2.auth_role_id = rc.conflicting_role_id
+				AND ur2.auth_user_id = ur1.auth_user_id
+			JOIN auth_user usr ON usr.auth_user_id = ur1.auth_user_id
+			JOIN auth_role r1 ON r1.auth_role_id = rc.auth_role_id
+			JOIN auth_role r2 ON r2.auth_role_id = rc.conflicting_role_id
+		`).
+		OrderBy("ur1.auth_user_id", "r1.role", "r2.role").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &RoleViolationReport{}, aphgrpc.HandleError(ctx, err)
+	}
+	rpt := &RoleViolationReport{Data: make([]*RoleViolation, 0)}
+	for _, v := range dbrows {
+		rpt.Data = append(rpt.Data, &RoleViolation{
+			UserId:          v.AuthUserId,
+			Email:           v.Email,
+			Role:            v.Role,
+			ConflictingRole: v.ConflictingRole,
+		})
+	}
+	return rpt, nil
+}
+
+func (s *RoleService) constraintToResourceData(dc *dbRoleConstraint) *RoleConstraintData {
+	return &RoleConstraintData{
+		Type: "role_constraints",
+		Id:   dc.AuthRoleConstraintId,
+		Attributes: &RoleConstraintAttributes{
+			RoleId:            dc.AuthRoleId,
+			Role:              dc.Role,
+			ConflictingRoleId: dc.ConflictingRoleId,
+			ConflictingRole:   dc.ConflictingRole,
+			Description:       aphgrpc.NullToString(dc.Description),
+			CreatedAt:         dc.CreatedAt.Time,
+		},
+	}
+}
+
+// -- Constraint enforcement, used by every path that assigns roles to users
+
+func getRoleConstraints(conn runner.Connection) ([]*dbRoleConstraint, error) {
+	var dbrows []*dbRoleConstraint
+	err := conn.Select(
+		"rc.auth_role_constraint_id", "rc.auth_role_id", "r1.role",
+		"rc.conflicting_role_id", "r2.role conflicting_role",
+		"rc.description", "rc.created_at",
+	).From(`
+			auth_role_constraint rc
+			JOIN auth_role r1 ON r1.auth_role_id = rc.auth_role_id
+			JOIN auth_role r2 ON r2.auth_role_id = rc.conflicting_role_id
+		`).
+		OrderBy("rc.auth_role_constraint_id").
+		QueryStructs(&dbrows)
+	return dbrows, err
+}
+
+func getUserRoleIds(conn runner.Connection, userId int64) ([]int64, error) {
+	ids := make([]int64, 0)
+	err := conn.Select("auth_role_id").
+		From("auth_user_role").
+		Where("auth_user_id = $1", userId).
+		QuerySlice(&ids)
+	return ids, err
+}
+
+// getRetainedRoleIds returns the roles the user holds outside of the scope,
+// the ones kept when the roles of the scope are replaced
+func getRetainedRoleIds(conn runner.Connection, userId int64, sc *Scope) ([]int64, error) {
+	ids := make([]int64, 0)
+	sclause, sargs := scopeClause("auth_user_role", sc, 1)
+	err := conn.Select("auth_role_id").
+		From("auth_user_role").
+		Where(
+			fmt.Sprintf("auth_user_id = $1 AND (%s) IS NOT TRUE", sclause),
+			append([]interface{}{userId}, sargs...)...,
+		).
+		QuerySlice(&ids)
+	return ids, err
+}
+
+// lockUserRoles locks the users and their role assignments until the end of
+// the transaction, so that concurrent changes cannot together give a user
+// conflicting roles. The user row is locked too as a user without any role
+// has no assignment to lock. The users are locked in order of their ids to
+// avoid deadlocks.
+func lockUserRoles(conn runner.Connection, userIds ...int64) error {
+	ids := append([]int64{}, userIds...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		_, err := conn.SQL(`
+			SELECT auth_user_id FROM auth_user
+			WHERE auth_user_id = $1
+			FOR UPDATE`, id).
+			Exec()
+		if err != nil {
+			return err
+		}
+		_, err = conn.SQL(`
+			SELECT auth_user_role_id FROM auth_user_role
+			WHERE auth_user_id = $1
+			FOR UPDATE`, id).
+			Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dataToIds(data []*jsonapi.Data) []int64 {
+	var ids []int64
+	for _, d := range data {
+		ids = append(ids, d.Id)
+	}
+	return ids
+}
+
+// findRoleConflicts returns the constraints that are violated by holding
+// all of the given roles together
+func findRoleConflicts(constraints []*dbRoleConstraint, roleIds []int64) []*dbRoleConstraint {
+	held := make(map[int64]bool)
+	for _, id := range roleIds {
+		held[id] = true
+	}
+	var conflicts []*dbRoleConstraint
+	for _, c := range constraints {
+		if held[c.AuthRoleId] && held[c.ConflictingRoleId] {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// conflictPair orders the roles of a constraint as the requested one and
+// the one it clashes with. The pair is stored in a canonical order, which
+// need not be the order of the request.
+func conflictPair(c *dbRoleConstraint, requested map[int64]bool) (string, int64, string) {
+	if requested[c.AuthRoleId] {
+		return c.Role, c.ConflictingRoleId, c.ConflictingRole
+	}
+	return c.ConflictingRole, c.AuthRoleId, c.Role
+}
+
+// roleConflictError reports the violated constraints, the subject of every
+// violation is the role that clashes with the requested one
+func roleConflictError(conflicts []*dbRoleConstraint, requestedIds []int64) error {
+	requested := make(map[int64]bool)
+	for _, id := range requestedIds {
+		requested[id] = true
+	}
+	pf := &errdetails.PreconditionFailure{}
+	for _, c := range conflicts {
+		role, otherId, other := conflictPair(c, requested)
+		pf.Violations = append(pf.Violations, &errdetails.PreconditionFailure_Violation{
+			Type:        RoleConflictViolation,
+			Subject:     fmt.Sprintf("roles/%d", otherId),
+			Description: fmt.Sprintf("role %s cannot be held along with role %s", role, other),
+		})
+	}
+	role, _, other := conflictPair(conflicts[0], requested)
+	st := status.Newf(
+		codes.FailedPrecondition,
+		"role %s conflicts with role %s", role, other,
+	)
+	dst, err := st.WithDetails(pf)
+	if err != nil {
+		return st.Err()
+	}
+	return dst.Err()
+}
+
+// checkRoleConstraints verifies that a single user can hold the requested
+// roles along with the held ones
+func checkRoleConstraints(ctx context.Context, conn runner.Connection, held, requested []int64) error {
+	roleIds := append(append([]int64{}, held...), requested...)
+	if len(roleIds) < 2 {
+		return nil
+	}
+	constraints, err := getRoleConstraints(conn)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if conflicts := findRoleConflicts(constraints, roleIds); len(conflicts) > 0 {
+		return roleConflictError(conflicts, requested)
+	}
+	return nil
+}
+
+// checkUserRoleConstraints verifies that the user can be given the new roles
+// in addition to the ones already held. It runs in the transaction of the
+// change and locks the roles of the user until it ends.
+func checkUserRoleConstraints(ctx context.Context, conn runner.Connection, userId int64, roleIds []int64) error {
+	if err := lockUserRoles(conn, userId); err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	existing, err := getUserRoleIds(conn, userId)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	return checkRoleConstraints(ctx, conn, existing, roleIds)
+}
+
+// checkReplacedRoleConstraints verifies that the roles of the user within
+// the scope can be replaced by the new ones, given the roles kept outside
+// of it. It runs in the transaction of the change and locks the roles of the
+// user until it ends.
+func checkReplacedRoleConstraints(ctx context.Context, conn runner.Connection, userId int64, sc *Scope, roleIds []int64) error {
+	if err := lockUserRoles(conn, userId); err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	retained, err := getRetainedRoleIds(conn, userId, sc)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	return checkRoleConstraints(ctx, conn, retained, roleIds)
+}
+
+// checkMembersRoleConstraints verifies that every user can be given the role
+// in addition to the ones already held. It runs in the transaction of the
+// change and locks the roles of the users until it ends.
+func checkMembersRoleConstraints(ctx context.Context, conn runner.Connection, roleId int64, userIds []int64) error {
+	if err := lockUserRoles(conn, userIds...); err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	constraints, err := getRoleConstraints(conn)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if len(constraints) == 0 {
+		return nil
+	}
+	for _, uid := range userIds {
+		existing, err := getUserRoleIds(conn, uid)
+		if err != nil {
+			return aphgrpc.HandleError(ctx, err)
+		}
+		if conflicts := findRoleConflicts(constraints, append(existing, roleId)); len(conflicts) > 0 {
+			return roleConflictError(conflicts, []int64{roleId})
+		}
+	}
+	return nil
+}
+
+// -- HTTP handlers
+
+func (s *RoleService) listConstraintsHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	coll, err := s.ListRoleConstraints(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *RoleService) createConstraintHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	nc := &NewRoleConstraint{}
+	if err := readJSON(r, nc); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	rc, err := s.CreateRoleConstraint(r.Context(), nc)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rc)
+}
+
+func (s *RoleService) deleteConstraintHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if _, err := s.DeleteRoleConstraint(r.Context(), &jsonapi.DeleteRequest{Id: id}); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *RoleService) listViolationsHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	rpt, err := s.ListRoleViolations(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rpt)
+}
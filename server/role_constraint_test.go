@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestRoleConstraint(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	rclient := pb.NewRoleServiceClient(conn)
+	submitter, err := rclient.CreateRole(context.Background(), NewRole("stock-order-submitter"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	approver, err := rclient.CreateRole(context.Background(), NewRole("stock-order-approver"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	uclient := pb.NewUserServiceClient(conn)
+	usr, err := uclient.CreateUser(context.Background(), NewUserWithRole("submitter@gmail.com", submitter))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	// assigned before the constraint exists
+	other, err := uclient.CreateUser(context.Background(), NewUserWithRole("both@gmail.com", submitter))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	_, err = uclient.CreateRoleRelationship(
+		context.Background(),
+		&jsonapi.DataCollection{Id: other.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: approver.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not create the relationship with role %s\n", err)
+	}
+	sctx := metadata.AppendToOutgoingContext(
+		context.Background(),
+		ScopeResourceTypeKey, "strain_collection",
+		ScopeResourceIdKey, "12",
+	)
+	_, err = uclient.CreateRoleRelationship(
+		sctx,
+		&jsonapi.DataCollection{Id: other.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: approver.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("could not create the scoped relationship with role %s\n", err)
+	}
+
+	s := NewRoleService(runner.NewDB(db, "postgres"))
+	rc, err := s.CreateRoleConstraint(
+		context.Background(),
+		&NewRoleConstraint{RoleId: approver.Data.Id, ConflictingRoleId: submitter.Data.Id},
+	)
+	if err != nil {
+		t.Fatalf("could not create the role constraint %s\n", err)
+	}
+	if rc.Data.Attributes.Role != "stock-order-submitter" && rc.Data.Attributes.ConflictingRole != "stock-order-submitter" {
+		t.Fatalf("expected constraint with stock-order-submitter, received %+v\n", rc.Data.Attributes)
+	}
+	_, err = s.CreateRoleConstraint(
+		context.Background(),
+		&NewRoleConstraint{RoleId: submitter.Data.Id, ConflictingRoleId: approver.Data.Id},
+	)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for the reversed constraint, received %s\n", err)
+	}
+
+	_, err = uclient.CreateRoleRelationship(
+		context.Background(),
+		&jsonapi.DataCollection{Id: usr.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: approver.Data.Id}}},
+	)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, received %s\n", err)
+	}
+	var found bool
+	for _, d := range status.Convert(err).Details() {
+		if pf, ok := d.(*errdetails.PreconditionFailure); ok {
+			for _, v := range pf.Violations {
+				if v.Type == RoleConflictViolation {
+					found = true
+					if v.Subject != fmt.Sprintf("roles/%d", submitter.Data.Id) {
+						t.Fatalf("expected the held role as the subject, received %s\n", v.Subject)
+					}
+				}
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected role conflict violation in the error details")
+	}
+	_, err = uclient.UpdateRoleRelationship(
+		sctx,
+		&jsonapi.DataCollection{Id: usr.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: approver.Data.Id}}},
+	)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition for the scoped role along with the global one, received %s\n", err)
+	}
+	_, err = rclient.CreateUserRelationship(
+		context.Background(),
+		&jsonapi.DataCollection{Id: approver.Data.Id, Data: []*jsonapi.Data{{Type: "users", Id: usr.Data.Id}}},
+	)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, received %s\n", err)
+	}
+	_, err = rclient.CreateRole(context.Background(), NewRoleWithUser("reviewer", usr))
+	if err != nil {
+		t.Fatalf("expected a role without constraints to be assigned %s\n", err)
+	}
+
+	rpt, err := s.ListRoleViolations(context.Background())
+	if err != nil {
+		t.Fatalf("could not list the violations %s\n", err)
+	}
+	if len(rpt.Data) != 1 {
+		t.Fatalf("expected 1 violation, received %d\n", len(rpt.Data))
+	}
+	if rpt.Data[0].UserId != other.Data.Id {
+		t.Fatalf("expected violation for user %d, received %d\n", other.Data.Id, rpt.Data[0].UserId)
+	}
+	if _, err := s.DeleteRoleConstraint(context.Background(), &jsonapi.DeleteRequest{Id: rc.Data.Id}); err != nil {
+		t.Fatalf("could not delete the constraint %s\n", err)
+	}
+	_, err = uclient.CreateRoleRelationship(
+		context.Background(),
+		&jsonapi.DataCollection{Id: usr.Data.Id, Data: []*jsonapi.Data{{Type: "roles", Id: approver.Data.Id}}},
+	)
+	if err != nil {
+		t.Fatalf("expected assignment after removing the constraint %s\n", err)
+	}
+}
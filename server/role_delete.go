@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// Metadata keys that control the deletion of a role that is still in use.
+// Through the HTTP gateway they are given as the force and reassign_to
+// query parameters of DELETE /roles/{id}.
+const (
+	DeleteForceKey      = "delete-force"
+	DeleteReassignToKey = "delete-reassign-to"
+)
+
+// RoleInUseViolation is the precondition failure type for every user
+// that still holds a role being deleted
+const RoleInUseViolation = "ROLE_IN_USE"
+
+// RoleDeletion removes a role. A role with members or permissions is only
+// removed with Force or when its members are moved to the ReassignTo role.
+type RoleDeletion struct {
+	Id         int64
+	Force      bool
+	ReassignTo int64
+}
+
+// RoleDeletionResult lists the users that held the deleted role
+type RoleDeletionResult struct {
+	Id         int64       `json:"id"`
+	ReassignTo int64       `json:"reassign_to,omitempty"`
+	Users      []*BulkUser `json:"users"`
+}
+
+// DeleteRoleWithOptions removes a role that is not protected. The removal
+// is refused with the list of affected users if the role is still in use
+// and neither force nor reassign is given.
+func (s *RoleService) DeleteRoleWithOptions(ctx context.Context, r *RoleDeletion) (*RoleDeletionResult, error) {
+	tx, err := s.Dbh.Begin()
+	if err != nil {
+		return &RoleDeletionResult{}, aphgrpc.HandleError(ctx, err)
+	}
+	defer tx.AutoRollback()
+	// the role stays locked until it is removed, so that no member or
+	// permission is added to it after the checks
+	var id int64
+	err = tx.SQL(`
+		SELECT auth_role_id FROM auth_role
+		WHERE auth_role_id = $1
+		FOR UPDATE`, r.Id).
+		QueryScalar(&id)
+	if err == sql.ErrNoRows {
+		return &RoleDeletionResult{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", r.Id))
+	}
+	if err != nil {
+		return &RoleDeletionResult{}, aphgrpc.HandleError(ctx, err)
+	}
+	if err := roleProtection.check(ctx, tx, r.Id, "deleted"); err != nil {
+		return &RoleDeletionResult{}, err
+	}
+	users, err := roleMembers(tx, r.Id)
+	if err != nil {
+		return &RoleDeletionResult{}, aphgrpc.HandleError(ctx, err)
+	}
+	var pcount int64
+	err = tx.Select("COUNT(*)").
+		From("auth_role_permission").
+		Where("auth_role_id = $1", r.Id).
+		QueryScalar(&pcount)
+	if err != nil {
+		return &RoleDeletionResult{}, aphgrpc.HandleError(ctx, err)
+	}
+	if (len(users) > 0 || pcount > 0) && !r.Force && r.ReassignTo == 0 {
+		return &RoleDeletionResult{}, roleInUseError(r.Id, users, pcount)
+	}
+	if r.ReassignTo != 0 {
+		if err := validateReassign(ctx, tx, r, users); err != nil {
+			return &RoleDeletionResult{}, err
+		}
+	}
+	affected, aerr := s.cache.roleUsers(tx, r.Id)
+	au, err := newAudit(ctx, tx, roleRPC("DeleteRole"), "roles", r.Id)
+	if err != nil {
+		return &RoleDeletionResult{}, err
+	}
+	for _, u := range users {
+		au.relate("users", u.Id)
+	}
+	if r.ReassignTo != 0 {
+		au.relate("roles", r.ReassignTo)
+		var reassigned []*assignmentChange
+		err := tx.SQL(`
+			INSERT INTO auth_user_role(auth_user_id, auth_role_id, resource_type, resource_id)
+			SELECT ur.auth_user_id, $2, ur.resource_type, ur.resource_id
+			FROM auth_user_role ur
+			WHERE ur.auth_role_id = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM auth_user_role ex
+				WHERE ex.auth_user_id = ur.auth_user_id
+				AND ex.auth_role_id = $2
+				AND ex.resource_type IS NOT DISTINCT FROM ur.resource_type
+				AND ex.resource_id IS NOT DISTINCT FROM ur.resource_id
+			)
+			RETURNING auth_user_id, auth_role_id, resource_type, resource_id`, r.Id, r.ReassignTo).
+			QueryStructs(&reassigned)
+		if err != nil {
+			return &RoleDeletionResult{}, aphgrpc.HandleUpdateError(ctx, err)
+		}
+		au.assign(reassigned...)
+		_, err = tx.SQL(`
+			INSERT INTO auth_service_account_role(auth_service_account_id, auth_role_id, resource_type, resource_id)
+			SELECT sr.auth_service_account_id, $2, sr.resource_type, sr.resource_id
+			FROM auth_service_account_role sr
+			WHERE sr.auth_role_id = $1
+			ON CONFLICT DO NOTHING`, r.Id, r.ReassignTo).Exec()
+		if err != nil {
+			return &RoleDeletionResult{}, aphgrpc.HandleUpdateError(ctx, err)
+		}
+	}
+	for _, tbl := range []string{"auth_user_role", "auth_role_permission", roleDbTable} {
+		_, err := tx.DeleteFrom(tbl).Where("auth_role_id = $1", r.Id).Exec()
+		if err != nil {
+			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
+			return &RoleDeletionResult{}, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := au.record(tx); err != nil {
+		return &RoleDeletionResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
+		return &RoleDeletionResult{}, status.Error(codes.Internal, err.Error())
+	}
+	s.cache.invalidateUsers(affected, aerr)
+	return &RoleDeletionResult{Id: r.Id, ReassignTo: r.ReassignTo, Users: users}, nil
+}
+
+func validateReassign(ctx context.Context, tx *runner.Tx, r *RoleDeletion, users []*BulkUser) error {
+	if r.ReassignTo == r.Id {
+		return status.Error(codes.InvalidArgument, "role cannot be reassigned to itself")
+	}
+	var count int64
+	err := tx.Select("COUNT(*)").
+		From(roleDbTable).
+		Where("auth_role_id = $1", r.ReassignTo).
+		QueryScalar(&count)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if count == 0 {
+		return aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", r.ReassignTo))
+	}
+	var ids []int64
+	for _, u := range users {
+		ids = append(ids, u.Id)
+	}
+	return checkMembersRoleConstraints(ctx, tx, r.ReassignTo, ids)
+}
+
+// deleteOptionsFromContext reads the deletion options from the incoming
+// metadata
+func deleteOptionsFromContext(ctx context.Context, r *RoleDeletion) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	if v := md.Get(DeleteForceKey); len(v) > 0 {
+		force, err := strconv.ParseBool(v[0])
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid %s %s", DeleteForceKey, v[0])
+		}
+		r.Force = force
+	}
+	if v := md.Get(DeleteReassignToKey); len(v) > 0 {
+		id, err := strconv.ParseInt(v[0], 10, 64)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid %s %s", DeleteReassignToKey, v[0])
+		}
+		r.ReassignTo = id
+	}
+	return nil
+}
+
+// roleMembers returns the distinct users holding the role in any scope
+func roleMembers(conn runner.Connection, roleId int64) ([]*BulkUser, error) {
+	var dbrows []*dbBulkUser
+	err := conn.SQL(`
+		SELECT DISTINCT auth_user.auth_user_id, CAST(auth_user.email AS TEXT) email
+		FROM auth_user
+		JOIN auth_user_role
+		ON auth_user.auth_user_id = auth_user_role.auth_user_id
+		WHERE auth_user_role.auth_role_id = $1
+		ORDER BY auth_user.auth_user_id`, roleId).
+		QueryStructs(&dbrows)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*BulkUser, 0)
+	for _, d := range dbrows {
+		users = append(users, &BulkUser{Id: d.AuthUserId, Email: d.Email})
+	}
+	return users, nil
+}
+
+func roleInUseError(id int64, users []*BulkUser, pcount int64) error {
+	pf := &errdetails.PreconditionFailure{}
+	for _, u := range users {
+		pf.Violations = append(pf.Violations, &errdetails.PreconditionFailure_Violation{
+			Type:        RoleInUseViolation,
+			Subject:     fmt.Sprintf("users/%d", u.Id),
+			Description: fmt.Sprintf("user %s holds the role", u.Email),
+		})
+	}
+	st := status.Newf(
+		codes.FailedPrecondition,
+		"role %d has %d users and %d permissions, use force or reassign to delete it",
+		id, len(users), pcount,
+	)
+	dst, err := st.WithDetails(pf)
+	if err != nil {
+		return st.Err()
+	}
+	return dst.Err()
+}
+
+// -- HTTP handlers
+
+func (s *RoleService) deleteRoleHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	del := &RoleDeletion{Id: id}
+	if v := r.URL.Query().Get("force"); len(v) > 0 {
+		force, err := strconv.ParseBool(v)
+		if err != nil {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid force %s", v))
+			return
+		}
+		del.Force = force
+	}
+	del.ReassignTo, err = queryParamToID(r, "reassign_to")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	res, err := s.DeleteRoleWithOptions(r.Context(), del)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,765 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/modware-user/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const (
+	roleReqDbTable        = "auth_role_request"
+	roleReqCommentDbTable = "auth_role_request_comment"
+	roleApproverDbTable   = "auth_role_approver"
+)
+
+// States of a role request
+const (
+	RoleRequestPending  = "pending"
+	RoleRequestApproved = "approved"
+	RoleRequestDenied   = "denied"
+)
+
+var roleReqCols = []string{
+	"auth_role_request_id",
+	"auth_user_id",
+	"auth_role_id",
+	"justification",
+	"state",
+	"decided_by",
+	"decision_comment",
+	"decided_at",
+	"created_at",
+	"updated_at",
+}
+
+type dbRoleRequest struct {
+	AuthRoleRequestId int64          `db:"auth_role_request_id"`
+	AuthUserId        int64          `db:"auth_user_id"`
+	AuthRoleId        int64          `db:"auth_role_id"`
+	Justification     string         `db:"justification"`
+	State             string         `db:"state"`
+	DecidedBy         dat.NullInt64  `db:"decided_by"`
+	DecisionComment   dat.NullString `db:"decision_comment"`
+	DecidedAt         dat.NullTime   `db:"decided_at"`
+	CreatedAt         dat.NullTime   `db:"created_at"`
+	UpdatedAt         dat.NullTime   `db:"updated_at"`
+}
+
+type dbRoleRequestComment struct {
+	AuthRoleRequestCommentId int64         `db:"auth_role_request_comment_id"`
+	AuthRoleRequestId        int64         `db:"auth_role_request_id"`
+	AuthUserId               dat.NullInt64 `db:"auth_user_id"`
+	Comment                  string        `db:"comment"`
+	CreatedAt                dat.NullTime  `db:"created_at"`
+}
+
+// RoleRequestComment is a remark added to a role request
+type RoleRequestComment struct {
+	UserId    int64     `json:"user_id"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RoleRequestAttributes are the attributes of a role request resource
+type RoleRequestAttributes struct {
+	UserId          int64                 `json:"user_id"`
+	RoleId          int64                 `json:"role_id"`
+	Justification   string                `json:"justification"`
+	State           string                `json:"state"`
+	DecidedBy       int64                 `json:"decided_by,omitempty"`
+	DecisionComment string                `json:"decision_comment,omitempty"`
+	DecidedAt       *time.Time            `json:"decided_at,omitempty"`
+	CreatedAt       time.Time             `json:"created_at"`
+	UpdatedAt       time.Time             `json:"updated_at"`
+	Comments        []*RoleRequestComment `json:"comments,omitempty"`
+}
+
+// RoleRequestData is the primary data of a role request resource
+type RoleRequestData struct {
+	Type       string                 `json:"type"`
+	Id         int64                  `json:"id"`
+	Attributes *RoleRequestAttributes `json:"attributes"`
+	Links      *jsonapi.Links         `json:"links"`
+}
+
+// RoleRequest is a request of an user to be granted a role
+type RoleRequest struct {
+	Data *RoleRequestData `json:"data"`
+}
+
+// RoleRequestCollection is a list of role requests
+type RoleRequestCollection struct {
+	Data  []*RoleRequestData `json:"data"`
+	Links *jsonapi.Links     `json:"links"`
+}
+
+// NewRoleRequest contains the attributes for creating a role request, the
+// requesting user is the authenticated one
+type NewRoleRequest struct {
+	RoleId        int64  `json:"role_id"`
+	Justification string `json:"justification"`
+}
+
+// RoleRequestDecision is the approval or denial of a pending role request,
+// the approver is the authenticated user
+type RoleRequestDecision struct {
+	Id      int64  `json:"-"`
+	Comment string `json:"comment"`
+}
+
+// NewRoleRequestComment contains the attributes for commenting on a role
+// request, the commenter is the authenticated user
+type NewRoleRequestComment struct {
+	Id      int64  `json:"-"`
+	Comment string `json:"comment"`
+}
+
+// RoleRequestFilter restricts the listing of role requests, the zero values
+// are ignored
+type RoleRequestFilter struct {
+	State  string
+	UserId int64
+	RoleId int64
+}
+
+// RoleApprovers are the roles whose members may decide requests for a role
+type RoleApprovers struct {
+	Id      int64   `json:"-"`
+	RoleIds []int64 `json:"approver_role_ids"`
+}
+
+type RoleRequestService struct {
+	*aphgrpc.Service
+	cache *PermissionCache
+}
+
+func roleRequestServiceOptions() *aphgrpc.ServiceOptions {
+	return &aphgrpc.ServiceOptions{
+		Resource:   "role_requests",
+		PathPrefix: "role_requests",
+	}
+}
+
+func NewRoleRequestService(dbh *runner.DB, opt ...aphgrpc.Option) *RoleRequestService {
+	so := roleRequestServiceOptions()
+	for _, optfn := range opt {
+		optfn(so)
+	}
+	srv := &aphgrpc.Service{Dbh: dbh}
+	aphgrpc.AssignFieldsToStructs(so, srv)
+	return &RoleRequestService{Service: srv}
+}
+
+// WithPermissionCache sets the cache of permission grants that is
+// invalidated when a role request is approved
+func (s *RoleRequestService) WithPermissionCache(c *PermissionCache) *RoleRequestService {
+	s.cache = c
+	return s
+}
+
+// HTTPRoutes returns the role request endpoints
+func (s *RoleRequestService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/role_requests", Handler: s.listHandler},
+		{Method: "POST", Path: "/role_requests", Handler: s.createHandler},
+		{Method: "GET", Path: "/role_requests/{id}", Handler: s.getHandler},
+		{Method: "POST", Path: "/role_requests/{id}/comments", Handler: s.commentHandler},
+		{Method: "POST", Path: "/role_requests/{id}/approve", Handler: s.approveHandler},
+		{Method: "POST", Path: "/role_requests/{id}/deny", Handler: s.denyHandler},
+		{Method: "GET", Path: "/roles/{id}/approvers", Handler: s.getApproversHandler},
+		{Method: "PUT", Path: "/roles/{id}/approvers", Handler: s.setApproversHandler},
+	}
+}
+
+func (s *RoleRequestService) CreateRoleRequest(ctx context.Context, r *NewRoleRequest) (*RoleRequest, error) {
+	userId, err := currentUserId(ctx)
+	if err != nil {
+		return &RoleRequest{}, err
+	}
+	if len(r.Justification) == 0 {
+		return &RoleRequest{}, aphgrpc.HandleInsertArgError(ctx, fmt.Errorf("justification is required"))
+	}
+	if err := s.checkUserAndRole(ctx, userId, r.RoleId); err != nil {
+		return &RoleRequest{}, err
+	}
+	held, err := hasRole(s.Dbh, userId, r.RoleId)
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+	}
+	if held {
+		return &RoleRequest{}, aphgrpc.HandleExistError(
+			ctx,
+			fmt.Errorf("user %d already has role %d", userId, r.RoleId),
+		)
+	}
+	pending, err := s.hasPendingRequest(userId, r.RoleId)
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+	}
+	if pending {
+		return &RoleRequest{}, aphgrpc.HandleExistError(
+			ctx,
+			fmt.Errorf("user %d already has a pending request for role %d", userId, r.RoleId),
+		)
+	}
+	tx, au, err := beginAudit(
+		ctx, s.Dbh, "/dictybase.user.RoleRequestService/CreateRoleRequest",
+		"role_requests", 0,
+	)
+	if err != nil {
+		return &RoleRequest{}, err
+	}
+	defer tx.AutoRollback()
+	dbreq := &dbRoleRequest{}
+	err = tx.InsertInto(roleReqDbTable).
+		Columns("auth_user_id", "auth_role_id", "justification", "state").
+		Values(userId, r.RoleId, r.Justification, RoleRequestPending).
+		Returning(roleReqCols...).
+		QueryStruct(dbreq)
+	if err != nil {
+		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
+		return &RoleRequest{}, status.Error(codes.Internal, err.Error())
+	}
+	au.created(dbreq.AuthRoleRequestId).relate("users", userId).relate("roles", r.RoleId)
+	if err := au.commit(tx); err != nil {
+		return &RoleRequest{}, err
+	}
+	return s.buildResource(dbreq, nil), nil
+}
+
+func (s *RoleRequestService) GetRoleRequest(ctx context.Context, r *jsonapi.IdRequest) (*RoleRequest, error) {
+	dbreq, err := s.getRow(r.Id)
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+	}
+	comments, err := s.getComments(r.Id)
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+	}
+	return s.buildResource(dbreq, comments), nil
+}
+
+func (s *RoleRequestService) ListRoleRequests(ctx context.Context, r *RoleRequestFilter) (*RoleRequestCollection, error) {
+	var dbrows []*dbRoleRequest
+	b := s.Dbh.Select(roleReqCols...).From(roleReqDbTable)
+	where := dat.M{}
+	if len(r.State) > 0 {
+		where["state"] = r.State
+	}
+	if r.UserId > 0 {
+		where["auth_user_id"] = r.UserId
+	}
+	if r.RoleId > 0 {
+		where["auth_role_id"] = r.RoleId
+	}
+	if len(where) > 0 {
+		b = b.Where(where)
+	}
+	err := b.OrderBy("auth_role_request_id").QueryStructs(&dbrows)
+	if err != nil {
+		return &RoleRequestCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	rdata := make([]*RoleRequestData, 0)
+	for _, dbreq := range dbrows {
+		rdata = append(rdata, s.buildResourceData(dbreq, nil))
+	}
+	return &RoleRequestCollection{
+		Data:  rdata,
+		Links: &jsonapi.Links{Self: aphgrpc.GenMultiResourceLink(s)},
+	}, nil
+}
+
+func (s *RoleRequestService) CommentRoleRequest(ctx context.Context, r *NewRoleRequestComment) (*RoleRequest, error) {
+	userId, err := currentUserId(ctx)
+	if err != nil {
+		return &RoleRequest{}, err
+	}
+	if len(r.Comment) == 0 {
+		return &RoleRequest{}, aphgrpc.HandleInsertArgError(ctx, fmt.Errorf("comment is required"))
+	}
+	dbreq, err := s.getRow(r.Id)
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+	}
+	if err := s.checkCommenter(ctx, userId, dbreq); err != nil {
+		return &RoleRequest{}, err
+	}
+	tx, au, err := beginAudit(
+		ctx, s.Dbh, "/dictybase.user.RoleRequestService/CommentRoleRequest",
+		"role_requests", r.Id,
+	)
+	if err != nil {
+		return &RoleRequest{}, err
+	}
+	defer tx.AutoRollback()
+	_, err = tx.InsertInto(roleReqCommentDbTable).
+		Columns("auth_role_request_id", "auth_user_id", "comment").
+		Values(r.Id, userId, r.Comment).
+		Exec()
+	if err != nil {
+		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
+		return &RoleRequest{}, status.Error(codes.Internal, err.Error())
+	}
+	if err := au.relate("users", userId).commit(tx); err != nil {
+		return &RoleRequest{}, err
+	}
+	return s.GetRoleRequest(ctx, &jsonapi.IdRequest{Id: r.Id})
+}
+
+// ApproveRoleRequest approves a pending request and grants the role to the
+// requesting user
+func (s *RoleRequestService) ApproveRoleRequest(ctx context.Context, r *RoleRequestDecision) (*RoleRequest, error) {
+	return s.decide(ctx, r, RoleRequestApproved)
+}
+
+// DenyRoleRequest denies a pending request
+func (s *RoleRequestService) DenyRoleRequest(ctx context.Context, r *RoleRequestDecision) (*RoleRequest, error) {
+	return s.decide(ctx, r, RoleRequestDenied)
+}
+
+func (s *RoleRequestService) GetRoleApprovers(ctx context.Context, r *jsonapi.IdRequest) (*RoleApprovers, error) {
+	ra := &RoleApprovers{Id: r.Id, RoleIds: make([]int64, 0)}
+	err := s.Dbh.Select("approver_role_id").
+		From(roleApproverDbTable).
+		Where("auth_role_id = $1", r.Id).
+		OrderBy("approver_role_id").
+		QuerySlice(&ra.RoleIds)
+	if err != nil {
+		return ra, aphgrpc.HandleError(ctx, err)
+	}
+	return ra, nil
+}
+
+// SetRoleApprovers replaces the approver roles of a role
+func (s *RoleRequestService) SetRoleApprovers(ctx context.Context, r *RoleApprovers) (*RoleApprovers, error) {
+	exists, err := NewRoleService(s.Dbh).existsResource(r.Id)
+	if err != nil {
+		return &RoleApprovers{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return &RoleApprovers{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", r.Id))
+	}
+	tx, au, err := beginAudit(
+		ctx, s.Dbh, "/dictybase.user.RoleRequestService/SetRoleApprovers",
+		"roles", r.Id,
+	)
+	if err != nil {
+		return &RoleApprovers{}, err
+	}
+	defer tx.AutoRollback()
+	_, err = tx.DeleteFrom(roleApproverDbTable).Where("auth_role_id = $1", r.Id).Exec()
+	if err != nil {
+		return &RoleApprovers{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	for _, aid := range r.RoleIds {
+		_, err := tx.InsertInto(roleApproverDbTable).
+			Columns("auth_role_id", "approver_role_id").
+			Values(r.Id, aid).
+			Exec()
+		if err != nil {
+			return &RoleApprovers{}, aphgrpc.HandleUpdateError(ctx, err)
+		}
+	}
+	if err := au.relate("roles", r.RoleIds...).commit(tx); err != nil {
+		return &RoleApprovers{}, err
+	}
+	return s.GetRoleApprovers(ctx, &jsonapi.IdRequest{Id: r.Id})
+}
+
+func (s *RoleRequestService) decide(ctx context.Context, r *RoleRequestDecision, state string) (*RoleRequest, error) {
+	approverId, err := currentUserId(ctx)
+	if err != nil {
+		return &RoleRequest{}, err
+	}
+	dbreq, err := s.getRow(r.Id)
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+	}
+	if dbreq.AuthUserId == approverId {
+		return &RoleRequest{}, status.Error(codes.PermissionDenied, "users cannot decide their own role request")
+	}
+	ok, err := s.isApprover(approverId, dbreq.AuthRoleId)
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !ok {
+		return &RoleRequest{}, status.Errorf(
+			codes.PermissionDenied,
+			"user %d is not an approver for role %d", approverId, dbreq.AuthRoleId,
+		)
+	}
+	rpc := "/dictybase.user.RoleRequestService/DenyRoleRequest"
+	if state == RoleRequestApproved {
+		rpc = "/dictybase.user.RoleRequestService/ApproveRoleRequest"
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, rpc, "role_requests", r.Id)
+	if err != nil {
+		return &RoleRequest{}, err
+	}
+	defer tx.AutoRollback()
+	now := time.Now()
+	err = tx.Update(roleReqDbTable).
+		SetMap(map[string]interface{}{
+			"state":            state,
+			"decided_by":       approverId,
+			"decision_comment": dat.NullStringFrom(r.Comment),
+			"decided_at":       now,
+			"updated_at":       now,
+		}).
+		Where("auth_role_request_id = $1 AND state = $2", r.Id, RoleRequestPending).
+		Returning(roleReqCols...).
+		QueryStruct(dbreq)
+	if err == sql.ErrNoRows {
+		// decided since it was read, the state is read again to report
+		// the decision that was made
+		var current string
+		err := tx.Select("state").
+			From(roleReqDbTable).
+			Where("auth_role_request_id = $1", r.Id).
+			QueryScalar(&current)
+		if err != nil {
+			return &RoleRequest{}, aphgrpc.HandleError(ctx, err)
+		}
+		return &RoleRequest{}, status.Errorf(
+			codes.FailedPrecondition,
+			"role request %d is already %s", r.Id, current,
+		)
+	}
+	if err != nil {
+		return &RoleRequest{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	if state == RoleRequestApproved {
+		held, err := hasRole(tx, dbreq.AuthUserId, dbreq.AuthRoleId)
+		if err != nil {
+			return &RoleRequest{}, aphgrpc.HandleUpdateError(ctx, err)
+		}
+		if !held {
+			err := checkUserRoleConstraints(ctx, tx, dbreq.AuthUserId, []int64{dbreq.AuthRoleId})
+			if err != nil {
+				return &RoleRequest{}, err
+			}
+			_, err = tx.InsertInto("auth_user_role").
+				Columns("auth_user_id", "auth_role_id").
+				Values(dbreq.AuthUserId, dbreq.AuthRoleId).
+				Exec()
+			if err != nil {
+				return &RoleRequest{}, aphgrpc.HandleInsertError(ctx, err)
+			}
+			// the granted role is an assignment of the user like any
+			// other
+			au.assign(&assignmentChange{UserId: dbreq.AuthUserId, RoleId: dbreq.AuthRoleId})
+		}
+	}
+	au.relate("users", dbreq.AuthUserId).relate("roles", dbreq.AuthRoleId)
+	if err := au.commit(tx); err != nil {
+		return &RoleRequest{}, err
+	}
+	if state == RoleRequestApproved {
+		s.cache.invalidate(dbreq.AuthUserId)
+	}
+	return s.GetRoleRequest(ctx, &jsonapi.IdRequest{Id: r.Id})
+}
+
+// All helper functions
+
+func (s *RoleRequestService) checkUserAndRole(ctx context.Context, userId, roleId int64) error {
+	exists, err := NewUserService(s.Dbh).existsResource(userId)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("user id %d not found", userId))
+	}
+	exists, err = NewRoleService(s.Dbh).existsResource(roleId)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if !exists {
+		return aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", roleId))
+	}
+	return nil
+}
+
+func (s *RoleRequestService) hasPendingRequest(userId, roleId int64) (bool, error) {
+	var count int64
+	err := s.Dbh.Select("COUNT(*)").
+		From(roleReqDbTable).
+		Where("auth_user_id = $1 AND auth_role_id = $2 AND state = $3", userId, roleId, RoleRequestPending).
+		QueryScalar(&count)
+	return count > 0, err
+}
+
+func (s *RoleRequestService) isApprover(userId, roleId int64) (bool, error) {
+	var count int64
+	err := s.Dbh.Select("COUNT(*)").
+		From(`
+			auth_role_approver approver
+			JOIN auth_user_role
+			ON auth_user_role.auth_role_id = approver.approver_role_id
+		`).
+		Where(
+			"approver.auth_role_id = $1 AND auth_user_role.auth_user_id = $2 AND auth_user_role.resource_type IS NULL",
+			roleId, userId,
+		).
+		QueryScalar(&count)
+	return count > 0, err
+}
+
+// checkCommenter allows the requesting user, the approvers of the role and
+// the users allowed to decide any request to comment on a request
+func (s *RoleRequestService) checkCommenter(ctx context.Context, userId int64, dbreq *dbRoleRequest) error {
+	if dbreq.AuthUserId == userId {
+		return nil
+	}
+	ok, err := s.isApprover(userId, dbreq.AuthRoleId)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if ok {
+		return nil
+	}
+	d, err := resolvePermission(s.Dbh, s.cache, &PermissionCheck{
+		UserId:     userId,
+		Permission: auth.VerbWrite,
+		Resource:   "role_requests",
+	})
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if !d.Allowed {
+		return status.Errorf(
+			codes.PermissionDenied,
+			"user %d can only comment on its own role requests", userId,
+		)
+	}
+	return nil
+}
+
+func (s *RoleRequestService) getRow(id int64) (*dbRoleRequest, error) {
+	dbreq := &dbRoleRequest{}
+	err := s.Dbh.Select(roleReqCols...).
+		From(roleReqDbTable).
+		Where("auth_role_request_id = $1", id).
+		QueryStruct(dbreq)
+	return dbreq, err
+}
+
+func (s *RoleRequestService) getComments(id int64) ([]*dbRoleRequestComment, error) {
+	var dbrows []*dbRoleRequestComment
+	err := s.Dbh.Select("*").
+		From(roleReqCommentDbTable).
+		Where("auth_role_request_id = $1", id).
+		OrderBy("auth_role_request_comment_id").
+		QueryStructs(&dbrows)
+	return dbrows, err
+}
+
+func (s *RoleRequestService) buildResourceData(dbreq *dbRoleRequest, comments []*dbRoleRequestComment) *RoleRequestData {
+	attr := &RoleRequestAttributes{
+		UserId:          dbreq.AuthUserId,
+		RoleId:          dbreq.AuthRoleId,
+		Justification:   dbreq.Justification,
+		State:           dbreq.State,
+		DecidedBy:       aphgrpc.NullToInt64(dbreq.DecidedBy),
+		DecisionComment: aphgrpc.NullToString(dbreq.DecisionComment),
+		CreatedAt:       dbreq.CreatedAt.Time,
+		UpdatedAt:       dbreq.UpdatedAt.Time,
+	}
+	if dbreq.DecidedAt.Valid {
+		attr.DecidedAt = &dbreq.DecidedAt.Time
+	}
+	for _, c := range comments {
+		attr.Comments = append(attr.Comments, &RoleRequestComment{
+			UserId:    aphgrpc.NullToInt64(c.AuthUserId),
+			Comment:   c.Comment,
+			CreatedAt: c.CreatedAt.Time,
+		})
+	}
+	return &RoleRequestData{
+		Type:       s.GetResourceName(),
+		Id:         dbreq.AuthRoleRequestId,
+		Attributes: attr,
+		Links: &jsonapi.Links{
+			Self: aphgrpc.GenSingleResourceLink(s, dbreq.AuthRoleRequestId),
+		},
+	}
+}
+
+func (s *RoleRequestService) buildResource(dbreq *dbRoleRequest, comments []*dbRoleRequestComment) *RoleRequest {
+	return &RoleRequest{Data: s.buildResourceData(dbreq, comments)}
+}
+
+// hasRole checks if the user is already assigned to the role globally
+func hasRole(conn runner.Connection, userId, roleId int64) (bool, error) {
+	var count int64
+	err := conn.Select("COUNT(*)").
+		From("auth_user_role").
+		Where("auth_user_id = $1 AND auth_role_id = $2 AND resource_type IS NULL", userId, roleId).
+		QueryScalar(&count)
+	return count > 0, err
+}
+
+// roleApproverUserIds returns the users who can decide the requests for a
+// role
+func roleApproverUserIds(conn runner.Connection, roleId int64) ([]int64, error) {
+	ids := make([]int64, 0)
+	err := conn.Select("DISTINCT auth_user_role.auth_user_id").
+		From(`
+			auth_role_approver approver
+			JOIN auth_user_role
+			ON auth_user_role.auth_role_id = approver.approver_role_id
+		`).
+		Where("approver.auth_role_id = $1 AND auth_user_role.resource_type IS NULL", roleId).
+		OrderBy("auth_user_role.auth_user_id").
+		QuerySlice(&ids)
+	return ids, err
+}
+
+// -- HTTP handlers
+
+func (s *RoleRequestService) listHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	userId, err := queryParamToID(r, "user_id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	roleId, err := queryParamToID(r, "role_id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	coll, err := s.ListRoleRequests(r.Context(), &RoleRequestFilter{
+		State:  r.URL.Query().Get("state"),
+		UserId: userId,
+		RoleId: roleId,
+	})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *RoleRequestService) createHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	nr := &NewRoleRequest{}
+	if err := readJSON(r, nr); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	rr, err := s.CreateRoleRequest(r.Context(), nr)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rr)
+}
+
+func (s *RoleRequestService) getHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	rr, err := s.GetRoleRequest(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rr)
+}
+
+func (s *RoleRequestService) commentHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	nc := &NewRoleRequestComment{}
+	if err := readJSON(r, nc); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	nc.Id = id
+	rr, err := s.CommentRoleRequest(r.Context(), nc)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rr)
+}
+
+func (s *RoleRequestService) approveHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	s.decisionHandler(w, r, params, s.ApproveRoleRequest)
+}
+
+func (s *RoleRequestService) denyHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	s.decisionHandler(w, r, params, s.DenyRoleRequest)
+}
+
+func (s *RoleRequestService) decisionHandler(
+	w http.ResponseWriter, r *http.Request, params map[string]string,
+	fn func(context.Context, *RoleRequestDecision) (*RoleRequest, error),
+) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	d := &RoleRequestDecision{}
+	if err := readJSON(r, d); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	d.Id = id
+	rr, err := fn(r.Context(), d)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rr)
+}
+
+func (s *RoleRequestService) getApproversHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	ra, err := s.GetRoleApprovers(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ra)
+}
+
+func (s *RoleRequestService) setApproversHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	ra := &RoleApprovers{}
+	if err := readJSON(r, ra); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	ra.Id = id
+	ra, err = s.SetRoleApprovers(r.Context(), ra)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ra)
+}
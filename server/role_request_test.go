@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/message/event"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// lastStoredEvent decodes into ev the latest event of its type stored in
+// the outbox and returns the number of such events
+func lastStoredEvent(t *testing.T, ev proto.Message) int {
+	rows, err := db.Query(
+		"SELECT payload FROM auth_event_outbox WHERE event_type = $1 ORDER BY auth_event_outbox_id",
+		string(ev.ProtoReflect().Descriptor().FullName()),
+	)
+	if err != nil {
+		t.Fatalf("could not read the outbox %s\n", err)
+	}
+	defer rows.Close()
+	var payload []byte
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(&payload); err != nil {
+			t.Fatalf("could not read the outbox %s\n", err)
+		}
+		count++
+	}
+	if count > 0 {
+		if err := proto.Unmarshal(payload, ev); err != nil {
+			t.Fatalf("could not decode the event %s\n", err)
+		}
+	}
+	return count
+}
+
+func TestRoleRequestApproval(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	rclient := pb.NewRoleServiceClient(conn)
+	admin, err := rclient.CreateRole(context.Background(), NewRole("admin"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	curator, err := rclient.CreateRole(context.Background(), NewRole("curator"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	uclient := pb.NewUserServiceClient(conn)
+	requester, err := uclient.CreateUser(context.Background(), NewUser("requester@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	approver, err := uclient.CreateUser(context.Background(), NewUserWithRole("approver@gmail.com", admin))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+
+	s := NewRoleRequestService(runner.NewDB(db, "postgres"))
+	ra, err := s.SetRoleApprovers(
+		context.Background(),
+		&RoleApprovers{Id: curator.Data.Id, RoleIds: []int64{admin.Data.Id}},
+	)
+	if err != nil {
+		t.Fatalf("could not set the approvers %s\n", err)
+	}
+	if len(ra.RoleIds) != 1 || ra.RoleIds[0] != admin.Data.Id {
+		t.Fatalf("expected admin as approver role, received %v\n", ra.RoleIds)
+	}
+	requesterCtx := auth.NewContext(context.Background(), &auth.Principal{UserId: requester.Data.Id})
+	approverCtx := auth.NewContext(context.Background(), &auth.Principal{UserId: approver.Data.Id})
+	nreq := &NewRoleRequest{
+		RoleId:        curator.Data.Id,
+		Justification: "need to annotate genes",
+	}
+	_, err = s.CreateRoleRequest(context.Background(), nreq)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a principal, received %s\n", err)
+	}
+	rr, err := s.CreateRoleRequest(requesterCtx, nreq)
+	if err != nil {
+		t.Fatalf("could not create the role request %s\n", err)
+	}
+	if rr.Data.Attributes.State != RoleRequestPending || rr.Data.Attributes.UserId != requester.Data.Id {
+		t.Fatalf("expected a pending request of the requester, received %+v\n", rr.Data.Attributes)
+	}
+	_, err = s.CreateRoleRequest(requesterCtx, nreq)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists for duplicate request, received %s\n", err)
+	}
+	created := &event.RoleRequestCreated{}
+	if n := lastStoredEvent(t, created); n != 1 {
+		t.Fatalf("expected a single created event, received %d\n", n)
+	}
+	if created.Request.GetId() != rr.Data.Id || created.Request.GetUserId() != requester.Data.Id {
+		t.Fatalf("expected the created event of the request, received %+v\n", created.Request)
+	}
+	if len(created.Approvers) != 1 || created.Approvers[0] != approver.Data.Id {
+		t.Fatalf("expected approver %d to be notified, received %v\n", approver.Data.Id, created.Approvers)
+	}
+	crr, err := s.CommentRoleRequest(
+		approverCtx,
+		&NewRoleRequestComment{Id: rr.Data.Id, Comment: "for which genes?"},
+	)
+	if err != nil {
+		t.Fatalf("could not comment on the role request %s\n", err)
+	}
+	if crr.Data.Attributes.Comments[0].UserId != approver.Data.Id {
+		t.Fatalf("expected the comment of the approver, received %+v\n", crr.Data.Attributes.Comments[0])
+	}
+	stranger, err := uclient.CreateUser(context.Background(), NewUser("stranger@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+	_, err = s.CommentRoleRequest(
+		auth.NewContext(context.Background(), &auth.Principal{UserId: stranger.Data.Id}),
+		&NewRoleRequestComment{Id: rr.Data.Id, Comment: "me too"},
+	)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for a comment on the request of another user, received %s\n", err)
+	}
+
+	_, err = s.ApproveRoleRequest(context.Background(), &RoleRequestDecision{Id: rr.Data.Id})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without a principal, received %s\n", err)
+	}
+	_, err = s.ApproveRoleRequest(requesterCtx, &RoleRequestDecision{Id: rr.Data.Id})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for self approval, received %s\n", err)
+	}
+	// the approver named in the body is ignored, the requester is still
+	// the one deciding
+	req := httptest.NewRequest(
+		"POST",
+		"/role_requests/"+strconv.FormatInt(rr.Data.Id, 10)+"/approve",
+		strings.NewReader(`{"approver_id": `+strconv.FormatInt(approver.Data.Id, 10)+`}`),
+	).WithContext(requesterCtx)
+	w := httptest.NewRecorder()
+	s.approveHandler(w, req, map[string]string{"id": strconv.FormatInt(rr.Data.Id, 10)})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected the approval with the id of another user to be refused, received %d\n", w.Code)
+	}
+	arr, err := s.ApproveRoleRequest(
+		approverCtx,
+		&RoleRequestDecision{Id: rr.Data.Id, Comment: "approved"},
+	)
+	if err != nil {
+		t.Fatalf("could not approve the role request %s\n", err)
+	}
+	attr := arr.Data.Attributes
+	if attr.State != RoleRequestApproved {
+		t.Fatalf("expected approved state, received %s\n", attr.State)
+	}
+	if attr.DecidedBy != approver.Data.Id || attr.DecisionComment != "approved" || attr.DecidedAt == nil {
+		t.Fatalf("expected the decision to be recorded, received %+v\n", attr)
+	}
+	if len(attr.Comments) != 1 {
+		t.Fatalf("expected 1 comment, received %d\n", len(attr.Comments))
+	}
+	approved := &event.RoleRequestApproved{}
+	if n := lastStoredEvent(t, approved); n != 1 || approved.Request.GetDecidedBy() != approver.Data.Id {
+		t.Fatalf("expected an approved event decided by %d, received %d %+v\n", approver.Data.Id, n, approved.Request)
+	}
+	assigned := &event.RoleAssigned{}
+	lastStoredEvent(t, assigned)
+	if assigned.UserId != requester.Data.Id || assigned.Role.GetId() != curator.Data.Id {
+		t.Fatalf("expected the curator role to be assigned to the requester, received %+v\n", assigned)
+	}
+	audits, err := NewAuditService(runner.NewDB(db, "postgres")).ListAuditEvents(
+		context.Background(),
+		&AuditEventFilter{ResourceType: "role_requests", ResourceId: rr.Data.Id},
+	)
+	if err != nil {
+		t.Fatalf("could not list the audit events %s\n", err)
+	}
+	if len(audits.Data) != 3 {
+		t.Fatalf("expected the creation, the comment and the approval to be audited, received %d events\n", len(audits.Data))
+	}
+	roles, err := uclient.GetRelatedRoles(context.Background(), &jsonapi.RelationshipRequest{Id: requester.Data.Id})
+	if err != nil {
+		t.Fatalf("could not fetch the roles of user %s\n", err)
+	}
+	if len(roles.Data) != 1 || roles.Data[0].Id != curator.Data.Id {
+		t.Fatal("expected curator role to be granted to the requester")
+	}
+	_, err = s.DenyRoleRequest(approverCtx, &RoleRequestDecision{Id: rr.Data.Id})
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), RoleRequestApproved) {
+		t.Fatalf("expected FailedPrecondition for the approved request, received %s\n", err)
+	}
+	coll, err := s.ListRoleRequests(context.Background(), &RoleRequestFilter{State: RoleRequestApproved})
+	if err != nil {
+		t.Fatalf("could not list the role requests %s\n", err)
+	}
+	if len(coll.Data) != 1 {
+		t.Fatalf("expected 1 approved request, received %d\n", len(coll.Data))
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// Metadata keys that restrict the relationship rpcs between users and roles
+// to a scope. Through the HTTP gateway they are given as
+// Grpc-Metadata-Scope-Resource-Type and Grpc-Metadata-Scope-Resource-Id
+// headers. Without them the rpcs work on global assignments.
+const (
+	ScopeResourceTypeKey = "scope-resource-type"
+	ScopeResourceIdKey   = "scope-resource-id"
+)
+
+// ScopedUsersKey is the header metadata key of the role rpcs including
+// users that lists the comma separated scoped assignments of the included
+// users as role_id:user_id:resource_type/resource_id, as the protocol buffer
+// definition of a user has no place for them
+const ScopedUsersKey = "scoped-users"
+
+// Scope binds a role assignment to a single resource, for example the
+// curator role of the strain collection with id 12. The resource type
+// matches the resource of the permissions the role grants.
+type Scope struct {
+	ResourceType string `json:"resource_type"`
+	ResourceId   string `json:"resource_id"`
+}
+
+// IsGlobal tells if the assignment applies to every resource
+func (sc *Scope) IsGlobal() bool {
+	return sc == nil || len(sc.ResourceType) == 0
+}
+
+// Validate makes sure that either both or none of the scope values are given
+func (sc *Scope) Validate() error {
+	if sc == nil {
+		return nil
+	}
+	if (len(sc.ResourceType) == 0) != (len(sc.ResourceId) == 0) {
+		return status.Error(
+			codes.InvalidArgument,
+			"both resource type and resource id are required for a scope",
+		)
+	}
+	return nil
+}
+
+// scopeFromContext reads the scope from the incoming metadata, a nil scope
+// is returned in the absence of any
+func scopeFromContext(ctx context.Context) (*Scope, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	sc := &Scope{}
+	if v := md.Get(ScopeResourceTypeKey); len(v) > 0 {
+		sc.ResourceType = v[0]
+	}
+	if v := md.Get(ScopeResourceIdKey); len(v) > 0 {
+		sc.ResourceId = v[0]
+	}
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	if sc.IsGlobal() {
+		return nil, nil
+	}
+	return sc, nil
+}
+
+// scopeClause returns the condition matching the scope of the assignments
+// in the given table. The placeholders of the condition start after
+// offset.
+func scopeClause(table string, sc *Scope, offset int) (string, []interface{}) {
+	if sc.IsGlobal() {
+		return fmt.Sprintf("%s.resource_type IS NULL", table), nil
+	}
+	return fmt.Sprintf(
+			"%s.resource_type = $%d AND %s.resource_id = $%d",
+			table, offset+1, table, offset+2,
+		),
+		[]interface{}{sc.ResourceType, sc.ResourceId}
+}
+
+// scopeValues returns the values of the scope columns for inserting an
+// assignment
+func scopeValues(sc *Scope) (dat.NullString, dat.NullString) {
+	if sc.IsGlobal() {
+		return dat.NullString{}, dat.NullString{}
+	}
+	return dat.NullStringFrom(sc.ResourceType), dat.NullStringFrom(sc.ResourceId)
+}
+
+// scopedWhere adds the scope condition of the assignments in the given
+// table to the condition
+func scopedWhere(table, cond string, sc *Scope, args ...interface{}) (string, []interface{}) {
+	sclause, sargs := scopeClause(table, sc, len(args))
+	return fmt.Sprintf("%s AND %s", cond, sclause), append(args, sargs...)
+}
+
+// insertUserRole assigns the role to the user within the scope
+func insertUserRole(conn runner.Connection, userId, roleId int64, sc *Scope) error {
+	rtype, rid := scopeValues(sc)
+	_, err := conn.InsertInto("auth_user_role").
+		Columns("auth_user_id", "auth_role_id", "resource_type", "resource_id").
+		Values(userId, roleId, rtype, rid).
+		Exec()
+	return err
+}
+
+// setIncludedScopesHeader sends the scoped assignments of the roles as
+// header metadata when their users are included
+func setIncludedScopesHeader(ctx context.Context, conn runner.Connection, params *aphgrpc.JSONAPIParams, roleIds ...int64) error {
+	if len(roleIds) == 0 || !hasInclude(params, "users") {
+		return nil
+	}
+	var scopes []string
+	for _, id := range roleIds {
+		var dbrows []*struct {
+			AuthUserId   int64  `db:"auth_user_id"`
+			ResourceType string `db:"resource_type"`
+			ResourceId   string `db:"resource_id"`
+		}
+		err := conn.Select("auth_user_id", "resource_type", "resource_id").
+			From("auth_user_role").
+			Where("auth_role_id = $1 AND resource_type IS NOT NULL", id).
+			OrderBy("auth_user_id", "resource_type", "resource_id").
+			QueryStructs(&dbrows)
+		if err != nil {
+			return err
+		}
+		for _, r := range dbrows {
+			scopes = append(scopes, fmt.Sprintf(
+				"%d:%d:%s/%s",
+				id, r.AuthUserId, r.ResourceType, r.ResourceId,
+			))
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	// fails only outside of a grpc call, where there is nobody to send the
+	// header to
+	grpc.SetHeader(ctx, metadata.Pairs(ScopedUsersKey, strings.Join(scopes, ",")))
+	return nil
+}
+
+func hasInclude(params *aphgrpc.JSONAPIParams, inc string) bool {
+	for _, i := range params.Includes {
+		if i == inc {
+			return true
+		}
+	}
+	return false
+}
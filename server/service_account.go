@@ -0,0 +1,937 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const (
+	svcAccountDbTable     = "auth_service_account"
+	svcAccountKeyDbTable  = "auth_service_account_key"
+	svcAccountRoleDbTable = "auth_service_account_role"
+)
+
+// APIKeyPrefix starts every service account key, it tells the keys apart
+// from json web tokens
+const APIKeyPrefix = "sak_"
+
+// States of a service account key
+const (
+	KeyActive  = "active"
+	KeyExpired = "expired"
+	KeyRevoked = "revoked"
+)
+
+// keyUseInterval limits how often the last use of a key is recorded
+const keyUseInterval = time.Minute
+
+var svcAccountCols = []string{
+	"auth_service_account_id",
+	"name",
+	"description",
+	"is_active",
+	"created_at",
+	"updated_at",
+}
+
+var svcAccountKeyCols = []string{
+	"auth_service_account_key_id",
+	"auth_service_account_id",
+	"key_id",
+	"expires_at",
+	"revoked_at",
+	"last_used_at",
+	"created_at",
+}
+
+type dbServiceAccount struct {
+	AuthServiceAccountId int64          `db:"auth_service_account_id"`
+	Name                 string         `db:"name"`
+	Description          dat.NullString `db:"description"`
+	IsActive             bool           `db:"is_active"`
+	CreatedAt            dat.NullTime   `db:"created_at"`
+	UpdatedAt            dat.NullTime   `db:"updated_at"`
+}
+
+type dbServiceAccountKey struct {
+	AuthServiceAccountKeyId int64        `db:"auth_service_account_key_id"`
+	AuthServiceAccountId    int64        `db:"auth_service_account_id"`
+	KeyId                   string       `db:"key_id"`
+	ExpiresAt               dat.NullTime `db:"expires_at"`
+	RevokedAt               dat.NullTime `db:"revoked_at"`
+	LastUsedAt              dat.NullTime `db:"last_used_at"`
+	CreatedAt               dat.NullTime `db:"created_at"`
+}
+
+// ServiceAccountAttributes are the attributes of a service account resource
+type ServiceAccountAttributes struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ServiceAccountData is the primary data of a service account resource
+type ServiceAccountData struct {
+	Type       string                    `json:"type"`
+	Id         int64                     `json:"id"`
+	Attributes *ServiceAccountAttributes `json:"attributes"`
+	Links      *jsonapi.Links            `json:"links"`
+}
+
+// ServiceAccount is a non human principal, such as another service or a
+// scheduled job, that authenticates with API keys
+type ServiceAccount struct {
+	Data *ServiceAccountData `json:"data"`
+}
+
+// ServiceAccountCollection is a list of service accounts
+type ServiceAccountCollection struct {
+	Data  []*ServiceAccountData `json:"data"`
+	Links *jsonapi.Links        `json:"links"`
+}
+
+// NewServiceAccount contains the attributes for creating a service account
+type NewServiceAccount struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ServiceAccountUpdate changes the attributes of a service account, the
+// absent ones are kept. An inactive account cannot authenticate.
+type ServiceAccountUpdate struct {
+	Id          int64   `json:"-"`
+	Description *string `json:"description"`
+	IsActive    *bool   `json:"is_active"`
+}
+
+// ServiceAccountKey is an API key of a service account without its secret
+type ServiceAccountKey struct {
+	Id         int64      `json:"id"`
+	KeyId      string     `json:"key_id"`
+	State      string     `json:"state"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ServiceAccountKeyCollection lists the API keys of a service account
+type ServiceAccountKeyCollection struct {
+	Data []*ServiceAccountKey `json:"data"`
+}
+
+// ServiceAccountKeySecret is a newly created API key along with its
+// secret. Only the hash of the key is stored, it cannot be revealed again.
+type ServiceAccountKeySecret struct {
+	*ServiceAccountKey
+	Key string `json:"key"`
+}
+
+// NewServiceAccountKey contains the attributes for creating an API key, a
+// key without expiration is valid until it is revoked
+type NewServiceAccountKey struct {
+	ServiceAccountId int64      `json:"-"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+// ServiceAccountKeyRotation replaces an API key with a new one. The
+// replaced key stays valid for the overlap, so that the clients can switch
+// over, without overlap it is revoked right away.
+type ServiceAccountKeyRotation struct {
+	ServiceAccountId int64      `json:"-"`
+	Id               int64      `json:"-"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	Overlap          int64      `json:"overlap_seconds"`
+}
+
+// ServiceAccountKeyRequest identifies an API key of a service account
+type ServiceAccountKeyRequest struct {
+	ServiceAccountId int64
+	Id               int64
+}
+
+// ServiceAccountRoles are roles assigned to or revoked from a service
+// account, either globally or within a scope
+type ServiceAccountRoles struct {
+	Id      int64   `json:"-"`
+	RoleIds []int64 `json:"role_ids"`
+	Scope   *Scope  `json:"scope,omitempty"`
+}
+
+type ServiceAccountService struct {
+	*aphgrpc.Service
+	cache *PermissionCache
+}
+
+func serviceAccountServiceOptions() *aphgrpc.ServiceOptions {
+	return &aphgrpc.ServiceOptions{
+		Resource:   "service_accounts",
+		PathPrefix: "service_accounts",
+	}
+}
+
+func NewServiceAccountService(dbh *runner.DB, opt ...aphgrpc.Option) *ServiceAccountService {
+	so := serviceAccountServiceOptions()
+	for _, optfn := range opt {
+		optfn(so)
+	}
+	srv := &aphgrpc.Service{Dbh: dbh}
+	aphgrpc.AssignFieldsToStructs(so, srv)
+	return &ServiceAccountService{Service: srv}
+}
+
+// WithPermissionCache sets the cache of permission grants that is
+// invalidated when the roles of a service account change
+func (s *ServiceAccountService) WithPermissionCache(c *PermissionCache) *ServiceAccountService {
+	s.cache = c
+	return s
+}
+
+// HTTPRoutes returns the service account endpoints
+func (s *ServiceAccountService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/service_accounts", Handler: s.listHandler},
+		{Method: "POST", Path: "/service_accounts", Handler: s.createHandler},
+		{Method: "GET", Path: "/service_accounts/{id}", Handler: s.getHandler},
+		{Method: "PATCH", Path: "/service_accounts/{id}", Handler: s.updateHandler},
+		{Method: "DELETE", Path: "/service_accounts/{id}", Handler: s.deleteHandler},
+		{Method: "GET", Path: "/service_accounts/{id}/keys", Handler: s.listKeysHandler},
+		{Method: "POST", Path: "/service_accounts/{id}/keys", Handler: s.createKeyHandler},
+		{Method: "POST", Path: "/service_accounts/{id}/keys/{key_id}/rotate", Handler: s.rotateKeyHandler},
+		{Method: "DELETE", Path: "/service_accounts/{id}/keys/{key_id}", Handler: s.revokeKeyHandler},
+		{Method: "GET", Path: "/service_accounts/{id}/roles", Handler: s.listRolesHandler},
+		{Method: "POST", Path: "/service_accounts/{id}/roles", Handler: s.assignRolesHandler},
+		{Method: "DELETE", Path: "/service_accounts/{id}/roles/{role_id}", Handler: s.revokeRoleHandler},
+	}
+}
+
+func (s *ServiceAccountService) CreateServiceAccount(ctx context.Context, r *NewServiceAccount) (*ServiceAccount, error) {
+	if len(strings.TrimSpace(r.Name)) == 0 {
+		return &ServiceAccount{}, aphgrpc.HandleInsertArgError(ctx, fmt.Errorf("name is required"))
+	}
+	var count int64
+	err := s.Dbh.Select("COUNT(*)").From(svcAccountDbTable).
+		Where("name = $1", r.Name).QueryScalar(&count)
+	if err != nil {
+		return &ServiceAccount{}, aphgrpc.HandleError(ctx, err)
+	}
+	if count > 0 {
+		return &ServiceAccount{}, aphgrpc.HandleExistError(
+			ctx,
+			fmt.Errorf("service account %s already exists", r.Name),
+		)
+	}
+	dbsa := &dbServiceAccount{}
+	err = s.Dbh.InsertInto(svcAccountDbTable).
+		Columns("name", "description").
+		Values(r.Name, dat.NullStringFrom(r.Description)).
+		Returning(svcAccountCols...).
+		QueryStruct(dbsa)
+	if err != nil {
+		return &ServiceAccount{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	return s.buildResource(dbsa), nil
+}
+
+func (s *ServiceAccountService) GetServiceAccount(ctx context.Context, r *jsonapi.IdRequest) (*ServiceAccount, error) {
+	dbsa, err := getServiceAccount(s.Dbh, r.Id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ServiceAccount{}, aphgrpc.HandleNotFoundError(
+				ctx,
+				fmt.Errorf("service account id %d not found", r.Id),
+			)
+		}
+		return &ServiceAccount{}, aphgrpc.HandleError(ctx, err)
+	}
+	return s.buildResource(dbsa), nil
+}
+
+func (s *ServiceAccountService) ListServiceAccounts(ctx context.Context) (*ServiceAccountCollection, error) {
+	var dbrows []*dbServiceAccount
+	err := s.Dbh.Select(svcAccountCols...).
+		From(svcAccountDbTable).
+		OrderBy("name").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &ServiceAccountCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	coll := &ServiceAccountCollection{
+		Data:  make([]*ServiceAccountData, 0),
+		Links: &jsonapi.Links{Self: s.GenCollResourceSelfLink(ctx)},
+	}
+	for _, dbsa := range dbrows {
+		coll.Data = append(coll.Data, s.buildResourceData(dbsa))
+	}
+	return coll, nil
+}
+
+func (s *ServiceAccountService) UpdateServiceAccount(ctx context.Context, r *ServiceAccountUpdate) (*ServiceAccount, error) {
+	smap := map[string]interface{}{"updated_at": time.Now()}
+	if r.Description != nil {
+		smap["description"] = dat.NullStringFrom(*r.Description)
+	}
+	if r.IsActive != nil {
+		smap["is_active"] = *r.IsActive
+	}
+	dbsa := &dbServiceAccount{}
+	err := s.Dbh.Update(svcAccountDbTable).
+		SetMap(smap).
+		Where("auth_service_account_id = $1", r.Id).
+		Returning(svcAccountCols...).
+		QueryStruct(dbsa)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ServiceAccount{}, aphgrpc.HandleNotFoundError(
+				ctx,
+				fmt.Errorf("service account id %d not found", r.Id),
+			)
+		}
+		return &ServiceAccount{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	return s.buildResource(dbsa), nil
+}
+
+func (s *ServiceAccountService) DeleteServiceAccount(ctx context.Context, r *jsonapi.DeleteRequest) (*empty.Empty, error) {
+	res, err := s.Dbh.DeleteFrom(svcAccountDbTable).
+		Where("auth_service_account_id = $1", r.Id).
+		Exec()
+	if err != nil {
+		return &empty.Empty{}, aphgrpc.HandleDeleteError(ctx, err)
+	}
+	if res.RowsAffected == 0 {
+		return &empty.Empty{}, aphgrpc.HandleNotFoundError(
+			ctx,
+			fmt.Errorf("service account id %d not found", r.Id),
+		)
+	}
+	s.cache.invalidate(serviceAccountGroup(r.Id))
+	return &empty.Empty{}, nil
+}
+
+// CreateServiceAccountKey creates an API key, its secret is only part of
+// the response
+func (s *ServiceAccountService) CreateServiceAccountKey(ctx context.Context, r *NewServiceAccountKey) (*ServiceAccountKeySecret, error) {
+	if err := s.checkServiceAccount(ctx, r.ServiceAccountId); err != nil {
+		return &ServiceAccountKeySecret{}, err
+	}
+	if err := validateKeyExpiry(r.ExpiresAt); err != nil {
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleInsertArgError(ctx, err)
+	}
+	ks, err := insertServiceAccountKey(s.Dbh, r.ServiceAccountId, r.ExpiresAt)
+	if err != nil {
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	return ks, nil
+}
+
+// ListServiceAccountKeys lists the API keys of a service account, including
+// the expired and revoked ones
+func (s *ServiceAccountService) ListServiceAccountKeys(ctx context.Context, r *jsonapi.IdRequest) (*ServiceAccountKeyCollection, error) {
+	if err := s.checkServiceAccount(ctx, r.Id); err != nil {
+		return &ServiceAccountKeyCollection{}, err
+	}
+	var dbrows []*dbServiceAccountKey
+	err := s.Dbh.Select(svcAccountKeyCols...).
+		From(svcAccountKeyDbTable).
+		Where("auth_service_account_id = $1", r.Id).
+		OrderBy("auth_service_account_key_id").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &ServiceAccountKeyCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	coll := &ServiceAccountKeyCollection{Data: make([]*ServiceAccountKey, 0)}
+	now := time.Now()
+	for _, dbk := range dbrows {
+		coll.Data = append(coll.Data, dbToServiceAccountKey(dbk, now))
+	}
+	return coll, nil
+}
+
+// RotateServiceAccountKey creates a new API key and retires the given one
+// after the overlap
+func (s *ServiceAccountService) RotateServiceAccountKey(ctx context.Context, r *ServiceAccountKeyRotation) (*ServiceAccountKeySecret, error) {
+	if r.Overlap < 0 {
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleUpdateArgError(ctx, fmt.Errorf("overlap cannot be negative"))
+	}
+	if err := validateKeyExpiry(r.ExpiresAt); err != nil {
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleUpdateArgError(ctx, err)
+	}
+	tx, err := s.Dbh.Begin()
+	if err != nil {
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	defer tx.AutoRollback()
+	old := &dbServiceAccountKey{}
+	err = tx.Select(svcAccountKeyCols...).
+		From(svcAccountKeyDbTable).
+		Where(
+			"auth_service_account_key_id = $1 AND auth_service_account_id = $2",
+			r.Id, r.ServiceAccountId,
+		).
+		QueryStruct(old)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &ServiceAccountKeySecret{}, aphgrpc.HandleNotFoundError(
+				ctx,
+				fmt.Errorf("key %d of service account %d not found", r.Id, r.ServiceAccountId),
+			)
+		}
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleError(ctx, err)
+	}
+	now := time.Now()
+	if state := keyState(old, now); state != KeyActive {
+		return &ServiceAccountKeySecret{}, status.Errorf(
+			codes.FailedPrecondition,
+			"key %d is %s and cannot be rotated", r.Id, state,
+		)
+	}
+	retire := map[string]interface{}{"revoked_at": now}
+	if r.Overlap > 0 {
+		until := now.Add(time.Duration(r.Overlap) * time.Second)
+		if !old.ExpiresAt.Valid || until.Before(old.ExpiresAt.Time) {
+			retire = map[string]interface{}{"expires_at": until}
+		} else {
+			retire = nil
+		}
+	}
+	if retire != nil {
+		_, err = tx.Update(svcAccountKeyDbTable).
+			SetMap(retire).
+			Where("auth_service_account_key_id = $1", r.Id).
+			Exec()
+		if err != nil {
+			return &ServiceAccountKeySecret{}, aphgrpc.HandleUpdateError(ctx, err)
+		}
+	}
+	ks, err := insertServiceAccountKey(tx, r.ServiceAccountId, r.ExpiresAt)
+	if err != nil {
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return &ServiceAccountKeySecret{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	return ks, nil
+}
+
+// RevokeServiceAccountKey stops an API key from being accepted
+func (s *ServiceAccountService) RevokeServiceAccountKey(ctx context.Context, r *ServiceAccountKeyRequest) (*empty.Empty, error) {
+	res, err := s.Dbh.Update(svcAccountKeyDbTable).
+		Set("revoked_at", time.Now()).
+		Where(
+			"auth_service_account_key_id = $1 AND auth_service_account_id = $2 AND revoked_at IS NULL",
+			r.Id, r.ServiceAccountId,
+		).
+		Exec()
+	if err != nil {
+		return &empty.Empty{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	if res.RowsAffected == 0 {
+		return &empty.Empty{}, aphgrpc.HandleNotFoundError(
+			ctx,
+			fmt.Errorf("active key %d of service account %d not found", r.Id, r.ServiceAccountId),
+		)
+	}
+	return &empty.Empty{}, nil
+}
+
+// ListServiceAccountRoles returns every role of the service account with
+// its scope
+func (s *ServiceAccountService) ListServiceAccountRoles(ctx context.Context, r *jsonapi.IdRequest) (*RoleAssignmentCollection, error) {
+	if err := s.checkServiceAccount(ctx, r.Id); err != nil {
+		return &RoleAssignmentCollection{}, err
+	}
+	var dbrows []*dbRoleAssignment
+	err := s.Dbh.Select(
+		"role.auth_role_id", "role.role",
+		"sarole.resource_type", "sarole.resource_id",
+	).From(`
+			auth_service_account_role sarole
+			JOIN auth_role role
+			ON sarole.auth_role_id = role.auth_role_id
+		`).
+		Where("sarole.auth_service_account_id = $1", r.Id).
+		OrderBy("role.role", "sarole.resource_type", "sarole.resource_id").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &RoleAssignmentCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	return &RoleAssignmentCollection{Data: dbToRoleAssignments(dbrows)}, nil
+}
+
+// AssignServiceAccountRoles assigns the roles to the service account, the
+// roles it already holds in the scope are left as they are
+func (s *ServiceAccountService) AssignServiceAccountRoles(ctx context.Context, r *ServiceAccountRoles) (*RoleAssignmentCollection, error) {
+	if err := s.validateRoles(ctx, r); err != nil {
+		return &RoleAssignmentCollection{}, err
+	}
+	rtype, rid := scopeValues(r.Scope)
+	for _, roleId := range r.RoleIds {
+		_, err := s.Dbh.SQL(`
+			INSERT INTO auth_service_account_role(auth_service_account_id, auth_role_id, resource_type, resource_id)
+			VALUES($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING`,
+			r.Id, roleId, rtype, rid,
+		).Exec()
+		if err != nil {
+			return &RoleAssignmentCollection{}, aphgrpc.HandleInsertError(ctx, err)
+		}
+	}
+	s.cache.invalidate(serviceAccountGroup(r.Id))
+	return s.ListServiceAccountRoles(ctx, &jsonapi.IdRequest{Id: r.Id})
+}
+
+// RevokeServiceAccountRoles removes the roles of the service account within
+// the scope
+func (s *ServiceAccountService) RevokeServiceAccountRoles(ctx context.Context, r *ServiceAccountRoles) (*RoleAssignmentCollection, error) {
+	if err := s.validateRoles(ctx, r); err != nil {
+		return &RoleAssignmentCollection{}, err
+	}
+	for _, roleId := range r.RoleIds {
+		where, args := scopedWhere(
+			"auth_service_account_role",
+			"auth_service_account_role.auth_service_account_id = $1 AND auth_service_account_role.auth_role_id = $2",
+			r.Scope, r.Id, roleId,
+		)
+		_, err := s.Dbh.DeleteFrom(svcAccountRoleDbTable).Where(where, args...).Exec()
+		if err != nil {
+			return &RoleAssignmentCollection{}, aphgrpc.HandleDeleteError(ctx, err)
+		}
+	}
+	s.cache.invalidate(serviceAccountGroup(r.Id))
+	return s.ListServiceAccountRoles(ctx, &jsonapi.IdRequest{Id: r.Id})
+}
+
+// All helper functions
+
+func (s *ServiceAccountService) checkServiceAccount(ctx context.Context, id int64) error {
+	var count int64
+	err := s.Dbh.Select("COUNT(*)").From(svcAccountDbTable).
+		Where("auth_service_account_id = $1", id).QueryScalar(&count)
+	if err != nil {
+		return aphgrpc.HandleError(ctx, err)
+	}
+	if count == 0 {
+		return aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("service account id %d not found", id))
+	}
+	return nil
+}
+
+func (s *ServiceAccountService) validateRoles(ctx context.Context, r *ServiceAccountRoles) error {
+	if len(r.RoleIds) == 0 {
+		return status.Error(codes.InvalidArgument, "no role id given")
+	}
+	if err := r.Scope.Validate(); err != nil {
+		return err
+	}
+	if err := s.checkServiceAccount(ctx, r.Id); err != nil {
+		return err
+	}
+	for _, roleId := range r.RoleIds {
+		var count int64
+		err := s.Dbh.Select("COUNT(*)").From("auth_role").
+			Where("auth_role_id = $1", roleId).QueryScalar(&count)
+		if err != nil {
+			return aphgrpc.HandleError(ctx, err)
+		}
+		if count == 0 {
+			return aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("role id %d not found", roleId))
+		}
+	}
+	return nil
+}
+
+func (s *ServiceAccountService) buildResourceData(dbsa *dbServiceAccount) *ServiceAccountData {
+	return &ServiceAccountData{
+		Type: s.GetResourceName(),
+		Id:   dbsa.AuthServiceAccountId,
+		Attributes: &ServiceAccountAttributes{
+			Name:        dbsa.Name,
+			Description: aphgrpc.NullToString(dbsa.Description),
+			IsActive:    dbsa.IsActive,
+			CreatedAt:   dbsa.CreatedAt.Time,
+			UpdatedAt:   dbsa.UpdatedAt.Time,
+		},
+		Links: &jsonapi.Links{
+			Self: aphgrpc.GenSingleResourceLink(s, dbsa.AuthServiceAccountId),
+		},
+	}
+}
+
+func (s *ServiceAccountService) buildResource(dbsa *dbServiceAccount) *ServiceAccount {
+	return &ServiceAccount{Data: s.buildResourceData(dbsa)}
+}
+
+func getServiceAccount(conn runner.Connection, id int64) (*dbServiceAccount, error) {
+	dbsa := &dbServiceAccount{}
+	err := conn.Select(svcAccountCols...).
+		From(svcAccountDbTable).
+		Where("auth_service_account_id = $1", id).
+		QueryStruct(dbsa)
+	return dbsa, err
+}
+
+// serviceAccountAttributes collects the attributes of the service account
+// that are available to conditions as user.<name>
+func serviceAccountAttributes(conn runner.Connection, id int64) (map[string]interface{}, error) {
+	dbsa, err := getServiceAccount(conn, id)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"id":              dbsa.AuthServiceAccountId,
+		"name":            dbsa.Name,
+		"is_active":       dbsa.IsActive,
+		"service_account": true,
+	}, nil
+}
+
+func validateKeyExpiry(t *time.Time) error {
+	if t != nil && !t.After(time.Now()) {
+		return fmt.Errorf("expiration %s is not in the future", t.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// insertServiceAccountKey generates and stores a new API key. The key is
+// the prefix, the public key id and the random secret joined by
+// underscores, only its hash is stored.
+func insertServiceAccountKey(conn runner.Connection, saId int64, expiresAt *time.Time) (*ServiceAccountKeySecret, error) {
+	kid := make([]byte, 6)
+	secret := make([]byte, 32)
+	for _, b := range [][]byte{kid, secret} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	keyId := hex.EncodeToString(kid)
+	key := fmt.Sprintf("%s%s_%s", APIKeyPrefix, keyId, base64.RawURLEncoding.EncodeToString(secret))
+	var exp dat.NullTime
+	if expiresAt != nil {
+		exp = dat.NullTimeFrom(*expiresAt)
+	}
+	dbk := &dbServiceAccountKey{}
+	err := conn.InsertInto(svcAccountKeyDbTable).
+		Columns("auth_service_account_id", "key_id", "key_hash", "expires_at").
+		Values(saId, keyId, hashAPIKey(key), exp).
+		Returning(svcAccountKeyCols...).
+		QueryStruct(dbk)
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceAccountKeySecret{
+		ServiceAccountKey: dbToServiceAccountKey(dbk, time.Now()),
+		Key:               key,
+	}, nil
+}
+
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// authenticateAPIKey returns the active service account of a valid key and
+// records the use of the key
+func authenticateAPIKey(conn runner.Connection, key string) (*dbServiceAccount, error) {
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, status.Error(codes.Unauthenticated, "malformed API key")
+	}
+	var dbrows []*struct {
+		dbServiceAccountKey
+		KeyHash string `db:"key_hash"`
+	}
+	err := conn.Select(append(svcAccountKeyCols, "key_hash")...).
+		From(svcAccountKeyDbTable).
+		Where("key_id = $1", parts[0]).
+		QueryStructs(&dbrows)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(dbrows) == 0 || subtle.ConstantTimeCompare([]byte(dbrows[0].KeyHash), []byte(hashAPIKey(key))) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	dbk := &dbrows[0].dbServiceAccountKey
+	now := time.Now()
+	if state := keyState(dbk, now); state != KeyActive {
+		return nil, status.Errorf(codes.Unauthenticated, "API key %s is %s", dbk.KeyId, state)
+	}
+	dbsa, err := getServiceAccount(conn, dbk.AuthServiceAccountId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !dbsa.IsActive {
+		return nil, status.Errorf(codes.Unauthenticated, "service account %s is not active", dbsa.Name)
+	}
+	if !dbk.LastUsedAt.Valid || now.Sub(dbk.LastUsedAt.Time) >= keyUseInterval {
+		_, err := conn.Update(svcAccountKeyDbTable).
+			Set("last_used_at", now).
+			Where("auth_service_account_key_id = $1", dbk.AuthServiceAccountKeyId).
+			Exec()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	return dbsa, nil
+}
+
+func keyState(dbk *dbServiceAccountKey, now time.Time) string {
+	switch {
+	case dbk.RevokedAt.Valid:
+		return KeyRevoked
+	case dbk.ExpiresAt.Valid && !now.Before(dbk.ExpiresAt.Time):
+		return KeyExpired
+	}
+	return KeyActive
+}
+
+func dbToServiceAccountKey(dbk *dbServiceAccountKey, now time.Time) *ServiceAccountKey {
+	k := &ServiceAccountKey{
+		Id:        dbk.AuthServiceAccountKeyId,
+		KeyId:     dbk.KeyId,
+		State:     keyState(dbk, now),
+		CreatedAt: dbk.CreatedAt.Time,
+	}
+	for _, t := range []struct {
+		src dat.NullTime
+		dst **time.Time
+	}{
+		{dbk.ExpiresAt, &k.ExpiresAt},
+		{dbk.RevokedAt, &k.RevokedAt},
+		{dbk.LastUsedAt, &k.LastUsedAt},
+	} {
+		if t.src.Valid {
+			v := t.src.Time
+			*t.dst = &v
+		}
+	}
+	return k
+}
+
+// -- HTTP handlers
+
+func (s *ServiceAccountService) listHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	coll, err := s.ListServiceAccounts(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *ServiceAccountService) createHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	ns := &NewServiceAccount{}
+	if err := readJSON(r, ns); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	sa, err := s.CreateServiceAccount(r.Context(), ns)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sa)
+}
+
+func (s *ServiceAccountService) getHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	sa, err := s.GetServiceAccount(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sa)
+}
+
+func (s *ServiceAccountService) updateHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	su := &ServiceAccountUpdate{}
+	if err := readJSON(r, su); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	su.Id = id
+	sa, err := s.UpdateServiceAccount(r.Context(), su)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sa)
+}
+
+func (s *ServiceAccountService) deleteHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if _, err := s.DeleteServiceAccount(r.Context(), &jsonapi.DeleteRequest{Id: id}); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ServiceAccountService) listKeysHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	coll, err := s.ListServiceAccountKeys(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *ServiceAccountService) createKeyHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	nk := &NewServiceAccountKey{}
+	if err := readJSON(r, nk); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	nk.ServiceAccountId = id
+	ks, err := s.CreateServiceAccountKey(r.Context(), nk)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ks)
+}
+
+func (s *ServiceAccountService) rotateKeyHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	kr, err := keyRequestFromParams(params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	rot := &ServiceAccountKeyRotation{}
+	if err := readJSON(r, rot); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	rot.ServiceAccountId, rot.Id = kr.ServiceAccountId, kr.Id
+	ks, err := s.RotateServiceAccountKey(r.Context(), rot)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ks)
+}
+
+func (s *ServiceAccountService) revokeKeyHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	kr, err := keyRequestFromParams(params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if _, err := s.RevokeServiceAccountKey(r.Context(), kr); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *ServiceAccountService) listRolesHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	coll, err := s.ListServiceAccountRoles(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *ServiceAccountService) assignRolesHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	sr := &ServiceAccountRoles{}
+	if err := readJSON(r, sr); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	sr.Id = id
+	coll, err := s.AssignServiceAccountRoles(r.Context(), sr)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *ServiceAccountService) revokeRoleHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	roleId, err := pathParamToID(params, "role_id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	sr := &ServiceAccountRoles{Id: id, RoleIds: []int64{roleId}}
+	if len(q.Get("resource_type")) > 0 || len(q.Get("resource_id")) > 0 {
+		sr.Scope = &Scope{ResourceType: q.Get("resource_type"), ResourceId: q.Get("resource_id")}
+	}
+	coll, err := s.RevokeServiceAccountRoles(r.Context(), sr)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func keyRequestFromParams(params map[string]string) (*ServiceAccountKeyRequest, error) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		return nil, err
+	}
+	keyId, err := pathParamToID(params, "key_id")
+	if err != nil {
+		return nil, err
+	}
+	return &ServiceAccountKeyRequest{ServiceAccountId: id, Id: keyId}, nil
+}
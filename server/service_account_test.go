@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestServiceAccountKeys(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	s := NewServiceAccountService(runner.NewDB(db, "postgres"))
+	sa, err := s.CreateServiceAccount(
+		context.Background(),
+		&NewServiceAccount{Name: "stock-sync", Description: "nightly stock import"},
+	)
+	if err != nil {
+		t.Fatalf("could not create the service account %s\n", err)
+	}
+	if !sa.Data.Attributes.IsActive || sa.Data.Attributes.Name != "stock-sync" {
+		t.Fatalf("unexpected service account %+v\n", sa.Data.Attributes)
+	}
+	_, err = s.CreateServiceAccount(context.Background(), &NewServiceAccount{Name: "stock-sync"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected duplicate name to be rejected, received %v\n", err)
+	}
+	ks, err := s.CreateServiceAccountKey(
+		context.Background(),
+		&NewServiceAccountKey{ServiceAccountId: sa.Data.Id},
+	)
+	if err != nil {
+		t.Fatalf("could not create the key %s\n", err)
+	}
+	if !strings.HasPrefix(ks.Key, APIKeyPrefix+ks.KeyId+"_") || ks.State != KeyActive {
+		t.Fatalf("unexpected key %s in state %s\n", ks.Key, ks.State)
+	}
+	dbsa, err := authenticateAPIKey(s.Dbh, ks.Key)
+	if err != nil {
+		t.Fatalf("expected the key to authenticate, received %s\n", err)
+	}
+	if dbsa.AuthServiceAccountId != sa.Data.Id {
+		t.Fatalf("expected service account %d, received %d\n", sa.Data.Id, dbsa.AuthServiceAccountId)
+	}
+	if _, err := authenticateAPIKey(s.Dbh, ks.Key+"x"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected a tampered key to be rejected, received %v\n", err)
+	}
+
+	rotated, err := s.RotateServiceAccountKey(
+		context.Background(),
+		&ServiceAccountKeyRotation{ServiceAccountId: sa.Data.Id, Id: ks.Id, Overlap: 3600},
+	)
+	if err != nil {
+		t.Fatalf("could not rotate the key %s\n", err)
+	}
+	for _, k := range []string{ks.Key, rotated.Key} {
+		if _, err := authenticateAPIKey(s.Dbh, k); err != nil {
+			t.Fatalf("expected both keys to work during the overlap, received %s\n", err)
+		}
+	}
+	coll, err := s.ListServiceAccountKeys(context.Background(), &jsonapi.IdRequest{Id: sa.Data.Id})
+	if err != nil {
+		t.Fatalf("could not list the keys %s\n", err)
+	}
+	if len(coll.Data) != 2 || coll.Data[0].ExpiresAt == nil || coll.Data[0].LastUsedAt == nil {
+		t.Fatalf("expected the rotated key to expire and be used, received %+v\n", coll.Data[0])
+	}
+
+	_, err = s.RevokeServiceAccountKey(
+		context.Background(),
+		&ServiceAccountKeyRequest{ServiceAccountId: sa.Data.Id, Id: ks.Id},
+	)
+	if err != nil {
+		t.Fatalf("could not revoke the key %s\n", err)
+	}
+	if _, err := authenticateAPIKey(s.Dbh, ks.Key); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the revoked key to be rejected, received %v\n", err)
+	}
+	_, err = s.RotateServiceAccountKey(
+		context.Background(),
+		&ServiceAccountKeyRotation{ServiceAccountId: sa.Data.Id, Id: ks.Id},
+	)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a revoked key not to be rotated, received %v\n", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	_, err = s.CreateServiceAccountKey(
+		context.Background(),
+		&NewServiceAccountKey{ServiceAccountId: sa.Data.Id, ExpiresAt: &past},
+	)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a past expiration to be rejected, received %v\n", err)
+	}
+
+	inactive := false
+	_, err = s.UpdateServiceAccount(
+		context.Background(),
+		&ServiceAccountUpdate{Id: sa.Data.Id, IsActive: &inactive},
+	)
+	if err != nil {
+		t.Fatalf("could not deactivate the service account %s\n", err)
+	}
+	if _, err := authenticateAPIKey(s.Dbh, rotated.Key); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the key of an inactive account to be rejected, received %v\n", err)
+	}
+}
+
+func TestServiceAccountAuthorize(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("read", "users"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("reader", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	dbh := runner.NewDB(db, "postgres")
+	s := NewServiceAccountService(dbh)
+	sa, err := s.CreateServiceAccount(context.Background(), &NewServiceAccount{Name: "reporter"})
+	if err != nil {
+		t.Fatalf("could not create the service account %s\n", err)
+	}
+	ks, err := s.CreateServiceAccountKey(
+		context.Background(),
+		&NewServiceAccountKey{ServiceAccountId: sa.Data.Id},
+	)
+	if err != nil {
+		t.Fatalf("could not create the key %s\n", err)
+	}
+	a := NewAuthenticator(
+		dbh,
+		&auth.Verifier{Sources: []auth.KeySource{auth.HMACKey("secret")}},
+		auth.DefaultPolicy(),
+	)
+	if _, err := a.Authorize(context.Background(), "/dictybase.user.UserService/GetUser", "Bearer "+ks.Key); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected an account without roles to be denied, received %v\n", err)
+	}
+	ra, err := s.AssignServiceAccountRoles(
+		context.Background(),
+		&ServiceAccountRoles{Id: sa.Data.Id, RoleIds: []int64{role.Data.Id}},
+	)
+	if err != nil {
+		t.Fatalf("could not assign the role %s\n", err)
+	}
+	if len(ra.Data) != 1 || ra.Data[0].RoleId != role.Data.Id {
+		t.Fatalf("expected the reader role, received %+v\n", ra.Data)
+	}
+	ctx, err := a.Authorize(context.Background(), "/dictybase.user.UserService/GetUser", "Bearer "+ks.Key)
+	if err != nil {
+		t.Fatalf("expected GetUser to be allowed, received %s\n", err)
+	}
+	p, ok := auth.FromContext(ctx)
+	if !ok || !p.IsServiceAccount() || p.Name != "reporter" {
+		t.Fatalf("expected the service account as principal, received %+v\n", p)
+	}
+	if _, err := a.Authorize(context.Background(), "/dictybase.user.UserService/UpdateUser", "Bearer "+ks.Key); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected UpdateUser to be denied, received %v\n", err)
+	}
+	ra, err = s.RevokeServiceAccountRoles(
+		context.Background(),
+		&ServiceAccountRoles{Id: sa.Data.Id, RoleIds: []int64{role.Data.Id}},
+	)
+	if err != nil {
+		t.Fatalf("could not revoke the role %s\n", err)
+	}
+	if len(ra.Data) != 0 {
+		t.Fatalf("expected no roles, received %+v\n", ra.Data)
+	}
+}
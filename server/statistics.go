@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// DefaultStatisticsInterval is the period by which the growth of assignments
+// is grouped
+const DefaultStatisticsInterval = "month"
+
+var statisticsIntervals = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+	"year":  true,
+}
+
+type dbRoleUsage struct {
+	AuthRoleId    int64  `db:"auth_role_id"`
+	Role          string `db:"role"`
+	Members       int64  `db:"members"`
+	ActiveMembers int64  `db:"active_members"`
+	Permissions   int64  `db:"permissions"`
+}
+
+type dbPermissionUsage struct {
+	AuthPermissionId int64  `db:"auth_permission_id"`
+	Permission       string `db:"permission"`
+	Resource         string `db:"resource"`
+	Roles            int64  `db:"roles"`
+	Users            int64  `db:"users"`
+}
+
+type dbGrowth struct {
+	Period string `db:"period"`
+	Count  int64  `db:"count"`
+}
+
+// StatisticsRequest sets the interval, either of day, week, month or year,
+// for grouping the growth of assignments
+type StatisticsRequest struct {
+	Interval string
+}
+
+// GrowthPoint is the number of assignments added in a period along with
+// the running total
+type GrowthPoint struct {
+	Period     string `json:"period"`
+	Added      int64  `json:"added"`
+	Cumulative int64  `json:"cumulative"`
+}
+
+// RoleUsage is the number of users and permissions of a role. An user
+// holding the role in several scopes is counted once.
+type RoleUsage struct {
+	RoleId        int64  `json:"role_id"`
+	Role          string `json:"role"`
+	Members       int64  `json:"members"`
+	ActiveMembers int64  `json:"active_members"`
+	Permissions   int64  `json:"permissions"`
+}
+
+// RoleStatistics summarizes the usage of roles. Orphan roles have no
+// members.
+type RoleStatistics struct {
+	Roles       []*RoleUsage   `json:"roles"`
+	OrphanRoles []string       `json:"orphan_roles"`
+	Growth      []*GrowthPoint `json:"growth"`
+}
+
+// PermissionUsage is the number of roles that are granted a permission and
+// the number of users holding it through those roles
+type PermissionUsage struct {
+	PermissionId int64  `json:"permission_id"`
+	Permission   string `json:"permission"`
+	Resource     string `json:"resource"`
+	Roles        int64  `json:"roles"`
+	Users        int64  `json:"users"`
+}
+
+// PermissionStatistics summarizes the usage of permissions. Orphan
+// permissions are not granted to any role.
+type PermissionStatistics struct {
+	Permissions       []*PermissionUsage `json:"permissions"`
+	OrphanPermissions []string           `json:"orphan_permissions"`
+	Growth            []*GrowthPoint     `json:"growth"`
+}
+
+func (s *RoleService) GetRoleStatistics(ctx context.Context, r *StatisticsRequest) (*RoleStatistics, error) {
+	interval, err := statisticsInterval(r)
+	if err != nil {
+		return &RoleStatistics{}, err
+	}
+	var dbrows []*dbRoleUsage
+	err = s.Dbh.SQL(`
+		SELECT
+			role.auth_role_id,
+			role.role,
+			COUNT(DISTINCT aur.auth_user_id) members,
+			COUNT(DISTINCT aur.auth_user_id) FILTER (WHERE usr.is_active) active_members,
+			(
+				SELECT COUNT(*) FROM auth_role_permission arp
+				WHERE arp.auth_role_id = role.auth_role_id
+			) permissions
+		FROM auth_role role
+		LEFT JOIN auth_user_role aur
+		ON aur.auth_role_id = role.auth_role_id
+		LEFT JOIN auth_user usr
+		ON usr.auth_user_id = aur.auth_user_id
+		GROUP BY role.auth_role_id, role.role
+		ORDER BY role.role
+	`).QueryStructs(&dbrows)
+	if err != nil {
+		return &RoleStatistics{}, aphgrpc.HandleError(ctx, err)
+	}
+	growth, err := assignmentGrowth(s.Dbh, "auth_user_role", interval)
+	if err != nil {
+		return &RoleStatistics{}, aphgrpc.HandleError(ctx, err)
+	}
+	st := &RoleStatistics{
+		Roles:       make([]*RoleUsage, 0),
+		OrphanRoles: make([]string, 0),
+		Growth:      growth,
+	}
+	for _, d := range dbrows {
+		st.Roles = append(st.Roles, &RoleUsage{
+			RoleId:        d.AuthRoleId,
+			Role:          d.Role,
+			Members:       d.Members,
+			ActiveMembers: d.ActiveMembers,
+			Permissions:   d.Permissions,
+		})
+		if d.Members == 0 {
+			st.OrphanRoles = append(st.OrphanRoles, d.Role)
+		}
+	}
+	return st, nil
+}
+
+func (s *PermissionService) GetPermissionStatistics(ctx context.Context, r *StatisticsRequest) (*PermissionStatistics, error) {
+	interval, err := statisticsInterval(r)
+	if err != nil {
+		return &PermissionStatistics{}, err
+	}
+	var dbrows []*dbPermissionUsage
+	err = s.Dbh.SQL(`
+		SELECT
+			perm.auth_permission_id,
+			perm.permission,
+			perm.resource,
+			COUNT(DISTINCT arp.auth_role_id) roles,
+			COUNT(DISTINCT aur.auth_user_id) users
+		FROM auth_permission perm
+		LEFT JOIN auth_role_permission arp
+		ON arp.auth_permission_id = perm.auth_permission_id
+		LEFT JOIN auth_user_role aur
+		ON aur.auth_role_id = arp.auth_role_id
+		GROUP BY perm.auth_permission_id, perm.permission, perm.resource
+		ORDER BY perm.permission, perm.resource
+	`).QueryStructs(&dbrows)
+	if err != nil {
+		return &PermissionStatistics{}, aphgrpc.HandleError(ctx, err)
+	}
+	growth, err := assignmentGrowth(s.Dbh, "auth_role_permission", interval)
+	if err != nil {
+		return &PermissionStatistics{}, aphgrpc.HandleError(ctx, err)
+	}
+	st := &PermissionStatistics{
+		Permissions:       make([]*PermissionUsage, 0),
+		OrphanPermissions: make([]string, 0),
+		Growth:            growth,
+	}
+	for _, d := range dbrows {
+		st.Permissions = append(st.Permissions, &PermissionUsage{
+			PermissionId: d.AuthPermissionId,
+			Permission:   d.Permission,
+			Resource:     d.Resource,
+			Roles:        d.Roles,
+			Users:        d.Users,
+		})
+		if d.Roles == 0 {
+			st.OrphanPermissions = append(
+				st.OrphanPermissions,
+				fmt.Sprintf("%s:%s", d.Permission, d.Resource),
+			)
+		}
+	}
+	return st, nil
+}
+
+func statisticsInterval(r *StatisticsRequest) (string, error) {
+	if len(r.Interval) == 0 {
+		return DefaultStatisticsInterval, nil
+	}
+	if !statisticsIntervals[r.Interval] {
+		return "", status.Errorf(
+			codes.InvalidArgument,
+			"interval %s is not supported, use day, week, month or year", r.Interval,
+		)
+	}
+	return r.Interval, nil
+}
+
+// assignmentGrowth groups the rows of the table by the interval of their
+// creation time
+func assignmentGrowth(conn runner.Connection, table, interval string) ([]*GrowthPoint, error) {
+	var dbrows []*dbGrowth
+	err := conn.SQL(
+		fmt.Sprintf(`
+			SELECT
+				to_char(date_trunc($1, created_at), 'YYYY-MM-DD') period,
+				COUNT(*) count
+			FROM %s
+			GROUP BY 1
+			ORDER BY 1
+		`, table),
+		interval,
+	).QueryStructs(&dbrows)
+	if err != nil {
+		return nil, err
+	}
+	growth := make([]*GrowthPoint, 0)
+	var total int64
+	for _, d := range dbrows {
+		total += d.Count
+		growth = append(growth, &GrowthPoint{
+			Period:     d.Period,
+			Added:      d.Count,
+			Cumulative: total,
+		})
+	}
+	return growth, nil
+}
+
+// -- HTTP handlers
+
+func (s *RoleService) statisticsHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	st, err := s.GetRoleStatistics(r.Context(), &StatisticsRequest{Interval: r.URL.Query().Get("interval")})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *PermissionService) statisticsHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	st, err := s.GetPermissionStatistics(r.Context(), &StatisticsRequest{Interval: r.URL.Query().Get("interval")})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestUsageStatistics(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	pclient := pb.NewPermissionServiceClient(conn)
+	perm, err := pclient.CreatePermission(context.Background(), NewPermission("write", "genes"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	_, err = pclient.CreatePermission(context.Background(), NewPermission("delete", "genes"))
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	rclient := pb.NewRoleServiceClient(conn)
+	curator, err := rclient.CreateRole(context.Background(), NewRoleWithPermission("curator", perm))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	_, err = rclient.CreateRole(context.Background(), NewRole("visitor"))
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	uclient := pb.NewUserServiceClient(conn)
+	for _, email := range []string{"first@gmail.com", "second@gmail.com"} {
+		_, err := uclient.CreateUser(context.Background(), NewUserWithRole(email, curator))
+		if err != nil {
+			t.Fatalf("could not store the user %s\n", err)
+		}
+	}
+
+	dbh := runner.NewDB(db, "postgres")
+	rst, err := NewRoleService(dbh).GetRoleStatistics(context.Background(), &StatisticsRequest{})
+	if err != nil {
+		t.Fatalf("could not fetch role statistics %s\n", err)
+	}
+	if len(rst.Roles) != 2 {
+		t.Fatalf("expected 2 roles, received %d\n", len(rst.Roles))
+	}
+	cu := rst.Roles[0]
+	if cu.Role != "curator" || cu.Members != 2 || cu.ActiveMembers != 2 || cu.Permissions != 1 {
+		t.Fatalf("unexpected usage of curator %+v\n", cu)
+	}
+	if len(rst.OrphanRoles) != 1 || rst.OrphanRoles[0] != "visitor" {
+		t.Fatalf("expected visitor as orphan role, received %v\n", rst.OrphanRoles)
+	}
+	if len(rst.Growth) != 1 || rst.Growth[0].Cumulative != 2 {
+		t.Fatalf("expected growth of 2 assignments, received %+v\n", rst.Growth)
+	}
+
+	pst, err := NewPermissionService(dbh).GetPermissionStatistics(
+		context.Background(),
+		&StatisticsRequest{Interval: "day"},
+	)
+	if err != nil {
+		t.Fatalf("could not fetch permission statistics %s\n", err)
+	}
+	if len(pst.Permissions) != 2 {
+		t.Fatalf("expected 2 permissions, received %d\n", len(pst.Permissions))
+	}
+	if len(pst.OrphanPermissions) != 1 || pst.OrphanPermissions[0] != "delete:genes" {
+		t.Fatalf("expected delete:genes as orphan permission, received %v\n", pst.OrphanPermissions)
+	}
+	for _, p := range pst.Permissions {
+		if p.Permission == "write" && (p.Roles != 1 || p.Users != 2) {
+			t.Fatalf("unexpected usage of write:genes %+v\n", p)
+		}
+	}
+	_, err = NewPermissionService(dbh).GetPermissionStatistics(
+		context.Background(),
+		&StatisticsRequest{Interval: "hour"},
+	)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for unsupported interval, received %s\n", err)
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/rbac"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// TokenIssuer signs the tokens issued by the user service
+type TokenIssuer struct {
+	Signer *auth.Signer
+	// Issuer and Audience are set as the iss and aud claims when they are
+	// not empty
+	Issuer   string
+	Audience string
+	// TTL is the lifetime of the tokens
+	TTL time.Duration
+}
+
+// TokenRequest identifies the already authenticated user a token is issued
+// for, either by id or by email
+type TokenRequest struct {
+	UserId int64  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// IssuedToken is a signed json web token along with its expiration
+type IssuedToken struct {
+	Token     string    `json:"token"`
+	TokenType string    `json:"token_type"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ExpiresIn int64     `json:"expires_in"`
+}
+
+// WithTokenIssuer enables the issuing of tokens
+func (s *UserService) WithTokenIssuer(ti *TokenIssuer) *UserService {
+	s.issuer = ti
+	return s
+}
+
+// IssueToken returns a signed token for an active user. Besides the user id
+// as subject and the email, the claims carry the names of the globally
+// assigned roles and the unconditional permission patterns they allow and
+// deny. Scoped assignments and conditional grants depend on the resource
+// and request, they are left out and have to be checked with the service.
+func (s *UserService) IssueToken(ctx context.Context, r *TokenRequest) (*IssuedToken, error) {
+	if s.issuer == nil {
+		return &IssuedToken{}, status.Error(codes.Unimplemented, "no token signing key is configured")
+	}
+	if r.UserId == 0 && len(r.Email) == 0 {
+		return &IssuedToken{}, status.Error(codes.InvalidArgument, "either user id or email is required")
+	}
+	q := s.Dbh.Select("auth_user_id", "CAST(email AS TEXT) email", "is_active").From("auth_user")
+	if r.UserId != 0 {
+		q = q.Where("auth_user_id = $1", r.UserId)
+	} else {
+		q = q.Where("email = $1", r.Email)
+	}
+	dbp := &dbPrincipal{}
+	if err := q.QueryStruct(dbp); err != nil {
+		if err == sql.ErrNoRows {
+			return &IssuedToken{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("user %d %s not found", r.UserId, r.Email))
+		}
+		return &IssuedToken{}, aphgrpc.HandleError(ctx, err)
+	}
+	if !dbp.IsActive {
+		return &IssuedToken{}, status.Errorf(codes.FailedPrecondition, "user %d is not active", dbp.AuthUserId)
+	}
+	it, err := s.issuer.issue(s.Dbh, s.cache, dbp, s.issuer.TTL, nil)
+	if err != nil {
+		return &IssuedToken{}, aphgrpc.HandleError(ctx, err)
+	}
+	return it, nil
+}
+
+// issue signs a token for the user that lasts for the ttl, the claims are
+// modified by the optional function before signing
+func (ti *TokenIssuer) issue(conn runner.Connection, pc *PermissionCache, dbp *dbPrincipal, ttl time.Duration, modify func(*auth.Claims)) (*IssuedToken, error) {
+	roles, err := globalRoles(conn, dbp.AuthUserId)
+	if err != nil {
+		return nil, err
+	}
+	grants, err := pc.grants(conn, &PermissionCheck{UserId: dbp.AuthUserId})
+	if err != nil {
+		return nil, err
+	}
+	allowed, denied := effectivePermissions(grants)
+	now := time.Now()
+	exp := now.Add(ttl)
+	c := &auth.Claims{
+		Subject:           strconv.FormatInt(dbp.AuthUserId, 10),
+		Issuer:            ti.Issuer,
+		ExpiresAt:         exp.Unix(),
+		IssuedAt:          now.Unix(),
+		Email:             dbp.Email,
+		Roles:             roles,
+		Permissions:       allowed,
+		DeniedPermissions: denied,
+	}
+	if len(ti.Audience) > 0 {
+		c.Audience = auth.Audience{ti.Audience}
+	}
+	if modify != nil {
+		modify(c)
+	}
+	token, err := ti.Signer.Sign(c)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to sign token %s", err)
+	}
+	return &IssuedToken{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresAt: time.Unix(exp.Unix(), 0),
+		ExpiresIn: int64(ttl / time.Second),
+	}, nil
+}
+
+// globalRoles returns the names of the roles the user holds outside of any
+// scope
+func globalRoles(conn runner.Connection, userId int64) ([]string, error) {
+	var roles []string
+	err := conn.Select("DISTINCT role.role").
+		From(`
+			auth_user_role
+			JOIN auth_role role
+			ON auth_user_role.auth_role_id = role.auth_role_id
+		`).
+		Where("auth_user_role.auth_user_id = $1 AND auth_user_role.resource_type IS NULL", userId).
+		OrderBy("role.role").
+		QuerySlice(&roles)
+	return roles, err
+}
+
+// effectivePermissions returns the keys of the unconditional allow and deny
+// patterns, the allowed literal patterns that a deny pattern matches are
+// dropped
+func effectivePermissions(grants []*dbRoleGrant) ([]string, []string) {
+	var allow, deny []*rbac.Pattern
+	for _, g := range grants {
+		if g.Condition.Valid {
+			continue
+		}
+		p, err := rbac.ParsePattern(g.Permission, g.Resource)
+		if err != nil {
+			continue
+		}
+		if g.Effect == rbac.EffectDeny {
+			deny = append(deny, p)
+		} else {
+			allow = append(allow, p)
+		}
+	}
+	allowed := make(map[string]bool)
+	for _, p := range allow {
+		if p.IsLiteral() && matchesAny(deny, p) {
+			continue
+		}
+		allowed[p.Key()] = true
+	}
+	denied := make(map[string]bool)
+	for _, p := range deny {
+		denied[p.Key()] = true
+	}
+	return sortedKeys(allowed), sortedKeys(denied)
+}
+
+func matchesAny(patterns []*rbac.Pattern, literal *rbac.Pattern) bool {
+	for _, p := range patterns {
+		if p.Matches(literal.Permission, literal.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// -- HTTP handlers
+
+func (s *UserService) issueTokenHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	tr := &TokenRequest{}
+	if err := readJSON(r, tr); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	it, err := s.IssueToken(r.Context(), tr)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, it)
+}
+
+func (s *UserService) jwksHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if s.issuer == nil {
+		writeHTTPError(w, status.Error(codes.NotFound, "no token signing key is configured"))
+		return
+	}
+	b, err := s.issuer.Signer.JWKS()
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(b)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+func TestIssueToken(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	conn, err := grpc.Dial("localhost"+port, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("could not connect to grpc server %s\n", err)
+	}
+	defer conn.Close()
+
+	perm, err := pb.NewPermissionServiceClient(conn).CreatePermission(
+		context.Background(),
+		NewPermission("read", "users"),
+	)
+	if err != nil {
+		t.Fatalf("could not store the permission %s\n", err)
+	}
+	role, err := pb.NewRoleServiceClient(conn).CreateRole(
+		context.Background(),
+		NewRoleWithPermission("reader", perm),
+	)
+	if err != nil {
+		t.Fatalf("could not store the role %s\n", err)
+	}
+	usr, err := pb.NewUserServiceClient(conn).CreateUser(
+		context.Background(),
+		NewUserWithRole("reader@gmail.com", role),
+	)
+	if err != nil {
+		t.Fatalf("could not store the user %s\n", err)
+	}
+
+	s := NewUserService(runner.NewDB(db, "postgres"))
+	if _, err := s.IssueToken(context.Background(), &TokenRequest{UserId: usr.Data.Id}); status.Code(err) != codes.Unimplemented {
+		t.Fatalf("expected no token without signing key, received %v\n", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := auth.NewSigner(&auth.SigningKey{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithTokenIssuer(&TokenIssuer{
+		Signer:   signer,
+		Issuer:   "dictybase",
+		Audience: "user-api",
+		TTL:      time.Hour,
+	})
+	it, err := s.IssueToken(context.Background(), &TokenRequest{Email: "reader@gmail.com"})
+	if err != nil {
+		t.Fatalf("could not issue the token %s\n", err)
+	}
+	if it.TokenType != "Bearer" || it.ExpiresIn != 3600 {
+		t.Fatalf("unexpected token %+v\n", it)
+	}
+	v := &auth.Verifier{Sources: []auth.KeySource{signer}, Issuer: "dictybase", Audience: "user-api"}
+	c, err := v.Verify(it.Token)
+	if err != nil {
+		t.Fatalf("expected the issued token to be valid, received %s\n", err)
+	}
+	if c.Subject != fmt.Sprintf("%d", usr.Data.Id) || c.Email != "reader@gmail.com" {
+		t.Fatalf("unexpected subject %s and email %s\n", c.Subject, c.Email)
+	}
+	if len(c.Roles) != 1 || c.Roles[0] != "reader" {
+		t.Fatalf("expected the reader role, received %v\n", c.Roles)
+	}
+	if len(c.Permissions) != 1 || c.Permissions[0] != "read:users" {
+		t.Fatalf("expected the read:users permission, received %v\n", c.Permissions)
+	}
+	if _, err := s.IssueToken(context.Background(), &TokenRequest{Email: "nobody@gmail.com"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected an unknown user to be rejected, received %v\n", err)
+	}
+	if _, err := s.IssueToken(context.Background(), &TokenRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected a request without identity to be rejected, received %v\n", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/dictyBase/apihelpers/aphgrpc"
 	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
 	"github.com/dictyBase/go-genproto/dictybaseapis/user"
+	"github.com/dictyBase/modware-user/auth"
 	"github.com/fatih/structs"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
@@ -106,6 +107,8 @@ type dbUserInfo struct {
 
 type UserService struct {
 	*aphgrpc.Service
+	cache  *PermissionCache
+	issuer *TokenIssuer
 }
 
 func userServiceOptions() *aphgrpc.ServiceOptions {
@@ -114,9 +117,13 @@ func userServiceOptions() *aphgrpc.ServiceOptions {
 		PathPrefix: "users",
 		Include:    []string{"roles"},
 		FilToColumns: map[string]string{
-			"first_name": "auth_user.first_name",
-			"last_name":  "auth_user.last_name",
-			"email":      "auth_user.email",
+			"first_name":   "auth_user.first_name",
+			"last_name":    "auth_user.last_name",
+			"email":        "auth_user.email",
+			"is_active":    "auth_user.is_active",
+			"organization": "auth_user_info.organization",
+			"group_name":   "auth_user_info.group_name",
+			"country":      "auth_user_info.country",
 		},
 		FieldsToColumns: map[string]string{
 			"first_name":     "auth_user.first_name",
@@ -146,7 +153,29 @@ func NewUserService(dbh *runner.DB, opt ...aphgrpc.Option) *UserService {
 	}
 	srv := &aphgrpc.Service{Dbh: dbh}
 	aphgrpc.AssignFieldsToStructs(so, srv)
-	return &UserService{srv}
+	return &UserService{Service: srv}
+}
+
+// WithPermissionCache sets the cache of permission grants that is
+// invalidated by the changes made through the service
+func (s *UserService) WithPermissionCache(c *PermissionCache) *UserService {
+	s.cache = c
+	return s
+}
+
+// HTTPRoutes returns the user endpoints that are not part of the protocol
+// buffer definitions
+func (s *UserService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/users/permissions/cache", Handler: s.permissionCacheStatsHandler},
+		{Method: "GET", Path: "/users/me", Handler: s.getCurrentUserHandler},
+		{Method: "PATCH", Path: "/users/me", Handler: s.updateCurrentUserHandler},
+		{Method: "GET", Path: "/users/me/permissions", Handler: s.getCurrentUserPermissionsHandler},
+		{Method: "GET", Path: "/users/{id}/role_assignments", Handler: s.listRoleAssignmentsHandler},
+		{Method: "GET", Path: "/users/{id}/permissions/check", Handler: s.checkPermissionHandler},
+		{Method: "POST", Path: "/tokens", Handler: s.issueTokenHandler},
+		{Method: "GET", Path: auth.JWKSPath, Handler: s.jwksHandler},
+	}
 }
 
 func (s *UserService) Healthz(ctx context.Context, r *jsonapi.HealthzIdRequest) (*empty.Empty, error) {
@@ -219,7 +248,11 @@ func (s *UserService) GetUser(ctx context.Context, r *jsonapi.GetRequest) (*user
 }
 
 func (s *UserService) GetRelatedRoles(ctx context.Context, r *jsonapi.RelationshipRequest) (*user.RoleCollection, error) {
-	rdata, err := s.getRoleResourceData(r.Id)
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &user.RoleCollection{}, err
+	}
+	rdata, err := s.getScopedRoleResourceData(r.Id, sc)
 	if err != nil {
 		return &user.RoleCollection{}, aphgrpc.HandleError(ctx, err)
 	}
@@ -444,9 +477,21 @@ func (s *UserService) ListUsers(ctx context.Context, r *jsonapi.ListRequest) (*u
 }
 
 func (s *UserService) CreateUser(ctx context.Context, r *user.CreateUserRequest) (*user.User, error) {
+	rstruct := structs.New(r).Field("Data").Field("Relationships")
+	tx, au, err := beginAudit(ctx, s.Dbh, userRPC("CreateUser"), "users", 0)
+	if err != nil {
+		return &user.User{}, err
+	}
+	defer tx.AutoRollback()
+	if !rstruct.IsZero() && !rstruct.Field("Roles").IsZero() {
+		err := checkRoleConstraints(ctx, tx, nil, dataToIds(r.Data.Relationships.Roles.Data))
+		if err != nil {
+			return &user.User{}, err
+		}
+	}
 	dbcuser := s.attrTodbCoreUser(r.Data.Attributes)
 	retcols := []string{"auth_user_id", "created_at", "updated_at"}
-	err := s.Dbh.InsertInto("auth_user").
+	err = tx.InsertInto("auth_user").
 		Columns(coreUserCols...).
 		Record(dbcuser).
 		Returning(retcols...).
@@ -459,7 +504,7 @@ func (s *UserService) CreateUser(ctx context.Context, r *user.CreateUserRequest)
 	dbusrInfo.AuthUserId = dbcuser.AuthUserId
 	defUsrInfoCols := aphgrpc.GetDefinedTags(dbusrInfo, "db")
 	if len(defUsrInfoCols) > 0 {
-		err = s.Dbh.InsertInto("auth_user_info").
+		err = tx.InsertInto("auth_user_info").
 			Columns(defUsrInfoCols...).
 			Record(dbusrInfo).
 			Returning(userInfoCols...).
@@ -469,11 +514,10 @@ func (s *UserService) CreateUser(ctx context.Context, r *user.CreateUserRequest)
 			return &user.User{}, status.Error(codes.Internal, err.Error())
 		}
 	}
-	rstruct := structs.New(r).Field("Data").Field("Relationships")
 	if !rstruct.IsZero() {
 		if !rstruct.Field("Roles").IsZero() {
 			for _, role := range r.Data.Relationships.Roles.Data {
-				_, err = s.Dbh.InsertInto("auth_user_role").
+				_, err = tx.InsertInto("auth_user_role").
 					Columns("auth_user_id", "auth_role_id").
 					Values(dbcuser.AuthUserId, role.Id).Exec()
 				if err != nil {
@@ -481,8 +525,12 @@ func (s *UserService) CreateUser(ctx context.Context, r *user.CreateUserRequest)
 					return &user.User{}, status.Error(codes.Internal, err.Error())
 				}
 			}
+			au.relate("roles", dataToIds(r.Data.Relationships.Roles.Data)...)
 		}
 	}
+	if err := au.created(dbcuser.AuthUserId).commit(tx); err != nil {
+		return &user.User{}, err
+	}
 	grpc.SetTrailer(ctx, metadata.Pairs("method", "POST"))
 	return s.buildResource(
 		context.TODO(),
@@ -503,19 +551,34 @@ func (s *UserService) CreateRoleRelationship(ctx context.Context, r *jsonapi.Dat
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, userRPC("CreateRoleRelationship"), "users", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	if err := checkUserRoleConstraints(ctx, tx, r.Id, dataToIds(r.Data)); err != nil {
+		return &empty.Empty{}, err
+	}
 	for _, rd := range r.Data {
-		res, err := s.Dbh.Select("aurole.auth_user_role_id").
+		where, args := scopedWhere(
+			"aurole",
+			"aurole.auth_user_id = $1 AND aurole.auth_role_id = $2",
+			sc, r.Id, rd.Id,
+		)
+		res, err := tx.Select("aurole.auth_user_role_id").
 			From("auth_user_role aurole").
-			Where("aurole.auth_user_id = $1 AND aurole.auth_role_id = $2", r.Id, rd.Id).
+			Where(where, args...).
 			Exec()
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 		if res.RowsAffected != 1 {
-			_, err := s.Dbh.InsertInto("auth_user_role").
-				Columns("auth_user_id", "auth_role_id").
-				Values(r.Id, rd.Id).Exec()
+			err := insertUserRole(tx, r.Id, rd.Id, sc)
 			if err != nil {
 				grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseInsert)
 				return &empty.Empty{}, status.Error(codes.Internal, err.Error())
@@ -529,6 +592,10 @@ func (s *UserService) CreateRoleRelationship(ctx context.Context, r *jsonapi.Dat
 		}
 
 	}
+	if err := au.relate("roles", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidate(r.Id)
 	grpc.SetTrailer(ctx, metadata.Pairs("method", "POST_NO_CONTENT"))
 	return &empty.Empty{}, nil
 }
@@ -542,10 +609,27 @@ func (s *UserService) UpdateUser(ctx context.Context, r *user.UpdateUserRequest)
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &user.User{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
+	rstruct := structs.New(r).Field("Data").Field("Relationships")
+	hasRoles := !rstruct.IsZero() && !rstruct.Field("Roles").IsZero()
+	tx, au, err := beginAudit(ctx, s.Dbh, userRPC("UpdateUser"), "users", r.Data.Id)
+	if err != nil {
+		return &user.User{}, err
+	}
+	defer tx.AutoRollback()
+	if hasRoles {
+		// the global roles are replaced, the scoped ones are kept
+		err := checkReplacedRoleConstraints(
+			ctx, tx, r.Data.Id, nil,
+			dataToIds(r.Data.Relationships.Roles.Data),
+		)
+		if err != nil {
+			return &user.User{}, err
+		}
+	}
 	dbcuser := s.attrTodbCoreUser(r.Data.Attributes)
 	usrMap := aphgrpc.GetDefinedTagsWithValue(dbcuser, "db")
 	if len(usrMap) > 0 {
-		err := s.Dbh.Update("auth_user").
+		err := tx.Update("auth_user").
 			SetMap(usrMap).
 			Where("auth_user_id = $1", r.Data.Id).
 			Returning([]string{"created_at", "updated_at"}...).
@@ -558,7 +642,7 @@ func (s *UserService) UpdateUser(ctx context.Context, r *user.UpdateUserRequest)
 	dbusrInfo := s.attrTodbUserInfo(r.Data.Attributes)
 	usrInfoMap := aphgrpc.GetDefinedTagsWithValue(dbusrInfo, "db")
 	if len(usrInfoMap) > 0 {
-		err := s.Dbh.Update("auth_user_info").
+		err := tx.Update("auth_user_info").
 			SetMap(usrInfoMap).
 			Where("auth_user_id = $1", r.Data.Id).
 			Returning(userInfoCols...).
@@ -568,19 +652,23 @@ func (s *UserService) UpdateUser(ctx context.Context, r *user.UpdateUserRequest)
 			return &user.User{}, status.Error(codes.Internal, err.Error())
 		}
 	}
-	rstruct := structs.New(r).Field("Data").Field("Relationships")
-	if !rstruct.IsZero() {
-		if !rstruct.Field("Roles").IsZero() {
-			for _, role := range r.Data.Relationships.Roles.Data {
-				_, err := s.Dbh.Update("auth_user_role").
-					Set("auth_role_id", role.Id).
-					Where("auth_user_id = $1", r.Data.Id).Exec()
-				if err != nil {
-					grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
-					return &user.User{}, status.Error(codes.Internal, err.Error())
-				}
+	if hasRoles {
+		for _, role := range r.Data.Relationships.Roles.Data {
+			_, err := tx.Update("auth_user_role").
+				Set("auth_role_id", role.Id).
+				Where("auth_user_id = $1 AND resource_type IS NULL", r.Data.Id).Exec()
+			if err != nil {
+				grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
+				return &user.User{}, status.Error(codes.Internal, err.Error())
 			}
 		}
+		au.relate("roles", dataToIds(r.Data.Relationships.Roles.Data)...)
+	}
+	if err := au.commit(tx); err != nil {
+		return &user.User{}, err
+	}
+	if hasRoles {
+		s.cache.invalidate(r.Data.Id)
 	}
 	return s.buildResource(
 		context.TODO(),
@@ -601,22 +689,37 @@ func (s *UserService) UpdateRoleRelationship(ctx context.Context, r *jsonapi.Dat
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
-	_, err = s.Dbh.DeleteFrom("auth_user_role").
-		Where("auth_user_role.auth_user_id = $1", r.Id).
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, userRPC("UpdateRoleRelationship"), "users", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	if err := checkReplacedRoleConstraints(ctx, tx, r.Id, sc, dataToIds(r.Data)); err != nil {
+		return &empty.Empty{}, err
+	}
+	where, args := scopedWhere("auth_user_role", "auth_user_role.auth_user_id = $1", sc, r.Id)
+	_, err = tx.DeleteFrom("auth_user_role").
+		Where(where, args...).
 		Exec()
 	if err != nil {
 		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
 		return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 	}
 	for _, rd := range r.Data {
-		_, err := s.Dbh.InsertInto("auth_user_role").
-			Columns("auth_user_id", "auth_role_id").
-			Values(r.Id, rd.Id).Exec()
+		err := insertUserRole(tx, r.Id, rd.Id, sc)
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseUpdate)
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 	}
+	if err := au.relate("roles", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidate(r.Id)
 	return &empty.Empty{}, nil
 }
 
@@ -629,11 +732,20 @@ func (s *UserService) DeleteUser(ctx context.Context, r *jsonapi.DeleteRequest)
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
-	_, err = s.Dbh.DeleteFrom("auth_user").Where("auth_user_id = $1", r.Id).Exec()
+	tx, au, err := beginAudit(ctx, s.Dbh, userRPC("DeleteUser"), "users", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
+	_, err = tx.DeleteFrom("auth_user").Where("auth_user_id = $1", r.Id).Exec()
 	if err != nil {
 		grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
 		return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 	}
+	if err := au.commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidate(r.Id)
 	return &empty.Empty{}, nil
 }
 
@@ -649,15 +761,33 @@ func (s *UserService) DeleteRoleRelationship(ctx context.Context, r *jsonapi.Dat
 		grpc.SetTrailer(ctx, aphgrpc.ErrNotFound)
 		return &empty.Empty{}, status.Error(codes.NotFound, fmt.Sprintf("id %d not found", r.Id))
 	}
+	sc, err := scopeFromContext(ctx)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	tx, au, err := beginAudit(ctx, s.Dbh, userRPC("DeleteRoleRelationship"), "users", r.Id)
+	if err != nil {
+		return &empty.Empty{}, err
+	}
+	defer tx.AutoRollback()
 	for _, rd := range r.Data {
-		_, err := s.Dbh.DeleteFrom("auth_user_role").
-			Where("auth_user_role.auth_user_id = $1 AND auth_user_role.auth_role_id = $2", r.Id, rd.Id).
+		where, args := scopedWhere(
+			"auth_user_role",
+			"auth_user_role.auth_user_id = $1 AND auth_user_role.auth_role_id = $2",
+			sc, r.Id, rd.Id,
+		)
+		_, err := tx.DeleteFrom("auth_user_role").
+			Where(where, args...).
 			Exec()
 		if err != nil {
 			grpc.SetTrailer(ctx, aphgrpc.ErrDatabaseDelete)
 			return &empty.Empty{}, status.Error(codes.Internal, err.Error())
 		}
 	}
+	if err := au.relate("roles", dataToIds(r.Data)...).commit(tx); err != nil {
+		return &empty.Empty{}, err
+	}
+	s.cache.invalidate(r.Id)
 	return &empty.Empty{}, nil
 }
 
@@ -804,13 +934,18 @@ func (s *UserService) getAllSelectedFilteredRowsWithPaging(ctx context.Context,
 // -- Functions that returns relationship resource objects
 
 func (s *UserService) getRoleResourceData(id int64) ([]*user.RoleData, error) {
+	return s.getScopedRoleResourceData(id, nil)
+}
+
+func (s *UserService) getScopedRoleResourceData(id int64, sc *Scope) ([]*user.RoleData, error) {
 	var drole []*dbRole
 	var rdata []*user.RoleData
+	where, args := scopedWhere("auth_user_role", "auth_user_role.auth_user_id = $1", sc, id)
 	err := s.Dbh.Select("role.*").From(`
 			auth_user_role
 			JOIN auth_role role
 			ON auth_user_role.auth_role_id = role.auth_role_id
-		`).Where("auth_user_role.auth_user_id = $1", id).QueryStructs(&drole)
+		`).Where(where, args...).QueryStructs(&drole)
 	if err != nil {
 		return rdata, err
 	}
@@ -0,0 +1,782 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dictyBase/apihelpers/aphgrpc"
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/message/event"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+const (
+	webhookDbTable         = "auth_webhook"
+	webhookDeliveryDbTable = "auth_webhook_delivery"
+	webhookAttemptDbTable  = "auth_webhook_attempt"
+)
+
+// WebhookSecretPrefix starts the generated signing secrets
+const WebhookSecretPrefix = "whsec_"
+
+const (
+	// DefaultWebhookDeliveryLimit is the number of deliveries listed when
+	// the request has no limit
+	DefaultWebhookDeliveryLimit = 50
+	// MaxWebhookDeliveryLimit is the largest number of deliveries listed at
+	// once
+	MaxWebhookDeliveryLimit = 500
+)
+
+// States of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+var webhookCols = []string{
+	"auth_webhook_id",
+	"url",
+	"event_types",
+	"description",
+	"is_active",
+	"created_by",
+	"created_at",
+	"updated_at",
+}
+
+var webhookDeliveryCols = []string{
+	"auth_webhook_delivery_id",
+	"auth_webhook_id",
+	"event_id",
+	"event_type",
+	"payload",
+	"state",
+	"attempts",
+	"next_attempt_at",
+	"last_response_code",
+	"last_error",
+	"replay_of",
+	"created_at",
+	"delivered_at",
+}
+
+type dbWebhook struct {
+	AuthWebhookId int64          `db:"auth_webhook_id"`
+	Url           string         `db:"url"`
+	EventTypes    string         `db:"event_types"`
+	Description   dat.NullString `db:"description"`
+	IsActive      bool           `db:"is_active"`
+	CreatedBy     dat.NullInt64  `db:"created_by"`
+	CreatedAt     time.Time      `db:"created_at"`
+	UpdatedAt     time.Time      `db:"updated_at"`
+}
+
+type dbWebhookDelivery struct {
+	AuthWebhookDeliveryId int64          `db:"auth_webhook_delivery_id"`
+	AuthWebhookId         int64          `db:"auth_webhook_id"`
+	EventId               string         `db:"event_id"`
+	EventType             string         `db:"event_type"`
+	Payload               string         `db:"payload"`
+	State                 string         `db:"state"`
+	Attempts              int            `db:"attempts"`
+	NextAttemptAt         time.Time      `db:"next_attempt_at"`
+	LastResponseCode      dat.NullInt64  `db:"last_response_code"`
+	LastError             dat.NullString `db:"last_error"`
+	ReplayOf              dat.NullInt64  `db:"replay_of"`
+	CreatedAt             time.Time      `db:"created_at"`
+	DeliveredAt           dat.NullTime   `db:"delivered_at"`
+}
+
+type dbWebhookAttempt struct {
+	AuthWebhookAttemptId int64          `db:"auth_webhook_attempt_id"`
+	AttemptedAt          time.Time      `db:"attempted_at"`
+	ResponseCode         dat.NullInt64  `db:"response_code"`
+	ResponseBody         dat.NullString `db:"response_body"`
+	Error                dat.NullString `db:"error"`
+	DurationMs           int64          `db:"duration_ms"`
+}
+
+// Webhook is a http endpoint subscribed to domain events. The secret signs
+// the deliveries, it is only returned when it is set.
+type Webhook struct {
+	Id          int64     `json:"id"`
+	URL         string    `json:"url"`
+	EventTypes  []string  `json:"event_types"`
+	Description string    `json:"description,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	Secret      string    `json:"secret,omitempty"`
+	CreatedBy   int64     `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookCollection lists the webhooks
+type WebhookCollection struct {
+	Data []*Webhook `json:"data"`
+}
+
+// NewWebhook subscribes an endpoint to the events named by their message,
+// for example RoleAssigned. Without event types the webhook receives every
+// event, without secret one is generated.
+type NewWebhook struct {
+	URL         string   `json:"url"`
+	EventTypes  []string `json:"event_types"`
+	Secret      string   `json:"secret"`
+	Description string   `json:"description"`
+}
+
+// WebhookUpdate changes a webhook, the absent attributes are kept. An
+// inactive webhook queues no deliveries and its pending ones wait until it
+// is active again.
+type WebhookUpdate struct {
+	Id          int64     `json:"-"`
+	URL         *string   `json:"url"`
+	EventTypes  *[]string `json:"event_types"`
+	Secret      *string   `json:"secret"`
+	Description *string   `json:"description"`
+	IsActive    *bool     `json:"is_active"`
+}
+
+// WebhookDelivery is an event sent to a webhook along with the log of its
+// attempts
+type WebhookDelivery struct {
+	Id               int64             `json:"id"`
+	WebhookId        int64             `json:"webhook_id"`
+	EventId          string            `json:"event_id"`
+	EventType        string            `json:"event_type"`
+	Payload          json.RawMessage   `json:"payload"`
+	State            string            `json:"state"`
+	Attempts         int               `json:"attempts"`
+	NextAttemptAt    *time.Time        `json:"next_attempt_at,omitempty"`
+	LastResponseCode int64             `json:"last_response_code,omitempty"`
+	LastError        string            `json:"last_error,omitempty"`
+	ReplayOf         int64             `json:"replay_of,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	DeliveredAt      *time.Time        `json:"delivered_at,omitempty"`
+	AttemptLog       []*WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt is a request made for a delivery, it has no response code
+// when the request failed before a response
+type WebhookAttempt struct {
+	AttemptedAt  time.Time `json:"attempted_at"`
+	ResponseCode int64     `json:"response_code,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DurationMs   int64     `json:"duration_ms"`
+}
+
+// WebhookDeliveryCollection lists deliveries, the latest first. The next
+// page starts before NextBefore.
+type WebhookDeliveryCollection struct {
+	Data       []*WebhookDelivery `json:"data"`
+	NextBefore int64              `json:"next_before,omitempty"`
+}
+
+// WebhookDeliveryFilter restricts the listed deliveries of a webhook
+type WebhookDeliveryFilter struct {
+	WebhookId int64
+	State     string
+	Before    int64
+	Limit     int64
+}
+
+// WebhookDeliveryRequest identifies a delivery of a webhook
+type WebhookDeliveryRequest struct {
+	WebhookId int64
+	Id        int64
+}
+
+// webhookPayload is the body of a delivery, data is the event in the
+// protobuf JSON mapping with the original field names
+type webhookPayload struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// WebhookService manages the webhook subscriptions and their deliveries.
+// The deliveries are queued along with the changes and sent by the
+// WebhookDispatcher.
+type WebhookService struct {
+	*aphgrpc.Service
+}
+
+func webhookServiceOptions() *aphgrpc.ServiceOptions {
+	return &aphgrpc.ServiceOptions{
+		Resource:   "webhooks",
+		PathPrefix: "webhooks",
+	}
+}
+
+func NewWebhookService(dbh *runner.DB, opt ...aphgrpc.Option) *WebhookService {
+	so := webhookServiceOptions()
+	for _, optfn := range opt {
+		optfn(so)
+	}
+	srv := &aphgrpc.Service{Dbh: dbh}
+	aphgrpc.AssignFieldsToStructs(so, srv)
+	return &WebhookService{Service: srv}
+}
+
+// HTTPRoutes returns the webhook endpoints
+func (s *WebhookService) HTTPRoutes() []*HTTPRoute {
+	return []*HTTPRoute{
+		{Method: "GET", Path: "/webhooks", Handler: s.listHandler},
+		{Method: "POST", Path: "/webhooks", Handler: s.createHandler},
+		{Method: "GET", Path: "/webhooks/{id}", Handler: s.getHandler},
+		{Method: "PATCH", Path: "/webhooks/{id}", Handler: s.updateHandler},
+		{Method: "DELETE", Path: "/webhooks/{id}", Handler: s.deleteHandler},
+		{Method: "GET", Path: "/webhooks/{id}/deliveries", Handler: s.listDeliveriesHandler},
+		{Method: "GET", Path: "/webhooks/{id}/deliveries/{delivery_id}", Handler: s.getDeliveryHandler},
+		{Method: "POST", Path: "/webhooks/{id}/deliveries/{delivery_id}/replay", Handler: s.replayHandler},
+	}
+}
+
+// WebhookEventTypes returns the names of the events webhooks can subscribe
+// to
+func WebhookEventTypes() []string {
+	var types []string
+	msgs := event.File_event_proto.Messages()
+	for i := 0; i < msgs.Len(); i++ {
+		if msgs.Get(i).Fields().ByName("meta") != nil {
+			types = append(types, string(msgs.Get(i).Name()))
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (s *WebhookService) CreateWebhook(ctx context.Context, r *NewWebhook) (*Webhook, error) {
+	if err := validateWebhookURL(r.URL); err != nil {
+		return &Webhook{}, aphgrpc.HandleInsertArgError(ctx, err)
+	}
+	types, err := validateWebhookEventTypes(r.EventTypes)
+	if err != nil {
+		return &Webhook{}, aphgrpc.HandleInsertArgError(ctx, err)
+	}
+	secret := r.Secret
+	if len(secret) == 0 {
+		if secret, err = generateWebhookSecret(); err != nil {
+			return &Webhook{}, aphgrpc.HandleError(ctx, err)
+		}
+	}
+	var createdBy dat.NullInt64
+	if p, ok := auth.FromContext(ctx); ok && p.UserId != 0 {
+		createdBy = dat.NullInt64From(p.UserId)
+	}
+	dbw := &dbWebhook{}
+	err = s.Dbh.InsertInto(webhookDbTable).
+		Columns("url", "event_types", "secret", "description", "created_by").
+		Values(r.URL, strings.Join(types, ","), secret, dat.NullStringFrom(r.Description), createdBy).
+		Returning(webhookCols...).
+		QueryStruct(dbw)
+	if err != nil {
+		return &Webhook{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	w := dbToWebhook(dbw)
+	w.Secret = secret
+	return w, nil
+}
+
+func (s *WebhookService) GetWebhook(ctx context.Context, r *jsonapi.IdRequest) (*Webhook, error) {
+	dbw, err := getWebhook(s.Dbh, r.Id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &Webhook{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("webhook id %d not found", r.Id))
+		}
+		return &Webhook{}, aphgrpc.HandleError(ctx, err)
+	}
+	return dbToWebhook(dbw), nil
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context) (*WebhookCollection, error) {
+	var dbrows []*dbWebhook
+	err := s.Dbh.Select(webhookCols...).
+		From(webhookDbTable).
+		OrderBy("auth_webhook_id").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &WebhookCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	coll := &WebhookCollection{Data: make([]*Webhook, 0)}
+	for _, dbw := range dbrows {
+		coll.Data = append(coll.Data, dbToWebhook(dbw))
+	}
+	return coll, nil
+}
+
+// UpdateWebhook changes the attributes of a webhook, an empty secret is
+// replaced by a generated one. The deliveries already queued keep their
+// payload but are sent to the new url with the new secret.
+func (s *WebhookService) UpdateWebhook(ctx context.Context, r *WebhookUpdate) (*Webhook, error) {
+	smap := map[string]interface{}{"updated_at": time.Now()}
+	if r.URL != nil {
+		if err := validateWebhookURL(*r.URL); err != nil {
+			return &Webhook{}, aphgrpc.HandleUpdateArgError(ctx, err)
+		}
+		smap["url"] = *r.URL
+	}
+	if r.EventTypes != nil {
+		types, err := validateWebhookEventTypes(*r.EventTypes)
+		if err != nil {
+			return &Webhook{}, aphgrpc.HandleUpdateArgError(ctx, err)
+		}
+		smap["event_types"] = strings.Join(types, ",")
+	}
+	var secret string
+	if r.Secret != nil {
+		secret = *r.Secret
+		if len(secret) == 0 {
+			var err error
+			if secret, err = generateWebhookSecret(); err != nil {
+				return &Webhook{}, aphgrpc.HandleError(ctx, err)
+			}
+		}
+		smap["secret"] = secret
+	}
+	if r.Description != nil {
+		smap["description"] = dat.NullStringFrom(*r.Description)
+	}
+	if r.IsActive != nil {
+		smap["is_active"] = *r.IsActive
+	}
+	dbw := &dbWebhook{}
+	err := s.Dbh.Update(webhookDbTable).
+		SetMap(smap).
+		Where("auth_webhook_id = $1", r.Id).
+		Returning(webhookCols...).
+		QueryStruct(dbw)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &Webhook{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("webhook id %d not found", r.Id))
+		}
+		return &Webhook{}, aphgrpc.HandleUpdateError(ctx, err)
+	}
+	w := dbToWebhook(dbw)
+	w.Secret = secret
+	return w, nil
+}
+
+// DeleteWebhook removes a webhook along with its deliveries
+func (s *WebhookService) DeleteWebhook(ctx context.Context, r *jsonapi.DeleteRequest) (*empty.Empty, error) {
+	res, err := s.Dbh.DeleteFrom(webhookDbTable).
+		Where("auth_webhook_id = $1", r.Id).
+		Exec()
+	if err != nil {
+		return &empty.Empty{}, aphgrpc.HandleDeleteError(ctx, err)
+	}
+	if res.RowsAffected == 0 {
+		return &empty.Empty{}, aphgrpc.HandleNotFoundError(ctx, fmt.Errorf("webhook id %d not found", r.Id))
+	}
+	return &empty.Empty{}, nil
+}
+
+// ListWebhookDeliveries returns the deliveries of a webhook without their
+// attempt log
+func (s *WebhookService) ListWebhookDeliveries(ctx context.Context, r *WebhookDeliveryFilter) (*WebhookDeliveryCollection, error) {
+	limit := r.Limit
+	switch {
+	case limit < 0:
+		return &WebhookDeliveryCollection{}, status.Error(codes.InvalidArgument, "limit cannot be negative")
+	case limit == 0:
+		limit = DefaultWebhookDeliveryLimit
+	case limit > MaxWebhookDeliveryLimit:
+		limit = MaxWebhookDeliveryLimit
+	}
+	switch r.State {
+	case "", DeliveryPending, DeliveryDelivered, DeliveryFailed:
+	default:
+		return &WebhookDeliveryCollection{}, status.Errorf(codes.InvalidArgument, "invalid state %s", r.State)
+	}
+	if _, err := s.GetWebhook(ctx, &jsonapi.IdRequest{Id: r.WebhookId}); err != nil {
+		return &WebhookDeliveryCollection{}, err
+	}
+	conds := []string{"auth_webhook_id = $1"}
+	args := []interface{}{r.WebhookId}
+	if len(r.State) > 0 {
+		args = append(args, r.State)
+		conds = append(conds, fmt.Sprintf("state = $%d", len(args)))
+	}
+	if r.Before != 0 {
+		args = append(args, r.Before)
+		conds = append(conds, fmt.Sprintf("auth_webhook_delivery_id < $%d", len(args)))
+	}
+	var dbrows []*dbWebhookDelivery
+	err := s.Dbh.Select(webhookDeliveryCols...).
+		From(webhookDeliveryDbTable).
+		Where(strings.Join(conds, " AND "), args...).
+		OrderBy("auth_webhook_delivery_id DESC").
+		Limit(uint64(limit + 1)).
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &WebhookDeliveryCollection{}, aphgrpc.HandleError(ctx, err)
+	}
+	coll := &WebhookDeliveryCollection{Data: make([]*WebhookDelivery, 0)}
+	if int64(len(dbrows)) > limit {
+		dbrows = dbrows[:limit]
+		coll.NextBefore = dbrows[limit-1].AuthWebhookDeliveryId
+	}
+	for _, dbd := range dbrows {
+		coll.Data = append(coll.Data, dbToWebhookDelivery(dbd))
+	}
+	return coll, nil
+}
+
+// GetWebhookDelivery returns a delivery along with its attempt log
+func (s *WebhookService) GetWebhookDelivery(ctx context.Context, r *WebhookDeliveryRequest) (*WebhookDelivery, error) {
+	dbd, err := s.getDelivery(ctx, r)
+	if err != nil {
+		return &WebhookDelivery{}, err
+	}
+	var dbrows []*dbWebhookAttempt
+	err = s.Dbh.Select(
+		"auth_webhook_attempt_id", "attempted_at", "response_code",
+		"response_body", "error", "duration_ms",
+	).
+		From(webhookAttemptDbTable).
+		Where("auth_webhook_delivery_id = $1", dbd.AuthWebhookDeliveryId).
+		OrderBy("auth_webhook_attempt_id").
+		QueryStructs(&dbrows)
+	if err != nil {
+		return &WebhookDelivery{}, aphgrpc.HandleError(ctx, err)
+	}
+	d := dbToWebhookDelivery(dbd)
+	for _, dba := range dbrows {
+		d.AttemptLog = append(d.AttemptLog, &WebhookAttempt{
+			AttemptedAt:  dba.AttemptedAt,
+			ResponseCode: aphgrpc.NullToInt64(dba.ResponseCode),
+			ResponseBody: aphgrpc.NullToString(dba.ResponseBody),
+			Error:        aphgrpc.NullToString(dba.Error),
+			DurationMs:   dba.DurationMs,
+		})
+	}
+	return d, nil
+}
+
+// ReplayWebhookDelivery queues the payload of a delivered or failed
+// delivery again, as a new delivery that refers to it. The event id is
+// kept, so that the receivers can drop the duplicates.
+func (s *WebhookService) ReplayWebhookDelivery(ctx context.Context, r *WebhookDeliveryRequest) (*WebhookDelivery, error) {
+	dbd, err := s.getDelivery(ctx, r)
+	if err != nil {
+		return &WebhookDelivery{}, err
+	}
+	if dbd.State == DeliveryPending {
+		return &WebhookDelivery{}, status.Errorf(
+			codes.FailedPrecondition,
+			"delivery %d is still pending", dbd.AuthWebhookDeliveryId,
+		)
+	}
+	replay := &dbWebhookDelivery{}
+	err = s.Dbh.InsertInto(webhookDeliveryDbTable).
+		Columns("auth_webhook_id", "event_id", "event_type", "payload", "replay_of").
+		Values(dbd.AuthWebhookId, dbd.EventId, dbd.EventType, dbd.Payload, dbd.AuthWebhookDeliveryId).
+		Returning(webhookDeliveryCols...).
+		QueryStruct(replay)
+	if err != nil {
+		return &WebhookDelivery{}, aphgrpc.HandleInsertError(ctx, err)
+	}
+	return dbToWebhookDelivery(replay), nil
+}
+
+// All helper functions
+
+func (s *WebhookService) getDelivery(ctx context.Context, r *WebhookDeliveryRequest) (*dbWebhookDelivery, error) {
+	dbd := &dbWebhookDelivery{}
+	err := s.Dbh.Select(webhookDeliveryCols...).
+		From(webhookDeliveryDbTable).
+		Where("auth_webhook_delivery_id = $1 AND auth_webhook_id = $2", r.Id, r.WebhookId).
+		QueryStruct(dbd)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return dbd, aphgrpc.HandleNotFoundError(
+				ctx,
+				fmt.Errorf("delivery id %d of webhook %d not found", r.Id, r.WebhookId),
+			)
+		}
+		return dbd, aphgrpc.HandleError(ctx, err)
+	}
+	return dbd, nil
+}
+
+// queueWebhookDeliveries queues an event for every active webhook
+// subscribed to it, in the transaction of the change
+func queueWebhookDeliveries(conn runner.Connection, ev proto.Message) error {
+	name := string(ev.ProtoReflect().Descriptor().Name())
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	p := &webhookPayload{Type: name, Data: data}
+	if m, ok := ev.(interface{ GetMeta() *event.Meta }); ok && m.GetMeta() != nil {
+		p.Id = m.GetMeta().Id
+		p.OccurredAt = m.GetMeta().OccurredAt.AsTime()
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = conn.SQL(`
+		INSERT INTO auth_webhook_delivery (auth_webhook_id, event_id, event_type, payload)
+		SELECT auth_webhook_id, CAST($1 AS text), CAST($2 AS text), CAST($3 AS text)
+		FROM auth_webhook
+		WHERE is_active
+		AND (event_types = '' OR CAST($2 AS text) = ANY(string_to_array(event_types, ',')))`,
+		p.Id, name, string(payload),
+	).Exec()
+	return err
+}
+
+func getWebhook(conn runner.Connection, id int64) (*dbWebhook, error) {
+	dbw := &dbWebhook{}
+	err := conn.Select(webhookCols...).
+		From(webhookDbTable).
+		Where("auth_webhook_id = $1", id).
+		QueryStruct(dbw)
+	return dbw, err
+}
+
+func validateWebhookURL(u string) error {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("invalid url %s %s", u, err)
+	}
+	if (pu.Scheme != "http" && pu.Scheme != "https") || len(pu.Host) == 0 {
+		return fmt.Errorf("url %s has to be an absolute http or https url", u)
+	}
+	return nil
+}
+
+// validateWebhookEventTypes checks the names of the events and returns
+// them sorted without duplicates
+func validateWebhookEventTypes(types []string) ([]string, error) {
+	known := make(map[string]bool)
+	for _, t := range WebhookEventTypes() {
+		known[t] = true
+	}
+	seen := make(map[string]bool)
+	var valid []string
+	for _, t := range types {
+		if !known[t] {
+			return nil, fmt.Errorf(
+				"unknown event type %s, expected one of %s",
+				t, strings.Join(WebhookEventTypes(), ", "),
+			)
+		}
+		if !seen[t] {
+			seen[t] = true
+			valid = append(valid, t)
+		}
+	}
+	sort.Strings(valid)
+	return valid, nil
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return WebhookSecretPrefix + hex.EncodeToString(b), nil
+}
+
+func dbToWebhook(dbw *dbWebhook) *Webhook {
+	w := &Webhook{
+		Id:          dbw.AuthWebhookId,
+		URL:         dbw.Url,
+		EventTypes:  make([]string, 0),
+		Description: aphgrpc.NullToString(dbw.Description),
+		IsActive:    dbw.IsActive,
+		CreatedBy:   aphgrpc.NullToInt64(dbw.CreatedBy),
+		CreatedAt:   dbw.CreatedAt,
+		UpdatedAt:   dbw.UpdatedAt,
+	}
+	if len(dbw.EventTypes) > 0 {
+		w.EventTypes = strings.Split(dbw.EventTypes, ",")
+	}
+	return w
+}
+
+func dbToWebhookDelivery(dbd *dbWebhookDelivery) *WebhookDelivery {
+	d := &WebhookDelivery{
+		Id:               dbd.AuthWebhookDeliveryId,
+		WebhookId:        dbd.AuthWebhookId,
+		EventId:          dbd.EventId,
+		EventType:        dbd.EventType,
+		Payload:          json.RawMessage(dbd.Payload),
+		State:            dbd.State,
+		Attempts:         dbd.Attempts,
+		LastResponseCode: aphgrpc.NullToInt64(dbd.LastResponseCode),
+		LastError:        aphgrpc.NullToString(dbd.LastError),
+		ReplayOf:         aphgrpc.NullToInt64(dbd.ReplayOf),
+		CreatedAt:        dbd.CreatedAt,
+	}
+	if dbd.State == DeliveryPending {
+		t := dbd.NextAttemptAt
+		d.NextAttemptAt = &t
+	}
+	if dbd.DeliveredAt.Valid {
+		t := dbd.DeliveredAt.Time
+		d.DeliveredAt = &t
+	}
+	return d
+}
+
+// -- HTTP handlers
+
+func (s *WebhookService) listHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	coll, err := s.ListWebhooks(r.Context())
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *WebhookService) createHandler(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	nw := &NewWebhook{}
+	if err := readJSON(r, nw); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	wh, err := s.CreateWebhook(r.Context(), nw)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, wh)
+}
+
+func (s *WebhookService) getHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	wh, err := s.GetWebhook(r.Context(), &jsonapi.IdRequest{Id: id})
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, wh)
+}
+
+func (s *WebhookService) updateHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	wu := &WebhookUpdate{}
+	if err := readJSON(r, wu); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	wu.Id = id
+	wh, err := s.UpdateWebhook(r.Context(), wu)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, wh)
+}
+
+func (s *WebhookService) deleteHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if _, err := s.DeleteWebhook(r.Context(), &jsonapi.DeleteRequest{Id: id}); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *WebhookService) listDeliveriesHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	id, err := pathParamToID(params, "id")
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	f := &WebhookDeliveryFilter{WebhookId: id, State: q.Get("state")}
+	if f.Before, err = queryParamToID(r, "before"); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if v := q.Get("limit"); len(v) > 0 {
+		if f.Limit, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid limit %s", v))
+			return
+		}
+	}
+	coll, err := s.ListWebhookDeliveries(r.Context(), f)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, coll)
+}
+
+func (s *WebhookService) getDeliveryHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	req, err := deliveryRequest(params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	d, err := s.GetWebhookDelivery(r.Context(), req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (s *WebhookService) replayHandler(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	req, err := deliveryRequest(params)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	d, err := s.ReplayWebhookDelivery(r.Context(), req)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, d)
+}
+
+func deliveryRequest(params map[string]string) (*WebhookDeliveryRequest, error) {
+	webhookId, err := pathParamToID(params, "id")
+	if err != nil {
+		return nil, err
+	}
+	id, err := pathParamToID(params, "delivery_id")
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookDeliveryRequest{WebhookId: webhookId, Id: id}, nil
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dat "gopkg.in/mgutz/dat.v2/dat"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// Headers of a webhook request
+const (
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// DefaultWebhookBatchSize is the number of deliveries sent at once
+	DefaultWebhookBatchSize = 20
+	// DefaultWebhookPollInterval is how long the dispatcher waits once no
+	// delivery is due
+	DefaultWebhookPollInterval = 5 * time.Second
+	// DefaultWebhookTimeout is how long a webhook has to respond
+	DefaultWebhookTimeout = 10 * time.Second
+	// DefaultWebhookMinBackoff is the delay before the first retry of a
+	// delivery
+	DefaultWebhookMinBackoff = 30 * time.Second
+	// DefaultWebhookMaxBackoff is the longest delay between the retries
+	DefaultWebhookMaxBackoff = time.Hour
+	// DefaultWebhookMaxAttempts is the number of attempts after which a
+	// delivery has failed
+	DefaultWebhookMaxAttempts = 10
+	// DefaultWebhookLease is how long the deliveries of a batch are held by
+	// the dispatcher sending them
+	DefaultWebhookLease = 5 * time.Minute
+)
+
+// maxWebhookResponseBody is the length of the response body kept in the
+// attempt log
+const maxWebhookResponseBody = 1024
+
+type dbDueDelivery struct {
+	AuthWebhookDeliveryId int64        `db:"auth_webhook_delivery_id"`
+	EventType             string       `db:"event_type"`
+	Payload               string       `db:"payload"`
+	Attempts              int          `db:"attempts"`
+	LeasedUntil           dat.NullTime `db:"leased_until"`
+	Url                   string       `db:"url"`
+	Secret                string       `db:"secret"`
+}
+
+// webhookResult is the outcome of a request to a webhook
+type webhookResult struct {
+	code     int
+	body     string
+	err      error
+	duration time.Duration
+}
+
+// WebhookSignature is the hex encoded HMAC-SHA256 of the timestamp and the
+// body of a delivery joined by a dot, keyed with the secret of the webhook.
+// The request carries it as sha256=<signature> in the X-Webhook-Signature
+// header, the timestamp in X-Webhook-Timestamp.
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher sends the queued webhook deliveries. A delivery
+// succeeds with a 2xx response, otherwise it is retried after a delay that
+// doubles from MinBackoff up to MaxBackoff until it fails for good after
+// MaxAttempts. Every attempt is logged along with its response code.
+//
+// A batch is claimed with a lease before it is sent, so that concurrent
+// dispatchers send distinct deliveries without holding a transaction open
+// during the requests. The deliveries of a dispatcher that stops before
+// recording them are sent again once the lease expires, the lease has to
+// be longer than sending a whole batch takes. The deliveries of a webhook
+// are not ordered.
+type WebhookDispatcher struct {
+	dbh          *runner.DB
+	Client       *http.Client
+	BatchSize    int
+	PollInterval time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	MaxAttempts  int
+	Lease        time.Duration
+}
+
+// NewWebhookDispatcher creates a dispatcher with the default settings
+func NewWebhookDispatcher(dbh *runner.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		dbh:          dbh,
+		Client:       &http.Client{Timeout: DefaultWebhookTimeout},
+		BatchSize:    DefaultWebhookBatchSize,
+		PollInterval: DefaultWebhookPollInterval,
+		MinBackoff:   DefaultWebhookMinBackoff,
+		MaxBackoff:   DefaultWebhookMaxBackoff,
+		MaxAttempts:  DefaultWebhookMaxAttempts,
+		Lease:        DefaultWebhookLease,
+	}
+}
+
+// Run sends the deliveries until the context is done, the errors are
+// handed over to the report function
+func (d *WebhookDispatcher) Run(ctx context.Context, report func(error)) {
+	for {
+		n, err := d.Dispatch()
+		wait := d.PollInterval
+		switch {
+		case err != nil:
+			if report != nil {
+				report(err)
+			}
+		case n == d.BatchSize:
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Dispatch sends a batch of due deliveries of the active webhooks and
+// returns how many were attempted. A delivery rejected by its webhook is
+// recorded and rescheduled, only a database failure is returned.
+func (d *WebhookDispatcher) Dispatch() (int, error) {
+	rows, err := d.claim()
+	if err != nil {
+		return 0, err
+	}
+	for i, row := range rows {
+		res := d.send(row)
+		tx, err := d.dbh.Begin()
+		if err != nil {
+			return i, err
+		}
+		if err := d.record(tx, row, res); err != nil {
+			tx.Rollback()
+			return i, err
+		}
+		if err := tx.Commit(); err != nil {
+			return i, err
+		}
+	}
+	return len(rows), nil
+}
+
+// All helper functions
+
+// claim leases a batch of due deliveries to the dispatcher, the lease is
+// committed before any of them is sent
+func (d *WebhookDispatcher) claim() ([]*dbDueDelivery, error) {
+	var rows []*dbDueDelivery
+	err := d.dbh.SQL(`
+		WITH due AS (
+			SELECT d.auth_webhook_delivery_id
+			FROM auth_webhook_delivery d
+			JOIN auth_webhook w ON w.auth_webhook_id = d.auth_webhook_id
+			WHERE d.state = 'pending' AND d.next_attempt_at <= now() AND w.is_active
+			AND (d.leased_until IS NULL OR d.leased_until <= now())
+			ORDER BY d.next_attempt_at, d.auth_webhook_delivery_id
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE auth_webhook_delivery d
+		SET leased_until = now() + CAST($2 AS double precision) * interval '1 millisecond'
+		FROM due, auth_webhook w
+		WHERE d.auth_webhook_delivery_id = due.auth_webhook_delivery_id
+		AND w.auth_webhook_id = d.auth_webhook_id
+		RETURNING d.auth_webhook_delivery_id, d.event_type, d.payload, d.attempts,
+			d.leased_until, w.url, w.secret`,
+		d.BatchSize, int64(d.Lease/time.Millisecond),
+	).QueryStructs(&rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].AuthWebhookDeliveryId < rows[j].AuthWebhookDeliveryId
+	})
+	return rows, nil
+}
+
+func (d *WebhookDispatcher) send(row *dbDueDelivery) *webhookResult {
+	start := time.Now()
+	res := &webhookResult{}
+	defer func() { res.duration = time.Since(start) }()
+	req, err := http.NewRequest("POST", row.Url, strings.NewReader(row.Payload))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	ts := start.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "modware-user-webhook")
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(row.AuthWebhookDeliveryId, 10))
+	req.Header.Set(WebhookEventHeader, row.EventType)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(
+		WebhookSignatureHeader,
+		"sha256="+WebhookSignature(row.Secret, ts, []byte(row.Payload)),
+	)
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBody))
+	res.code = resp.StatusCode
+	res.body = string(body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		res.err = fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return res
+}
+
+// record logs the attempt and moves the delivery to its next state, which
+// ends its lease. A delivery whose lease expired and was claimed again is
+// left to the dispatcher holding the new lease, only the attempt is logged.
+func (d *WebhookDispatcher) record(conn runner.Connection, row *dbDueDelivery, res *webhookResult) error {
+	var code dat.NullInt64
+	if res.code != 0 {
+		code = dat.NullInt64From(int64(res.code))
+	}
+	var errMsg dat.NullString
+	if res.err != nil {
+		errMsg = dat.NullStringFrom(res.err.Error())
+	}
+	_, err := conn.InsertInto(webhookAttemptDbTable).
+		Columns("auth_webhook_delivery_id", "response_code", "response_body", "error", "duration_ms").
+		Values(
+			row.AuthWebhookDeliveryId, code, dat.NullStringFrom(res.body),
+			errMsg, int64(res.duration/time.Millisecond),
+		).
+		Exec()
+	if err != nil {
+		return err
+	}
+	attempts := row.Attempts + 1
+	now := time.Now()
+	smap := map[string]interface{}{
+		"attempts":           attempts,
+		"last_response_code": code,
+		"last_error":         errMsg,
+		"leased_until":       dat.NullTime{},
+	}
+	switch {
+	case res.err == nil:
+		smap["state"] = DeliveryDelivered
+		smap["delivered_at"] = now
+	case attempts >= d.MaxAttempts:
+		smap["state"] = DeliveryFailed
+	default:
+		smap["next_attempt_at"] = now.Add(d.backoff(attempts))
+	}
+	_, err = conn.Update(webhookDeliveryDbTable).
+		SetMap(smap).
+		Where(
+			"auth_webhook_delivery_id = $1 AND leased_until = $2",
+			row.AuthWebhookDeliveryId, row.LeasedUntil,
+		).
+		Exec()
+	return err
+}
+
+// backoff returns the delay before the retry that follows the given number
+// of attempts
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.MinBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxBackoff {
+		delay = d.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dictyBase/go-genproto/dictybaseapis/api/jsonapi"
+	"github.com/dictyBase/modware-user/auth"
+	"github.com/dictyBase/modware-user/testutils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runner "gopkg.in/mgutz/dat.v2/sqlx-runner"
+)
+
+// webhookStub is a local webhook that answers with the queued status codes,
+// 200 once they are used up, and keeps the requests with a valid signature
+type webhookStub struct {
+	secret string
+	mu     sync.Mutex
+	codes  []int
+	bodies [][]byte
+	events []string
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	ts, _ := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	if r.Header.Get(WebhookSignatureHeader) != "sha256="+WebhookSignature(s.secret, ts, body) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := http.StatusOK
+	if len(s.codes) > 0 {
+		code, s.codes = s.codes[0], s.codes[1:]
+	}
+	if code == http.StatusOK {
+		s.bodies = append(s.bodies, body)
+		s.events = append(s.events, r.Header.Get(WebhookEventHeader))
+	}
+	w.WriteHeader(code)
+}
+
+func (s *webhookStub) respond(codes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes = codes
+}
+
+func TestWebhooks(t *testing.T) {
+	defer testutils.TearDownTest(db, t)
+	stub := &webhookStub{secret: "whsec_test", codes: []int{http.StatusInternalServerError}}
+	hs := httptest.NewServer(stub)
+	defer hs.Close()
+	dbh := runner.NewDB(db, "postgres")
+	s := NewWebhookService(dbh)
+	usr, err := NewUserService(dbh).CreateUser(context.Background(), NewUser("hooks@gmail.com"))
+	if err != nil {
+		t.Fatalf("could not create the user %s\n", err)
+	}
+	ctx := auth.NewContext(context.Background(), &auth.Principal{UserId: usr.Data.Id})
+	for _, nw := range []*NewWebhook{
+		{URL: "ftp://example.org/hook"},
+		{URL: "/hook"},
+		{URL: hs.URL, EventTypes: []string{"Meta"}},
+		{URL: hs.URL, EventTypes: []string{"UserRenamed"}},
+	} {
+		if _, err := s.CreateWebhook(ctx, nw); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("expected %+v to be rejected, received %v\n", nw, err)
+		}
+	}
+	wh, err := s.CreateWebhook(ctx, &NewWebhook{
+		URL:        hs.URL,
+		EventTypes: []string{"PermissionChanged", "PermissionChanged"},
+		Secret:     "whsec_test",
+	})
+	if err != nil {
+		t.Fatalf("could not create the webhook %s\n", err)
+	}
+	if len(wh.EventTypes) != 1 || wh.Secret != "whsec_test" || !wh.IsActive || wh.CreatedBy != usr.Data.Id {
+		t.Fatalf("unexpected webhook %+v\n", wh)
+	}
+	other, err := s.CreateWebhook(ctx, &NewWebhook{URL: hs.URL, EventTypes: []string{"RoleCreated"}})
+	if err != nil {
+		t.Fatalf("could not create the webhook %s\n", err)
+	}
+	if len(other.Secret) <= len(WebhookSecretPrefix) {
+		t.Fatalf("expected a generated secret, received %s\n", other.Secret)
+	}
+	got, err := s.GetWebhook(ctx, &jsonapi.IdRequest{Id: wh.Id})
+	if err != nil {
+		t.Fatalf("could not get the webhook %s\n", err)
+	}
+	if len(got.Secret) != 0 {
+		t.Fatal("expected the secret to be left out")
+	}
+
+	perm, err := NewPermissionService(dbh).CreatePermission(ctx, NewPermission("read", "users"))
+	if err != nil {
+		t.Fatalf("could not create the permission %s\n", err)
+	}
+	coll, err := s.ListWebhookDeliveries(ctx, &WebhookDeliveryFilter{WebhookId: wh.Id})
+	if err != nil {
+		t.Fatalf("could not list the deliveries %s\n", err)
+	}
+	if len(coll.Data) != 1 || coll.Data[0].EventType != "PermissionChanged" || coll.Data[0].State != DeliveryPending {
+		t.Fatalf("expected a pending permission delivery, received %+v\n", coll.Data)
+	}
+	dr := &WebhookDeliveryRequest{WebhookId: wh.Id, Id: coll.Data[0].Id}
+	coll, err = s.ListWebhookDeliveries(ctx, &WebhookDeliveryFilter{WebhookId: other.Id})
+	if err != nil {
+		t.Fatalf("could not list the deliveries %s\n", err)
+	}
+	if len(coll.Data) != 0 {
+		t.Fatalf("expected no delivery for an unsubscribed event, received %d\n", len(coll.Data))
+	}
+
+	d := NewWebhookDispatcher(dbh)
+	d.MinBackoff = time.Millisecond
+	d.MaxAttempts = 3
+	if n, err := d.Dispatch(); err != nil || n != 1 {
+		t.Fatalf("expected a single attempt, received %d %v\n", n, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n, err := d.Dispatch(); err != nil || n != 1 {
+		t.Fatalf("expected the delivery to be retried, received %d %v\n", n, err)
+	}
+	del, err := s.GetWebhookDelivery(ctx, dr)
+	if err != nil {
+		t.Fatalf("could not get the delivery %s\n", err)
+	}
+	if del.State != DeliveryDelivered || del.Attempts != 2 || del.LastResponseCode != http.StatusOK || len(del.AttemptLog) != 2 {
+		t.Fatalf("expected a delivery after a retry, received %+v\n", del)
+	}
+	if del.AttemptLog[0].ResponseCode != http.StatusInternalServerError || len(del.AttemptLog[0].Error) == 0 {
+		t.Fatalf("expected the failed attempt in the log, received %+v\n", del.AttemptLog[0])
+	}
+	var payload struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Change     string `json:"change"`
+			Permission struct {
+				Id       string `json:"id"`
+				Resource string `json:"resource"`
+			} `json:"permission"`
+		} `json:"data"`
+	}
+	if len(stub.bodies) != 1 || stub.events[0] != "PermissionChanged" {
+		t.Fatalf("expected a single delivered request, received %d\n", len(stub.bodies))
+	}
+	if err := json.Unmarshal(stub.bodies[0], &payload); err != nil {
+		t.Fatalf("could not decode the payload %s\n", err)
+	}
+	if payload.Type != "PermissionChanged" || payload.Data.Change != "CREATED" ||
+		payload.Data.Permission.Id != strconv.FormatInt(perm.Data.Id, 10) || payload.Id != del.EventId {
+		t.Fatalf("unexpected payload %s\n", stub.bodies[0])
+	}
+
+	if _, err := s.ReplayWebhookDelivery(ctx, &WebhookDeliveryRequest{WebhookId: other.Id, Id: del.Id}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the delivery of another webhook to be missing, received %v\n", err)
+	}
+	replay, err := s.ReplayWebhookDelivery(ctx, dr)
+	if err != nil {
+		t.Fatalf("could not replay the delivery %s\n", err)
+	}
+	if replay.ReplayOf != del.Id || replay.State != DeliveryPending || replay.EventId != del.EventId {
+		t.Fatalf("unexpected replay %+v\n", replay)
+	}
+	if _, err := s.ReplayWebhookDelivery(ctx, &WebhookDeliveryRequest{WebhookId: wh.Id, Id: replay.Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a pending delivery to be refused, received %v\n", err)
+	}
+	// a delivery leased by another dispatcher is left alone until the
+	// lease expires
+	lease := "UPDATE auth_webhook_delivery SET leased_until = now() + CAST($1 AS interval) WHERE auth_webhook_delivery_id = $2"
+	if _, err := db.Exec(lease, "1 hour", replay.Id); err != nil {
+		t.Fatalf("could not lease the replay %s\n", err)
+	}
+	if n, err := d.Dispatch(); err != nil || n != 0 {
+		t.Fatalf("expected the leased replay to be skipped, received %d %v\n", n, err)
+	}
+	if _, err := db.Exec(lease, "-1 second", replay.Id); err != nil {
+		t.Fatalf("could not expire the lease of the replay %s\n", err)
+	}
+	// a dispatcher whose lease expired and was taken over does not record
+	// its attempt over the one of the new holder
+	rows, err := d.claim()
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("expected to claim the replay, received %d %v\n", len(rows), err)
+	}
+	if _, err := db.Exec(lease, "1 hour", replay.Id); err != nil {
+		t.Fatalf("could not lease the replay %s\n", err)
+	}
+	if err := d.record(dbh, rows[0], &webhookResult{code: http.StatusOK}); err != nil {
+		t.Fatalf("could not record the attempt %s\n", err)
+	}
+	var state string
+	var attempts int
+	err = db.QueryRow(
+		"SELECT state, attempts FROM auth_webhook_delivery WHERE auth_webhook_delivery_id = $1",
+		replay.Id,
+	).Scan(&state, &attempts)
+	if err != nil {
+		t.Fatalf("could not read the replay %s\n", err)
+	}
+	if state != DeliveryPending || attempts != 0 {
+		t.Fatalf("expected the replay to be left to the new lease holder, received %s after %d attempts\n", state, attempts)
+	}
+	if _, err := db.Exec(lease, "-1 second", replay.Id); err != nil {
+		t.Fatalf("could not expire the lease of the replay %s\n", err)
+	}
+	if _, err := d.Dispatch(); err != nil {
+		t.Fatalf("could not dispatch the replay %s\n", err)
+	}
+	if len(stub.bodies) != 2 || string(stub.bodies[1]) != string(stub.bodies[0]) {
+		t.Fatalf("expected the replay to send the same payload, received %d requests\n", len(stub.bodies))
+	}
+
+	stub.respond(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	if _, err := NewPermissionService(dbh).CreatePermission(ctx, NewPermission("write", "users")); err != nil {
+		t.Fatalf("could not create the permission %s\n", err)
+	}
+	for i := 0; i < d.MaxAttempts; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if _, err := d.Dispatch(); err != nil {
+			t.Fatalf("could not dispatch the deliveries %s\n", err)
+		}
+	}
+	failed, err := s.ListWebhookDeliveries(ctx, &WebhookDeliveryFilter{WebhookId: wh.Id, State: DeliveryFailed})
+	if err != nil {
+		t.Fatalf("could not list the failed deliveries %s\n", err)
+	}
+	if len(failed.Data) != 1 || failed.Data[0].Attempts != 3 || failed.Data[0].LastResponseCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a delivery failed after three attempts, received %+v\n", failed.Data)
+	}
+
+	inactive := false
+	if _, err := s.UpdateWebhook(ctx, &WebhookUpdate{Id: wh.Id, IsActive: &inactive}); err != nil {
+		t.Fatalf("could not deactivate the webhook %s\n", err)
+	}
+	if _, err := NewPermissionService(dbh).CreatePermission(ctx, NewPermission("delete", "users")); err != nil {
+		t.Fatalf("could not create the permission %s\n", err)
+	}
+	if all := mustListDeliveries(t, s, wh.Id); len(all) != 3 {
+		t.Fatalf("expected no delivery for an inactive webhook, received %d\n", len(all))
+	}
+	if _, err := s.DeleteWebhook(ctx, &jsonapi.DeleteRequest{Id: wh.Id}); err != nil {
+		t.Fatalf("could not delete the webhook %s\n", err)
+	}
+	if _, err := s.ListWebhookDeliveries(ctx, &WebhookDeliveryFilter{WebhookId: wh.Id}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected the deleted webhook to be missing, received %v\n", err)
+	}
+
+	d.MinBackoff, d.MaxBackoff = time.Second, 4*time.Second
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 8: 4 * time.Second} {
+		if got := d.backoff(attempts); got != want {
+			t.Fatalf("expected a backoff of %s after %d attempts, received %s\n", want, attempts, got)
+		}
+	}
+}
+
+func mustListDeliveries(t *testing.T, s *WebhookService, id int64) []*WebhookDelivery {
+	coll, err := s.ListWebhookDeliveries(context.Background(), &WebhookDeliveryFilter{WebhookId: id})
+	if err != nil {
+		t.Fatalf("could not list the deliveries %s\n", err)
+	}
+	return coll.Data
+}
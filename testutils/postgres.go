@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -33,7 +35,27 @@ func CheckPostgresEnv() error {
 func TearDownTest(db *sql.DB, t *testing.T) {
 	userTbls := []string{"auth_user", "auth_user_info", "auth_user_role"}
 	roleTbls := []string{"auth_permission", "auth_role", "auth_role_permission"}
+	localTbls := []string{
+		"auth_role_approver",
+		"auth_role_request",
+		"auth_role_request_comment",
+		"auth_role_constraint",
+		"auth_role_protected",
+		"auth_permission_protected",
+		"auth_permission_catalog",
+		"auth_service_account",
+		"auth_service_account_key",
+		"auth_service_account_role",
+		"auth_impersonation",
+		"auth_audit_event",
+		"auth_audit_event_resource",
+		"auth_event_outbox",
+		"auth_webhook",
+		"auth_webhook_delivery",
+		"auth_webhook_attempt",
+	}
 	tbls := append(userTbls, roleTbls...)
+	tbls = append(tbls, localTbls...)
 	for _, tbl := range tbls {
 		_, err := db.Exec(fmt.Sprintf("TRUNCATE %s CASCADE", tbl))
 		if err != nil {
@@ -99,9 +121,19 @@ func SetupTestDB(db *sql.DB) error {
 	if err := goose.Up(db, dir); err != nil {
 		return fmt.Errorf("issue with running database migration %s", err)
 	}
+	if err := goose.Up(db, MigrationsDir()); err != nil {
+		return fmt.Errorf("issue with running local database migration %s", err)
+	}
 	return nil
 }
 
+// MigrationsDir returns the folder with the database migrations that
+// are maintained in this repository
+func MigrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "migrations")
+}
+
 func getPgxDbHandler(cp *ConnectParams) (*sql.DB, error) {
 	db := &sql.DB{}
 	pgConn := fmt.Sprintf(
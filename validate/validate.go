@@ -2,14 +2,28 @@ package validate
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/dictyBase/modware-user/rbac"
 	"github.com/urfave/cli"
 )
 
 func ValidateReplyArgs(c *cli.Context) error {
+	return validateReplyArgs(c, "user")
+}
+
+func ValidateRoleReplyArgs(c *cli.Context) error {
+	return validateReplyArgs(c, "role")
+}
+
+func ValidatePermissionReplyArgs(c *cli.Context) error {
+	return validateReplyArgs(c, "permission")
+}
+
+func validateReplyArgs(c *cli.Context, svc string) error {
 	for _, p := range []string{
-		"user-grpc-host",
-		"user-grpc-port",
+		svc + "-grpc-host",
+		svc + "-grpc-port",
 		"messaging-host",
 		"messaging-port",
 	} {
@@ -20,6 +34,62 @@ func ValidateReplyArgs(c *cli.Context) error {
 			)
 		}
 	}
+	for _, p := range []string{"reply-workers", "reply-max-pending"} {
+		if c.Int(p) <= 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("argument %s has to be positive", p),
+				2,
+			)
+		}
+	}
+	return nil
+}
+
+func ValidateRelayArgs(c *cli.Context) error {
+	for _, p := range []string{
+		"dictyuser-pass",
+		"dictyuser-db",
+		"dictyuser-user",
+		"messaging-host",
+		"messaging-port",
+	} {
+		if len(c.String(p)) == 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("argument %s is missing", p),
+				2,
+			)
+		}
+	}
+	if c.Int("relay-batch-size") <= 0 {
+		return cli.NewExitError("argument relay-batch-size has to be positive", 2)
+	}
+	return nil
+}
+
+func ValidateWebhookArgs(c *cli.Context) error {
+	for _, p := range []string{
+		"dictyuser-pass",
+		"dictyuser-db",
+		"dictyuser-user",
+	} {
+		if len(c.String(p)) == 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("argument %s is missing", p),
+				2,
+			)
+		}
+	}
+	for _, p := range []string{"webhook-batch-size", "webhook-max-attempts"} {
+		if c.Int(p) <= 0 {
+			return cli.NewExitError(fmt.Sprintf("argument %s has to be positive", p), 2)
+		}
+	}
+	if c.Duration("webhook-lease") < time.Duration(c.Int("webhook-batch-size"))*c.Duration("webhook-timeout") {
+		return cli.NewExitError(
+			"argument webhook-lease has to cover the timeouts of a whole batch",
+			2,
+		)
+	}
 	return nil
 }
 
@@ -37,6 +107,71 @@ func ValidateArgs(c *cli.Context) error {
 			)
 		}
 	}
+	// the cached grants are only dropped when the other services broadcast
+	// their changes
+	messaging := len(c.String("messaging-host")) > 0 && len(c.String("messaging-port")) > 0
+	if c.IsSet("permission-cache-size") && c.Int("permission-cache-size") > 0 && !messaging {
+		return cli.NewExitError(
+			"permission-cache-size requires messaging-host and messaging-port to receive the cache invalidations",
+			2,
+		)
+	}
+	return nil
+}
+
+func ValidateMigrateArgs(c *cli.Context) error {
+	for _, p := range []string{
+		"dictyuser-pass",
+		"dictyuser-db",
+		"dictyuser-user",
+		"migrations-dir",
+	} {
+		if len(c.String(p)) == 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("argument %s is missing", p),
+				2,
+			)
+		}
+	}
+	return nil
+}
+
+func ValidateExportArgs(c *cli.Context) error {
+	for _, p := range []string{
+		"dictyuser-pass",
+		"dictyuser-db",
+		"dictyuser-user",
+	} {
+		if len(c.String(p)) == 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("argument %s is missing", p),
+				2,
+			)
+		}
+	}
+	if !rbac.IsValidFormat(c.String("format")) {
+		return cli.NewExitError(
+			fmt.Sprintf("format %s is not supported", c.String("format")),
+			2,
+		)
+	}
+	return nil
+}
+
+func ValidateApplyArgs(c *cli.Context) error {
+	for _, p := range []string{
+		"dictyuser-pass",
+		"dictyuser-db",
+		"dictyuser-user",
+		"file",
+	} {
+		if len(c.String(p)) == 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("argument %s is missing", p),
+				2,
+			)
+		}
+	}
 	return nil
 }
 
@@ -60,3 +195,19 @@ func ValidateLoad(c *cli.Context) error {
 	}
 	return nil
 }
+
+func ValidateAuditArgs(c *cli.Context) error {
+	for _, p := range []string{
+		"dictyuser-pass",
+		"dictyuser-db",
+		"dictyuser-user",
+	} {
+		if len(c.String(p)) == 0 {
+			return cli.NewExitError(
+				fmt.Sprintf("argument %s is missing", p),
+				2,
+			)
+		}
+	}
+	return nil
+}